@@ -1,12 +1,21 @@
 package udig
 
 import (
+	"context"
+	"crypto/tls"
 	"crypto/x509"
+	"errors"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/domainr/whois"
+	"github.com/ip2location/ip2location-go"
 	"github.com/miekg/dns"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
 )
 
 /////////////////////////////////////////
@@ -18,6 +27,9 @@ const (
 	DefaultTimeout = 3 * time.Second
 )
 
+// DialContextFunc opens a network connection to a given address (e.g. through an SSH tunnel).
+type DialContextFunc func(ctx context.Context, network string, address string) (net.Conn, error)
+
 // ResolutionType is an enumeration type for resolutions types.
 type ResolutionType string
 
@@ -42,6 +54,21 @@ const (
 
 	// TypeGEO is a type of all GeoIP resolutions.
 	TypeGEO ResolutionType = "GEO"
+
+	// TypeIPWHOIS is a type of all IP WHOIS (RIR) resolutions.
+	TypeIPWHOIS ResolutionType = "IPWHOIS"
+
+	// TypeSPF is a type of all SPF audit resolutions.
+	TypeSPF ResolutionType = "SPF"
+
+	// TypePTR is a type of all reverse DNS (PTR) resolutions.
+	TypePTR ResolutionType = "PTR"
+
+	// TypePassiveDNS is a type of all passive DNS resolutions.
+	TypePassiveDNS ResolutionType = "PDNS"
+
+	// TypeBruteForce is a type of all subdomain brute-force resolutions.
+	TypeBruteForce ResolutionType = "BRUTE"
 )
 
 // Udig is a high-level facade for domain resolution which:
@@ -49,15 +76,25 @@ const (
 //  2. deals with domain crawling
 //  3. caches intermediate results and summarizes the outputs
 type Udig interface {
-	Resolve(domain string) []Resolution
+	Resolve(ctx context.Context, domain string) []Resolution
 	AddDomainResolver(resolver DomainResolver)
 	AddIPResolver(resolver IPResolver)
 }
 
+// Clock is an API contract for a source of the current time (e.g. a fixed one in tests).
+// Resolvers without a Clock use the real one.
+type Clock interface {
+	Now() time.Time // Returns the current time.
+}
+
+// Option is a functional option which configures a Udig instance.
+// Options are applied after the default resolvers have been registered.
+type Option func(udig *udigImpl)
+
 // DomainResolver is an API contract for all Resolver modules that resolve domains.
 // Discovered domains that relate to the original query are recursively resolved.
 type DomainResolver interface {
-	ResolveDomain(domain string) Resolution // Resolves a given domain.
+	ResolveDomain(ctx context.Context, domain string) Resolution // Resolves a given domain.
 }
 
 // IPResolver is an API contract for all Resolver modules that resolve IPs.
@@ -73,10 +110,50 @@ type Resolution interface {
 	IPs() []string        // Returns a list of IP addresses discovered in this resolution.
 }
 
+// RawResolution is an optional API contract for Resolutions which expose
+// their concrete payload (e.g. []dns.RR for DNS or []x509.Certificate for TLS).
+type RawResolution interface {
+	Raw() interface{} // Returns the concrete payload of this resolution.
+}
+
+// ErrorResolution is an optional API contract for Resolutions which record
+// failures (e.g. timeouts) as opposed to simply finding no data.
+// All the built-in resolutions implement it.
+type ErrorResolution interface {
+	Error() error // Returns a failure which occurred during the resolution (if any).
+}
+
+// ErrResolverDisabled is a failure of a resolver which cannot run due to its configuration
+// (e.g. GEO without a DB). It is recorded (wrapped) by each of its resolutions.
+var ErrResolverDisabled = errors.New("disabled")
+
+// disabledError is ErrResolverDisabled with a reason (e.g. "no DB").
+type disabledError struct {
+	reason string
+}
+
+func (err *disabledError) Error() string {
+	return ErrResolverDisabled.Error() + " (" + err.reason + ")"
+}
+
+func (err *disabledError) Unwrap() error {
+	return ErrResolverDisabled
+}
+
+// ResolverDiagnostic sums up failures of one type of resolver during a crawl (see Diagnose).
+type ResolverDiagnostic struct {
+	Type     ResolutionType
+	Disabled string // Reason why the resolver is disabled (if it is), e.g. "no DB".
+	Timeouts int    // Number of resolutions which timed out.
+	Failures int    // Number of resolutions which failed otherwise.
+}
+
 // ResolutionBase is a shared implementation for all Resolutions (i.e. results).
 type ResolutionBase struct {
 	Resolution `json:"-"`
 	query      string
+	errs       []error
+	errMutex   sync.Mutex
 }
 
 // Query getter.
@@ -84,6 +161,46 @@ func (res *ResolutionBase) Query() string {
 	return res.query
 }
 
+// Error returns a failure which occurred during this resolution (if any).
+// Empty results without an error mean there was simply no data to find.
+// Multiple failures are combined into one error.
+func (res *ResolutionBase) Error() error {
+	res.errMutex.Lock()
+	defer res.errMutex.Unlock()
+
+	switch len(res.errs) {
+	case 0:
+		return nil
+	case 1:
+		return res.errs[0]
+	}
+	return resolutionErrors(append([]error{}, res.errs...))
+}
+
+// addError records a given failure (safe for concurrent use).
+func (res *ResolutionBase) addError(err error) {
+	res.errMutex.Lock()
+	res.errs = append(res.errs, err)
+	res.errMutex.Unlock()
+}
+
+// resolutionErrors is a list of failures which occurred during one resolution.
+type resolutionErrors []error
+
+func (errs resolutionErrors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Errors returns the individual failures. Note that errors.Is and errors.As
+// do not inspect them (multiple wrapped errors need Go 1.20).
+func (errs resolutionErrors) Errors() []error {
+	return errs
+}
+
 // Domains returns a list of domains discovered in this resolution.
 func (res *ResolutionBase) Domains() (domains []string) {
 	// Not supported by default.
@@ -103,26 +220,76 @@ func (res *ResolutionBase) IPs() (ips []string) {
 // DNSResolver is a Resolver which is able to resolve a domain
 // to a bunch of the most interesting DNS records.
 //
-// You can configure which query types are actually used
+// You can configure which query types are actually used,
+// how many of them run in parallel (MaxConcurrency, 0 means no limit),
+// how many records are kept per answer (MaxRecordsPerQuery, 0 means no limit),
+// for how long the answers are cached (CacheTTL, 0 means no caching),
+// whether to probe for wildcard records (DetectWildcards),
+// how many names to enumerate by walking NSEC chains (ZoneWalkLimit, 0 means no walking),
+// whether to send DNS cookies (Cookies, see RFC 7873),
+// to which block size the queries are padded (PaddingBlockSize, 0 means no padding),
+// whether to take IPs of TXT records from SPF policies only (SPFOnlyTXTIPs),
+// whether to attempt zone transfers for AXFR queries (ZoneTransfer, otherwise they are skipped)
 // and you can also supply a custom name server.
 // If you don't a name server for each domain is discovered
 // using NS record query, falling back to a local NS
 // (e.g. the one in /etc/resolv.conf).
 type DNSResolver struct {
 	DomainResolver
-	QueryTypes      []uint16
-	NameServer      string
-	Client          *dns.Client
-	nameServerCache map[string]string
-	resolvedDomains map[string]bool
+	QueryTypes         []uint16
+	DKIMSelectors      []string
+	MaxConcurrency     int
+	MaxRecordsPerQuery int
+	CacheTTL           time.Duration
+	DetectWildcards    bool
+	ZoneWalkLimit      int
+	Cookies            bool
+	PaddingBlockSize   int
+	SPFOnlyTXTIPs      bool
+	ZoneTransfer       bool
+	NameServer         string
+	Client             *dns.Client
+	DialContext        DialContextFunc
+	Clock              Clock
+	nameServerCache    map[string]string
+	answerCache        map[dnsCacheKey]*dnsCacheEntry
+	wildcardCache      map[string][]string
+	resolvedDomains    map[string]bool
+	cookieServers      map[string]bool
+	clientCookie       string
+	cacheMutex         sync.RWMutex
+	limiter            limiter
+}
+
+type dnsCacheKey struct {
+	domain string
+	qType  uint16
+}
+
+type dnsCacheEntry struct {
+	answers []DNSRecordPair
+	expires time.Time
 }
 
 // DNSResolution is a DNS multi-query resolution yielding many DNS records
 // in a form of query-answer pairs.
+//
+// Wildcard is set when the queried domain resolves to the same addresses
+// as a random label under its parent, i.e. it is most likely a product
+// of a wildcard record (only with DNSResolver.DetectWildcards).
+// WalkedDomains are names enumerated by following the NSEC chain
+// of the queried zone (only with DNSResolver.ZoneWalkLimit).
+// ServerCookie is set when the name server echoed a valid DNS cookie
+// (only with DNSResolver.Cookies).
 type DNSResolution struct {
 	*ResolutionBase
-	Records    []DNSRecordPair
-	nameServer string
+	Records       []DNSRecordPair
+	DKIMKeys      []DKIMKey
+	Wildcard      bool
+	WalkedDomains []string
+	ServerCookie  bool
+	nameServer    string
+	spfOnlyTXTIPs bool
 }
 
 // DNSRecordPair is a pair of DNS record type used in the query
@@ -137,15 +304,81 @@ type DNSRecord struct {
 	dns.RR
 }
 
+// DKIMKey is a record found under a DKIM selector of a domain
+// (i.e. "<selector>._domainkey.<domain>").
+type DKIMKey struct {
+	Selector string
+	Record   *DNSRecord
+}
+
+// Host is a union of all IPv4 and IPv6 addresses found for a single host name.
+type Host struct {
+	Name  string
+	IPv4s []string
+	IPv6s []string
+}
+
+/////////////////////////////////////////
+// SPF
+/////////////////////////////////////////
+
+// SPFResolver is a Resolver which audits the SPF policy of a domain by recursively
+// resolving all its includes and redirects (at most MaxDepth deep).
+// The queries carry DNS cookies if Cookies is set and are padded to PaddingBlockSize
+// (0 means no padding).
+type SPFResolver struct {
+	DomainResolver
+	MaxDepth         int
+	Cookies          bool
+	PaddingBlockSize int
+	NameServer       string
+	Client           *dns.Client
+	DialContext      DialContextFunc
+	clientCookie     string
+	limiter          limiter
+}
+
+// SPFResolution is an SPF audit of a domain yielding the whole include tree.
+//
+// Lookups is the number of DNS lookups an SPF evaluator would need (include, a, mx,
+// ptr, exists and redirect terms across the tree). SPFTooManyLookups is set when
+// it exceeds the limit of SPFMaxLookups (RFC 7208), i.e. the policy fails with permerror.
+type SPFResolution struct {
+	*ResolutionBase
+	Record            *SPFRecord
+	Lookups           int
+	SPFTooManyLookups bool
+}
+
+// SPFRecord is an SPF policy of a single domain along with policies it includes (or redirects to).
+type SPFRecord struct {
+	Domain   string
+	Policy   string
+	Lookups  int
+	Includes []*SPFRecord
+}
+
 /////////////////////////////////////////
 // WHOIS
 /////////////////////////////////////////
 
 // WhoisResolver is a Resolver responsible for resolution of a given
 // domain to a list of WHOIS contacts.
+//
+// If PreferRDAP is set, the resolver first tries RDAP (structured JSON, RFC 7483)
+// via an IANA bootstrap and falls back to classic WHOIS only on failure.
+// If FollowReferral is set, classic WHOIS follows registrar referrals
+// (see MaxWhoisReferralHops) and merges the richer registrar data in.
+// MaxContacts caps the number of contacts kept per query (0 means no limit).
 type WhoisResolver struct {
 	DomainResolver
-	Client *whois.Client
+	PreferRDAP     bool
+	FollowReferral bool
+	MaxContacts    int
+	Client         *whois.Client
+	HTTPClient     *http.Client
+	rdapServers    map[string][]string
+	rdapMutex      sync.Mutex
 }
 
 // WhoisResolution is a WHOIS query resolution yielding many contacts.
@@ -176,26 +409,69 @@ type WhoisContact struct {
 	Address                 string
 }
 
+// WhoisContactSummary is a WHOIS contact shared by several domains (see AggregateWhoisContacts).
+// Only the identity of the contact is kept (i.e. no dates), Domains are sorted.
+type WhoisContactSummary struct {
+	WhoisContact
+	Domains []string
+}
+
 /////////////////////////////////////////
 // TLS
 /////////////////////////////////////////
 
 // TLSResolver is a Resolver responsible for resolution of a given domain
 // to a list of TLS certificates.
+//
+// If ExpectedIssuers are given, any leaf certificate issued by someone else
+// is flagged (see TLSResolution.UnexpectedIssuer).
+//
+// The handshake itself never verifies certificates (so that even misconfigured
+// hosts yield some), the chain is verified separately against Roots
+// (nil means the system pool).
+//
+// Every port in Ports is probed (any TLS service will do, e.g. IMAPS on 993).
+// ServerName overrides the SNI, which defaults to the queried domain.
 type TLSResolver struct {
 	DomainResolver
-	Client *http.Client
+	Ports              []int
+	ServerName         string
+	ExpectedIssuers    []string
+	Roots              *x509.CertPool
+	ClientCertificates []tls.Certificate
+	Dialer             *net.Dialer
+	DialContext        DialContextFunc
+	Clock              Clock
+	limiter            limiter
 }
 
 // TLSResolution is a TLS handshake resolution, which yields a certificate chain.
+//
+// ChainValid is set when the leaf certificate of every probed port verifies
+// for the queried domain (or the SNI) against the trusted roots.
+//
+// HostnameMismatch is set when a leaf certificate does not cover the queried domain.
+// If such a leaf covers shared names of a CDN instead (see CDNSharedDomains),
+// the domain is CDNFronted by the CDNProvider.
 type TLSResolution struct {
 	*ResolutionBase
-	Certificates []TLSCertificate
+	Certificates     []TLSCertificate
+	UnexpectedIssuer bool
+	ChainValid       bool
+	IncompleteChain  bool
+	HostnameMismatch bool
+	CDNFronted       bool
+	CDNProvider      string
 }
 
-// TLSCertificate is a wrapper for the actual x509.Certificate.
+// TLSCertificate is a wrapper for the actual x509.Certificate
+// along with its validity status at the time of resolution.
 type TLSCertificate struct {
 	x509.Certificate
+	Port            int
+	Expired         bool
+	SelfSigned      bool
+	DaysUntilExpiry int
 }
 
 /////////////////////////////////////////
@@ -204,22 +480,42 @@ type TLSCertificate struct {
 
 // HTTPResolver is a Resolver responsible for resolution of a given domain
 // to a list of corresponding HTTP headers.
+//
+// If FollowRedirects is not set, only the first-hop response is inspected
+// and its redirect target is captured instead.
 type HTTPResolver struct {
 	DomainResolver
-	Headers []string
-	Client  *http.Client
+	Headers         []string
+	Schemes         []string
+	FollowRedirects bool
+	ScanBody        bool
+	MaxBodyBytes    int64
+	UserAgent       string
+	RequestHeaders  http.Header
+	Client          *http.Client
 }
 
 // HTTPResolution is a HTTP header resolution yielding many HTTP protocol headers.
+//
+// Downgrade is set when the domain serves content over plain HTTP without redirecting to
+// HTTPS (probed only if "http" is among the resolver Schemes). InsecureDomains are domains
+// of resources referenced over plain HTTP from the HTTPS page (i.e. mixed content).
+// Location is the redirect target of the first hop when redirects are not followed.
 type HTTPResolution struct {
 	*ResolutionBase
-	Headers []HTTPHeader
+	Headers         []HTTPHeader
+	Downgrade       bool
+	InsecureDomains []string
+	Location        string
+	Redirects       []string
+	BodyDomains     []string
 }
 
 // HTTPHeader is a pair of HTTP header name and corresponding value(s).
 type HTTPHeader struct {
-	Name  string
-	Value []string
+	Name   string
+	Value  []string
+	Scheme string
 }
 
 /////////////////////////////////////////
@@ -228,24 +524,86 @@ type HTTPHeader struct {
 
 // CTResolver is a Resolver responsible for resolution of a given domain
 // to a list of CT logs.
+//
+// Backends are tried in order until one of them answers (crt.sh by default).
+// If ExpectedIssuers are given, any log of a certificate issued by someone else
+// is flagged (see CTAggregatedLog.UnexpectedIssuer).
+// MaxLogs caps the number of logs decoded per query (0 means no limit).
+//
+// If FetchCerts is set, the actual certificate of each aggregated log is downloaded
+// from crt.sh and parsed (at most MaxCerts per query, 0 means no limit). Logs coming
+// from other backends carry no crt.sh ID and are skipped.
+//
+// Logs can be filtered by validity of their certificates: OnlyCurrentlyValid keeps
+// only certificates valid right now, NotAfterBefore (unless zero) keeps only certificates
+// expiring before it. Logs with missing or malformed dates are kept.
 type CTResolver struct {
 	DomainResolver
-	Client        *http.Client
-	cachedResults map[string]*CTResolution
+	Backends           []CTBackend
+	ExpectedIssuers    []string
+	MaxLogs            int
+	FetchCerts         bool
+	MaxCerts           int
+	OnlyCurrentlyValid bool
+	NotAfterBefore     time.Time
+	Client             *http.Client
+	Clock              Clock
+	cachedResults      map[string]*CTResolution
+	cacheMutex         sync.RWMutex
+}
+
+// CTBackend is an API contract for CT log search services.
+type CTBackend interface {
+	Name() string // Returns a human-readable name of the service.
+
+	// FetchLogs returns logs of certificates issued for a given domain (and its subdomains),
+	// aggregated by names. At most maxLogs logs are fetched (0 means no limit).
+	// Logs older than a given date (YYYY-MM-DD) are skipped.
+	FetchLogs(ctx context.Context, client *http.Client, domain string, maxLogs int, from string) ([]CTAggregatedLog, error)
+}
+
+// CrtShBackend is a CTBackend querying crt.sh (see CTApiUrl).
+type CrtShBackend struct {
+	CTBackend
+}
+
+// CertSpotterBackend is a CTBackend querying SSLMate's Cert Spotter API.
+// Token is optional, though unauthenticated queries are heavily rate-limited.
+type CertSpotterBackend struct {
+	CTBackend
+	ApiUrl string
+	Token  string
+}
+
+// CensysBackend is a CTBackend querying Censys Search (certificates) API.
+// ApiID and ApiSecret are required.
+type CensysBackend struct {
+	CTBackend
+	ApiUrl    string
+	ApiID     string
+	ApiSecret string
 }
 
 // CTResolution is a certificate transparency project resolution, which yields a CT log.
 type CTResolution struct {
 	*ResolutionBase
-	Logs []CTAggregatedLog
+	Logs         []CTAggregatedLog
+	Certificates []CTCertificate
+}
+
+// CTCertificate is a certificate downloaded for a CT log (see CTResolver.FetchCerts).
+type CTCertificate struct {
+	x509.Certificate
+	LogId int64
 }
 
 // CTAggregatedLog is a wrapper of a CT log that is aggregated over all logs
 // with the same CN in time.
 type CTAggregatedLog struct {
 	CTLog
-	FirstSeen string
-	LastSeen  string
+	FirstSeen        string
+	LastSeen         string
+	UnexpectedIssuer bool
 }
 
 // CTLog is a wrapper for attributes of interest that appear in the CT log.
@@ -259,6 +617,79 @@ type CTLog struct {
 	NotAfter   string `json:"not_after"`
 }
 
+/////////////////////////////////////////
+// PASSIVE DNS
+/////////////////////////////////////////
+
+// PassiveDNSResolver is a Resolver responsible for resolution of a given domain
+// to a list of its subdomains observed by a passive DNS database in the past.
+//
+// Passive DNS databases are external (and mostly paid) services, so this resolver
+// is not used by default and its default Source knows no subdomains (see NoopPassiveDNSSource).
+// Only subdomains of the query are kept, the crawl decides which of them to follow.
+type PassiveDNSResolver struct {
+	DomainResolver
+	Source        PassiveDNSSource
+	cachedResults map[string]*PassiveDNSResolution
+	cacheMutex    sync.RWMutex
+}
+
+// PassiveDNSSource is an API contract for passive DNS databases (e.g. SecurityTrails or DNSDB).
+type PassiveDNSSource interface {
+	Name() string // Returns a human-readable name of the database.
+
+	// FetchSubdomains returns subdomains of a given domain the database has ever observed.
+	FetchSubdomains(ctx context.Context, domain string) ([]string, error)
+}
+
+// NoopPassiveDNSSource is a PassiveDNSSource which knows no subdomains.
+type NoopPassiveDNSSource struct {
+	PassiveDNSSource
+}
+
+// PassiveDNSResolution is a passive DNS resolution yielding subdomains of the query.
+type PassiveDNSResolution struct {
+	*ResolutionBase
+	Source     string
+	Subdomains []string
+}
+
+/////////////////////////////////////////
+// BRUTE FORCE
+/////////////////////////////////////////
+
+// BruteForceResolver is a Resolver which guesses subdomains of a given domain by prefixing it
+// with each word of a Wordlist (e.g. "www", "mail" or "dev") and keeps those resolving to an A
+// or AAAA record.
+//
+// It is not used by default (see WithBruteForce). Only registrable domains (i.e. directly under
+// a public suffix, e.g. "example.com" or "example.co.uk") are brute forced, each of them once.
+// At most MaxConcurrency guesses are resolved at once. Guesses resolving to the same addresses
+// as a random label are products of a wildcard record and are dropped. The queries carry DNS
+// cookies if Cookies is set and are padded to PaddingBlockSize (0 means no padding).
+type BruteForceResolver struct {
+	DomainResolver
+	Wordlist         []string
+	MaxConcurrency   int
+	Cookies          bool
+	PaddingBlockSize int
+	NameServer       string
+	Client           *dns.Client
+	DialContext      DialContextFunc
+	clientCookie     string
+	resolvedDomains  map[string]bool
+	cacheMutex       sync.Mutex
+	limiter          limiter
+	rateLimiter      *rateLimiter
+}
+
+// BruteForceResolution is a brute-force resolution of a domain yielding the guessed subdomains
+// along with their addresses.
+type BruteForceResolution struct {
+	*ResolutionBase
+	Hosts []Host
+}
+
 /////////////////////////////////////////
 // BGP
 /////////////////////////////////////////
@@ -268,10 +699,24 @@ type CTLog struct {
 //
 // Internally this resolver is leveraging a DNS interface of
 // IP-to-ASN lookup service by Team Cymru.
+//
+// Reverse lookups (ASN to announced prefixes) are done via WHOIS
+// of a routing registry (see ASNWhoisServer).
+//
+// If the DNS lookup fails (e.g. the network is blocked) and there is a MaxMind
+// GeoLite2-ASN DB at ASNDBPath, the AS record is looked up there instead.
+// The DB is opened once (so ASNDBPath must be set before the first lookup)
+// and shared by concurrent lookups until Close.
 type BGPResolver struct {
 	IPResolver
 	Client        *dns.Client
+	WhoisClient   *whois.Client
+	ASNDBPath     string
 	cachedResults map[string]*BGPResolution
+	cacheMutex    sync.RWMutex
+	limiter       limiter
+	asnDB         *maxminddb.Reader
+	asnDBMutex    sync.Mutex
 }
 
 // BGPResolution is a BGP resolution of a given IP yielding AS records.
@@ -294,10 +739,42 @@ type ASRecord struct {
 /////////////////////////////////////////
 
 // GeoResolver is a Resolver which is able to resolve an IP to a geographical location.
+//
+// The location is looked up in a local DB via a Backend (see NewGeoBackend),
+// IP2Location by default.
 type GeoResolver struct {
 	IPResolver
+	Backend       GeoBackend
 	enabled       bool
 	cachedResults map[string]*GeoResolution
+	cacheMutex    sync.RWMutex
+}
+
+// GeoBackend is an API contract for GeoIP databases.
+type GeoBackend interface {
+	Name() string                         // Returns a human-readable name of the DB vendor.
+	Path() string                         // Returns a path to the DB file.
+	Check() error                         // Returns an error if the DB cannot be used.
+	Lookup(ip string) (*GeoRecord, error) // Returns a record of a given IP (nil if unknown).
+	Close() error                         // Releases the DB (if open).
+}
+
+// IP2LocationBackend is a GeoBackend reading IP2Location BIN files.
+// The DB is opened once and shared by concurrent lookups.
+type IP2LocationBackend struct {
+	GeoBackend
+	DBPath  string
+	db      *ip2location.DB
+	dbMutex sync.Mutex
+}
+
+// MaxMindBackend is a GeoBackend reading MaxMind GeoLite2/GeoIP2 Country or City mmdb files.
+// The DB is opened once and shared by concurrent lookups.
+type MaxMindBackend struct {
+	GeoBackend
+	DBPath  string
+	db      *geoip2.Reader
+	dbMutex sync.Mutex
 }
 
 // GeoResolution is a GeoIP resolution of a given IP yielding geographical records.
@@ -307,6 +784,90 @@ type GeoResolution struct {
 }
 
 // GeoRecord contains information about a geographical location.
+// Only CountryCode is guaranteed, the rest depends on the DB in use
+// (e.g. IP2Location DB11 or MaxMind City) and is empty if not available.
 type GeoRecord struct {
 	CountryCode string
+	Region      string
+	City        string
+	Latitude    float64
+	Longitude   float64
+	ASN         uint32
+}
+
+/////////////////////////////////////////
+// IP WHOIS
+/////////////////////////////////////////
+
+// IPWhoisResolver is a Resolver responsible for resolution of a given IP
+// to a netblock record of the responsible RIR (ARIN, RIPE, APNIC, ...).
+//
+// The RIR is found by following referrals from IANA's WHOIS.
+type IPWhoisResolver struct {
+	IPResolver
+	Client        *whois.Client
+	cachedResults map[string]*IPWhoisResolution
+	cacheMutex    sync.RWMutex
+}
+
+// IPWhoisResolution is an IP WHOIS resolution of a given IP yielding a netblock record.
+type IPWhoisResolution struct {
+	*ResolutionBase
+	Record *IPWhoisRecord
+}
+
+// IPWhoisRecord contains selected properties of a netblock registered with an RIR.
+type IPWhoisRecord struct {
+	Range        string
+	CIDR         string
+	NetName      string
+	Organization string
+	Abuse        string
+	Country      string
+	Registry     string
+}
+
+/////////////////////////////////////////
+// PTR
+/////////////////////////////////////////
+
+// PTRResolver is a Resolver which is able to resolve an IP to its PTR records
+// (reverse DNS) and confirm them by forward lookups (FCrDNS).
+type PTRResolver struct {
+	IPResolver
+	NameServer    string
+	Client        *dns.Client
+	cachedResults map[string]*PTRResolution
+	cacheMutex    sync.RWMutex
+	limiter       limiter
+}
+
+// PTRConsistency is an enumeration type for verdicts of reverse/forward DNS consistency.
+type PTRConsistency string
+
+const (
+	// PTRConsistent means that some PTR name of the IP resolves back to it.
+	PTRConsistent PTRConsistency = "Consistent"
+
+	// PTRMismatch means that no PTR name of the IP resolves back to it.
+	PTRMismatch PTRConsistency = "Mismatch"
+
+	// PTRNone means that the IP has no PTR record at all.
+	PTRNone PTRConsistency = "NoPTR"
+)
+
+// PTRResolution is a reverse DNS resolution of a given IP yielding PTR records
+// and a verdict of their consistency with forward DNS.
+type PTRResolution struct {
+	*ResolutionBase
+	Records     []PTRRecord
+	Consistency PTRConsistency
+}
+
+// PTRRecord is a PTR name of an IP along with addresses it resolves to.
+// Confirmed is set if the addresses include the IP.
+type PTRRecord struct {
+	Name       string
+	ForwardIPs []string
+	Confirmed  bool
 }
@@ -1,13 +1,27 @@
 package udig
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"github.com/domainr/whois"
 	"github.com/miekg/dns"
+	"github.com/oschwald/maxminddb-golang"
 	"net"
+	"os"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 )
 
+// ASNWhoisServer is a routing registry WHOIS server used for ASN->prefix lookups.
+var ASNWhoisServer = "whois.radb.net"
+
+// ASNDBPath is a path to MaxMind GeoLite2-ASN DB file used as a fallback of DNS lookups.
+var ASNDBPath = findGeoipDatabase("GeoLite2-ASN.mmdb")
+
 var (
 	// For parsing ASN records, eg. "13335 | 104.28.16.0/20 | US | arin | 2014-03-28"
 	asnRecordPattern = regexp.MustCompile(`([0-9]+) \| (.+) \| ([A-Z]+) \| (.+) \| (.+)`)
@@ -16,11 +30,12 @@ var (
 )
 
 // lookupASN uses Team Cymru's IP->ASN lookup via DNS, returns matching ASN records.
-func lookupASN(ip string, client *dns.Client) (asnRecords []string) {
+// The returned error is set only for actual failures, an unknown IP is just no records.
+func lookupASN(ip string, client *dns.Client, limiter limiter) (asnRecords []string, err error) {
 	ipAddr := net.ParseIP(ip)
 	if ipAddr == nil {
 		LogErr("%s: IP %s is invalid.", TypeBGP, ip)
-		return asnRecords
+		return asnRecords, fmt.Errorf("invalid IP %s", ip)
 	}
 
 	var query string
@@ -30,14 +45,14 @@ func lookupASN(ip string, client *dns.Client) (asnRecords []string) {
 		query = fmt.Sprintf("%s.origin6.asn.cymru.com", reverseIPv6(ipAddr))
 	}
 
-	msg, err := queryOneCallback(query, dns.TypeTXT, localNameServer, client)
+	msg, err := limiter.query(context.Background(), query, dns.TypeTXT, getLocalNameServer(), client)
 	if err != nil {
 		if err.Error() == "NXDOMAIN" {
 			LogDebug("%s: No ASN record found for IP %s (query %s).", TypeBGP, ip, query)
-		} else {
-			LogErr("%s: Could not query BGP endpoint (TXT %s). The cause was: %s", TypeBGP, query, err.Error())
+			return asnRecords, nil
 		}
-		return asnRecords
+		LogErr("%s: Could not query BGP endpoint (TXT %s). The cause was: %s", TypeBGP, query, err.Error())
+		return asnRecords, fmt.Errorf("TXT %s: %s", query, err.Error())
 	}
 
 	for _, record := range msg.Answer {
@@ -52,14 +67,46 @@ func lookupASN(ip string, client *dns.Client) (asnRecords []string) {
 		}
 	}
 
-	return asnRecords
+	return asnRecords, nil
+}
+
+// asnDBRecord is a record of MaxMind GeoLite2-ASN DB.
+type asnDBRecord struct {
+	ASN          uint32 `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// lookupASNInDB looks up a given IP in a given MaxMind ASN DB,
+// returns a matching AS record or nil.
+func lookupASNInDB(ip string, db *maxminddb.Reader) (*ASRecord, error) {
+	ipAddr := net.ParseIP(ip)
+	if ipAddr == nil {
+		return nil, fmt.Errorf("invalid IP %s", ip)
+	}
+
+	var record asnDBRecord
+	network, ok, err := db.LookupNetwork(ipAddr, &record)
+	if err != nil {
+		LogErr("%s: Could not query ASN DB for IP %s. The cause was: %s", TypeBGP, ip, err.Error())
+		return nil, err
+	}
+	if !ok || record.ASN == 0 {
+		LogDebug("%s: No ASN record found in DB for IP %s.", TypeBGP, ip)
+		return nil, nil
+	}
+
+	return &ASRecord{
+		Name:      record.Organization,
+		ASN:       record.ASN,
+		BGPPrefix: network.String(),
+	}, nil
 }
 
 // lookupAS uses Team Cymru's ASN->AS lookup via DNS, returns a matching ASN record or "".
-func lookupAS(asn uint32, client *dns.Client) string {
+func lookupAS(asn uint32, client *dns.Client, limiter limiter) string {
 	query := fmt.Sprintf("AS%d.asn.cymru.com", asn)
 
-	msg, err := queryOneCallback(query, dns.TypeTXT, localNameServer, client)
+	msg, err := limiter.query(context.Background(), query, dns.TypeTXT, getLocalNameServer(), client)
 	if err != nil {
 		if err.Error() == "NXDOMAIN" {
 			LogDebug("%s: No AS record found for AS%d (query %s).", TypeBGP, asn, query)
@@ -124,6 +171,57 @@ func parseASName(asRecord string) string {
 	return groups[5]
 }
 
+// lookupPrefixes queries the routing registry for route objects originated by a given AS,
+// returns a raw WHOIS response or nil.
+func lookupPrefixes(asn uint32, client *whois.Client) []byte {
+	request := &whois.Request{Query: fmt.Sprintf("-i origin AS%d", asn), Host: ASNWhoisServer}
+	if err := request.Prepare(); err != nil {
+		LogErr("%s: Could not prepare prefix query for AS%d. The cause was: %s", TypeBGP, asn, err.Error())
+		return nil
+	}
+
+	response, err := client.Fetch(request)
+	if err != nil {
+		LogErr("%s: Could not query %s for AS%d prefixes. The cause was: %s", TypeBGP, ASNWhoisServer, asn, err.Error())
+		return nil
+	}
+
+	return response.Body
+}
+
+// parseRoutePrefixes extracts unique IPv4 ("route:") and IPv6 ("route6:") prefixes
+// from a routing registry WHOIS response.
+func parseRoutePrefixes(response []byte) (prefixes []string) {
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(response))
+
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		if key != "route" && key != "route6" {
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(strings.TrimSpace(parts[1]))
+		if err != nil {
+			LogDebug("%s: Invalid route prefix '%s'. Skipping.", TypeBGP, parts[1])
+			continue
+		}
+
+		prefix := network.String()
+		if !seen[prefix] {
+			prefixes = append(prefixes, prefix)
+			seen[prefix] = true
+		}
+	}
+
+	return prefixes
+}
+
 /////////////////////////////////////////
 // BGP RESOLVER
 /////////////////////////////////////////
@@ -132,33 +230,120 @@ func parseASName(asRecord string) string {
 func NewBGPResolver() *BGPResolver {
 	return &BGPResolver{
 		Client:        &dns.Client{ReadTimeout: DefaultTimeout},
+		WhoisClient:   whois.NewClient(DefaultTimeout),
+		ASNDBPath:     ASNDBPath,
 		cachedResults: map[string]*BGPResolution{},
 	}
 }
 
 // ResolveIP resolves a given IP address to a list of corresponding AS records.
 func (resolver *BGPResolver) ResolveIP(ip string) Resolution {
+	resolver.cacheMutex.RLock()
 	resolution := resolver.cachedResults[ip]
+	resolver.cacheMutex.RUnlock()
 	if resolution != nil {
 		return resolution
 	}
 	resolution = &BGPResolution{ResolutionBase: &ResolutionBase{query: ip}}
-	resolver.cachedResults[ip] = resolution
+	defer resolver.cacheResult(ip, resolution)
 
-	results := lookupASN(ip, resolver.Client)
+	results, err := lookupASN(ip, resolver.Client, resolver.limiter)
+	if err != nil {
+		if record := resolver.lookupASNFallback(ip); record != nil {
+			resolution.Records = append(resolution.Records, *record)
+			return resolution
+		}
+		resolution.addError(err)
+	}
 	for _, result := range results {
 		asRecord := parseASNRecord(result)
 		if asRecord == nil {
 			continue
 		}
 
-		asRecord.Name = parseASName(lookupAS(asRecord.ASN, resolver.Client))
+		asRecord.Name = parseASName(lookupAS(asRecord.ASN, resolver.Client, resolver.limiter))
 		resolution.Records = append(resolution.Records, *asRecord)
 	}
+	sortASRecords(resolution.Records)
 
 	return resolution
 }
 
+// lookupASNFallback looks up a given IP in the ASN DB (if there is one), returns a matching AS record or nil.
+func (resolver *BGPResolver) lookupASNFallback(ip string) *ASRecord {
+	if resolver.ASNDBPath == "" {
+		return nil
+	}
+	if info, err := os.Stat(resolver.ASNDBPath); err != nil || info.IsDir() {
+		return nil
+	}
+
+	db, err := resolver.openASNDB()
+	if err != nil {
+		LogErr("%s: Could not open ASN DB. The cause was: %s", TypeBGP, err.Error())
+		return nil
+	}
+
+	LogDebug("%s: Falling back to ASN DB at '%s' for IP %s.", TypeBGP, resolver.ASNDBPath, ip)
+	record, _ := lookupASNInDB(ip, db)
+	return record
+}
+
+// openASNDB returns the ASN DB, opening it on the first call.
+// The reader is safe for concurrent lookups.
+func (resolver *BGPResolver) openASNDB() (*maxminddb.Reader, error) {
+	resolver.asnDBMutex.Lock()
+	defer resolver.asnDBMutex.Unlock()
+
+	if resolver.asnDB == nil {
+		db, err := maxminddb.Open(resolver.ASNDBPath)
+		if err != nil {
+			return nil, err
+		}
+		resolver.asnDB = db
+	}
+	return resolver.asnDB, nil
+}
+
+// Close closes the ASN DB (if open). It must not be called while lookups are in flight.
+func (resolver *BGPResolver) Close() (err error) {
+	resolver.asnDBMutex.Lock()
+	defer resolver.asnDBMutex.Unlock()
+
+	if resolver.asnDB != nil {
+		err = resolver.asnDB.Close()
+		resolver.asnDB = nil
+	}
+	return err
+}
+
+// ResolveASN resolves a given AS number to a list of announced IPv4 and IPv6 prefixes (CIDRs).
+func (resolver *BGPResolver) ResolveASN(asn uint32) (prefixes []string) {
+	as := fmt.Sprintf("AS%d", asn)
+
+	resolver.cacheMutex.RLock()
+	resolution := resolver.cachedResults[as]
+	resolver.cacheMutex.RUnlock()
+	if resolution == nil {
+		resolution = &BGPResolution{ResolutionBase: &ResolutionBase{query: as}}
+		for _, prefix := range parseRoutePrefixes(lookupPrefixes(asn, resolver.WhoisClient)) {
+			resolution.Records = append(resolution.Records, ASRecord{ASN: asn, BGPPrefix: prefix})
+		}
+		resolver.cacheResult(as, resolution)
+	}
+
+	for _, record := range resolution.Records {
+		prefixes = append(prefixes, record.BGPPrefix)
+	}
+	return prefixes
+}
+
+func (resolver *BGPResolver) cacheResult(ip string, resolution *BGPResolution) {
+	resolver.cacheMutex.Lock()
+	resolver.cachedResults[ip] = resolution
+	resolver.cacheMutex.Unlock()
+}
+
 // Type returns "BGP".
 func (resolver *BGPResolver) Type() ResolutionType {
 	return TypeBGP
@@ -173,6 +358,34 @@ func (res *BGPResolution) Type() ResolutionType {
 	return TypeBGP
 }
 
+// Raw returns the AS records as []ASRecord.
+func (res *BGPResolution) Raw() interface{} {
+	return res.Records
+}
+
+// String lists all the AS records (ordered by ASN and prefix), separated by "; ".
+func (res *BGPResolution) String() string {
+	records := make([]ASRecord, len(res.Records))
+	copy(records, res.Records)
+	sortASRecords(records)
+
+	entries := make([]string, 0, len(records))
+	for _, record := range records {
+		entries = append(entries, record.String())
+	}
+	return strings.Join(entries, "; ")
+}
+
+// sortASRecords orders given AS records by ASN and BGP prefix, so the output is stable.
+func sortASRecords(records []ASRecord) {
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].ASN != records[j].ASN {
+			return records[i].ASN < records[j].ASN
+		}
+		return records[i].BGPPrefix < records[j].BGPPrefix
+	})
+}
+
 /////////////////////////////////////////
 // AS RECORD
 /////////////////////////////////////////
@@ -0,0 +1,229 @@
+package udig
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseRoutePrefixes_By_IPv4_and_IPv6_route_objects(t *testing.T) {
+	// Setup.
+	response := []byte(`route:          104.16.0.0/13
+descr:          Cloudflare
+origin:         AS13335
+source:         RADB
+
+route:          104.16.0.0/13
+origin:         AS13335
+
+route6:         2606:4700::/32
+origin:         AS13335
+
+route:          not-a-prefix
+`)
+
+	// Execute.
+	prefixes := parseRoutePrefixes(response)
+
+	// Assert.
+	assert.Equal(t, []string{"104.16.0.0/13", "2606:4700::/32"}, prefixes)
+}
+
+func Test_When_ASN_is_cached_Then_prefixes_are_returned_from_cache(t *testing.T) {
+	// Setup.
+	resolver := NewBGPResolver()
+	resolver.WhoisClient = nil // Any fetch would panic.
+	resolver.cachedResults["AS13335"] = &BGPResolution{
+		ResolutionBase: &ResolutionBase{query: "AS13335"},
+		Records: []ASRecord{
+			{ASN: 13335, BGPPrefix: "104.16.0.0/13"},
+			{ASN: 13335, BGPPrefix: "2606:4700::/32"},
+		},
+	}
+
+	// Execute.
+	prefixes := resolver.ResolveASN(13335)
+
+	// Assert.
+	assert.Equal(t, []string{"104.16.0.0/13", "2606:4700::/32"}, prefixes)
+}
+
+// mmdbEncoder encodes MaxMind DB data section values (only the types needed below).
+type mmdbEncoder []byte
+
+func (enc mmdbEncoder) control(typ byte, size int) mmdbEncoder {
+	var extra []byte
+	if size >= 29 {
+		// Sizes up to 284 are stored in one extra byte.
+		size, extra = 29, []byte{byte(size - 29)}
+	}
+	if typ > 7 {
+		enc = append(enc, byte(size), typ-7)
+	} else {
+		enc = append(enc, typ<<5|byte(size))
+	}
+	return append(enc, extra...)
+}
+
+func (enc mmdbEncoder) str(value string) mmdbEncoder {
+	return append(enc.control(2, len(value)), value...)
+}
+
+func (enc mmdbEncoder) uint(typ byte, value uint64) mmdbEncoder {
+	var raw []byte
+	for ; value > 0; value >>= 8 {
+		raw = append([]byte{byte(value)}, raw...)
+	}
+	return append(enc.control(typ, len(raw)), raw...)
+}
+
+func (enc mmdbEncoder) double(value float64) mmdbEncoder {
+	raw := make([]byte, 8)
+	binary.BigEndian.PutUint64(raw, math.Float64bits(value))
+	return append(enc.control(3, 8), raw...)
+}
+
+// writeMMDB writes an IPv4 MaxMind DB of a given type with a single network
+// (mapped to given encoded data) to a given path.
+func writeMMDB(t *testing.T, path string, databaseType string, network string, data mmdbEncoder) {
+	_, ipNet, err := net.ParseCIDR(network)
+	assert.NoError(t, err)
+	ip := ipNet.IP.To4()
+	prefix, _ := ipNet.Mask.Size()
+
+	// Search tree: one node per prefix bit, the other branch of each is empty.
+	nodeCount := uint32(prefix)
+	var tree []byte
+	for i := 0; i < prefix; i++ {
+		next := uint32(i + 1)
+		if i == prefix-1 {
+			next = nodeCount + 16 // Pointer to the data section start.
+		}
+		records := [2]uint32{nodeCount, nodeCount}
+		records[(ip[i/8]>>(7-uint(i%8)))&1] = next
+		for _, record := range records {
+			raw := make([]byte, 4)
+			binary.BigEndian.PutUint32(raw, record)
+			tree = append(tree, raw[1:]...)
+		}
+	}
+
+	metadata := mmdbEncoder{}.control(7, 9).
+		str("binary_format_major_version").uint(5, 2).
+		str("binary_format_minor_version").uint(5, 0).
+		str("build_epoch").uint(9, 1).
+		str("database_type").str(databaseType).
+		str("description").control(7, 0).
+		str("ip_version").uint(5, 4).
+		str("languages").control(11, 0).
+		str("node_count").uint(6, uint64(nodeCount)).
+		str("record_size").uint(5, 24)
+
+	db := append(tree, make([]byte, 16)...)
+	db = append(db, data...)
+	db = append(db, "\xAB\xCD\xEFMaxMind.com"...)
+	db = append(db, metadata...)
+	assert.NoError(t, ioutil.WriteFile(path, db, 0644))
+}
+
+func Test_When_DNS_lookup_fails_Then_ASN_DB_is_used(t *testing.T) {
+	// Mock.
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		return nil, errors.New("i/o timeout")
+	}
+	dir, err := ioutil.TempDir("", "udig")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := filepath.Join(dir, "GeoLite2-ASN.mmdb")
+	writeMMDB(t, dbPath, "GeoLite2-ASN", "192.0.2.0/24", mmdbEncoder{}.control(7, 2).
+		str("autonomous_system_number").uint(6, 64496).
+		str("autonomous_system_organization").str("EXAMPLE-AS"))
+
+	// Setup.
+	resolver := NewBGPResolver()
+	resolver.ASNDBPath = dbPath
+
+	// Execute.
+	resolution := resolver.ResolveIP("192.0.2.10").(*BGPResolution)
+
+	// Assert.
+	assert.NoError(t, resolution.Error())
+	assert.Equal(t, []ASRecord{{Name: "EXAMPLE-AS", ASN: 64496, BGPPrefix: "192.0.2.0/24"}}, resolution.Records)
+}
+
+func Test_When_ASN_DB_is_used_Then_it_is_opened_once_until_Close(t *testing.T) {
+	// Mock.
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		return nil, errors.New("i/o timeout")
+	}
+	dir, err := ioutil.TempDir("", "udig")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := filepath.Join(dir, "GeoLite2-ASN.mmdb")
+	writeMMDB(t, dbPath, "GeoLite2-ASN", "192.0.2.0/24", mmdbEncoder{}.control(7, 2).
+		str("autonomous_system_number").uint(6, 64496).
+		str("autonomous_system_organization").str("EXAMPLE-AS"))
+
+	// Setup.
+	resolver := NewBGPResolver()
+	resolver.ASNDBPath = dbPath
+
+	// Execute.
+	first := resolver.lookupASNFallback("192.0.2.10")
+	db := resolver.asnDB
+	second := resolver.lookupASNFallback("192.0.2.20")
+	reused := resolver.asnDB == db
+	closeErr := resolver.Close()
+
+	// Assert.
+	assert.NotNil(t, db)
+	assert.True(t, reused)
+	assert.Equal(t, first, second)
+	assert.NoError(t, closeErr)
+	assert.Nil(t, resolver.asnDB)
+}
+
+func Test_When_DNS_lookup_fails_and_there_is_no_ASN_DB_Then_resolution_has_error(t *testing.T) {
+	// Mock.
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		return nil, errors.New("i/o timeout")
+	}
+
+	// Setup.
+	resolver := NewBGPResolver()
+	resolver.ASNDBPath = filepath.Join(os.TempDir(), "udig-missing.mmdb")
+
+	// Execute.
+	resolution := resolver.ResolveIP("192.0.2.10").(*BGPResolution)
+
+	// Assert.
+	assert.Error(t, resolution.Error())
+	assert.Empty(t, resolution.Records)
+}
+
+func Test_When_BGP_resolution_has_two_ASes_Then_output_lists_all_fields_in_order(t *testing.T) {
+	// Setup.
+	resolution := &BGPResolution{
+		ResolutionBase: &ResolutionBase{query: "192.0.2.10"},
+		Records: []ASRecord{
+			{Name: "EXAMPLE-B", ASN: 64500, BGPPrefix: "192.0.2.0/24", Registry: "ripencc", Allocated: "2012-01-02"},
+			{Name: "EXAMPLE-A", ASN: 64496, BGPPrefix: "192.0.0.0/16", Registry: "arin", Allocated: "2010-07-14"},
+		},
+	}
+
+	// Execute.
+	output := resolution.String()
+
+	// Assert.
+	assert.Equal(t, "ASN: 64496, AS: EXAMPLE-A, prefix: 192.0.0.0/16, registry: arin, allocated: 2010-07-14; "+
+		"ASN: 64500, AS: EXAMPLE-B, prefix: 192.0.2.0/24, registry: ripencc, allocated: 2012-01-02", output)
+}
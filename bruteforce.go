@@ -0,0 +1,201 @@
+package udig
+
+import (
+	"context"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+/////////////////////////////////////////
+// BRUTE FORCE RESOLVER
+/////////////////////////////////////////
+
+// NewBruteForceResolver creates a new BruteForceResolver trying a given wordlist.
+func NewBruteForceResolver(wordlist ...string) *BruteForceResolver {
+	return &BruteForceResolver{
+		Wordlist:        wordlist,
+		MaxConcurrency:  DefaultDNSMaxConcurrency,
+		Client:          &dns.Client{ReadTimeout: DefaultTimeout},
+		clientCookie:    newClientCookie(),
+		resolvedDomains: map[string]bool{},
+	}
+}
+
+// Type returns "BRUTE".
+func (resolver *BruteForceResolver) Type() ResolutionType {
+	return TypeBruteForce
+}
+
+// ResolveDomain tries each word of the wordlist as a subdomain of a given registrable domain
+// (e.g. "example.co.uk") and resolves to those which exist (and are not products of a wildcard record).
+// Once the context is cancelled no more guesses are tried.
+func (resolver *BruteForceResolver) ResolveDomain(ctx context.Context, domain string) Resolution {
+	resolution := &BruteForceResolution{
+		ResolutionBase: &ResolutionBase{query: domain},
+	}
+
+	if !isRegistrableDomain(domain) || !resolver.claim(domain) {
+		return resolution
+	}
+
+	ctx = withDNSDialContext(ctx, resolver.DialContext)
+	if resolver.Cookies {
+		ctx = withDNSCookie(ctx, resolver.clientCookie)
+	}
+	ctx = withDNSPadding(ctx, resolver.PaddingBlockSize)
+
+	nameServer := resolver.NameServer
+	if nameServer == "" {
+		nameServer = getLocalNameServer()
+	}
+
+	wildcardAddresses := map[string]bool{}
+	for _, address := range resolver.wildcardAddressesOf(ctx, domain, nameServer) {
+		wildcardAddresses[address] = true
+	}
+
+	// Keep the hosts in the order of the wordlist.
+	hosts := make([]*Host, len(resolver.Wordlist))
+	semaphore := resolver.newSemaphore()
+	var wg sync.WaitGroup
+
+	for i, word := range resolver.Wordlist {
+		if !resolver.rateLimiter.wait(ctx) || !acquire(ctx, semaphore) {
+			LogDebug("%s: Brute force of %s cancelled: %s", TypeBruteForce, domain, ctx.Err().Error())
+			break
+		}
+		wg.Add(1)
+		go func(i int, guess string) {
+			hosts[i] = resolver.resolveGuess(ctx, resolution, guess, nameServer)
+			<-semaphore
+			wg.Done()
+		}(i, word+"."+domain)
+	}
+	wg.Wait()
+
+	for _, host := range hosts {
+		if host == nil {
+			continue
+		}
+		if isWildcardHost(host, wildcardAddresses) {
+			LogDebug("%s: Domain %s is resolved by a wildcard record -> skipping.", TypeBruteForce, host.Name)
+			continue
+		}
+		resolution.Hosts = append(resolution.Hosts, *host)
+	}
+
+	if len(resolution.Hosts) > 0 {
+		LogDebug("%s: Guessed %d subdomains of %s.", TypeBruteForce, len(resolution.Hosts), domain)
+	}
+	return resolution
+}
+
+// claim marks a given domain as brute forced, returns false if it already was.
+func (resolver *BruteForceResolver) claim(domain string) bool {
+	resolver.cacheMutex.Lock()
+	defer resolver.cacheMutex.Unlock()
+
+	if resolver.resolvedDomains[domain] {
+		return false
+	}
+	resolver.resolvedDomains[domain] = true
+	return true
+}
+
+// resolveGuess returns a host of a given name with its A and AAAA addresses, or nil if there are none.
+// Unless the name simply does not exist, failures are recorded in a given resolution.
+func (resolver *BruteForceResolver) resolveGuess(ctx context.Context, resolution *BruteForceResolution, name string, nameServer string) *Host {
+	host := &Host{Name: name}
+
+	for _, qType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg, err := resolver.limiter.query(ctx, name, qType, nameServer, resolver.Client)
+		if err != nil {
+			if err.Error() == dns.RcodeToString[dns.RcodeNameError] {
+				// No such name, no point in asking for other types.
+				break
+			}
+			LogDebug("%s: %s %s -> %s", TypeBruteForce, dns.TypeToString[qType], name, err.Error())
+			resolution.addError(err)
+			continue
+		}
+
+		for _, rr := range msg.Answer {
+			switch record := rr.(type) {
+			case *dns.A:
+				host.IPv4s = append(host.IPv4s, record.A.String())
+				break
+			case *dns.AAAA:
+				host.IPv6s = append(host.IPv6s, record.AAAA.String())
+				break
+			}
+		}
+	}
+
+	if len(host.IPv4s) == 0 && len(host.IPv6s) == 0 {
+		return nil
+	}
+	return host
+}
+
+// wildcardAddressesOf returns A and AAAA addresses of a random label under a given domain.
+func (resolver *BruteForceResolver) wildcardAddressesOf(ctx context.Context, domain string, nameServer string) []string {
+	probe := resolver.resolveGuess(ctx, &BruteForceResolution{ResolutionBase: &ResolutionBase{}}, randomLabel()+"."+domain, nameServer)
+	if probe == nil {
+		// NXDOMAIN is what we hope for.
+		return nil
+	}
+	return append(probe.IPv4s, probe.IPv6s...)
+}
+
+func (resolver *BruteForceResolver) newSemaphore() chan struct{} {
+	size := resolver.MaxConcurrency
+	if size <= 0 {
+		size = 1
+	}
+	return make(chan struct{}, size)
+}
+
+// isWildcardHost tells if all addresses of a given host are among given wildcard addresses.
+func isWildcardHost(host *Host, wildcardAddresses map[string]bool) bool {
+	if len(wildcardAddresses) == 0 {
+		return false
+	}
+	for _, address := range append(append([]string{}, host.IPv4s...), host.IPv6s...) {
+		if !wildcardAddresses[address] {
+			return false
+		}
+	}
+	return true
+}
+
+/////////////////////////////////////////
+// BRUTE FORCE RESOLUTION
+/////////////////////////////////////////
+
+// Type returns "BRUTE".
+func (res *BruteForceResolution) Type() ResolutionType {
+	return TypeBruteForce
+}
+
+// Domains returns the guessed subdomains.
+func (res *BruteForceResolution) Domains() (domains []string) {
+	for _, host := range res.Hosts {
+		domains = append(domains, host.Name)
+	}
+	return domains
+}
+
+// IPs returns addresses of the guessed subdomains.
+func (res *BruteForceResolution) IPs() (ips []string) {
+	for _, host := range res.Hosts {
+		ips = append(ips, host.IPv4s...)
+		ips = append(ips, host.IPv6s...)
+	}
+	return ips
+}
+
+// Raw returns the guessed subdomains as []Host.
+func (res *BruteForceResolution) Raw() interface{} {
+	return res.Hosts
+}
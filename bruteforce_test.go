@@ -0,0 +1,137 @@
+package udig
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockBruteForceZone answers A queries from given names, names under a given wildcard domain
+// (if any) resolve to a given wildcard IP. All the queried names are recorded.
+func mockBruteForceZone(zone map[string]string, wildcardDomain string, wildcardIP string) *[]string {
+	var queried []string
+	var mutex sync.Mutex
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		mutex.Lock()
+		queried = append(queried, domain)
+		mutex.Unlock()
+
+		ip, ok := zone[domain]
+		if !ok && wildcardDomain != "" && strings.HasSuffix(domain, "."+wildcardDomain) {
+			ip, ok = wildcardIP, true
+		}
+		if !ok {
+			return nil, errors.New(dns.RcodeToString[dns.RcodeNameError])
+		}
+
+		msg := &dns.Msg{}
+		if qType == dns.TypeA {
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: dns.Fqdn(domain), Rrtype: dns.TypeA, Class: dns.ClassINET},
+				A:   net.ParseIP(ip),
+			})
+		}
+		return msg, nil
+	}
+	return &queried
+}
+
+func Test_When_wordlist_guesses_resolve_Then_they_are_discovered(t *testing.T) {
+	// Mock.
+	mockBruteForceZone(map[string]string{"www.example.com": "192.0.2.1", "dev.example.com": "192.0.2.2"}, "", "")
+
+	// Setup.
+	resolver := NewBruteForceResolver("www", "mail", "dev")
+	resolver.NameServer = "127.0.0.1:53"
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*BruteForceResolution)
+
+	// Assert.
+	assert.NoError(t, resolution.Error())
+	assert.Equal(t, []Host{
+		{Name: "www.example.com", IPv4s: []string{"192.0.2.1"}},
+		{Name: "dev.example.com", IPv4s: []string{"192.0.2.2"}},
+	}, resolution.Hosts)
+	assert.Equal(t, []string{"www.example.com", "dev.example.com"}, resolution.Domains())
+	assert.Equal(t, []string{"192.0.2.1", "192.0.2.2"}, resolution.IPs())
+}
+
+func Test_When_domain_has_wildcard_record_Then_guesses_resolved_by_it_are_dropped(t *testing.T) {
+	// Mock.
+	mockBruteForceZone(map[string]string{"www.example.com": "192.0.2.1"}, "example.com", "192.0.2.99")
+
+	// Setup.
+	resolver := NewBruteForceResolver("www", "mail", "dev")
+	resolver.NameServer = "127.0.0.1:53"
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*BruteForceResolution)
+
+	// Assert.
+	assert.Equal(t, []string{"www.example.com"}, resolution.Domains())
+}
+
+func Test_When_domain_is_subdomain_or_already_brute_forced_Then_nothing_is_queried(t *testing.T) {
+	// Mock.
+	queried := mockBruteForceZone(map[string]string{"www.example.com": "192.0.2.1"}, "", "")
+
+	// Setup.
+	resolver := NewBruteForceResolver("www")
+	resolver.NameServer = "127.0.0.1:53"
+	resolver.ResolveDomain(context.Background(), "example.com")
+	*queried = nil
+
+	// Execute.
+	again := resolver.ResolveDomain(context.Background(), "example.com")
+	subdomain := resolver.ResolveDomain(context.Background(), "www.example.com")
+
+	// Assert.
+	assert.Empty(t, *queried)
+	assert.Empty(t, again.Domains())
+	assert.Empty(t, subdomain.Domains())
+}
+
+func Test_When_WithBruteForce_is_used_Then_limits_and_name_server_apply_to_it(t *testing.T) {
+	// Setup.
+	dig := NewUdig(
+		WithDomainResolvers(),
+		WithBruteForce("www"),
+		WithMaxConcurrency(2),
+		WithRateLimit(10),
+		WithNameServer("127.0.0.1:53"),
+	).(*udigImpl)
+
+	// Assert.
+	assert.Len(t, dig.domainResolvers, 1)
+	resolver := dig.domainResolvers[0].(*BruteForceResolver)
+	assert.Equal(t, []string{"www"}, resolver.Wordlist)
+	assert.Equal(t, 2, cap(resolver.limiter))
+	assert.Equal(t, dig.rateLimiter, resolver.rateLimiter)
+	assert.Equal(t, "127.0.0.1:53", resolver.NameServer)
+}
+
+func Test_When_domain_is_under_multi_label_public_suffix_Then_it_is_brute_forced(t *testing.T) {
+	// Mock.
+	mockBruteForceZone(map[string]string{"www.example.co.uk": "192.0.2.1", "www.www.example.co.uk": "192.0.2.2"}, "", "")
+
+	// Setup.
+	resolver := NewBruteForceResolver("www")
+	resolver.NameServer = "127.0.0.1:53"
+
+	// Execute.
+	registrable := resolver.ResolveDomain(context.Background(), "example.co.uk")
+	subdomain := resolver.ResolveDomain(context.Background(), "www.example.co.uk")
+	suffix := resolver.ResolveDomain(context.Background(), "co.uk")
+
+	// Assert.
+	assert.Equal(t, []string{"www.example.co.uk"}, registrable.Domains())
+	assert.Empty(t, subdomain.Domains())
+	assert.Empty(t, suffix.Domains())
+}
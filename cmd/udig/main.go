@@ -1,15 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/akamensky/argparse"
 	"github.com/miekg/dns"
 	"github.com/netrixone/udig"
+	"github.com/netrixone/udig/graph"
 )
 
 const (
@@ -29,6 +35,28 @@ var (
 `
 )
 var outputJson = false
+var outputNDJSON = false
+var aggregateWhois = false
+var baselineFile = ""
+var certDir = ""
+var graphFormat = ""
+var graphFile = ""
+var graphOptions []graph.Option
+var options []udig.Option
+var maxRuntime time.Duration
+
+// newUdig creates the crawler (monkey patch).
+var newUdig = func() udig.Udig {
+	return udig.NewUdig(options...)
+}
+
+// newContext returns a context of a single run, cancelled after maxRuntime (if set).
+func newContext() (context.Context, context.CancelFunc) {
+	if maxRuntime > 0 {
+		return context.WithTimeout(context.Background(), maxRuntime)
+	}
+	return context.WithCancel(context.Background())
+}
 
 func resolve(domain string) {
 	// Some input checks.
@@ -37,24 +65,277 @@ func resolve(domain string) {
 		return
 	}
 
-	dig := udig.NewUdig()
-	resolutions := dig.Resolve(domain)
+	root := domain
+	if ascii, err := udig.ToASCIIDomain(domain); err == nil && ascii != domain {
+		udig.LogInfo("Resolving %s as %s.", domain, ascii)
+		root = ascii
+	}
+
+	// A single crawl feeds both the log and the graph.
+	ctx, cancel := newContext()
+	defer cancel()
+	resolutions := newUdig().Resolve(ctx, domain)
+	if ctx.Err() == context.DeadlineExceeded {
+		udig.LogErr("Max runtime of %s exceeded, the results are partial.", maxRuntime)
+	}
+	printDiagnostics(resolutions)
+
+	if certDir != "" {
+		writeCertificates(resolutions)
+	}
+
+	if graphFormat != "" {
+		if err := writeGraph(graph.Collect(root, resolutions, graphOptions...)); err != nil {
+			udig.LogErr("Could not emit the graph. The cause was: %s", err.Error())
+		}
+	}
+
+	if baselineFile != "" {
+		reportNew(resolutions)
+		return
+	}
+
+	if outputNDJSON {
+		// Streamed during the crawl already.
+		return
+	}
+	printResolutions(resolutions)
+}
+
+// resolveDomains resolves all domains in a given file ("-" for STDIN) as a batch,
+// sharing the resolvers and their caches. The results are reported in the order of the file.
+func resolveDomains(path string) {
+	input := os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			udig.LogErr("Could not open the domains file. The cause was: %s", err.Error())
+			return
+		}
+		defer file.Close()
+		input = file
+	}
+	entries, err := udig.ReadDomainList(input)
+	if err != nil {
+		udig.LogErr("Could not read the domains file. The cause was: %s", err.Error())
+		return
+	}
+
+	var domains []string
+	for _, domain := range entries {
+		if !isValidDomain(domain) {
+			udig.LogErr("'%s' does not appear like a valid domain to me -> skipping.", domain)
+			continue
+		}
+		domains = append(domains, domain)
+	}
+
+	ctx, cancel := newContext()
+	defer cancel()
+	results := udig.ResolveBatch(ctx, domains, options...)
+	if ctx.Err() == context.DeadlineExceeded {
+		udig.LogErr("Max runtime of %s exceeded, the results are partial.", maxRuntime)
+	}
+
+	var resolutions []udig.Resolution
+	for _, domain := range domains {
+		resolutions = append(resolutions, results[domain]...)
+	}
+	printDiagnostics(resolutions)
+
+	if certDir != "" {
+		writeCertificates(resolutions)
+	}
+
+	if graphFormat != "" {
+		// A single graph joining all the domains.
+		batchOptions := append([]graph.Option{graph.WithVirtualRoot(graph.DefaultVirtualRoot)}, graphOptions...)
+		if err := writeGraph(graph.CollectBatch(results, batchOptions...)[0]); err != nil {
+			udig.LogErr("Could not emit the graph. The cause was: %s", err.Error())
+		}
+	}
+
+	if baselineFile != "" {
+		reportNew(resolutions)
+		return
+	}
+
+	if outputNDJSON {
+		// Streamed during the crawl already.
+		return
+	}
+	for _, domain := range domains {
+		udig.LogInfo("=== %s (%d resolutions) ===", domain, len(results[domain]))
+		printResolutions(results[domain])
+	}
+}
+
+// printDiagnostics logs a summary of resolvers which failed or were disabled (see udig.Diagnose).
+func printDiagnostics(resolutions []udig.Resolution) {
+	diagnostics := udig.Diagnose(resolutions)
+	if len(diagnostics) == 0 {
+		return
+	}
+
+	entries := make([]string, 0, len(diagnostics))
+	for _, diagnostic := range diagnostics {
+		entries = append(entries, diagnostic.String())
+	}
+	udig.LogErr("Some resolvers came up short: %s", strings.Join(entries, "; "))
+}
+
+// writeCertificates saves all unique TLS certificates of given resolutions into certDir.
+func writeCertificates(resolutions []udig.Resolution) {
+	paths, err := udig.WriteCertificates(certDir, resolutions)
+	if err != nil {
+		udig.LogErr("Could not write the certificates. The cause was: %s", err.Error())
+	}
+	udig.LogInfo("%d certificates written to %s.", len(paths), certDir)
+}
+
+// reportNew logs domains and IPs missing in the baseline file, then adds them to it.
+// A missing baseline file is created, so the first run reports everything.
+func reportNew(resolutions []udig.Resolution) {
+	baseline := map[string]bool{}
+	if file, err := os.Open(baselineFile); err == nil {
+		baseline, err = udig.ReadBaseline(file)
+		file.Close()
+		if err != nil {
+			udig.LogErr("Could not read the baseline file. The cause was: %s", err.Error())
+			return
+		}
+	} else if !os.IsNotExist(err) {
+		udig.LogErr("Could not open the baseline file. The cause was: %s", err.Error())
+		return
+	}
 
+	domains, ips := udig.NewlyDiscovered(resolutions, baseline)
+	for _, domain := range domains {
+		if unicode := udig.ToUnicodeDomain(domain); unicode != domain {
+			udig.LogInfo("NEW domain: %s (%s)", domain, unicode)
+		} else {
+			udig.LogInfo("NEW domain: %s", domain)
+		}
+		baseline[domain] = true
+	}
+	for _, ip := range ips {
+		udig.LogInfo("NEW IP: %s", ip)
+		baseline[ip] = true
+	}
+	udig.LogInfo("%d new domains and %d new IPs since the baseline.", len(domains), len(ips))
+
+	items := make([]string, 0, len(baseline))
+	for item := range baseline {
+		items = append(items, item)
+	}
+	sort.Strings(items)
+
+	file, err := os.Create(baselineFile)
+	if err != nil {
+		udig.LogErr("Could not write the baseline file. The cause was: %s", err.Error())
+		return
+	}
+	if err = udig.WriteBaseline(file, items); err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err != nil {
+		udig.LogErr("Could not write the baseline file. The cause was: %s", err.Error())
+	}
+}
+
+// readWordlist reads subdomain prefixes (e.g. "www"), one per line, from a given file.
+func readWordlist(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return udig.ReadDomainList(file)
+}
+
+func resolveIPs(path string, crawl bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		udig.LogErr("Could not open the IPs file. The cause was: %s", err.Error())
+		return
+	}
+	ips, err := udig.ReadIPList(file)
+	file.Close()
+	if err != nil {
+		udig.LogErr("Could not read the IPs file. The cause was: %s", err.Error())
+		return
+	}
+
+	ctx, cancel := newContext()
+	defer cancel()
+	results := udig.ResolveIPBatch(ctx, ips, crawl, options...)
+	if ctx.Err() == context.DeadlineExceeded {
+		udig.LogErr("Max runtime of %s exceeded, the results are partial.", maxRuntime)
+	}
+	var resolutions []udig.Resolution
+	for _, ip := range ips {
+		resolutions = append(resolutions, results[ip]...)
+	}
+	printDiagnostics(resolutions)
+
+	if outputNDJSON {
+		// Streamed during the crawl already.
+		return
+	}
+
+	// Report in the order of the file.
+	for _, ip := range ips {
+		printResolutions(results[ip])
+	}
+}
+
+// printResolutions logs given resolutions in a human-readable form (or JSON payloads).
+func printResolutions(resolutions []udig.Resolution) {
 	for _, res := range resolutions {
 		switch res.Type() {
 		case udig.TypeDNS:
 			for _, rr := range (res).(*udig.DNSResolution).Records {
 				udig.LogInfo("%s: %s %s -> %s", res.Type(), dns.TypeToString[rr.QueryType], res.Query(), formatPayload(rr.Record))
 			}
+			for _, key := range (res).(*udig.DNSResolution).DKIMKeys {
+				udig.LogInfo("%s: DKIM %s._domainkey.%s -> %s", res.Type(), key.Selector, res.Query(), formatPayload(key.Record))
+			}
+			if (res).(*udig.DNSResolution).ServerCookie {
+				udig.LogInfo("%s: %s -> name server echoed a valid DNS cookie", res.Type(), res.Query())
+			}
 			break
 
 		case udig.TypeTLS:
 			for _, cert := range (res).(*udig.TLSResolution).Certificates {
 				udig.LogInfo("%s: %s -> %s", res.Type(), res.Query(), formatPayload(&cert))
 			}
+			if (res).(*udig.TLSResolution).UnexpectedIssuer {
+				udig.LogInfo("%s: %s -> certificate issued by an unexpected CA", res.Type(), res.Query())
+			}
+			if (res).(*udig.TLSResolution).CDNFronted {
+				udig.LogInfo("%s: %s -> fronted by CDN %s (shared certificate)", res.Type(), res.Query(), (res).(*udig.TLSResolution).CDNProvider)
+			} else if (res).(*udig.TLSResolution).HostnameMismatch {
+				udig.LogInfo("%s: %s -> certificate does not cover the domain", res.Type(), res.Query())
+			}
+			if len((res).(*udig.TLSResolution).Certificates) > 0 {
+				leaf := (res).(*udig.TLSResolution).Certificates[0]
+				if leaf.Expired {
+					udig.LogInfo("%s: %s -> certificate expired %d days ago", res.Type(), res.Query(), -leaf.DaysUntilExpiry)
+				} else if (res).(*udig.TLSResolution).IncompleteChain {
+					udig.LogInfo("%s: %s -> certificate chain is incomplete (missing intermediates)", res.Type(), res.Query())
+				} else if !(res).(*udig.TLSResolution).ChainValid {
+					udig.LogInfo("%s: %s -> certificate chain is not trusted (self-signed: %t)", res.Type(), res.Query(), leaf.SelfSigned)
+				}
+			}
 			break
 
 		case udig.TypeWHOIS:
+			if aggregateWhois {
+				// Summarized below.
+				break
+			}
 			for _, contact := range (res).(*udig.WhoisResolution).Contacts {
 				udig.LogInfo("%s: %s -> %s", res.Type(), res.Query(), formatPayload(&contact))
 			}
@@ -64,12 +345,48 @@ func resolve(domain string) {
 			for _, header := range (res).(*udig.HTTPResolution).Headers {
 				udig.LogInfo("%s: %s -> %s", res.Type(), res.Query(), formatPayload(&header))
 			}
+			if (res).(*udig.HTTPResolution).Downgrade {
+				udig.LogInfo("%s: %s -> served over plain HTTP without redirect to HTTPS", res.Type(), res.Query())
+			}
+			for _, domain := range (res).(*udig.HTTPResolution).InsecureDomains {
+				udig.LogInfo("%s: %s -> mixed content from %s", res.Type(), res.Query(), domain)
+			}
+			if location := (res).(*udig.HTTPResolution).Location; location != "" {
+				udig.LogInfo("%s: %s -> redirects to %s", res.Type(), res.Query(), location)
+			}
+			for _, redirect := range (res).(*udig.HTTPResolution).Redirects {
+				udig.LogInfo("%s: %s -> redirected via %s", res.Type(), res.Query(), redirect)
+			}
 			break
 
 		case udig.TypeCT:
 			for _, ctLog := range (res).(*udig.CTResolution).Logs {
 				udig.LogInfo("%s: %s -> %s", res.Type(), res.Query(), formatPayload(&ctLog))
 			}
+			for _, cert := range (res).(*udig.CTResolution).Certificates {
+				udig.LogInfo("%s: %s -> %s", res.Type(), res.Query(), formatPayload(&cert))
+			}
+			break
+
+		case udig.TypePassiveDNS:
+			for _, subdomain := range (res).(*udig.PassiveDNSResolution).Subdomains {
+				udig.LogInfo("%s: %s -> %s", res.Type(), res.Query(), subdomain)
+			}
+			break
+
+		case udig.TypeBruteForce:
+			for _, host := range (res).(*udig.BruteForceResolution).Hosts {
+				udig.LogInfo("%s: %s -> %s", res.Type(), res.Query(), formatPayload(&host))
+			}
+			break
+
+		case udig.TypeSPF:
+			if record := (res).(*udig.SPFResolution).Record; record != nil {
+				udig.LogInfo("%s: %s -> %s", res.Type(), res.Query(), formatPayload(record))
+			}
+			if (res).(*udig.SPFResolution).SPFTooManyLookups {
+				udig.LogInfo("%s: %s -> policy requires %d DNS lookups (max %d)", res.Type(), res.Query(), (res).(*udig.SPFResolution).Lookups, udig.SPFMaxLookups)
+			}
 			break
 
 		case udig.TypeBGP:
@@ -83,10 +400,117 @@ func resolve(domain string) {
 				udig.LogInfo("%s: %s -> %s", res.Type(), res.Query(), formatPayload((res).(*udig.GeoResolution).Record))
 			}
 			break
+
+		case udig.TypePTR:
+			for _, record := range (res).(*udig.PTRResolution).Records {
+				udig.LogInfo("%s: %s -> %s", res.Type(), res.Query(), formatPayload(&record))
+			}
+			if consistency := (res).(*udig.PTRResolution).Consistency; consistency != "" {
+				udig.LogInfo("%s: %s -> reverse/forward DNS: %s", res.Type(), res.Query(), consistency)
+			}
+			break
+
+		case udig.TypeIPWHOIS:
+			if (res).(*udig.IPWhoisResolution).Record != nil {
+				udig.LogInfo("%s: %s -> %s", res.Type(), res.Query(), formatPayload((res).(*udig.IPWhoisResolution).Record))
+			}
+			break
+		}
+	}
+
+	if aggregateWhois {
+		for _, summary := range udig.AggregateWhoisContacts(resolutions) {
+			udig.LogInfo("%s: %d domains -> %s", udig.TypeWHOIS, len(summary.Domains), formatPayload(&summary))
 		}
 	}
 }
 
+// ndjsonRecord is a self-describing line of the NDJSON output.
+type ndjsonRecord struct {
+	Type    udig.ResolutionType `json:"type"`
+	Query   string              `json:"query"`
+	Payload udig.Resolution     `json:"payload"`
+	Error   string              `json:"error,omitempty"`
+}
+
+// ndjsonMutex serializes lines of concurrent batch resolutions.
+var ndjsonMutex sync.Mutex
+
+// newNDJSONRecord describes a given resolution by its type, query and error (if any).
+func newNDJSONRecord(res udig.Resolution) ndjsonRecord {
+	record := ndjsonRecord{Type: res.Type(), Query: res.Query(), Payload: res}
+	if failed, ok := res.(interface{ Error() error }); ok && failed.Error() != nil {
+		record.Error = failed.Error().Error()
+	}
+	return record
+}
+
+// emitNDJSON writes a given resolution to STDOUT as a single line of JSON.
+func emitNDJSON(res udig.Resolution) {
+	writeNDJSON(os.Stdout, res)
+}
+
+// writeNDJSON writes a given resolution to a given writer as a single line of JSON.
+func writeNDJSON(w io.Writer, res udig.Resolution) {
+	line, err := json.Marshal(newNDJSONRecord(res))
+	if err != nil {
+		udig.LogErr("Could not marshal %s resolution of %s. The cause was: %s", res.Type(), res.Query(), err.Error())
+		return
+	}
+
+	ndjsonMutex.Lock()
+	defer ndjsonMutex.Unlock()
+	fmt.Fprintln(w, string(line))
+}
+
+// writeGraph emits a given graph to the graph file (if any) or STDOUT.
+func writeGraph(g *graph.Graph) error {
+	if graphFile == "" {
+		return emitGraph(os.Stdout, g)
+	}
+
+	file, err := os.Create(graphFile)
+	if err != nil {
+		return err
+	}
+	if err = emitGraph(file, g); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func emitGraph(w io.Writer, g *graph.Graph) error {
+	switch graphFormat {
+	case "json":
+		return g.EmitJSON(w)
+	case "html":
+		return g.EmitHTML(w)
+	case "cypher":
+		return g.EmitCypher(w)
+	case "graphml":
+		return g.EmitGraphML(w)
+	case "mermaid":
+		return g.EmitMermaid(w)
+	case "csv":
+		return g.EmitCSV(w)
+	case "dot":
+		return g.EmitDOT(w)
+	}
+	return fmt.Errorf("unsupported graph format %s", graphFormat)
+}
+
+// splitList splits a given comma separated list, skipping empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func isValidDomain(domain string) bool {
 	if len(domain) == 0 {
 		return false
@@ -112,18 +536,69 @@ func main() {
 	printVersion := parser.Flag("v", "version", &argparse.Options{Required: false, Help: "Print version and exit"})
 	beVerbose := parser.Flag("V", "verbose", &argparse.Options{Required: false, Help: "Be more verbose"})
 	beStrict := parser.Flag("s", "strict", &argparse.Options{Required: false, Help: "Strict domain relation (TLD match)"})
+	detectWildcards := parser.Flag("w", "wildcards", &argparse.Options{Required: false, Help: "Detect DNS wildcards (costs extra queries)"})
 	domain := parser.String("d", "domain", &argparse.Options{Required: false, Help: "Domain to resolve"})
+	domainsFile := parser.String("i", "domains-file", &argparse.Options{Required: false, Help: "File with domains to resolve (one per line, - for STDIN)"})
+	ipsFile := parser.String("", "ips-file", &argparse.Options{Required: false, Help: "File with IPs or CIDRs to resolve (one per line, IP resolvers only)"})
+	crawl := parser.Flag("", "crawl", &argparse.Options{Required: false, Help: "Crawl hostnames found in PTR records of the IPs file"})
+	privateIPs := parser.Flag("", "private-ips", &argparse.Options{Required: false, Help: "Resolve private and link-local IPs too (e.g. for internal recon)"})
+	runtimeLimit := parser.String("", "max-runtime", &argparse.Options{
+		Required: false,
+		Help:     "Stop resolving after a given time (e.g. 60s) and report partial results",
+		Validate: func(args []string) error {
+			_, err := time.ParseDuration(args[0])
+			return err
+		},
+	})
+	maxQueries := parser.Int("", "max-queries", &argparse.Options{Required: false, Help: "Stop crawling after a given number of unique domains and IPs"})
+	rateLimit := parser.Int("", "rate-limit", &argparse.Options{Required: false, Help: "Dispatch at most a given number of resolvers per second"})
+	bruteWordlist := parser.String("", "brute", &argparse.Options{Required: false, Help: "Guess subdomains by prefixes from a given wordlist file (one per line, costs lots of queries)"})
+	onlyResolvers := parser.String("", "only", &argparse.Options{Required: false, Help: "Use given resolvers only (comma separated, e.g. dns,ct), overrides --no-* flags"})
+	disabledResolvers := map[string]*bool{}
+	for _, name := range resolverNames {
+		disabledResolvers[name] = parser.Flag("", "no-"+name, &argparse.Options{Required: false, Help: "Disable the " + strings.ToUpper(name) + " resolver"})
+	}
+	whoisAggregate := parser.Flag("", "whois:aggregate", &argparse.Options{Required: false, Help: "Aggregate WHOIS contacts of all domains by registrar and registrant"})
+	dnsPreset := parser.Selector("", "dns:preset", []string{"mail", "web", "security", "all"}, &argparse.Options{Required: false, Help: "Query a preset of DNS record types only (mail, web, security or all)"})
+	dnsCookies := parser.Flag("", "dns:cookies", &argparse.Options{Required: false, Help: "Send DNS cookies to make the answers harder to spoof"})
+	dnsPadding := parser.Flag("", "dns:padding", &argparse.Options{Required: false, Help: "Pad DNS queries to uniform sizes to hide them from on-path observers"})
+	dnsSPFIPs := parser.Flag("", "dns:spf-ips", &argparse.Options{Required: false, Help: "Take IPs of TXT records from SPF policies only (e.g. not from DKIM keys)"})
+	dnsAXFR := parser.Flag("", "dns:axfr", &argparse.Options{Required: false, Help: "Attempt zone transfers (AXFR) from authoritative name servers"})
+	dnsTXTRefs := parser.Flag("", "dns:txt-refs", &argparse.Options{Required: false, Help: "Follow domains delegated to by TXT records (SPF includes) even if unrelated"})
 	ctExpired := parser.Flag("", "ct:expired", &argparse.Options{Required: false, Help: "Collect expired CT logs"})
 	ctFrom := parser.String("", "ct:from", &argparse.Options{
 		Required: false,
-		Help:     "Date to collect logs from",
-		Default:  fmt.Sprintf("1 year ago (%s)", udig.CTLogFrom),
+		Help:     fmt.Sprintf("Date to collect logs from (default: 1 year ago, i.e. %s)", udig.CTLogFromDate(time.Now())),
 		Validate: func(args []string) error {
 			_, err := time.Parse("2006-01-02", args[0])
 			return err
 		},
 	})
+	ctValid := parser.Flag("", "ct:valid", &argparse.Options{Required: false, Help: "Collect CT logs of currently valid certificates only"})
+	ctExpiring := parser.String("", "ct:expiring", &argparse.Options{
+		Required: false,
+		Help:     "Collect CT logs of certificates expiring before a date only",
+		Validate: func(args []string) error {
+			_, err := time.Parse("2006-01-02", args[0])
+			return err
+		},
+	})
+	ctCerts := parser.Flag("", "ct:certs", &argparse.Options{Required: false, Help: "Download and parse certificates of CT logs (crt.sh only)"})
+	httpNoRedirects := parser.Flag("", "http:no-redirects", &argparse.Options{Required: false, Help: "Do not follow HTTP redirects, capture their targets instead"})
+	httpBody := parser.Flag("", "http:body", &argparse.Options{Required: false, Help: "Dissect domains from HTTP response bodies too"})
+	geoDB := parser.String("", "geo:db", &argparse.Options{Required: false, Help: "GeoIP DB file to use, IP2Location (BIN) or MaxMind (mmdb)"})
+	certificates := parser.String("", "cert-dir", &argparse.Options{Required: false, Help: "Save all unique TLS certificates into a given directory as PEM files"})
+	baseline := parser.String("", "baseline", &argparse.Options{Required: false, Help: "Report only domains and IPs not in a given file of a previous run, then add them to it"})
 	jsonOutput := parser.Flag("", "json", &argparse.Options{Required: false, Help: "Output payloads as JSON objects"})
+	ndjsonOutput := parser.Flag("", "ndjson", &argparse.Options{Required: false, Help: "Stream resolutions as JSON objects, one per line"})
+	graphOutput := parser.Selector("", "graph", graphFormats, &argparse.Options{Required: false, Help: "Output a graph of all the findings on STDOUT and the log on STDERR (json, html, cypher, graphml, mermaid, csv or dot)"})
+	outputPath := parser.String("o", "output", &argparse.Options{Required: false, Help: "Write the findings to a file and print the log on STDERR"})
+	outputFormat := parser.Selector("", "format", outputFormats, &argparse.Options{Required: false, Help: "Format of the output file: resolutions (json or ndjson) or a graph (html, cypher, graphml, mermaid, csv or dot), implied by the file extension by default"})
+	graphOutputFile := parser.String("", "graph:file", &argparse.Options{Required: false, Help: "Write the graph to a file and print the log as usual"})
+	graphDetails := parser.Flag("", "graph:details", &argparse.Options{Required: false, Help: "Record the raw value behind each graph edge"})
+	graphTypes := parser.String("", "graph:types", &argparse.Options{Required: false, Help: "Keep graph nodes of given types only (comma separated, e.g. domain,ip,as)"})
+	graphExclude := parser.String("", "graph:exclude", &argparse.Options{Required: false, Help: "Drop graph edges with given label prefixes (comma separated, e.g. WHOIS,CT)"})
+	serveAddress := parser.String("", "serve", &argparse.Options{Required: false, Help: "Serve an interactive graph of the crawl at a given address (e.g. :8080)"})
 
 	err := parser.Parse(os.Args)
 	if err != nil {
@@ -134,7 +609,7 @@ func main() {
 	if *printVersion {
 		fmt.Println(version)
 		os.Exit(0)
-	} else if *domain == "" {
+	} else if *domain == "" && *domainsFile == "" && *ipsFile == "" {
 		fmt.Fprint(os.Stderr, parser.Usage(err))
 		os.Exit(1)
 	}
@@ -149,16 +624,196 @@ func main() {
 		udig.IsDomainRelated = udig.StrictDomainRelation
 	}
 
+	if *runtimeLimit != "" {
+		maxRuntime, _ = time.ParseDuration(*runtimeLimit)
+	}
+
+	disabled := map[string]bool{}
+	for name, flag := range disabledResolvers {
+		disabled[name] = *flag
+	}
+	selection, err := selectResolvers(splitList(*onlyResolvers), disabled)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid resolver selection: "+err.Error()+".")
+		os.Exit(1)
+	}
+	// Narrow down the resolvers first, so that the other options configure them.
+	options = append(options, selection...)
+
+	if *bruteWordlist != "" {
+		wordlist, err := readWordlist(*bruteWordlist)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not read the wordlist: "+err.Error()+".")
+			os.Exit(1)
+		}
+		options = append(options, udig.WithBruteForce(wordlist...))
+	}
+
+	if *maxQueries > 0 {
+		options = append(options, udig.WithMaxQueries(*maxQueries))
+	}
+
+	if *privateIPs {
+		options = append(options, udig.WithIncludePrivateIPs(true))
+	}
+
+	if *rateLimit > 0 {
+		options = append(options, udig.WithRateLimit(*rateLimit))
+	}
+
+	if *detectWildcards {
+		options = append(options, udig.WithWildcardDetection())
+	}
+
+	if *dnsPreset != "" {
+		options = append(options, udig.WithDNSPreset(*dnsPreset))
+	}
+
+	if *dnsCookies {
+		options = append(options, udig.WithDNSCookies())
+	}
+
+	if *dnsPadding {
+		options = append(options, udig.WithObfuscatedQueries(udig.DefaultDNSPaddingBlockSize))
+	}
+
+	if *dnsTXTRefs {
+		options = append(options, udig.WithTXTReferences())
+	}
+
+	if *dnsSPFIPs {
+		options = append(options, udig.WithSPFOnlyTXTIPs())
+	}
+
+	if *dnsAXFR {
+		options = append(options, udig.WithZoneTransfer())
+	}
+
+	if *httpNoRedirects {
+		options = append(options, udig.WithoutRedirects())
+	}
+
+	if *httpBody {
+		options = append(options, udig.WithBodyScan(0))
+	}
+
+	if *geoDB != "" {
+		options = append(options, udig.WithGeoDatabase(*geoDB))
+	}
+
 	if *ctExpired {
 		udig.CTExclude = ""
 	}
 
+	if *ctValid || *ctExpiring != "" {
+		var notAfterBefore time.Time
+		if *ctExpiring != "" {
+			notAfterBefore, _ = time.Parse("2006-01-02", *ctExpiring)
+		}
+		options = append(options, udig.WithCTValidity(*ctValid, notAfterBefore))
+	}
+
+	if *ctCerts {
+		options = append(options, udig.WithCTCertificates(udig.DefaultCTMaxCerts))
+	}
+
 	if *ctFrom != "" {
 		udig.CTLogFrom = *ctFrom
 	}
 
 	outputJson = *jsonOutput
+	baselineFile = *baseline
+	certDir = *certificates
+	aggregateWhois = *whoisAggregate
+	outputNDJSON = *ndjsonOutput
+	var handlers []func(res udig.Resolution)
+	closeOutput := func() {}
+	if outputNDJSON {
+		handlers = append(handlers, emitNDJSON)
+	}
+	graphFormat = *graphOutput
+	graphFile = *graphOutputFile
+	if graphFile != "" && graphFormat == "" {
+		graphFormat = "json"
+	}
+	if *outputPath != "" {
+		format := outputFormatOf(*outputPath, *outputFormat)
+		if isGraphFormat(format) {
+			if graphFormat != "" {
+				fmt.Fprintln(os.Stderr, "The graph can only be output once, via either --graph or --output.")
+				os.Exit(1)
+			}
+			graphFormat, graphFile = format, *outputPath
+		} else {
+			out, err := createResolutionsFile(*outputPath, format)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not create the output file. The cause was: %s\n", err.Error())
+				os.Exit(1)
+			}
+			var closeOnce sync.Once
+			closeOutput = func() {
+				closeOnce.Do(func() {
+					if err := out.close(); err != nil {
+						udig.LogErr("Could not write the output file. The cause was: %s", err.Error())
+					}
+				})
+			}
+			defer closeOutput()
+			handlers = append(handlers, out.add)
+		}
+	}
+	if len(handlers) > 0 {
+		options = append(options, udig.WithResolutionHandler(handleAll(handlers...)))
+	}
+	if *graphDetails {
+		graphOptions = append(graphOptions, graph.WithEdgeDetails())
+	}
+	if *graphTypes != "" || *graphExclude != "" {
+		filter := graph.Filter{ExcludeLabels: splitList(*graphExclude)}
+		for _, nodeType := range splitList(*graphTypes) {
+			filter.IncludeTypes = append(filter.IncludeTypes, graph.NodeType(nodeType))
+		}
+		graphOptions = append(graphOptions, graph.WithFilter(filter))
+	}
 
-	fmt.Println(banner)
+	if *serveAddress != "" && graphFormat != "" {
+		fmt.Fprintln(os.Stderr, "The graph is served already, it cannot be output via --graph or --output too.")
+		os.Exit(1)
+	}
+
+	if graphFormat != "" && graphFile == "" && outputNDJSON {
+		fmt.Fprintln(os.Stderr, "The graph can only be combined with NDJSON output via --graph:file.")
+		os.Exit(1)
+	}
+
+	logOutput := os.Stdout
+	if (graphFormat != "" && graphFile == "") || *outputPath != "" {
+		// Keep STDOUT clean for the graph and machine-readable output, the log goes to STDERR instead.
+		logOutput = os.Stderr
+		udig.LogFile = logOutput
+	}
+
+	if outputNDJSON {
+		// Keep STDOUT clean for NDJSON.
+		udig.LogLevel = udig.LogLevelErr
+	} else {
+		fmt.Fprintln(logOutput, banner)
+	}
+
+	if *domainsFile != "" {
+		resolveDomains(*domainsFile)
+		return
+	}
+	if *ipsFile != "" {
+		resolveIPs(*ipsFile, *crawl)
+		return
+	}
+	if *serveAddress != "" {
+		if err := serve(*serveAddress, *domain, handlers, closeOutput); err != nil {
+			udig.LogErr("Could not serve the graph. The cause was: %s", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
 	resolve(*domain)
 }
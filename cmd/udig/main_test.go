@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/netrixone/udig"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockUdig crawls with a single CT resolver and counts the crawls.
+type mockUdig struct {
+	udig.Udig
+	crawls int
+}
+
+func (dig *mockUdig) Resolve(ctx context.Context, domain string) []udig.Resolution {
+	dig.crawls++
+	return []udig.Resolution{udig.NewCTResolver().ResolveDomain(ctx, domain)}
+}
+
+// captureStdout returns everything written to STDOUT while running a given function.
+func captureStdout(t *testing.T, run func()) string {
+	return captureFile(t, &os.Stdout, run)
+}
+
+// captureFile returns everything written to a given standard file (e.g. os.Stderr) while running a given function.
+func captureFile(t *testing.T, file **os.File, run func()) string {
+	reader, writer, err := os.Pipe()
+	assert.NoError(t, err)
+
+	original := *file
+	*file = writer
+	defer func() { *file = original }()
+
+	output := make(chan []byte)
+	go func() {
+		raw, _ := ioutil.ReadAll(reader)
+		output <- raw
+	}()
+
+	run()
+	writer.Close()
+	return string(<-output)
+}
+
+func Test_When_graph_file_is_set_Then_one_crawl_yields_both_log_and_graph(t *testing.T) {
+	// Mock.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"id": 1, "issuer_name": "CN=R3", "name_value": "api.example.com", "entry_timestamp": "2999-01-01T00:00:00"}]`))
+	}))
+	defer server.Close()
+
+	origURL := udig.CTApiUrl
+	udig.CTApiUrl = server.URL
+	defer func() { udig.CTApiUrl = origURL }()
+
+	dig := &mockUdig{}
+	newUdig = func() udig.Udig { return dig }
+
+	dir, err := ioutil.TempDir("", "udig")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Setup.
+	graphFormat = "json"
+	graphFile = filepath.Join(dir, "graph.json")
+	defer func() { graphFormat, graphFile = "", "" }()
+
+	// Execute.
+	output := captureStdout(t, func() { resolve("example.com") })
+
+	// Assert.
+	assert.Equal(t, 1, dig.crawls)
+	assert.Contains(t, output, "CT: example.com -> name: api.example.com")
+	graphJSON, err := ioutil.ReadFile(graphFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(graphJSON), `"id": "api.example.com"`)
+}
+
+func Test_When_graph_goes_to_STDOUT_Then_one_crawl_yields_the_graph_and_log_on_STDERR(t *testing.T) {
+	// Mock.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"id": 1, "issuer_name": "CN=R3", "name_value": "api.example.com", "entry_timestamp": "2999-01-01T00:00:00"}]`))
+	}))
+	defer server.Close()
+
+	origURL := udig.CTApiUrl
+	udig.CTApiUrl = server.URL
+	defer func() { udig.CTApiUrl = origURL }()
+
+	dig := &mockUdig{}
+	newUdig = func() udig.Udig { return dig }
+
+	// Setup.
+	graphFormat = "json"
+	defer func() { graphFormat, udig.LogFile = "", nil }()
+
+	// Execute.
+	var stdout string
+	stderr := captureFile(t, &os.Stderr, func() {
+		udig.LogFile = os.Stderr
+		stdout = captureStdout(t, func() { resolve("example.com") })
+	})
+
+	// Assert.
+	assert.Equal(t, 1, dig.crawls)
+	var g map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(stdout), &g))
+	assert.Equal(t, "example.com", g["root"])
+	assert.Contains(t, stderr, "CT: example.com -> name: api.example.com")
+}
+
+func Test_When_NDJSON_is_emitted_Then_each_resolution_is_a_self_describing_line(t *testing.T) {
+	// Setup.
+	resolutions := []udig.Resolution{
+		&udig.GeoResolution{ResolutionBase: &udig.ResolutionBase{}, Record: &udig.GeoRecord{CountryCode: "CZ"}},
+		&udig.PTRResolution{ResolutionBase: &udig.ResolutionBase{}, Consistency: udig.PTRNone},
+	}
+
+	// Execute.
+	output := captureStdout(t, func() {
+		for _, res := range resolutions {
+			emitNDJSON(res)
+		}
+	})
+
+	// Assert.
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	assert.Len(t, lines, 2)
+
+	var records []map[string]interface{}
+	for _, line := range lines {
+		var record map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	assert.Equal(t, "GEO", records[0]["type"])
+	assert.Equal(t, "CZ", records[0]["payload"].(map[string]interface{})["Record"].(map[string]interface{})["CountryCode"])
+	assert.Equal(t, "PTR", records[1]["type"])
+	assert.Contains(t, records[1], "query")
+	assert.NotContains(t, records[1], "error")
+}
+
+func Test_When_baseline_is_set_Then_second_run_reports_only_the_delta(t *testing.T) {
+	// Mock.
+	names := "api.example.com"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"id": 1, "issuer_name": "CN=R3", "name_value": "` + names + `", "entry_timestamp": "2999-01-01T00:00:00"}]`))
+	}))
+	defer server.Close()
+
+	origURL := udig.CTApiUrl
+	udig.CTApiUrl = server.URL
+	defer func() { udig.CTApiUrl = origURL }()
+
+	dig := &mockUdig{}
+	newUdig = func() udig.Udig { return dig }
+
+	dir, err := ioutil.TempDir("", "udig")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Setup.
+	baselineFile = filepath.Join(dir, "baseline.txt")
+	defer func() { baselineFile = "" }()
+
+	// Execute.
+	first := captureStdout(t, func() { resolve("example.com") })
+	names = `api.example.com\nmail.example.com`
+	second := captureStdout(t, func() { resolve("example.com") })
+
+	// Assert.
+	assert.Contains(t, first, "NEW domain: api.example.com")
+	assert.Contains(t, first, "NEW domain: example.com")
+	assert.NotContains(t, second, "NEW domain: api.example.com")
+	assert.NotContains(t, second, "NEW domain: example.com")
+	assert.Contains(t, second, "NEW domain: mail.example.com")
+	assert.Contains(t, second, "1 new domains and 0 new IPs since the baseline.")
+
+	raw, err := ioutil.ReadFile(baselineFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "api.example.com\nexample.com\nmail.example.com\n", string(raw))
+}
+
+func Test_When_resolvers_are_selected_Then_only_overrides_disabled_ones(t *testing.T) {
+	// Execute.
+	none, noneErr := selectResolvers(nil, map[string]bool{})
+	only, onlyErr := selectResolvers([]string{"DNS", "ct"}, map[string]bool{"dns": true})
+	unknown, unknownErr := selectResolvers([]string{"dns", "smtp"}, nil)
+	empty, emptyErr := selectResolvers(nil, map[string]bool{
+		"dns": true, "whois": true, "tls": true, "http": true, "ct": true, "spf": true,
+		"bgp": true, "geo": true, "ipwhois": true, "ptr": true,
+	})
+
+	// Assert.
+	assert.NoError(t, noneErr)
+	assert.Nil(t, none)
+	assert.NoError(t, onlyErr)
+	assert.Len(t, only, 2)
+	assert.EqualError(t, unknownErr, "unknown resolver smtp (expected one of dns, whois, tls, http, ct, spf, bgp, geo, ipwhois, ptr)")
+	assert.Nil(t, unknown)
+	assert.EqualError(t, emptyErr, "no resolvers selected")
+	assert.Nil(t, empty)
+}
+
+// slowUdig blocks until its context is done (or for a minute), then returns a single resolution.
+type slowUdig struct {
+	udig.Udig
+}
+
+func (dig *slowUdig) Resolve(ctx context.Context, domain string) []udig.Resolution {
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Minute):
+	}
+	return []udig.Resolution{&udig.GeoResolution{ResolutionBase: &udig.ResolutionBase{}, Record: &udig.GeoRecord{CountryCode: "CZ"}}}
+}
+
+func Test_When_max_runtime_is_set_Then_resolve_terminates_with_partial_results(t *testing.T) {
+	// Mock.
+	newUdig = func() udig.Udig { return &slowUdig{} }
+
+	// Setup.
+	maxRuntime = 50 * time.Millisecond
+	defer func() { maxRuntime = 0 }()
+
+	// Execute.
+	started := time.Now()
+	output := captureStdout(t, func() { resolve("example.com") })
+
+	// Assert.
+	assert.Less(t, int64(time.Since(started)), int64(5*time.Second))
+	assert.Contains(t, output, "GEO")
+}
+
+func Test_When_domains_file_is_given_Then_each_domain_is_reported_in_order(t *testing.T) {
+	// Mock.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"id": 1, "issuer_name": "CN=R3", "name_value": "cdn.thirdparty.io", "entry_timestamp": "2999-01-01T00:00:00"}]`))
+	}))
+	defer server.Close()
+
+	origURL := udig.CTApiUrl
+	udig.CTApiUrl = server.URL
+	defer func() { udig.CTApiUrl = origURL }()
+
+	dir, err := ioutil.TempDir("", "udig")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Setup.
+	path := filepath.Join(dir, "domains.txt")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("example.org\n# comment\nexample.com\n"), 0644))
+	options = []udig.Option{udig.WithDomainResolvers(udig.NewCTResolver()), udig.WithIPResolvers()}
+	defer func() { options = nil }()
+
+	// Execute.
+	output := captureStdout(t, func() { resolveDomains(path) })
+
+	// Assert.
+	org := strings.Index(output, "=== example.org (1 resolutions) ===")
+	com := strings.Index(output, "=== example.com (1 resolutions) ===")
+	if !assert.True(t, org >= 0 && com > org) {
+		return
+	}
+	assert.Contains(t, output[org:com], "CT: example.org -> name: cdn.thirdparty.io")
+	assert.Contains(t, output[com:], "CT: example.com -> name: cdn.thirdparty.io")
+}
+
+func Test_When_only_domains_file_is_given_Then_main_resolves_it(t *testing.T) {
+	// Mock.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"id": 1, "issuer_name": "CN=R3", "name_value": "cdn.thirdparty.io", "entry_timestamp": "2999-01-01T00:00:00"}]`))
+	}))
+	defer server.Close()
+
+	origURL := udig.CTApiUrl
+	udig.CTApiUrl = server.URL
+	defer func() { udig.CTApiUrl = origURL }()
+
+	origArgs, origLogLevel := os.Args, udig.LogLevel
+	defer func() { os.Args, udig.LogLevel, options = origArgs, origLogLevel, nil }()
+
+	dir, err := ioutil.TempDir("", "udig")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Setup.
+	path := filepath.Join(dir, "domains.txt")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("example.org\n"), 0644))
+	os.Args = []string{"udig", "-i", path, "--only", "ct"}
+
+	// Execute.
+	output := captureStdout(t, main)
+
+	// Assert.
+	assert.Contains(t, output, "=== example.org (1 resolutions) ===")
+	assert.Contains(t, output, "CT: example.org -> name: cdn.thirdparty.io")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/netrixone/udig"
+)
+
+// graphFormats are the supported formats of the graph output (see emitGraph).
+var graphFormats = []string{"json", "html", "cypher", "graphml", "mermaid", "csv", "dot"}
+
+// outputFormats are the supported formats of the output file: resolutions as a JSON array
+// or NDJSON lines, or a graph in any of graphFormats but JSON.
+var outputFormats = []string{"json", "ndjson", "html", "cypher", "graphml", "mermaid", "csv", "dot"}
+
+// outputFormatOf returns a given format of an output file, or one implied by its extension (JSON by default).
+func outputFormatOf(path string, format string) string {
+	if format != "" {
+		return format
+	}
+	extension := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	for _, known := range outputFormats {
+		if extension == known {
+			return known
+		}
+	}
+	return "json"
+}
+
+// isGraphFormat tells if a given output format is a graph one (i.e. not resolutions).
+func isGraphFormat(format string) bool {
+	return format != "json" && format != "ndjson"
+}
+
+// resolutionsFile collects resolutions of the crawl in a file, as a JSON array or NDJSON lines.
+type resolutionsFile struct {
+	file    *os.File
+	format  string
+	records []ndjsonRecord
+	mutex   sync.Mutex
+}
+
+func createResolutionsFile(path string, format string) (*resolutionsFile, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &resolutionsFile{file: file, format: format, records: []ndjsonRecord{}}, nil
+}
+
+// add writes a given resolution to the file (NDJSON) or keeps it for later (JSON, see close).
+// It is safe to be called concurrently.
+func (out *resolutionsFile) add(res udig.Resolution) {
+	if out.format == "ndjson" {
+		writeNDJSON(out.file, res)
+		return
+	}
+
+	out.mutex.Lock()
+	out.records = append(out.records, newNDJSONRecord(res))
+	out.mutex.Unlock()
+}
+
+// close writes all the kept resolutions to the file (JSON) and closes it.
+func (out *resolutionsFile) close() error {
+	if out.format == "json" {
+		out.mutex.Lock()
+		encoder := json.NewEncoder(out.file)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(out.records)
+		out.mutex.Unlock()
+		if err != nil {
+			out.file.Close()
+			return err
+		}
+	}
+	return out.file.Close()
+}
+
+// handleAll returns a resolution handler passing each resolution to all given handlers.
+func handleAll(handlers ...func(res udig.Resolution)) func(res udig.Resolution) {
+	return func(res udig.Resolution) {
+		for _, handler := range handlers {
+			handler(res)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/netrixone/udig"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_When_output_format_is_not_given_Then_it_is_implied_by_the_extension(t *testing.T) {
+	// Assert.
+	assert.Equal(t, "dot", outputFormatOf("results.DOT", ""))
+	assert.Equal(t, "ndjson", outputFormatOf("results.ndjson", ""))
+	assert.Equal(t, "json", outputFormatOf("results.txt", ""))
+	assert.Equal(t, "csv", outputFormatOf("results.json", "csv"))
+	assert.True(t, isGraphFormat("graphml"))
+	assert.False(t, isGraphFormat("ndjson"))
+}
+
+func Test_When_resolutions_file_is_JSON_Then_it_holds_an_array_of_all_resolutions(t *testing.T) {
+	// Setup.
+	dir, err := ioutil.TempDir("", "udig")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "results.json")
+
+	out, err := createResolutionsFile(path, "json")
+	assert.NoError(t, err)
+
+	// Execute.
+	out.add(&udig.GeoResolution{ResolutionBase: &udig.ResolutionBase{}, Record: &udig.GeoRecord{CountryCode: "CZ"}})
+	out.add(&udig.PTRResolution{ResolutionBase: &udig.ResolutionBase{}, Consistency: udig.PTRNone})
+	assert.NoError(t, out.close())
+
+	// Assert.
+	raw, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	var records []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(raw, &records))
+	assert.Len(t, records, 2)
+	assert.Equal(t, "GEO", records[0]["type"])
+	assert.Equal(t, "PTR", records[1]["type"])
+}
+
+func Test_When_resolutions_file_is_NDJSON_Then_each_resolution_is_a_line(t *testing.T) {
+	// Setup.
+	dir, err := ioutil.TempDir("", "udig")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "results.ndjson")
+
+	out, err := createResolutionsFile(path, "ndjson")
+	assert.NoError(t, err)
+
+	// Execute.
+	output := captureStdout(t, func() {
+		out.add(&udig.GeoResolution{ResolutionBase: &udig.ResolutionBase{}, Record: &udig.GeoRecord{CountryCode: "CZ"}})
+		out.add(&udig.PTRResolution{ResolutionBase: &udig.ResolutionBase{}, Consistency: udig.PTRNone})
+	})
+	assert.NoError(t, out.close())
+
+	// Assert.
+	raw, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(raw)), "\n"), 2)
+	assert.Empty(t, output)
+}
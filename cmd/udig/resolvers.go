@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/netrixone/udig"
+)
+
+// resolverNames lists all resolvers selectable by --only and --no-* flags (in the order of NewUdig).
+var resolverNames = []string{"dns", "whois", "tls", "http", "ct", "spf", "bgp", "geo", "ipwhois", "ptr"}
+
+var domainResolverFactories = map[string]func() udig.DomainResolver{
+	"dns":   func() udig.DomainResolver { return udig.NewDNSResolver() },
+	"whois": func() udig.DomainResolver { return udig.NewWhoisResolver() },
+	"tls":   func() udig.DomainResolver { return udig.NewTLSResolver() },
+	"http":  func() udig.DomainResolver { return udig.NewHTTPResolver() },
+	"ct":    func() udig.DomainResolver { return udig.NewCTResolver() },
+	"spf":   func() udig.DomainResolver { return udig.NewSPFResolver() },
+}
+
+var ipResolverFactories = map[string]func() udig.IPResolver{
+	"bgp":     func() udig.IPResolver { return udig.NewBGPResolver() },
+	"geo":     func() udig.IPResolver { return udig.NewGeoResolver() },
+	"ipwhois": func() udig.IPResolver { return udig.NewIPWhoisResolver() },
+	"ptr":     func() udig.IPResolver { return udig.NewPTRResolver() },
+}
+
+// selectResolvers returns options narrowing down the resolvers to given ones (if any),
+// otherwise to all but the disabled ones. Returns nil if there is nothing to narrow down.
+func selectResolvers(only []string, disabled map[string]bool) ([]udig.Option, error) {
+	enabled := map[string]bool{}
+	if len(only) > 0 {
+		for _, name := range only {
+			name = strings.ToLower(name)
+			if domainResolverFactories[name] == nil && ipResolverFactories[name] == nil {
+				return nil, fmt.Errorf("unknown resolver %s (expected one of %s)", name, strings.Join(resolverNames, ", "))
+			}
+			enabled[name] = true
+		}
+	} else {
+		anyDisabled := false
+		for _, name := range resolverNames {
+			enabled[name] = !disabled[name]
+			anyDisabled = anyDisabled || disabled[name]
+		}
+		if !anyDisabled {
+			return nil, nil
+		}
+	}
+
+	var domainResolvers []udig.DomainResolver
+	var ipResolvers []udig.IPResolver
+	for _, name := range resolverNames {
+		if !enabled[name] {
+			continue
+		}
+		if factory := domainResolverFactories[name]; factory != nil {
+			domainResolvers = append(domainResolvers, factory())
+		} else {
+			ipResolvers = append(ipResolvers, ipResolverFactories[name]())
+		}
+	}
+
+	if len(domainResolvers) == 0 && len(ipResolvers) == 0 {
+		return nil, fmt.Errorf("no resolvers selected")
+	}
+
+	return []udig.Option{udig.WithDomainResolvers(domainResolvers...), udig.WithIPResolvers(ipResolvers...)}, nil
+}
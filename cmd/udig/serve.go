@@ -0,0 +1,194 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/netrixone/udig"
+	"github.com/netrixone/udig/graph"
+)
+
+// graphServer serves the graph of a single crawl over HTTP, growing as the crawl streams.
+type graphServer struct {
+	root        string
+	resolutions []udig.Resolution
+	done        bool
+	mutex       sync.RWMutex
+}
+
+func newGraphServer(root string) *graphServer {
+	return &graphServer{root: root}
+}
+
+// serve crawls a given domain in the background and serves its graph at a given address.
+// The resolutions are passed to given handlers as well, finished is called once the crawl is done.
+func serve(address string, domain string, handlers []func(res udig.Resolution), finished func()) error {
+	server := newGraphServer(domain)
+	options = append(options, udig.WithResolutionHandler(handleAll(append(handlers, server.add)...)))
+
+	go func() {
+		server.crawl(domain)
+		finished()
+	}()
+
+	udig.LogInfo("Serving the graph of %s at http://%s/", domain, address)
+	return http.ListenAndServe(address, server.handler())
+}
+
+// crawl resolves a given domain, the resolutions are streamed to the server (see add).
+func (server *graphServer) crawl(domain string) {
+	ctx, cancel := newContext()
+	defer cancel()
+	resolutions := newUdig().Resolve(ctx, domain)
+	server.finish(resolutions)
+	udig.LogInfo("Crawl of %s finished with %d resolutions.", domain, len(resolutions))
+}
+
+// add appends a given resolution to the graph.
+func (server *graphServer) add(res udig.Resolution) {
+	server.mutex.Lock()
+	server.resolutions = append(server.resolutions, res)
+	server.mutex.Unlock()
+}
+
+// finish replaces the streamed resolutions with the final ones and marks the crawl done.
+func (server *graphServer) finish(resolutions []udig.Resolution) {
+	server.mutex.Lock()
+	server.resolutions = resolutions
+	server.done = true
+	server.mutex.Unlock()
+}
+
+// snapshot returns the graph of all the resolutions so far and whether the crawl is done.
+func (server *graphServer) snapshot() (*graph.Graph, bool) {
+	server.mutex.RLock()
+	resolutions := make([]udig.Resolution, len(server.resolutions))
+	copy(resolutions, server.resolutions)
+	done := server.done
+	server.mutex.RUnlock()
+
+	return graph.Collect(server.root, resolutions, graphOptions...), done
+}
+
+func (server *graphServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", server.serveViewer)
+	mux.HandleFunc("/graph.json", server.serveGraph)
+	return mux
+}
+
+func (server *graphServer) serveViewer(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(graphViewer))
+}
+
+// serveGraph responds with the graph as JSON, the X-Udig-Crawl header tells if the crawl is "running" or "done".
+func (server *graphServer) serveGraph(w http.ResponseWriter, r *http.Request) {
+	g, done := server.snapshot()
+
+	state := "running"
+	if done {
+		state = "done"
+	}
+	w.Header().Set("X-Udig-Crawl", state)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := g.EmitJSON(w); err != nil {
+		udig.LogErr("Could not emit the graph. The cause was: %s", err.Error())
+	}
+}
+
+// graphViewer is a static page polling the graph and drawing it by a simple force layout.
+const graphViewer = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>udig graph</title>
+<style>
+body { margin: 0; font-family: sans-serif; }
+#status { position: fixed; top: 8px; left: 8px; background: rgba(255,255,255,.8); padding: 4px 8px; }
+canvas { display: block; }
+</style>
+</head>
+<body>
+<div id="status">loading...</div>
+<canvas id="graph"></canvas>
+<script>
+var canvas = document.getElementById("graph"), ctx = canvas.getContext("2d");
+var colors = {domain: "#1f77b4", ip: "#2ca02c", as: "#9467bd", geo: "#8c564b", contact: "#e377c2", virtual: "#7f7f7f"};
+var nodes = {}, edges = [], hover = null;
+
+function resize() { canvas.width = window.innerWidth; canvas.height = window.innerHeight; }
+window.addEventListener("resize", resize);
+resize();
+
+function refresh() {
+	fetch("graph.json").then(function (res) {
+		var state = res.headers.get("X-Udig-Crawl");
+		return res.json().then(function (g) {
+			var next = {};
+			g.nodes.forEach(function (n) {
+				var old = nodes[n.id];
+				next[n.id] = {id: n.id, type: n.type, label: n.label,
+					x: old ? old.x : Math.random() * canvas.width, y: old ? old.y : Math.random() * canvas.height,
+					vx: 0, vy: 0};
+			});
+			nodes = next;
+			edges = g.edges.filter(function (e) { return nodes[e.from] && nodes[e.to]; });
+			document.getElementById("status").textContent = g.root + ": " + g.nodes.length + " nodes, " +
+				g.edges.length + " edges (" + state + ")";
+			if (state !== "done") {
+				setTimeout(refresh, 2000);
+			}
+		});
+	});
+}
+
+function step() {
+	var list = Object.keys(nodes).map(function (id) { return nodes[id]; });
+	list.forEach(function (a) {
+		list.forEach(function (b) {
+			if (a === b) return;
+			var dx = a.x - b.x, dy = a.y - b.y, d2 = dx * dx + dy * dy + 0.01;
+			a.vx += dx / d2 * 200; a.vy += dy / d2 * 200;
+		});
+		a.vx += (canvas.width / 2 - a.x) * 0.001; a.vy += (canvas.height / 2 - a.y) * 0.001;
+	});
+	edges.forEach(function (e) {
+		var a = nodes[e.from], b = nodes[e.to], dx = b.x - a.x, dy = b.y - a.y;
+		a.vx += dx * 0.01; a.vy += dy * 0.01; b.vx -= dx * 0.01; b.vy -= dy * 0.01;
+	});
+	list.forEach(function (n) { n.vx *= 0.8; n.vy *= 0.8; n.x += n.vx; n.y += n.vy; });
+
+	ctx.clearRect(0, 0, canvas.width, canvas.height);
+	ctx.strokeStyle = "#ccc";
+	edges.forEach(function (e) {
+		ctx.beginPath(); ctx.moveTo(nodes[e.from].x, nodes[e.from].y); ctx.lineTo(nodes[e.to].x, nodes[e.to].y); ctx.stroke();
+	});
+	list.forEach(function (n) {
+		ctx.fillStyle = colors[n.type] || "#000";
+		ctx.beginPath(); ctx.arc(n.x, n.y, 5, 0, 2 * Math.PI); ctx.fill();
+		if (n === hover || list.length < 50) { ctx.fillText(n.label, n.x + 7, n.y + 3); }
+	});
+	requestAnimationFrame(step);
+}
+
+canvas.addEventListener("mousemove", function (ev) {
+	hover = null;
+	Object.keys(nodes).forEach(function (id) {
+		var n = nodes[id];
+		if (Math.abs(n.x - ev.clientX) < 6 && Math.abs(n.y - ev.clientY) < 6) hover = n;
+	});
+});
+
+refresh();
+step();
+</script>
+</body>
+</html>
+`
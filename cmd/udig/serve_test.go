@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/netrixone/udig"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_When_graph_is_served_Then_endpoint_returns_valid_graph_JSON(t *testing.T) {
+	// Mock.
+	ctServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"id": 1, "issuer_name": "CN=R3", "name_value": "api.example.com", "entry_timestamp": "2999-01-01T00:00:00"}]`))
+	}))
+	defer ctServer.Close()
+
+	origURL := udig.CTApiUrl
+	udig.CTApiUrl = ctServer.URL
+	defer func() { udig.CTApiUrl = origURL }()
+
+	dig := &mockUdig{}
+	newUdig = func() udig.Udig { return dig }
+
+	// Setup.
+	server := newGraphServer("example.com")
+	httpServer := httptest.NewServer(server.handler())
+	defer httpServer.Close()
+
+	// Execute.
+	server.crawl("example.com")
+	res, err := http.Get(httpServer.URL + "/graph.json")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	assert.NoError(t, err)
+
+	viewer, err := http.Get(httpServer.URL + "/")
+	assert.NoError(t, err)
+	viewer.Body.Close()
+
+	// Assert.
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "done", res.Header.Get("X-Udig-Crawl"))
+
+	var g struct {
+		Root  string `json:"root"`
+		Nodes []struct {
+			ID string `json:"id"`
+		} `json:"nodes"`
+	}
+	assert.NoError(t, json.Unmarshal(body, &g))
+	assert.Equal(t, "example.com", g.Root)
+	var ids []string
+	for _, node := range g.Nodes {
+		ids = append(ids, node.ID)
+	}
+	assert.Contains(t, ids, "api.example.com")
+
+	assert.Equal(t, http.StatusOK, viewer.StatusCode)
+	assert.Contains(t, viewer.Header.Get("Content-Type"), "text/html")
+}
+
+func Test_When_crawl_is_running_Then_streamed_resolutions_are_served(t *testing.T) {
+	// Setup.
+	server := newGraphServer("example.com")
+	server.add(udig.NewGeoResolver().ResolveIP("192.0.2.1"))
+	httpServer := httptest.NewServer(server.handler())
+	defer httpServer.Close()
+
+	// Execute.
+	res, err := http.Get(httpServer.URL + "/graph.json")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+
+	// Assert.
+	assert.Equal(t, "running", res.Header.Get("X-Udig-Crawl"))
+	var g map[string]interface{}
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&g))
+	assert.Equal(t, "example.com", g["root"])
+}
+
+func Test_When_graph_is_served_Then_other_handlers_still_get_the_resolutions(t *testing.T) {
+	// Mock.
+	ctServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"id": 1, "issuer_name": "CN=R3", "name_value": "api.example.com", "entry_timestamp": "2999-01-01T00:00:00"}]`))
+	}))
+	defer ctServer.Close()
+
+	origURL := udig.CTApiUrl
+	udig.CTApiUrl = ctServer.URL
+	defer func() { udig.CTApiUrl = origURL }()
+
+	origNewUdig := newUdig
+	newUdig = func() udig.Udig { return udig.NewUdig(options...) }
+	options = []udig.Option{udig.WithDomainResolvers(udig.NewCTResolver()), udig.WithIPResolvers()}
+	defer func() { newUdig, options = origNewUdig, nil }()
+
+	// Setup.
+	var handled []udig.Resolution
+	finished := make(chan bool)
+
+	// Execute.
+	err := serve("127.0.0.1:-1", "example.com", []func(res udig.Resolution){func(res udig.Resolution) {
+		handled = append(handled, res)
+	}}, func() { close(finished) })
+	<-finished
+
+	// Assert.
+	assert.Error(t, err)
+	if assert.NotEmpty(t, handled) {
+		assert.Equal(t, "example.com", handled[0].Query())
+		assert.Contains(t, handled[0].Domains(), "api.example.com")
+	}
+}
@@ -1,12 +1,16 @@
 package udig
 
 import (
-	"crypto/tls"
+	"context"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,31 +18,58 @@ import (
 // CT RESOLVER
 /////////////////////////////////////////
 
-const DefaultCTApiUrl = "https://crt.sh"
+const (
+	DefaultCTApiUrl = "https://crt.sh"
+
+	// DefaultCTMaxCerts is a default max number of certificates downloaded per query.
+	DefaultCTMaxCerts = 100
+
+	// CTCertConcurrency is a max number of certificates downloaded at once per query.
+	CTCertConcurrency = 4
+
+	// DefaultCTLogMaxAge is a default max age of collected logs (see CTLogMaxAge).
+	DefaultCTLogMaxAge = 365 * 24 * time.Hour
+
+	// DefaultCTMaxResponseBytes is a default max size of a crt.sh response (see CTMaxResponseBytes).
+	DefaultCTMaxResponseBytes = 512 << 20
+
+	// DefaultCTMaxAggregatedLogs is a default max number of distinct names per query (see CTMaxAggregatedLogs).
+	DefaultCTMaxAggregatedLogs = 50000
+)
 
 var CTApiUrl = DefaultCTApiUrl
-var CTLogFrom = time.Now().AddDate(-1, 0, 0).Format("2006-01-02")
-var CTExclude = "expired"
 
-// NewCTResolver creates a new CTResolver with sensible defaults.
-func NewCTResolver() *CTResolver {
-	transport := http.DefaultTransport.(*http.Transport)
+// CTLogFrom is a fixed date (YYYY-MM-DD) to collect logs from. If empty (default),
+// logs not older than CTLogMaxAge at the time of each query are collected instead.
+var CTLogFrom = ""
 
-	transport.DialContext = (&net.Dialer{
-		Timeout:   DefaultTimeout,
-		KeepAlive: DefaultTimeout,
-	}).DialContext
+// CTLogMaxAge is a max age of collected logs, evaluated relative to each query (see CTLogFrom).
+var CTLogMaxAge = DefaultCTLogMaxAge
+var CTExclude = "expired"
 
-	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	transport.TLSHandshakeTimeout = DefaultTimeout
+// CTMaxResponseBytes is a max number of bytes read from a crt.sh response, the logs decoded
+// until then are kept. The response is streamed, so this caps the traffic rather than memory,
+// which grows with the number of distinct names instead (see CTMaxAggregatedLogs).
+var CTMaxResponseBytes int64 = DefaultCTMaxResponseBytes
 
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   DefaultTimeout,
+// CTMaxAggregatedLogs is a max number of distinct names aggregated per query (0 means no limit),
+// logs of any other names are dropped. This is what bounds the memory of huge responses.
+var CTMaxAggregatedLogs = DefaultCTMaxAggregatedLogs
+
+// CTLogFromDate returns the date (YYYY-MM-DD) to collect logs from when querying at a given time.
+func CTLogFromDate(now time.Time) string {
+	if CTLogFrom != "" {
+		return CTLogFrom
 	}
+	return now.Add(-CTLogMaxAge).Format("2006-01-02")
+}
 
+// NewCTResolver creates a new CTResolver with sensible defaults.
+func NewCTResolver() *CTResolver {
 	return &CTResolver{
-		Client:        client,
+		Backends:      []CTBackend{&CrtShBackend{}},
+		MaxCerts:      DefaultCTMaxCerts,
+		Client:        newHTTPClient(),
 		cachedResults: make(map[string]*CTResolution),
 	}
 }
@@ -49,7 +80,7 @@ func (resolver *CTResolver) Type() ResolutionType {
 }
 
 // ResolveDomain resolves a given domain to a list of TLS certificates.
-func (resolver *CTResolver) ResolveDomain(domain string) Resolution {
+func (resolver *CTResolver) ResolveDomain(ctx context.Context, domain string) Resolution {
 	resolution := &CTResolution{
 		ResolutionBase: &ResolutionBase{query: domain},
 	}
@@ -59,13 +90,27 @@ func (resolver *CTResolver) ResolveDomain(domain string) Resolution {
 		return resolution
 	}
 
-	resolution.Logs = resolver.fetchLogs(domain)
+	logs, err := resolver.fetchLogs(ctx, domain)
+	if err != nil {
+		resolution.addError(err)
+	}
+	resolution.Logs = logs
+
+	if resolver.FetchCerts {
+		resolution.Certificates = resolver.fetchCerts(ctx, resolution, domain)
+	}
+
+	resolver.cacheMutex.Lock()
 	resolver.cachedResults[domain] = resolution
+	resolver.cacheMutex.Unlock()
 
 	return resolution
 }
 
 func (resolver *CTResolver) cacheLookup(domain string) *CTResolution {
+	resolver.cacheMutex.RLock()
+	defer resolver.cacheMutex.RUnlock()
+
 	resolution := resolver.cachedResults[domain]
 	if resolution != nil {
 		return resolution
@@ -82,62 +127,329 @@ func (resolver *CTResolver) cacheLookup(domain string) *CTResolution {
 	return nil
 }
 
-func (resolver *CTResolver) fetchLogs(domain string) (logs []CTAggregatedLog) {
-	url := fmt.Sprintf("%s/?match=LIKE&exclude=%s&CN=%s&output=json", CTApiUrl, CTExclude, domain)
-	res, err := resolver.Client.Get(url)
-	if err != nil {
-		LogErr("%s: %s -> %s", TypeCT, domain, err.Error())
-		return logs
+// fetchLogs tries all the backends in order and returns logs of the first one that answers.
+func (resolver *CTResolver) fetchLogs(ctx context.Context, domain string) (logs []CTAggregatedLog, err error) {
+	now := clockNow(resolver.Clock)
+	for _, backend := range resolver.Backends {
+		logs, err = backend.FetchLogs(ctx, resolver.Client, domain, resolver.MaxLogs, CTLogFromDate(now))
+		if err != nil {
+			LogErr("%s: %s -> %s failed, trying the next backend (if any). The cause was: %s", TypeCT, domain, backend.Name(), err.Error())
+			continue
+		}
+
+		logs = resolver.filterByValidity(logs, now)
+		for i := range logs {
+			logs[i].UnexpectedIssuer = !isExpectedIssuer(logs[i].IssuerName, resolver.ExpectedIssuers)
+		}
+		return logs, nil
 	}
 
-	var rawBody []byte
-	if rawBody, err = io.ReadAll(res.Body); err != nil {
-		LogErr("%s: %s -> %s", TypeCT, domain, err.Error())
-		return logs
+	return logs, err
+}
+
+// ctDateLayouts are layouts of certificate dates used by the backends (crt.sh has no zone).
+var ctDateLayouts = [...]string{"2006-01-02T15:04:05", time.RFC3339}
+
+// parseCTDate parses a given certificate date, returning false if it is empty or malformed.
+func parseCTDate(value string) (time.Time, bool) {
+	for _, layout := range ctDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, true
+		}
 	}
+	return time.Time{}, false
+}
 
-	rawLogs := make([]CTLog, 0)
-	if err = json.Unmarshal(rawBody, &rawLogs); err != nil {
-		LogErr("%s: %s -> %s", TypeCT, domain, err.Error())
+// filterByValidity drops logs of certificates outside of the requested validity window
+// (see CTResolver.OnlyCurrentlyValid and CTResolver.NotAfterBefore) as of a given time.
+// Dates which cannot be parsed do not exclude a log.
+func (resolver *CTResolver) filterByValidity(logs []CTAggregatedLog, now time.Time) []CTAggregatedLog {
+	if !resolver.OnlyCurrentlyValid && resolver.NotAfterBefore.IsZero() {
 		return logs
 	}
 
-	// Aggregate the Logs by CN (domain), while keeping min/max log time.
-	aggregatedLogs := make(map[string]*CTAggregatedLog)
-	for _, log := range rawLogs {
+	filtered := logs[:0]
+	for _, log := range logs {
+		notBefore, hasNotBefore := parseCTDate(log.NotBefore)
+		notAfter, hasNotAfter := parseCTDate(log.NotAfter)
 
-		// Skip logs outside of our time scope.
-		// @todo: maybe use a DB to query CRT.sh and filter the logs directly
-		if log.LoggedAt < CTLogFrom {
+		if resolver.OnlyCurrentlyValid {
+			if hasNotBefore && now.Before(notBefore) {
+				continue
+			}
+			if hasNotAfter && now.After(notAfter) {
+				continue
+			}
+		}
+		if !resolver.NotAfterBefore.IsZero() && hasNotAfter && !notAfter.Before(resolver.NotAfterBefore) {
 			continue
 		}
 
-		// Save every unique name record and keep the last known record.
-		if aggregatedLogs[log.NameValue] == nil {
-			aggregatedLogs[log.NameValue] = &CTAggregatedLog{
-				CTLog:     log,
-				FirstSeen: log.LoggedAt,
-				LastSeen:  log.LoggedAt,
-			}
-		} else {
-			// Update log.
-			if aggregatedLogs[log.NameValue].FirstSeen > log.LoggedAt {
-				aggregatedLogs[log.NameValue].FirstSeen = log.LoggedAt
-			}
-			if aggregatedLogs[log.NameValue].LastSeen < log.LoggedAt {
-				aggregatedLogs[log.NameValue].LastSeen = log.LoggedAt
-				aggregatedLogs[log.NameValue].CTLog = log
+		filtered = append(filtered, log)
+	}
+	return filtered
+}
+
+// fetchCerts downloads and parses certificates of the resolved logs (see CTResolver.FetchCerts).
+// The order of logs is kept, failed downloads are recorded as resolution errors.
+func (resolver *CTResolver) fetchCerts(ctx context.Context, resolution *CTResolution, domain string) (certificates []CTCertificate) {
+	var ids []int64
+	for _, log := range resolution.Logs {
+		if log.Id <= 0 {
+			continue
+		}
+		if resolver.MaxCerts > 0 && len(ids) >= resolver.MaxCerts {
+			LogDebug("%s: %s -> more than %d certificates, downloading first %d.", TypeCT, domain, resolver.MaxCerts, resolver.MaxCerts)
+			break
+		}
+		ids = append(ids, log.Id)
+	}
+
+	results := make([]*x509.Certificate, len(ids))
+	semaphore := make(chan struct{}, CTCertConcurrency)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		if !acquire(ctx, semaphore) {
+			break
+		}
+		wg.Add(1)
+		go func(i int, id int64) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			cert, err := resolver.fetchCert(ctx, id)
+			if err != nil {
+				LogErr("%s: %s -> could not fetch certificate %d. The cause was: %s", TypeCT, domain, id, err.Error())
+				resolution.addError(fmt.Errorf("certificate %d: %s", id, err.Error()))
+				return
 			}
+			results[i] = cert
+		}(i, id)
+	}
+	wg.Wait()
+
+	for i, cert := range results {
+		if cert != nil {
+			certificates = append(certificates, CTCertificate{Certificate: *cert, LogId: ids[i]})
 		}
 	}
+	return certificates
+}
+
+// fetchCert downloads a PEM certificate of a crt.sh log with a given ID and parses it.
+func (resolver *CTResolver) fetchCert(ctx context.Context, id int64) (*x509.Certificate, error) {
+	url := fmt.Sprintf("%s/?d=%d", CTApiUrl, id)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resolver.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response %s", res.Status)
+	}
+
+	// A PEM certificate is a few kB at most.
+	raw, err := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// ctLogAggregator aggregates logs by names as they come, while keeping min/max log time.
+// Only one log per unique name is held in memory, for at most limit names (0 means no limit).
+type ctLogAggregator struct {
+	aggregatedLogs map[string]*CTAggregatedLog
+	names          []string
+	from           string
+	limit          int
+	dropped        int
+}
+
+// newCTLogAggregator creates an aggregator skipping logs older than a given date (YYYY-MM-DD)
+// and keeping at most CTMaxAggregatedLogs names.
+func newCTLogAggregator(from string) *ctLogAggregator {
+	return &ctLogAggregator{aggregatedLogs: make(map[string]*CTAggregatedLog), from: from, limit: CTMaxAggregatedLogs}
+}
 
-	for _, log := range aggregatedLogs {
-		logs = append(logs, *log)
+// add aggregates a given log. Logs outside of our time scope are skipped,
+// logs of new names over the limit are dropped (and counted).
+func (aggregator *ctLogAggregator) add(log CTLog) {
+	// Skip logs outside of our time scope.
+	// @todo: maybe use a DB to query CRT.sh and filter the logs directly
+	if log.LoggedAt < aggregator.from {
+		return
+	}
+
+	// Save every unique name record and keep the last known record.
+	aggregated := aggregator.aggregatedLogs[log.NameValue]
+	if aggregated == nil {
+		if aggregator.limit > 0 && len(aggregator.names) >= aggregator.limit {
+			aggregator.dropped++
+			return
+		}
+		aggregator.aggregatedLogs[log.NameValue] = &CTAggregatedLog{
+			CTLog:     log,
+			FirstSeen: log.LoggedAt,
+			LastSeen:  log.LoggedAt,
+		}
+		aggregator.names = append(aggregator.names, log.NameValue)
+		return
 	}
 
+	// Update log.
+	if aggregated.FirstSeen > log.LoggedAt {
+		aggregated.FirstSeen = log.LoggedAt
+	}
+	if aggregated.LastSeen < log.LoggedAt {
+		aggregated.LastSeen = log.LoggedAt
+		aggregated.CTLog = log
+	}
+}
+
+// logs returns the aggregated logs in order of their first occurrence.
+func (aggregator *ctLogAggregator) logs() (logs []CTAggregatedLog) {
+	for _, name := range aggregator.names {
+		logs = append(logs, *aggregator.aggregatedLogs[name])
+	}
 	return logs
 }
 
+// aggregateCTLogs aggregates given logs by names, while keeping min/max log time.
+// Logs older than a given date (YYYY-MM-DD) are skipped.
+func aggregateCTLogs(rawLogs []CTLog, from string) []CTAggregatedLog {
+	aggregator := newCTLogAggregator(from)
+	for _, log := range rawLogs {
+		aggregator.add(log)
+	}
+	return aggregator.logs()
+}
+
+// errCTLogLimit is returned by streamCTLogs when there are more logs than allowed.
+var errCTLogLimit = errors.New("CT log limit reached")
+
+// errCTResponseLimit is returned by streamCTLogs when the response is bigger than allowed.
+var errCTResponseLimit = errors.New("CT response size limit reached")
+
+// cappedReader fails with errCTResponseLimit once more than a given number of bytes is read.
+type cappedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *cappedReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, errCTResponseLimit
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
+
+// streamCTLogs decodes a JSON array of CT logs one element at a time and passes
+// each of them to a given callback, so that the response is never buffered as a whole
+// and all complete logs are processed even if the stream breaks midway.
+// At most limit logs are processed (0 means no limit). Returns the number of processed logs.
+func streamCTLogs(reader io.Reader, limit int, callback func(log CTLog)) (count int, err error) {
+	decoder := json.NewDecoder(reader)
+
+	if _, err = decoder.Token(); err != nil {
+		return count, err
+	}
+
+	for decoder.More() {
+		if limit > 0 && count >= limit {
+			return count, errCTLogLimit
+		}
+
+		var log CTLog
+		if err = decoder.Decode(&log); err != nil {
+			return count, err
+		}
+		callback(log)
+		count++
+	}
+
+	// Consume the closing bracket to detect truncation right after the last log.
+	_, err = decoder.Token()
+	return count, err
+}
+
+// decodeCTLogs decodes a JSON array of CT logs (see streamCTLogs).
+func decodeCTLogs(reader io.Reader, limit int) (logs []CTLog, err error) {
+	_, err = streamCTLogs(reader, limit, func(log CTLog) {
+		logs = append(logs, log)
+	})
+	return logs, err
+}
+
+/////////////////////////////////////////
+// CRT.SH BACKEND
+/////////////////////////////////////////
+
+// Name returns "crt.sh".
+func (backend *CrtShBackend) Name() string {
+	return "crt.sh"
+}
+
+// FetchLogs queries crt.sh for logs of a given domain (see CTApiUrl and CTExclude).
+func (backend *CrtShBackend) FetchLogs(ctx context.Context, client *http.Client, domain string, maxLogs int, from string) ([]CTAggregatedLog, error) {
+	url := fmt.Sprintf("%s/?match=LIKE&exclude=%s&CN=%s&output=json", CTApiUrl, CTExclude, domain)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		// Typically rate-limited (429) or overloaded (502/503).
+		return nil, fmt.Errorf("unexpected response %s", res.Status)
+	}
+
+	// Aggregate on the fly, busy domains yield tens of thousands of (mostly duplicate) logs.
+	aggregator := newCTLogAggregator(from)
+	body := io.Reader(res.Body)
+	if CTMaxResponseBytes > 0 {
+		body = &cappedReader{reader: res.Body, remaining: CTMaxResponseBytes}
+	}
+	count, err := streamCTLogs(body, maxLogs, aggregator.add)
+	if err == errCTLogLimit {
+		LogErr("%s: %s -> more than %d logs returned, keeping first %d.", TypeCT, domain, maxLogs, maxLogs)
+	} else if err != nil {
+		if count == 0 {
+			return nil, err
+		}
+		LogErr("%s: %s -> response truncated after %d logs, keeping them. The cause was: %s", TypeCT, domain, count, err.Error())
+	}
+	if aggregator.dropped > 0 {
+		LogErr("%s: %s -> more than %d distinct names returned, keeping first %d.", TypeCT, domain, aggregator.limit, aggregator.limit)
+	}
+
+	return aggregator.logs(), nil
+}
+
 /////////////////////////////////////////
 // CT RESOLUTION
 /////////////////////////////////////////
@@ -161,9 +473,23 @@ func (res *CTResolution) Domains() (domains []string) {
 		}
 	}
 
+	for _, cert := range res.Certificates {
+		for _, domain := range dissectDomainsFromCert(&TLSCertificate{Certificate: cert.Certificate}) {
+			if !seen[domain] {
+				domains = append(domains, domain)
+				seen[domain] = true
+			}
+		}
+	}
+
 	return domains
 }
 
+// Raw returns the logs as []CTAggregatedLog.
+func (res *CTResolution) Raw() interface{} {
+	return res.Logs
+}
+
 /////////////////////////////////////////
 // CT AGGREGATED LOG
 /////////////////////////////////////////
@@ -175,15 +501,42 @@ func (log *CTAggregatedLog) String() string {
 	)
 }
 
+/////////////////////////////////////////
+// CT CERTIFICATE
+/////////////////////////////////////////
+
+func (cert *CTCertificate) String() string {
+	return fmt.Sprintf(
+		"log: %d, subject: %s, issuer: %s, not_before: %s, not_after: %s, domains: %v",
+		cert.LogId, cert.Subject.String(), cert.Issuer.String(), cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339), cert.DNSNames,
+	)
+}
+
 /////////////////////////////////////////
 // CT LOG
 /////////////////////////////////////////
 
+// ExtractDomains returns domains for crawling found in this log's names.
+// Wildcard names (e.g. "*.example.com") yield their base domain only,
+// NameValue keeps the original notation.
 func (log *CTLog) ExtractDomains() (domains []string) {
-	domains = append(domains, DissectDomainsFromString(log.NameValue)...)
+	for _, name := range strings.Split(log.NameValue, "\n") {
+		name = strings.TrimPrefix(strings.TrimSpace(name), "*.")
+		domains = append(domains, DissectDomainsFromString(name)...)
+	}
 	return domains
 }
 
+// IsWildcard returns true if any of this log's names is a wildcard.
+func (log *CTLog) IsWildcard() bool {
+	for _, name := range strings.Split(log.NameValue, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(name), "*.") {
+			return true
+		}
+	}
+	return false
+}
+
 func (log *CTLog) String() string {
 	return fmt.Sprintf(
 		"name: %s, logged_at: %s, not_before: %s, not_after: %s, issuer: %s",
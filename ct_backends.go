@@ -0,0 +1,218 @@
+package udig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// DefaultCertSpotterApiUrl is a base URL of the Cert Spotter API.
+	DefaultCertSpotterApiUrl = "https://api.certspotter.com"
+
+	// DefaultCensysApiUrl is a base URL of the Censys Search API.
+	DefaultCensysApiUrl = "https://search.censys.io"
+
+	// MaxCTPages is a max number of result pages fetched from paginated CT backends.
+	MaxCTPages = 10
+)
+
+// getCTJSON fetches a given URL and decodes the JSON response into a given value.
+func getCTJSON(ctx context.Context, client *http.Client, url string, prepare func(req *http.Request), value interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if prepare != nil {
+		prepare(req)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response %s", res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(value)
+}
+
+// capCTLogs truncates given logs of a given domain to maxLogs (if set)
+// and tells if the limit has been reached, i.e. there is no point in fetching more.
+func capCTLogs(domain string, logs []CTLog, maxLogs int) ([]CTLog, bool) {
+	if maxLogs <= 0 || len(logs) < maxLogs {
+		return logs, false
+	}
+	if len(logs) > maxLogs {
+		LogErr("%s: %s -> more than %d logs returned, keeping first %d.", TypeCT, domain, maxLogs, maxLogs)
+	}
+	return logs[:maxLogs], true
+}
+
+/////////////////////////////////////////
+// CERT SPOTTER BACKEND
+/////////////////////////////////////////
+
+type certSpotterIssuance struct {
+	Id        string   `json:"id"`
+	DNSNames  []string `json:"dns_names"`
+	NotBefore string   `json:"not_before"`
+	NotAfter  string   `json:"not_after"`
+	Issuer    struct {
+		Name string `json:"name"`
+	} `json:"issuer"`
+}
+
+// Name returns "certspotter".
+func (backend *CertSpotterBackend) Name() string {
+	return "certspotter"
+}
+
+// FetchLogs queries Cert Spotter for issuances of a given domain and its subdomains.
+// There is no log timestamp in the API, so the certificate's NotBefore is used instead.
+func (backend *CertSpotterBackend) FetchLogs(ctx context.Context, client *http.Client, domain string, maxLogs int, from string) ([]CTAggregatedLog, error) {
+	apiUrl := backend.ApiUrl
+	if apiUrl == "" {
+		apiUrl = DefaultCertSpotterApiUrl
+	}
+
+	var rawLogs []CTLog
+	after := ""
+	for page := 0; page < MaxCTPages; page++ {
+		query := url.Values{}
+		query.Set("domain", domain)
+		query.Set("include_subdomains", "true")
+		query.Add("expand", "dns_names")
+		query.Add("expand", "issuer")
+		if after != "" {
+			query.Set("after", after)
+		}
+
+		var issuances []certSpotterIssuance
+		err := getCTJSON(ctx, client, apiUrl+"/v1/issuances?"+query.Encode(), func(req *http.Request) {
+			if backend.Token != "" {
+				req.Header.Set("Authorization", "Bearer "+backend.Token)
+			}
+		}, &issuances)
+		if err != nil {
+			if len(rawLogs) == 0 {
+				return nil, err
+			}
+			LogErr("%s: %s -> %s pagination failed, keeping %d logs. The cause was: %s", TypeCT, domain, backend.Name(), len(rawLogs), err.Error())
+			break
+		}
+		if len(issuances) == 0 {
+			break
+		}
+
+		for _, issuance := range issuances {
+			rawLogs = append(rawLogs, CTLog{
+				IssuerName: issuance.Issuer.Name,
+				NameValue:  strings.Join(issuance.DNSNames, "\n"),
+				LoggedAt:   issuance.NotBefore,
+				NotBefore:  issuance.NotBefore,
+				NotAfter:   issuance.NotAfter,
+			})
+		}
+
+		var capped bool
+		if rawLogs, capped = capCTLogs(domain, rawLogs, maxLogs); capped {
+			break
+		}
+		after = issuances[len(issuances)-1].Id
+	}
+
+	return aggregateCTLogs(rawLogs, from), nil
+}
+
+/////////////////////////////////////////
+// CENSYS BACKEND
+/////////////////////////////////////////
+
+type censysSearchResponse struct {
+	Result struct {
+		Hits []struct {
+			Names  []string `json:"names"`
+			Parsed struct {
+				IssuerDN       string `json:"issuer_dn"`
+				ValidityPeriod struct {
+					NotBefore string `json:"not_before"`
+					NotAfter  string `json:"not_after"`
+				} `json:"validity_period"`
+			} `json:"parsed"`
+		} `json:"hits"`
+		Links struct {
+			Next string `json:"next"`
+		} `json:"links"`
+	} `json:"result"`
+}
+
+// Name returns "censys".
+func (backend *CensysBackend) Name() string {
+	return "censys"
+}
+
+// FetchLogs queries Censys for certificates of a given domain and its subdomains.
+// There is no log timestamp in the API, so the certificate's NotBefore is used instead.
+func (backend *CensysBackend) FetchLogs(ctx context.Context, client *http.Client, domain string, maxLogs int, from string) ([]CTAggregatedLog, error) {
+	if backend.ApiID == "" || backend.ApiSecret == "" {
+		return nil, fmt.Errorf("%s API credentials are missing", backend.Name())
+	}
+
+	apiUrl := backend.ApiUrl
+	if apiUrl == "" {
+		apiUrl = DefaultCensysApiUrl
+	}
+
+	var rawLogs []CTLog
+	cursor := ""
+	for page := 0; page < MaxCTPages; page++ {
+		query := url.Values{}
+		query.Set("q", "names: "+domain)
+		query.Set("per_page", "100")
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+
+		var response censysSearchResponse
+		err := getCTJSON(ctx, client, apiUrl+"/api/v2/certificates/search?"+query.Encode(), func(req *http.Request) {
+			req.SetBasicAuth(backend.ApiID, backend.ApiSecret)
+		}, &response)
+		if err != nil {
+			if len(rawLogs) == 0 {
+				return nil, err
+			}
+			LogErr("%s: %s -> %s pagination failed, keeping %d logs. The cause was: %s", TypeCT, domain, backend.Name(), len(rawLogs), err.Error())
+			break
+		}
+
+		for _, hit := range response.Result.Hits {
+			rawLogs = append(rawLogs, CTLog{
+				IssuerName: hit.Parsed.IssuerDN,
+				NameValue:  strings.Join(hit.Names, "\n"),
+				LoggedAt:   hit.Parsed.ValidityPeriod.NotBefore,
+				NotBefore:  hit.Parsed.ValidityPeriod.NotBefore,
+				NotAfter:   hit.Parsed.ValidityPeriod.NotAfter,
+			})
+		}
+
+		var capped bool
+		if rawLogs, capped = capCTLogs(domain, rawLogs, maxLogs); capped {
+			break
+		}
+
+		cursor = response.Result.Links.Next
+		if cursor == "" || len(response.Result.Hits) == 0 {
+			break
+		}
+	}
+
+	return aggregateCTLogs(rawLogs, from), nil
+}
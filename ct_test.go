@@ -0,0 +1,471 @@
+package udig
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fixedClock is a Clock stuck at a given time.
+type fixedClock struct {
+	now time.Time
+}
+
+func (clock *fixedClock) Now() time.Time {
+	return clock.now
+}
+
+func Test_When_CT_log_is_from_unlisted_issuer_Then_unexpected_issuer_is_flagged(t *testing.T) {
+	// Mock.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[
+			{"id": 1, "issuer_name": "C=US, O=Let's Encrypt, CN=R3", "name_value": "a.example.com", "entry_timestamp": "2999-01-01T00:00:00"},
+			{"id": 2, "issuer_name": "C=XX, O=Shady CA, CN=Shady", "name_value": "b.example.com", "entry_timestamp": "2999-01-01T00:00:00"}
+		]`))
+	}))
+	defer server.Close()
+
+	origURL := CTApiUrl
+	CTApiUrl = server.URL
+	defer func() { CTApiUrl = origURL }()
+
+	// Setup.
+	resolver := NewCTResolver()
+	resolver.ExpectedIssuers = []string{"Let's Encrypt"}
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*CTResolution)
+
+	// Assert.
+	flagged := map[string]bool{}
+	for _, log := range resolution.Logs {
+		flagged[log.NameValue] = log.UnexpectedIssuer
+	}
+	assert.Equal(t, map[string]bool{"a.example.com": false, "b.example.com": true}, flagged)
+}
+
+func Test_When_CT_response_is_truncated_Then_complete_logs_are_kept(t *testing.T) {
+	// Setup.
+	truncated := `[
+		{"id": 1, "issuer_name": "CN=R3", "name_value": "a.example.com", "entry_timestamp": "2999-01-01T00:00:00"},
+		{"id": 2, "issuer_name": "CN=R3", "name_value": "b.example.com", "entry_timestamp": "2999-01-01T00:00:00"},
+		{"id": 3, "issuer_name": "CN=R3", "name_va`
+
+	// Execute.
+	logs, err := decodeCTLogs(strings.NewReader(truncated), 0)
+
+	// Assert.
+	assert.Error(t, err)
+	assert.Len(t, logs, 2)
+	assert.Equal(t, "a.example.com", logs[0].NameValue)
+	assert.Equal(t, "b.example.com", logs[1].NameValue)
+}
+
+func Test_When_CT_response_is_complete_Then_all_logs_are_decoded(t *testing.T) {
+	// Setup.
+	complete := `[{"id": 1, "name_value": "a.example.com"}, {"id": 2, "name_value": "b.example.com"}]`
+
+	// Execute.
+	logs, err := decodeCTLogs(strings.NewReader(complete), 0)
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.Len(t, logs, 2)
+}
+
+func Test_When_CT_response_exceeds_the_limit_Then_logs_are_truncated(t *testing.T) {
+	// Setup.
+	complete := `[{"id": 1}, {"id": 2}, {"id": 3}]`
+
+	// Execute.
+	logs, err := decodeCTLogs(strings.NewReader(complete), 2)
+
+	// Assert.
+	assert.Equal(t, errCTLogLimit, err)
+	assert.Len(t, logs, 2)
+}
+
+func Test_When_crt_sh_streams_many_duplicate_logs_Then_they_are_aggregated_up_to_the_cap(t *testing.T) {
+	// Mock.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("["))
+		for i := 0; i < 10000; i++ {
+			if i > 0 {
+				_, _ = w.Write([]byte(","))
+			}
+			_, _ = fmt.Fprintf(w, `{"id": %d, "issuer_name": "CN=R3", "name_value": "%c.example.com", "entry_timestamp": "2999-01-%02dT00:00:00"}`, i, 'a'+i%3, 1+i%28)
+		}
+		_, _ = w.Write([]byte("]"))
+	}))
+	defer server.Close()
+
+	origURL := CTApiUrl
+	CTApiUrl = server.URL
+	defer func() { CTApiUrl = origURL }()
+
+	// Setup.
+	resolver := NewCTResolver()
+	resolver.MaxLogs = 5000
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*CTResolution)
+
+	// Assert.
+	assert.NoError(t, resolution.Error())
+	assert.Len(t, resolution.Logs, 3)
+	assert.Equal(t, "a.example.com", resolution.Logs[0].NameValue)
+	assert.Equal(t, "2999-01-01T00:00:00", resolution.Logs[0].FirstSeen)
+	assert.Equal(t, "2999-01-28T00:00:00", resolution.Logs[0].LastSeen)
+}
+
+func Test_When_CT_log_is_a_wildcard_Then_only_its_base_domain_is_crawled(t *testing.T) {
+	// Setup.
+	resolution := &CTResolution{
+		ResolutionBase: &ResolutionBase{query: "example.com"},
+		Logs: []CTAggregatedLog{
+			{CTLog: CTLog{NameValue: "*.example.com\napi.example.com"}},
+			{CTLog: CTLog{NameValue: "*.dev.example.com"}},
+		},
+	}
+
+	// Execute.
+	domains := resolution.Domains()
+
+	// Assert.
+	assert.Equal(t, []string{"example.com", "api.example.com", "dev.example.com"}, domains)
+	assert.True(t, resolution.Logs[0].IsWildcard())
+	assert.Equal(t, "*.example.com\napi.example.com", resolution.Logs[0].NameValue)
+}
+
+func Test_When_crt_sh_is_unavailable_Then_next_backend_is_used(t *testing.T) {
+	// Mock.
+	crtSh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer crtSh.Close()
+
+	origURL := CTApiUrl
+	CTApiUrl = crtSh.URL
+	defer func() { CTApiUrl = origURL }()
+
+	var query string
+	certSpotter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("after") != "" {
+			_, _ = w.Write([]byte(`[]`))
+			return
+		}
+		query = r.URL.RawQuery
+		_, _ = w.Write([]byte(`[{
+			"id": "42",
+			"dns_names": ["example.com", "*.example.com"],
+			"not_before": "2999-01-01T00:00:00Z",
+			"not_after": "2999-04-01T00:00:00Z",
+			"issuer": {"name": "C=US, O=Let's Encrypt, CN=R3"}
+		}]`))
+	}))
+	defer certSpotter.Close()
+
+	// Setup.
+	resolver := NewCTResolver()
+	resolver.Backends = []CTBackend{&CrtShBackend{}, &CertSpotterBackend{ApiUrl: certSpotter.URL}}
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*CTResolution)
+
+	// Assert.
+	assert.Contains(t, query, "domain=example.com")
+	assert.NoError(t, resolution.Error())
+	assert.Len(t, resolution.Logs, 1)
+	assert.Equal(t, "example.com\n*.example.com", resolution.Logs[0].NameValue)
+	assert.Equal(t, "C=US, O=Let's Encrypt, CN=R3", resolution.Logs[0].IssuerName)
+	assert.Equal(t, "2999-04-01T00:00:00Z", resolution.Logs[0].NotAfter)
+}
+
+func Test_When_Censys_backend_is_used_Then_hits_are_paginated_and_authenticated(t *testing.T) {
+	// Mock.
+	var users []string
+	censys := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		users = append(users, user)
+		name, next := "a.example.com", "page2"
+		if r.URL.Query().Get("cursor") == "page2" {
+			name, next = "b.example.com", ""
+		}
+		_, _ = w.Write([]byte(`{"result": {"hits": [{
+			"names": ["` + name + `"],
+			"parsed": {"issuer_dn": "C=US, O=DigiCert Inc", "validity_period": {"not_before": "2999-01-01T00:00:00Z", "not_after": "2999-02-01T00:00:00Z"}}
+		}], "links": {"next": "` + next + `"}}}`))
+	}))
+	defer censys.Close()
+
+	// Setup.
+	resolver := NewCTResolver()
+	resolver.Backends = []CTBackend{&CensysBackend{ApiUrl: censys.URL, ApiID: "id", ApiSecret: "secret"}}
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*CTResolution)
+
+	// Assert.
+	assert.Equal(t, []string{"id", "id"}, users)
+	assert.Len(t, resolution.Logs, 2)
+	assert.Equal(t, "a.example.com", resolution.Logs[0].NameValue)
+	assert.Equal(t, "b.example.com", resolution.Logs[1].NameValue)
+}
+
+func Test_When_all_CT_backends_fail_Then_resolution_has_error(t *testing.T) {
+	// Setup.
+	resolver := NewCTResolver()
+	resolver.Backends = []CTBackend{&CensysBackend{}}
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*CTResolution)
+
+	// Assert.
+	assert.Empty(t, resolution.Logs)
+	assert.EqualError(t, resolution.Error(), "censys API credentials are missing")
+}
+
+func Test_When_FetchCerts_is_set_Then_certificates_of_logs_are_parsed(t *testing.T) {
+	// Mock.
+	cert, _ := issueCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "a.example.com"},
+		DNSNames:     []string{"a.example.com", "san.example.com"},
+	}, nil, nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("d") {
+		case "":
+			_, _ = w.Write([]byte(`[
+				{"id": 1, "issuer_name": "CN=R3", "name_value": "a.example.com", "entry_timestamp": "2999-01-01T00:00:00"},
+				{"id": 2, "issuer_name": "CN=R3", "name_value": "b.example.com", "entry_timestamp": "2999-01-01T00:00:00"}
+			]`))
+			break
+		case "1":
+			_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+			break
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			break
+		}
+	}))
+	defer server.Close()
+
+	origURL := CTApiUrl
+	CTApiUrl = server.URL
+	defer func() { CTApiUrl = origURL }()
+
+	// Setup.
+	resolver := NewCTResolver()
+	resolver.FetchCerts = true
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*CTResolution)
+
+	// Assert.
+	assert.Error(t, resolution.Error())
+	assert.Len(t, resolution.Certificates, 1)
+	assert.Equal(t, int64(1), resolution.Certificates[0].LogId)
+	assert.Equal(t, []string{"a.example.com", "san.example.com"}, resolution.Certificates[0].DNSNames)
+	assert.Contains(t, resolution.Domains(), "san.example.com")
+}
+
+func Test_When_CT_validity_filters_are_set_Then_only_matching_logs_are_kept(t *testing.T) {
+	// Setup.
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	logs := []CTAggregatedLog{
+		{CTLog: CTLog{NameValue: "expired.example.com", NotBefore: "2023-01-01T00:00:00", NotAfter: "2024-01-01T00:00:00"}},
+		{CTLog: CTLog{NameValue: "future.example.com", NotBefore: "2024-07-01T00:00:00", NotAfter: "2025-07-01T00:00:00"}},
+		{CTLog: CTLog{NameValue: "soon.example.com", NotBefore: "2024-03-01T00:00:00Z", NotAfter: "2024-06-15T00:00:00Z"}},
+		{CTLog: CTLog{NameValue: "later.example.com", NotBefore: "2024-03-01T00:00:00", NotAfter: "2025-03-01T00:00:00"}},
+		{CTLog: CTLog{NameValue: "unknown.example.com", NotBefore: "", NotAfter: "n/a"}},
+	}
+	resolver := NewCTResolver()
+	resolver.OnlyCurrentlyValid = true
+	resolver.NotAfterBefore = time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	// Execute.
+	filtered := resolver.filterByValidity(logs, now)
+
+	// Assert.
+	var names []string
+	for _, log := range filtered {
+		names = append(names, log.NameValue)
+	}
+	assert.Equal(t, []string{"soon.example.com", "unknown.example.com"}, names)
+}
+
+func Test_When_CT_resolver_is_kept_alive_Then_log_window_is_relative_to_each_query(t *testing.T) {
+	// Mock.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[
+			{"id": 1, "issuer_name": "CN=R3", "name_value": "old.example.com", "entry_timestamp": "2023-03-01T00:00:00"},
+			{"id": 2, "issuer_name": "CN=R3", "name_value": "new.example.com", "entry_timestamp": "2025-03-01T00:00:00"}
+		]`))
+	}))
+	defer server.Close()
+
+	origURL := CTApiUrl
+	CTApiUrl = server.URL
+	defer func() { CTApiUrl = origURL }()
+
+	clock := &fixedClock{now: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)}
+
+	// Setup.
+	resolver := NewCTResolver()
+	resolver.Clock = clock
+	names := func(logs []CTAggregatedLog) (names []string) {
+		for _, log := range logs {
+			names = append(names, log.NameValue)
+		}
+		return names
+	}
+
+	// Execute.
+	earlyLogs, earlyErr := resolver.fetchLogs(context.Background(), "example.com")
+	clock.now = clock.now.AddDate(2, 0, 0)
+	lateLogs, lateErr := resolver.fetchLogs(context.Background(), "example.com")
+
+	// Assert.
+	assert.NoError(t, earlyErr)
+	assert.NoError(t, lateErr)
+	assert.Equal(t, []string{"old.example.com", "new.example.com"}, names(earlyLogs))
+	assert.Equal(t, []string{"new.example.com"}, names(lateLogs))
+}
+
+func Test_When_CTLogFrom_is_set_Then_it_overrides_the_relative_window(t *testing.T) {
+	// Setup.
+	CTLogFrom = "2020-01-01"
+	defer func() { CTLogFrom = "" }()
+
+	// Execute.
+	from := CTLogFromDate(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+
+	// Assert.
+	assert.Equal(t, "2020-01-01", from)
+	CTLogFrom = ""
+	assert.Equal(t, "2023-06-02", CTLogFromDate(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)))
+}
+
+// syntheticCTLogs generates a JSON array of n CT logs on the fly, either of a given number
+// of distinct names ("n<i>.example.com"), or of 3 of them ("a.example.com" to "c.example.com").
+type syntheticCTLogs struct {
+	n        int
+	distinct int
+	i        int
+	pending  []byte
+}
+
+func (logs *syntheticCTLogs) name() string {
+	if logs.distinct > 0 {
+		return fmt.Sprintf("n%d.example.com", logs.i%logs.distinct)
+	}
+	return fmt.Sprintf("%c.example.com", 'a'+logs.i%3)
+}
+
+func (logs *syntheticCTLogs) Read(p []byte) (int, error) {
+	for len(logs.pending) < len(p) && logs.i <= logs.n {
+		switch {
+		case logs.i == 0:
+			logs.pending = append(logs.pending, '[')
+		case logs.i == logs.n:
+			logs.pending = append(logs.pending, ']')
+		}
+		if logs.i < logs.n {
+			if logs.i > 0 {
+				logs.pending = append(logs.pending, ',')
+			}
+			logs.pending = append(logs.pending, fmt.Sprintf(`{"id": %d, "issuer_name": "CN=R3", "name_value": "%s", "entry_timestamp": "2999-01-%02dT00:00:00"}`, logs.i, logs.name(), 1+logs.i%28)...)
+		}
+		logs.i++
+	}
+	if len(logs.pending) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, logs.pending)
+	logs.pending = logs.pending[n:]
+	return n, nil
+}
+
+func Test_When_huge_CT_response_is_streamed_Then_logs_are_aggregated(t *testing.T) {
+	// Setup.
+	stream := &syntheticCTLogs{n: 200000}
+	aggregator := newCTLogAggregator("")
+
+	// Execute.
+	count, err := streamCTLogs(stream, 0, aggregator.add)
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.Equal(t, 200000, count)
+	logs := aggregator.logs()
+	assert.Len(t, logs, 3)
+	assert.Equal(t, "a.example.com", logs[0].NameValue)
+	assert.Equal(t, "2999-01-01T00:00:00", logs[0].FirstSeen)
+	assert.Equal(t, "2999-01-28T00:00:00", logs[0].LastSeen)
+}
+
+func Test_When_CT_response_has_too_many_distinct_names_Then_memory_stays_bounded(t *testing.T) {
+	// Mock.
+	origMax := CTMaxAggregatedLogs
+	CTMaxAggregatedLogs = 1000
+	defer func() { CTMaxAggregatedLogs = origMax }()
+
+	// Setup.
+	stream := &syntheticCTLogs{n: 200000, distinct: 100000}
+	aggregator := newCTLogAggregator("")
+
+	// Execute.
+	count, err := streamCTLogs(stream, 0, aggregator.add)
+	allocs := testing.AllocsPerRun(100, func() {
+		aggregator.add(CTLog{NameValue: "other.example.com", LoggedAt: "2999-01-01T00:00:00"})
+		aggregator.add(CTLog{NameValue: "n0.example.com", LoggedAt: "2999-01-02T00:00:00"})
+	})
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.Equal(t, 200000, count)
+	// Once full, the aggregator grows no more, neither by new names nor by the known ones.
+	assert.Zero(t, allocs)
+	logs := aggregator.logs()
+	assert.Len(t, logs, 1000)
+	assert.Equal(t, "n0.example.com", logs[0].NameValue)
+	assert.Equal(t, "n999.example.com", logs[999].NameValue)
+	// All logs but those of the first 1000 names, plus the other name once per run (incl. the warm-up).
+	assert.Equal(t, 200000-2000+101, aggregator.dropped)
+}
+
+func Test_When_crt_sh_response_exceeds_the_size_cap_Then_logs_read_so_far_are_kept(t *testing.T) {
+	// Mock.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(w, &syntheticCTLogs{n: 10000})
+	}))
+	defer server.Close()
+
+	origURL := CTApiUrl
+	CTApiUrl = server.URL
+	defer func() { CTApiUrl = origURL }()
+
+	origMax := CTMaxResponseBytes
+	CTMaxResponseBytes = 1000
+	defer func() { CTMaxResponseBytes = origMax }()
+
+	// Execute.
+	logs, err := (&CrtShBackend{}).FetchLogs(context.Background(), http.DefaultClient, "example.com", 0, "")
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.Len(t, logs, 3)
+	assert.Equal(t, "a.example.com", logs[0].NameValue)
+	assert.Equal(t, "2999-01-01T00:00:00", logs[0].FirstSeen)
+}
@@ -1,15 +1,29 @@
 package udig
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/miekg/dns"
 )
 
+const (
+	// DefaultDNSMaxConcurrency is a default max number of DNS queries in flight per domain.
+	DefaultDNSMaxConcurrency = 8
+
+	// DefaultDNSPaddingBlockSize is a block size of padded DNS queries recommended by RFC 8467.
+	DefaultDNSPaddingBlockSize = 128
+)
+
 var (
 	// DefaultDNSQueryTypes is a list of default DNS RR types that we query.
 	DefaultDNSQueryTypes = [...]uint16{
@@ -37,32 +51,170 @@ var (
 		dns.TypeIXFR,
 		dns.TypeAXFR,
 		dns.TypeMAILB,
+		dns.TypeSVCB,
+		dns.TypeHTTPS,
+		dns.TypeCAA,
 		dns.TypeANY,
 	}
 
-	localNameServer  string     // A name server resolved using resolv.conf.
-	queryOneCallback = queryOne // Callback reference which performs the actual DNS query (monkey patch).
+	// DNSQueryPresets are named lists of DNS RR types for common workflows (see WithDNSPreset).
+	DNSQueryPresets = map[string][]uint16{
+		"mail":     {dns.TypeMX, dns.TypeTXT, dns.TypeA, dns.TypeAAAA, dns.TypeSPF, dns.TypeCAA},
+		"web":      {dns.TypeA, dns.TypeAAAA, dns.TypeCNAME, dns.TypeHTTPS, dns.TypeSVCB},
+		"security": {dns.TypeDNSKEY, dns.TypeDS, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeCAA, dns.TypeTLSA},
+		"all":      DefaultDNSQueryTypes[:],
+	}
+
+	// DefaultDKIMSelectors is a list of commonly used DKIM selectors that we probe.
+	DefaultDKIMSelectors = [...]string{
+		"default",
+		"dkim",
+		"mail",
+		"google",
+		"selector1",
+		"selector2",
+		"k1",
+		"s1",
+		"s2",
+		"mandrill",
+	}
+
+	// ResolvConfPath is a path to resolv.conf which is used to find the local name server.
+	// Change it before the first resolution takes place.
+	ResolvConfPath = "/etc/resolv.conf"
+
+	// FallbackNameServer is used whenever there is no usable name server in ResolvConfPath
+	// (e.g. on Windows).
+	FallbackNameServer = "1.1.1.1:53"
+
+	localNameServer     string     // A name server resolved using resolv.conf.
+	localNameServerOnce sync.Once  // Guards lazy initialization of localNameServer.
+	queryOneCallback    = queryOne // Callback reference which performs the actual DNS query (monkey patch).
 )
 
-func init() {
-	localNameServer = findLocalNameServer()
+// getLocalNameServer returns the local name server, which is looked up on the first call.
+func getLocalNameServer() string {
+	localNameServerOnce.Do(func() {
+		localNameServer = findLocalNameServer()
+	})
+	return localNameServer
 }
 
 func findLocalNameServer() string {
-	config, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	config, err := dns.ClientConfigFromFile(ResolvConfPath)
 	if err != nil || config == nil {
-		LogPanic("Cannot initialize the local resolver: %s", err)
+		LogErr("%s: Cannot initialize the local resolver from %s: %s -> falling back to %s.", TypeDNS, ResolvConfPath, err, FallbackNameServer)
+		return FallbackNameServer
 	} else if len(config.Servers) == 0 {
-		LogPanic("No local name server found")
+		LogErr("%s: No local name server found in %s -> falling back to %s.", TypeDNS, ResolvConfPath, FallbackNameServer)
+		return FallbackNameServer
+	}
+	return net.JoinHostPort(config.Servers[0], config.Port)
+}
+
+// dnsDialContextKey is a context key of a custom dialer used for DNS queries.
+type dnsDialContextKey struct{}
+
+// withDNSDialContext returns a context making DNS queries go over TCP via a given dialer (if any).
+func withDNSDialContext(ctx context.Context, dial DialContextFunc) context.Context {
+	if dial == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, dnsDialContextKey{}, dial)
+}
+
+// dnsCookieContextKey is a context key of a client cookie sent along with DNS queries.
+type dnsCookieContextKey struct{}
+
+// withDNSCookie returns a context making DNS queries carry a given client cookie (if any),
+// see RFC 7873.
+func withDNSCookie(ctx context.Context, cookie string) context.Context {
+	if cookie == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, dnsCookieContextKey{}, cookie)
+}
+
+// newClientCookie returns a random 8 byte client cookie (hex encoded).
+func newClientCookie() string {
+	cookie := make([]byte, 8)
+	if _, err := rand.Read(cookie); err != nil {
+		LogErr("%s: Could not generate a client cookie. The cause was: %s", TypeDNS, err.Error())
+		return ""
+	}
+	return hex.EncodeToString(cookie)
+}
+
+// setCookie adds an EDNS0 COOKIE option with a given client cookie to a given query.
+func setCookie(msg *dns.Msg, cookie string) {
+	msg.SetEdns0(dns.DefaultMsgSize, false)
+	opt := msg.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+}
+
+// hasServerCookie tells if a given response echoes a given client cookie along with a server cookie.
+func hasServerCookie(res *dns.Msg, cookie string) bool {
+	opt := res.IsEdns0()
+	if opt == nil {
+		return false
+	}
+	for _, option := range opt.Option {
+		if echoed, ok := option.(*dns.EDNS0_COOKIE); ok {
+			// A server cookie is 8 to 32 bytes long.
+			value := strings.ToLower(echoed.Cookie)
+			return strings.HasPrefix(value, cookie) && len(value) >= len(cookie)+16 && len(value) <= len(cookie)+64
+		}
 	}
-	return config.Servers[0] + ":53"
+	return false
 }
 
-func queryOne(domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+// dnsPaddingContextKey is a context key of a block size DNS queries are padded to.
+type dnsPaddingContextKey struct{}
+
+// withDNSPadding returns a context making DNS queries padded to a multiple of a given block size
+// (if any), see RFC 7830.
+func withDNSPadding(ctx context.Context, blockSize int) context.Context {
+	if blockSize <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, dnsPaddingContextKey{}, blockSize)
+}
+
+// setPadding adds an EDNS0 PADDING option to a given query, so that its size
+// is a multiple of a given block size. It must be the last option added.
+func setPadding(msg *dns.Msg, blockSize int) {
+	if msg.IsEdns0() == nil {
+		msg.SetEdns0(dns.DefaultMsgSize, false)
+	}
+	opt := msg.IsEdns0()
+	padding := &dns.EDNS0_PADDING{}
+	opt.Option = append(opt.Option, padding)
+	if rest := msg.Len() % blockSize; rest != 0 {
+		padding.Padding = make([]byte, blockSize-rest)
+	}
+}
+
+func queryOne(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
 	msg := &dns.Msg{}
 	msg.SetQuestion(dns.Fqdn(domain), qType)
 
-	res, _, err := client.Exchange(msg, nameServer)
+	if cookie, ok := ctx.Value(dnsCookieContextKey{}).(string); ok {
+		setCookie(msg, cookie)
+	}
+	if blockSize, ok := ctx.Value(dnsPaddingContextKey{}).(int); ok {
+		setPadding(msg, blockSize)
+	}
+
+	dial, viaDialer := ctx.Value(dnsDialContextKey{}).(DialContextFunc)
+
+	res, err := exchange(ctx, dial, msg, nameServer, client)
+	if err == nil && msg.IsEdns0() != nil && res.Rcode == dns.RcodeFormatError {
+		// Some servers choke on EDNS0 -> retry without the options.
+		LogDebug("%s: %s %s -> EDNS0 not supported by %s, retrying without it.", TypeDNS, dns.TypeToString[qType], domain, nameServer)
+		msg = &dns.Msg{}
+		msg.SetQuestion(dns.Fqdn(domain), qType)
+		res, err = exchange(ctx, dial, msg, nameServer, client)
+	}
 	if err != nil {
 		if ne, ok := err.(*net.OpError); ok && ne.Timeout() {
 			return nil, fmt.Errorf("timeout")
@@ -75,9 +227,81 @@ func queryOne(domain string, qType uint16, nameServer string, client *dns.Client
 		return nil, errors.New(dns.RcodeToString[res.Rcode])
 	}
 
+	if res.Truncated && client.Net != "tcp" && !viaDialer {
+		// The answer did not fit into a UDP datagram -> retry over TCP.
+		tcpClient := &dns.Client{Net: "tcp", ReadTimeout: DefaultTimeout}
+		tcpRes, _, err := tcpClient.ExchangeContext(ctx, msg, nameServer)
+		if err != nil || tcpRes.Rcode != dns.RcodeSuccess {
+			// Stick with what we've got.
+			LogDebug("%s: TCP retry of %s %s failed -> using truncated answer.", TypeDNS, dns.TypeToString[qType], domain)
+			return res, nil
+		}
+		return tcpRes, nil
+	}
+
 	return res, nil
 }
 
+// exchange sends a given DNS query via a given dialer (if any) or a given client.
+func exchange(ctx context.Context, dial DialContextFunc, msg *dns.Msg, nameServer string, client *dns.Client) (*dns.Msg, error) {
+	if dial != nil {
+		return exchangeVia(ctx, dial, msg, nameServer, client)
+	}
+	res, _, err := client.ExchangeContext(ctx, msg, nameServer)
+	return res, err
+}
+
+// exchangeVia sends a given DNS query over a TCP connection opened by a given dialer.
+func exchangeVia(ctx context.Context, dial DialContextFunc, msg *dns.Msg, nameServer string, client *dns.Client) (*dns.Msg, error) {
+	conn, err := dial(ctx, "tcp", nameServer)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	timeout := client.ReadTimeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	_ = conn.SetDeadline(deadline)
+
+	res, _, err := client.ExchangeWithConn(msg, &dns.Conn{Conn: conn})
+	return res, err
+}
+
+// LookupRecords queries a given domain for a single record type and returns the answer
+// records (like `dig example.com MX +short`), without crawling anything.
+// DNS related options (e.g. WithNameServer) are honored. Unless a name server
+// is given, the local one is used.
+func LookupRecords(domain string, qType uint16, opts ...Option) ([]dns.RR, error) {
+	resolver := NewDNSResolver()
+	udig := newUdigImpl()
+	udig.AddDomainResolver(resolver)
+	for _, opt := range opts {
+		opt(udig)
+	}
+
+	nameServer := resolver.NameServer
+	if nameServer == "" {
+		nameServer = getLocalNameServer()
+	}
+
+	ctx := withDNSDialContext(context.Background(), resolver.DialContext)
+	if resolver.Cookies {
+		ctx = withDNSCookie(ctx, resolver.clientCookie)
+	}
+	ctx = withDNSPadding(ctx, resolver.PaddingBlockSize)
+	msg, err := resolver.limiter.query(ctx, domain, qType, nameServer, resolver.Client)
+	if err != nil {
+		return nil, err
+	}
+	return msg.Answer, nil
+}
+
 func dissectDomainsFromRecord(record dns.RR) (domains []string) {
 	switch record.Header().Rrtype {
 	case dns.TypeNS:
@@ -97,7 +321,9 @@ func dissectDomainsFromRecord(record dns.RR) (domains []string) {
 		break
 
 	case dns.TypeTXT:
-		domains = DissectDomainsFromStrings((record).(*dns.TXT).Txt)
+		txt := (record).(*dns.TXT).Txt
+		domains = DissectDomainsFromStrings(txt)
+		domains = append(domains, dissectDomainsFromSPF(strings.Join(txt, ""))...)
 		break
 
 	case dns.TypeRRSIG:
@@ -111,10 +337,88 @@ func dissectDomainsFromRecord(record dns.RR) (domains []string) {
 	case dns.TypeKX:
 		domains = append(domains, (record).(*dns.KX).Exchanger)
 		break
+
+	case dns.TypeSVCB:
+		domains = append(domains, (record).(*dns.SVCB).Target)
+		break
+
+	case dns.TypeHTTPS:
+		domains = append(domains, (record).(*dns.HTTPS).Target)
+		break
+
+	case dns.TypeCAA:
+		domains = append(domains, CAADomain((record).(*dns.CAA)))
+		break
 	}
 
-	for i := range domains {
-		domains[i] = CleanDomain(domains[i])
+	var cleanDomains []string
+	for _, domain := range domains {
+		// Note: SVCB target "." stands for the owner name -> gets cleaned to "".
+		if domain = CleanDomain(domain); domain != "" {
+			cleanDomains = append(cleanDomains, domain)
+		}
+	}
+
+	return cleanDomains
+}
+
+// CAADomain returns the domain of a given CAA record, i.e. the CA allowed by an issue or issuewild
+// property (e.g. "letsencrypt.org") or the host of an iodef report endpoint (a URL or a mailto).
+// Returns an empty string if there is none (e.g. "issue ;" forbidding any CA).
+func CAADomain(caa *dns.CAA) string {
+	switch strings.ToLower(caa.Tag) {
+	case "issue", "issuewild":
+		return strings.TrimSpace(strings.SplitN(caa.Value, ";", 2)[0])
+
+	case "iodef":
+		endpoint, err := url.Parse(caa.Value)
+		if err != nil {
+			return ""
+		}
+		if endpoint.Scheme == "mailto" {
+			address := endpoint.Opaque
+			return address[strings.LastIndex(address, "@")+1:]
+		}
+		return endpoint.Hostname()
+	}
+	return ""
+}
+
+// isSPFRecord tells if a given TXT record is an SPF policy (long policies are split into multiple strings).
+func isSPFRecord(record *dns.TXT) bool {
+	terms := strings.Fields(strings.ToLower(strings.Join(record.Txt, "")))
+	return len(terms) > 0 && terms[0] == "v=spf1"
+}
+
+// dissectDomainsFromSPF returns domains referenced by include and redirect
+// mechanisms of a given SPF record. Nested includes are not followed here,
+// the returned domains are crawled as any other related domain instead.
+func dissectDomainsFromSPF(spf string) (domains []string) {
+	terms := strings.Fields(strings.ToLower(spf))
+	if len(terms) == 0 || terms[0] != "v=spf1" {
+		// Not an SPF record.
+		return domains
+	}
+
+	for _, term := range terms[1:] {
+		// Strip the qualifier (if any).
+		term = strings.TrimLeft(term, "+-~?")
+
+		var domain string
+		if strings.HasPrefix(term, "include:") {
+			domain = strings.TrimPrefix(term, "include:")
+		} else if strings.HasPrefix(term, "redirect=") {
+			domain = strings.TrimPrefix(term, "redirect=")
+		} else {
+			continue
+		}
+
+		// Skip malformed and macro-expanded domains.
+		if _, ok := dns.IsDomainName(domain); !ok || strings.Contains(domain, "%") || dns.CountLabel(domain) < 2 {
+			continue
+		}
+
+		domains = append(domains, domain)
 	}
 
 	return domains
@@ -134,11 +438,39 @@ func dissectIPsFromRecord(record dns.RR) (ips []string) {
 		// For SPF typically.
 		ips = DissectIpsFromStrings((record).(*dns.TXT).Txt)
 		break
+
+	case dns.TypeSVCB:
+		ips = dissectIPsFromSVCB(record.(*dns.SVCB))
+		break
+
+	case dns.TypeHTTPS:
+		ips = dissectIPsFromSVCB(&record.(*dns.HTTPS).SVCB)
+		break
 	}
 
 	return ips
 }
 
+// dissectIPsFromSVCB returns all IPv4 and IPv6 hints found in SvcParams of a given record.
+func dissectIPsFromSVCB(record *dns.SVCB) (ips []string) {
+	for _, param := range record.Value {
+		switch hint := param.(type) {
+		case *dns.SVCBIPv4Hint:
+			for _, ip := range hint.Hint {
+				ips = append(ips, ip.String())
+			}
+			break
+
+		case *dns.SVCBIPv6Hint:
+			for _, ip := range hint.Hint {
+				ips = append(ips, ip.String())
+			}
+			break
+		}
+	}
+	return ips
+}
+
 /////////////////////////////////////////
 // DNS RESOLVER
 /////////////////////////////////////////
@@ -148,9 +480,15 @@ func dissectIPsFromRecord(record dns.RR) (ips []string) {
 func NewDNSResolver() *DNSResolver {
 	return &DNSResolver{
 		QueryTypes:      DefaultDNSQueryTypes[:],
+		DKIMSelectors:   DefaultDKIMSelectors[:],
+		MaxConcurrency:  DefaultDNSMaxConcurrency,
 		Client:          &dns.Client{ReadTimeout: DefaultTimeout},
 		nameServerCache: map[string]string{},
+		answerCache:     map[dnsCacheKey]*dnsCacheEntry{},
+		wildcardCache:   map[string][]string{},
 		resolvedDomains: map[string]bool{},
+		cookieServers:   map[string]bool{},
+		clientCookie:    newClientCookie(),
 	}
 }
 
@@ -162,24 +500,43 @@ func (resolver *DNSResolver) Type() ResolutionType {
 // ResolveDomain attempts to resolve a given domain for every DNS record
 // type defined in resolver.QueryTypes using either a user-supplied
 // name-server or dynamically resolved one for this domain.
-func (resolver *DNSResolver) ResolveDomain(domain string) Resolution {
+// Once the context is cancelled no more queries are dispatched
+// and whatever has been collected so far is returned.
+func (resolver *DNSResolver) ResolveDomain(ctx context.Context, domain string) Resolution {
+	ctx = withDNSDialContext(ctx, resolver.DialContext)
+	if resolver.Cookies {
+		ctx = withDNSCookie(ctx, resolver.clientCookie)
+	}
+	ctx = withDNSPadding(ctx, resolver.PaddingBlockSize)
+
 	// First find a name server for this domain (if not pre-defined).
-	nameServer := resolver.findNameServerFor(domain)
+	nameServer := resolver.findNameServerFor(ctx, domain)
 	LogDebug("%s: Using NS %s for domain %s.", TypeDNS, nameServer, domain)
 
 	resolution := &DNSResolution{
 		ResolutionBase: &ResolutionBase{query: domain},
 		nameServer:     nameServer,
+		spfOnlyTXTIPs:  resolver.SPFOnlyTXTIPs,
 	}
 
 	// Now do a DNS query for each record type (in parallel).
-	recordChannel := make(chan []DNSRecordPair, 128)
+	recordChannel := make(chan []DNSRecordPair, len(resolver.QueryTypes))
+	semaphore := resolver.newSemaphore(len(resolver.QueryTypes))
 	var wg sync.WaitGroup
-	wg.Add(len(resolver.QueryTypes))
 
 	for _, qType := range resolver.QueryTypes {
+		if !acquire(ctx, semaphore) {
+			LogDebug("%s: Resolution of %s cancelled: %s", TypeDNS, domain, ctx.Err().Error())
+			break
+		}
+		wg.Add(1)
 		go func(qType uint16) {
-			recordChannel <- resolver.resolveOne(domain, qType, nameServer)
+			records, err := resolver.resolveOne(ctx, domain, qType, nameServer)
+			if err != nil {
+				resolution.addError(err)
+			}
+			recordChannel <- records
+			<-semaphore
 			wg.Done()
 		}(qType)
 	}
@@ -190,63 +547,468 @@ func (resolver *DNSResolver) ResolveDomain(domain string) Resolution {
 		resolution.Records = append(resolution.Records, <-recordChannel...)
 	}
 
+	// Probe the DKIM selectors.
+	resolution.DKIMKeys = resolver.resolveDKIM(ctx, domain, nameServer)
+
+	// Walk the NSEC chain (if the zone uses NSEC at all).
+	if resolver.ZoneWalkLimit > 0 {
+		resolution.WalkedDomains = resolver.walkZone(ctx, domain, resolution.Records, nameServer)
+	}
+
+	// Finally, check if this is a product of a wildcard record.
+	if resolver.DetectWildcards && IsSubdomain(domain) {
+		resolution.Wildcard = resolver.isWildcard(ctx, domain, addressesOf(resolution.Records), nameServer)
+		if resolution.Wildcard {
+			LogDebug("%s: Domain %s is resolved by a wildcard record.", TypeDNS, domain)
+		}
+	}
+
+	if resolver.Cookies {
+		resolution.ServerCookie = resolver.hasServerCookie(nameServer)
+	}
+
 	return resolution
 }
 
-func (resolver *DNSResolver) resolveOne(domain string, qType uint16, nameServer string) (answers []DNSRecordPair) {
-	msg, err := queryOneCallback(domain, qType, nameServer, resolver.Client)
+// isWildcard returns true if all given addresses of a given domain are the same as those
+// of a random (i.e. non-existent) label under the domain's parent.
+func (resolver *DNSResolver) isWildcard(ctx context.Context, domain string, addresses []string, nameServer string) bool {
+	if len(addresses) == 0 {
+		return false
+	}
+
+	wildcardAddresses := map[string]bool{}
+	for _, address := range resolver.wildcardAddressesOf(ctx, ParentDomainOf(domain), nameServer) {
+		wildcardAddresses[address] = true
+	}
+
+	for _, address := range addresses {
+		if !wildcardAddresses[address] {
+			return false
+		}
+	}
+	return true
+}
+
+// wildcardAddressesOf returns A and AAAA addresses of a random label under a given domain (cached).
+func (resolver *DNSResolver) wildcardAddressesOf(ctx context.Context, domain string, nameServer string) []string {
+	resolver.cacheMutex.RLock()
+	addresses, ok := resolver.wildcardCache[domain]
+	resolver.cacheMutex.RUnlock()
+	if ok {
+		return addresses
+	}
+
+	probe := randomLabel() + "." + domain
+	for _, qType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg, err := resolver.limiter.query(ctx, probe, qType, nameServer, resolver.Client)
+		if err != nil {
+			// NXDOMAIN is what we hope for.
+			continue
+		}
+		var records []DNSRecordPair
+		for _, rr := range msg.Answer {
+			records = append(records, DNSRecordPair{QueryType: qType, Record: &DNSRecord{rr}})
+		}
+		addresses = append(addresses, addressesOf(records)...)
+	}
+
+	resolver.cacheMutex.Lock()
+	resolver.wildcardCache[domain] = addresses
+	resolver.cacheMutex.Unlock()
+
+	return addresses
+}
+
+// walkZone follows the chain of NSEC NextDomain pointers starting at a given domain's
+// NSEC record and returns the enumerated names (at most ZoneWalkLimit of them).
+// The walk stops once the chain leaves the zone, wraps around or loops.
+func (resolver *DNSResolver) walkZone(ctx context.Context, domain string, records []DNSRecordPair, nameServer string) (walked []string) {
+	zone := normalizeName(domain)
+	next := nextSecureName(zone, records)
+	seen := map[string]bool{zone: true}
+
+	for next != "" && len(walked) < resolver.ZoneWalkLimit && ctx.Err() == nil {
+		// Black lies (RFC 4470) and foreign names mean there is nothing to walk.
+		if seen[next] || strings.HasPrefix(next, "\\000") || !strings.HasSuffix(next, "."+zone) {
+			break
+		}
+		seen[next] = true
+		walked = append(walked, next)
+
+		msg, err := resolver.limiter.query(ctx, next, dns.TypeNSEC, nameServer, resolver.Client)
+		if err != nil {
+			LogDebug("%s: %s %s -> %s", TypeDNS, "NSEC", next, err.Error())
+			break
+		}
+
+		var answers []DNSRecordPair
+		for _, rr := range msg.Answer {
+			answers = append(answers, DNSRecordPair{QueryType: dns.TypeNSEC, Record: &DNSRecord{rr}})
+		}
+		next = nextSecureName(next, answers)
+	}
+
+	if len(walked) > 0 {
+		LogDebug("%s: Walked %d names in zone %s.", TypeDNS, len(walked), zone)
+	}
+	return walked
+}
+
+// nextSecureName returns the NextDomain of an NSEC record owned by a given name or "".
+func nextSecureName(owner string, records []DNSRecordPair) string {
+	for _, answer := range records {
+		if nsec, ok := answer.Record.RR.(*dns.NSEC); ok && normalizeName(nsec.Hdr.Name) == owner {
+			return normalizeName(nsec.NextDomain)
+		}
+	}
+	return ""
+}
+
+// normalizeName lower-cases a given name and strips the trailing dot.
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// addressesOf returns all IPs found in A and AAAA records among given records.
+func addressesOf(records []DNSRecordPair) (addresses []string) {
+	for _, answer := range records {
+		switch answer.Record.Header().Rrtype {
+		case dns.TypeA, dns.TypeAAAA:
+			addresses = append(addresses, dissectIPsFromRecord(answer.Record.RR)...)
+			break
+		}
+	}
+	return addresses
+}
+
+func (resolver *DNSResolver) resolveDKIM(ctx context.Context, domain string, nameServer string) (keys []DKIMKey) {
+	keyChannel := make(chan []DKIMKey, len(resolver.DKIMSelectors))
+	semaphore := resolver.newSemaphore(len(resolver.DKIMSelectors))
+	var wg sync.WaitGroup
+
+	for _, selector := range resolver.DKIMSelectors {
+		if !acquire(ctx, semaphore) {
+			break
+		}
+		wg.Add(1)
+		go func(selector string) {
+			var found []DKIMKey
+			query := selector + "._domainkey." + domain
+
+			// Most of the selectors won't exist, so don't be too loud about it.
+			msg, err := resolver.limiter.query(ctx, query, dns.TypeTXT, nameServer, resolver.Client)
+			if err != nil {
+				LogDebug("%s: %s %s -> %s", TypeDNS, "TXT", query, err.Error())
+			} else {
+				for _, rr := range msg.Answer {
+					found = append(found, DKIMKey{Selector: selector, Record: &DNSRecord{rr}})
+				}
+			}
+			keyChannel <- found
+			<-semaphore
+			wg.Done()
+		}(selector)
+	}
+	wg.Wait()
+
+	for len(keyChannel) > 0 {
+		keys = append(keys, <-keyChannel...)
+	}
+
+	return keys
+}
+
+// newSemaphore creates a channel which caps the number of queries in flight
+// to MaxConcurrency (or to a given number of tasks if there is no limit).
+func (resolver *DNSResolver) newSemaphore(tasks int) chan struct{} {
+	size := resolver.MaxConcurrency
+	if size <= 0 || size > tasks {
+		size = tasks
+	}
+	if size <= 0 {
+		size = 1
+	}
+	return make(chan struct{}, size)
+}
+
+// resolveOne queries a given record type of a given domain. The returned error is set
+// only for actual failures, a non-existent domain is just no answers.
+func (resolver *DNSResolver) resolveOne(ctx context.Context, domain string, qType uint16, nameServer string) (answers []DNSRecordPair, err error) {
+	key := dnsCacheKey{domain: domain, qType: qType}
+	if cached, ok := resolver.cacheLookup(key); ok {
+		return cached, nil
+	}
+
+	if qType == dns.TypeAXFR {
+		if !resolver.ZoneTransfer {
+			// Zone transfers are opt-in (see WithZoneTransfer).
+			return answers, nil
+		}
+		// Zone transfers are not plain queries.
+		return resolver.resolveTransfer(ctx, domain, nameServer)
+	}
+
+	msg, err := resolver.limiter.query(ctx, domain, qType, nameServer, resolver.Client)
 	if err != nil {
 		LogErr("%s: %s %s -> %s", TypeDNS, dns.TypeToString[qType], domain, err.Error())
-		return answers
+		if err.Error() == dns.RcodeToString[dns.RcodeNameError] {
+			return answers, nil
+		}
+		return answers, fmt.Errorf("%s %s: %s", dns.TypeToString[qType], domain, err.Error())
 	}
 
-	for _, rr := range msg.Answer {
+	if resolver.Cookies && hasServerCookie(msg, resolver.clientCookie) {
+		resolver.addServerCookie(nameServer)
+	}
+
+	records := msg.Answer
+	if resolver.MaxRecordsPerQuery > 0 && len(records) > resolver.MaxRecordsPerQuery {
+		LogErr("%s: %s %s -> %d records returned, keeping first %d.", TypeDNS, dns.TypeToString[qType], domain, len(records), resolver.MaxRecordsPerQuery)
+		records = records[:resolver.MaxRecordsPerQuery]
+	}
+
+	ttl := resolver.CacheTTL
+	for _, rr := range records {
 		answers = append(answers, DNSRecordPair{
 			QueryType: qType,
 			Record:    &DNSRecord{rr},
 		})
+
+		// Never cache longer than the records live.
+		if recordTTL := time.Duration(rr.Header().Ttl) * time.Second; recordTTL < ttl {
+			ttl = recordTTL
+		}
 	}
 
-	return answers
+	resolver.cacheAnswers(key, answers, ttl)
+
+	return answers, nil
 }
 
-func (resolver *DNSResolver) findNameServerFor(domain string) string {
+// resolveTransfer attempts a zone transfer (AXFR) of a given domain from each of its authoritative
+// name servers (or the user-supplied one) and returns all the records of the zone from the first
+// one allowing it. Refusals are expected, so they are not considered failures.
+func (resolver *DNSResolver) resolveTransfer(ctx context.Context, domain string, nameServer string) (answers []DNSRecordPair, err error) {
+	key := dnsCacheKey{domain: domain, qType: dns.TypeAXFR}
+
+	for _, target := range resolver.transferTargetsFor(ctx, domain, nameServer) {
+		if !resolver.limiter.acquire(ctx) {
+			return answers, ctx.Err()
+		}
+		records, transferErr := transferZone(ctx, domain, target)
+		resolver.limiter.release()
+
+		if transferErr != nil {
+			if isTransferRefused(transferErr) {
+				LogDebug("%s: AXFR %s -> refused by %s.", TypeDNS, domain, target)
+			} else {
+				LogErr("%s: AXFR %s -> %s failed: %s", TypeDNS, domain, target, transferErr.Error())
+				err = fmt.Errorf("AXFR %s: %s", domain, transferErr.Error())
+			}
+			continue
+		}
+
+		LogInfo("%s: AXFR %s -> zone transfer allowed by %s (%d records).", TypeDNS, domain, target, len(records))
+		if resolver.MaxRecordsPerQuery > 0 && len(records) > resolver.MaxRecordsPerQuery {
+			LogErr("%s: AXFR %s -> %d records returned, keeping first %d.", TypeDNS, domain, len(records), resolver.MaxRecordsPerQuery)
+			records = records[:resolver.MaxRecordsPerQuery]
+		}
+		for _, rr := range records {
+			answers = append(answers, DNSRecordPair{QueryType: dns.TypeAXFR, Record: &DNSRecord{rr}})
+		}
+		resolver.cacheAnswers(key, answers, resolver.CacheTTL)
+		return answers, nil
+	}
+
+	return answers, err
+}
+
+// transferTargetsFor returns addresses (host:port) of name servers to request a zone transfer
+// of a given domain from, i.e. the user-supplied one or those authoritative for the domain.
+// Domains which are not zones (i.e. have no NS records) have none.
+func (resolver *DNSResolver) transferTargetsFor(ctx context.Context, domain string, nameServer string) (targets []string) {
+	if resolver.NameServer != "" {
+		return []string{resolver.NameServer}
+	}
+
+	msg, err := resolver.limiter.query(ctx, domain, dns.TypeNS, nameServer, resolver.Client)
+	if err != nil {
+		LogDebug("%s: NS %s -> %s, no zone to transfer.", TypeDNS, domain, err.Error())
+		return targets
+	}
+	for _, rr := range msg.Answer {
+		if ns, ok := rr.(*dns.NS); ok {
+			targets = append(targets, normalizeName(ns.Ns)+":53")
+		}
+	}
+	return uniqueStrings(targets)
+}
+
+// transferZone requests a zone transfer (AXFR) of a given domain from a given name server over TCP
+// (via a custom dialer if the context carries one, see withDNSDialContext) and returns all the records.
+// The transfer ends by the context deadline at the latest.
+// The closing SOA record of the transfer is left out, since it repeats the opening one.
+func transferZone(ctx context.Context, domain string, nameServer string) (records []dns.RR, err error) {
+	msg := &dns.Msg{}
+	msg.SetAxfr(dns.Fqdn(domain))
+
+	dial := DialContextFunc((&net.Dialer{Timeout: DefaultTimeout}).DialContext)
+	if custom, ok := ctx.Value(dnsDialContextKey{}).(DialContextFunc); ok {
+		dial = custom
+	}
+	conn, err := dial(ctx, "tcp", nameServer)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn = &deadlineConn{Conn: conn, deadline: deadline}
+		_ = conn.SetDeadline(deadline)
+	}
+
+	transfer := &dns.Transfer{Conn: &dns.Conn{Conn: conn}, ReadTimeout: DefaultTimeout, WriteTimeout: DefaultTimeout}
+	envelopes, err := transfer.In(msg, nameServer)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The transfer closes the connection once done.
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			return nil, envelope.Error
+		}
+		records = append(records, envelope.RR...)
+	}
+
+	if len(records) > 1 && records[len(records)-1].Header().Rrtype == dns.TypeSOA {
+		records = records[:len(records)-1]
+	}
+	return records, nil
+}
+
+// deadlineConn is a connection whose deadlines never exceed a given one,
+// e.g. the zone transfer resets the read deadline before each message.
+type deadlineConn struct {
+	net.Conn
+	deadline time.Time
+}
+
+func (conn *deadlineConn) SetDeadline(t time.Time) error {
+	return conn.Conn.SetDeadline(conn.capped(t))
+}
+
+func (conn *deadlineConn) SetReadDeadline(t time.Time) error {
+	return conn.Conn.SetReadDeadline(conn.capped(t))
+}
+
+func (conn *deadlineConn) SetWriteDeadline(t time.Time) error {
+	return conn.Conn.SetWriteDeadline(conn.capped(t))
+}
+
+// capped returns the earlier of a given deadline and the connection one (no deadline counts as the latest).
+func (conn *deadlineConn) capped(t time.Time) time.Time {
+	if t.IsZero() || t.After(conn.deadline) {
+		return conn.deadline
+	}
+	return t
+}
+
+// isTransferRefused tells if a given zone transfer failure is a refusal by the name server
+// (i.e. the usual case), either by an error code or by closing the connection.
+func isTransferRefused(err error) bool {
+	if errors.Is(err, io.EOF) {
+		return true
+	}
+	for _, rcode := range []int{dns.RcodeRefused, dns.RcodeNotAuth, dns.RcodeNotImplemented, dns.RcodeNameError} {
+		if err.Error() == fmt.Sprintf("dns: bad xfr rcode: %d", rcode) {
+			return true
+		}
+	}
+	return false
+}
+
+// addServerCookie remembers that a given name server has echoed a valid cookie.
+func (resolver *DNSResolver) addServerCookie(nameServer string) {
+	resolver.cacheMutex.Lock()
+	if resolver.cookieServers == nil {
+		resolver.cookieServers = map[string]bool{}
+	}
+	resolver.cookieServers[nameServer] = true
+	resolver.cacheMutex.Unlock()
+}
+
+// hasServerCookie tells if a given name server has echoed a valid cookie so far.
+func (resolver *DNSResolver) hasServerCookie(nameServer string) bool {
+	resolver.cacheMutex.RLock()
+	defer resolver.cacheMutex.RUnlock()
+	return resolver.cookieServers[nameServer]
+}
+
+// cacheLookup returns cached answers for a given key unless they have expired.
+func (resolver *DNSResolver) cacheLookup(key dnsCacheKey) ([]DNSRecordPair, bool) {
+	resolver.cacheMutex.RLock()
+	defer resolver.cacheMutex.RUnlock()
+
+	entry := resolver.answerCache[key]
+	if entry == nil || clockNow(resolver.Clock).After(entry.expires) {
+		return nil, false
+	}
+	return entry.answers, true
+}
+
+// cacheAnswers stores given answers for a given time (if any).
+func (resolver *DNSResolver) cacheAnswers(key dnsCacheKey, answers []DNSRecordPair, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	resolver.cacheMutex.Lock()
+	resolver.answerCache[key] = &dnsCacheEntry{answers: answers, expires: clockNow(resolver.Clock).Add(ttl)}
+	resolver.cacheMutex.Unlock()
+}
+
+func (resolver *DNSResolver) findNameServerFor(ctx context.Context, domain string) string {
 	// Use user-supplied NS if available.
 	if resolver.NameServer != "" {
 		return resolver.NameServer
 	}
 
 	// Check NS cache.
-	if resolver.nameServerCache[domain] != "" {
-		return resolver.nameServerCache[domain]
+	resolver.cacheMutex.RLock()
+	cachedNameServer := resolver.nameServerCache[domain]
+	resolver.cacheMutex.RUnlock()
+	if cachedNameServer != "" {
+		return cachedNameServer
 	}
 
 	// Use DNS NS lookup.
-	nameServer := resolver.getNameServerFor(domain)
+	nameServer := resolver.getNameServerFor(ctx, domain)
 
 	if nameServer != "" {
 		// OK, NS found.
 	} else if IsSubdomain(domain) {
 		// This is a subdomain -> try the parent.
 		LogDebug("%s: No NS found for subdomain %s -> trying parent domain.", TypeDNS, domain)
-		nameServer = resolver.findNameServerFor(ParentDomainOf(domain))
+		nameServer = resolver.findNameServerFor(ctx, ParentDomainOf(domain))
 	} else {
 		// Fallback to local NS.
 		LogErr("%s: Could not resolve NS for domain %s -> falling back to local.", TypeDNS, domain)
-		nameServer = localNameServer
+		nameServer = getLocalNameServer()
 	}
 
 	// Cache the result.
+	resolver.cacheMutex.Lock()
 	resolver.nameServerCache[domain] = nameServer
+	resolver.cacheMutex.Unlock()
 
 	return nameServer
 }
 
-func (resolver *DNSResolver) getNameServerFor(domain string) string {
+func (resolver *DNSResolver) getNameServerFor(ctx context.Context, domain string) string {
 	var nsRecord *dns.NS
 
 	// Do a NS query.
-	msg, err := queryOneCallback(domain, dns.TypeNS, localNameServer, resolver.Client)
+	msg, err := resolver.limiter.query(ctx, domain, dns.TypeNS, getLocalNameServer(), resolver.Client)
 	if err != nil {
 		LogErr("%s: %s %s -> %s", TypeDNS, "NS", domain, err.Error())
 	} else {
@@ -279,21 +1041,99 @@ func (res *DNSResolution) Type() ResolutionType {
 }
 
 // Domains returns a list of domains discovered in records within this Resolution.
+// Owners of records from a zone transfer are discovered domains too.
 func (res *DNSResolution) Domains() (domains []string) {
 	for _, answer := range res.Records {
+		if answer.QueryType == dns.TypeAXFR {
+			domains = append(domains, CleanDomain(answer.Record.Header().Name))
+		}
 		domains = append(domains, dissectDomainsFromRecord(answer.Record.RR)...)
 	}
+	for _, key := range res.DKIMKeys {
+		domains = append(domains, dissectDomainsFromRecord(key.Record.RR)...)
+	}
+	for _, domain := range res.WalkedDomains {
+		domains = append(domains, CleanDomain(domain))
+	}
 	return domains
 }
 
 // IPs returns a list of IP addresses discovered in this resolution.
+// With DNSResolver.SPFOnlyTXTIPs, TXT records other than SPF policies (e.g. DKIM) are skipped.
 func (res *DNSResolution) IPs() (ips []string) {
 	for _, answer := range res.Records {
+		if txt, ok := answer.Record.RR.(*dns.TXT); ok && res.spfOnlyTXTIPs && !isSPFRecord(txt) {
+			continue
+		}
 		ips = append(ips, dissectIPsFromRecord(answer.Record.RR)...)
 	}
 	return ips
 }
 
+// Raw returns all DNS records within this resolution as []dns.RR.
+func (res *DNSResolution) Raw() interface{} {
+	records := make([]dns.RR, 0, len(res.Records))
+	for _, answer := range res.Records {
+		records = append(records, answer.Record.RR)
+	}
+	return records
+}
+
+// Hosts merges A and AAAA records within this resolution into a list of hosts,
+// so that all addresses of a single host name are grouped together.
+// The hosts are returned in the order in which they first appeared.
+func (res *DNSResolution) Hosts() (hosts []Host) {
+	index := map[string]int{}
+	seen := map[string]bool{}
+
+	for _, answer := range res.Records {
+		var ip string
+		rrType := answer.Record.Header().Rrtype
+
+		switch rrType {
+		case dns.TypeA:
+			ip = answer.Record.RR.(*dns.A).A.String()
+			break
+
+		case dns.TypeAAAA:
+			ip = answer.Record.RR.(*dns.AAAA).AAAA.String()
+			break
+
+		default:
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimSuffix(answer.Record.Header().Name, "."))
+		if seen[name+" "+ip] {
+			continue
+		}
+		seen[name+" "+ip] = true
+
+		i, ok := index[name]
+		if !ok {
+			i = len(hosts)
+			index[name] = i
+			hosts = append(hosts, Host{Name: name})
+		}
+
+		if rrType == dns.TypeA {
+			hosts[i].IPv4s = append(hosts[i].IPv4s, ip)
+		} else {
+			hosts[i].IPv6s = append(hosts[i].IPv6s, ip)
+		}
+	}
+
+	return hosts
+}
+
+/////////////////////////////////////////
+// HOST
+/////////////////////////////////////////
+
+func (host *Host) String() string {
+	return fmt.Sprintf("name: %s, ipv4: %v, ipv6: %v", host.Name, host.IPv4s, host.IPv6s)
+}
+
 /////////////////////////////////////////
 // DNS RECORD
 /////////////////////////////////////////
@@ -1,9 +1,14 @@
 package udig
 
 import (
+	"context"
 	"errors"
+	"net"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
@@ -15,7 +20,7 @@ func Test_When_DnsResolver_Resolve_completes_Then_all_records_are_picked(t *test
 
 	counterMux := sync.Mutex{}
 	invocationCount := 0
-	queryOneCallback = func(domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
 		count := recordsAvailable - invocationCount
 
 		// We need to count with a mutex, because DNS queries are run concurrently.
@@ -37,12 +42,13 @@ func Test_When_DnsResolver_Resolve_completes_Then_all_records_are_picked(t *test
 	resolver := NewDNSResolver()
 
 	// Execute.
-	resolution := resolver.ResolveDomain("all.tens.ten").(*DNSResolution)
+	resolution := resolver.ResolveDomain(context.Background(), "all.tens.ten").(*DNSResolution)
 
 	// Assert.
 
-	// There should have been 1 invocation per DNS query type and additional 2 spent on NS queries for all.tens.ten + tens.ten.
-	assert.Equal(t, len(DefaultDNSQueryTypes)+2, invocationCount)
+	// There should have been 1 invocation per DNS query type but AXFR (zone transfers are opt-in),
+	// 1 per DKIM selector and additional 2 spent on NS queries for all.tens.ten + tens.ten.
+	assert.Equal(t, len(DefaultDNSQueryTypes)-1+len(DefaultDKIMSelectors)+2, invocationCount)
 
 	// There should be a record for each mocked response.
 	assert.Len(t, resolution.Records, recordsAvailable-2)
@@ -51,7 +57,7 @@ func Test_When_DnsResolver_Resolve_completes_Then_all_records_are_picked(t *test
 func Test_When_DnsResolver_Resolve_completes_Then_custom_NameServer_was_used(t *testing.T) {
 	// Mock.
 	var usedNameServer string
-	queryOneCallback = func(domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
 		usedNameServer = nameServer
 		return &dns.Msg{}, nil
 	}
@@ -61,15 +67,180 @@ func Test_When_DnsResolver_Resolve_completes_Then_custom_NameServer_was_used(t *
 	resolver.NameServer = "1.1.1.1"
 
 	// Execute.
-	resolver.ResolveDomain("example.com")
+	resolver.ResolveDomain(context.Background(), "example.com")
 
 	// Assert.
 	assert.Equal(t, resolver.NameServer, usedNameServer)
 }
 
+func Test_When_DnsResolver_MaxConcurrency_is_set_Then_queries_in_flight_are_capped(t *testing.T) {
+	// Mock.
+	counterMux := sync.Mutex{}
+	inFlight, maxInFlight := 0, 0
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		counterMux.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		counterMux.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		counterMux.Lock()
+		inFlight--
+		counterMux.Unlock()
+
+		return &dns.Msg{}, nil
+	}
+
+	// Setup.
+	resolver := NewDNSResolver()
+	resolver.NameServer = "1.1.1.1"
+	resolver.MaxConcurrency = 2
+
+	// Execute.
+	resolver.ResolveDomain(context.Background(), "example.com")
+
+	// Assert.
+	assert.Equal(t, 2, maxInFlight)
+}
+
+func Test_When_DnsResolver_context_is_cancelled_Then_remaining_queries_are_not_dispatched(t *testing.T) {
+	// Setup.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Mock.
+	var invocationCount int
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		invocationCount++
+		cancel()
+		return mockDNSResponse(dns.TypeA, 1), nil
+	}
+
+	resolver := NewDNSResolver()
+	resolver.NameServer = "1.1.1.1"
+	resolver.MaxConcurrency = 1
+
+	// Execute.
+	resolution := resolver.ResolveDomain(ctx, "example.com").(*DNSResolution)
+
+	// Assert.
+	assert.Equal(t, 1, invocationCount)
+	assert.Len(t, resolution.Records, 1)
+	assert.Empty(t, resolution.DKIMKeys)
+}
+
+func Test_When_DnsResolver_CacheTTL_is_set_Then_answers_are_reused(t *testing.T) {
+	// Mock.
+	var invocationCount int
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		invocationCount++
+		msg := mockDNSResponse(dns.TypeA, 1)
+		msg.Answer[0].Header().Ttl = 300
+		return msg, nil
+	}
+
+	// Setup.
+	resolver := NewDNSResolver()
+	resolver.NameServer = "1.1.1.1"
+	resolver.QueryTypes = []uint16{dns.TypeA}
+	resolver.DKIMSelectors = []string{}
+	resolver.CacheTTL = time.Minute
+
+	// Execute.
+	first := resolver.ResolveDomain(context.Background(), "example.com").(*DNSResolution)
+	second := resolver.ResolveDomain(context.Background(), "example.com").(*DNSResolution)
+
+	// Assert.
+	assert.Equal(t, 1, invocationCount)
+	assert.Len(t, first.Records, 1)
+	assert.Equal(t, first.Records, second.Records)
+}
+
+func Test_When_DNS_record_TTL_is_zero_Then_answers_are_not_cached(t *testing.T) {
+	// Mock.
+	var invocationCount int
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		invocationCount++
+		return mockDNSResponse(dns.TypeA, 1), nil
+	}
+
+	// Setup.
+	resolver := NewDNSResolver()
+	resolver.NameServer = "1.1.1.1"
+	resolver.QueryTypes = []uint16{dns.TypeA}
+	resolver.DKIMSelectors = []string{}
+	resolver.CacheTTL = time.Minute
+
+	// Execute.
+	resolver.ResolveDomain(context.Background(), "example.com")
+	resolver.ResolveDomain(context.Background(), "example.com")
+
+	// Assert.
+	assert.Equal(t, 2, invocationCount)
+}
+
+func Test_When_DetectWildcards_is_set_and_random_label_resolves_Then_resolution_is_wildcard(t *testing.T) {
+	// Mock.
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		// Every *.example.com resolves to the same IP.
+		msg := &dns.Msg{}
+		if qType == dns.TypeA {
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: dns.Fqdn(domain), Rrtype: dns.TypeA},
+				A:   net.ParseIP("192.0.2.1"),
+			})
+		}
+		return msg, nil
+	}
+
+	// Setup.
+	resolver := NewDNSResolver()
+	resolver.NameServer = "1.1.1.1"
+	resolver.QueryTypes = []uint16{dns.TypeA}
+	resolver.DKIMSelectors = []string{}
+	resolver.DetectWildcards = true
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "foo.example.com").(*DNSResolution)
+
+	// Assert.
+	assert.True(t, resolution.Wildcard)
+}
+
+func Test_When_DetectWildcards_is_set_and_random_label_does_not_exist_Then_resolution_is_not_wildcard(t *testing.T) {
+	// Mock.
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		if domain != "foo.example.com" {
+			return nil, errors.New("NXDOMAIN")
+		}
+		msg := &dns.Msg{}
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: dns.Fqdn(domain), Rrtype: dns.TypeA},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+		return msg, nil
+	}
+
+	// Setup.
+	resolver := NewDNSResolver()
+	resolver.NameServer = "1.1.1.1"
+	resolver.QueryTypes = []uint16{dns.TypeA}
+	resolver.DKIMSelectors = []string{}
+	resolver.DetectWildcards = true
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "foo.example.com").(*DNSResolution)
+
+	// Assert.
+	assert.False(t, resolution.Wildcard)
+}
+
 func Test_When_queryOne_returns_error_Then_empty_response(t *testing.T) {
 	// Mock.
-	queryOneCallback = func(domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
 		var msg *dns.Msg
 		return msg, errors.New("something silly happened")
 	}
@@ -79,15 +250,145 @@ func Test_When_queryOne_returns_error_Then_empty_response(t *testing.T) {
 	resolver.QueryTypes = []uint16{dns.TypeA}
 
 	// Execute.
-	resolution := resolver.ResolveDomain("example.com")
+	resolution := resolver.ResolveDomain(context.Background(), "example.com")
 
 	// Assert.
 	assert.Len(t, resolution.Domains(), 0)
 }
 
+func Test_When_DNS_query_times_out_Then_resolution_has_error(t *testing.T) {
+	// Mock.
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		return nil, errors.New("timeout")
+	}
+
+	// Setup.
+	resolver := NewDNSResolver()
+	resolver.NameServer = "127.0.0.1:53"
+	resolver.QueryTypes = []uint16{dns.TypeA}
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*DNSResolution)
+
+	// Assert.
+	assert.Empty(t, resolution.Records)
+	assert.EqualError(t, resolution.Error(), "A example.com: timeout")
+}
+
+func Test_When_domain_does_not_exist_Then_resolution_has_no_error(t *testing.T) {
+	// Mock.
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		return nil, errors.New(dns.RcodeToString[dns.RcodeNameError])
+	}
+
+	// Setup.
+	resolver := NewDNSResolver()
+	resolver.NameServer = "127.0.0.1:53"
+	resolver.QueryTypes = []uint16{dns.TypeA}
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*DNSResolution)
+
+	// Assert.
+	assert.Empty(t, resolution.Records)
+	assert.NoError(t, resolution.Error())
+}
+
+func Test_When_queryOne_gets_truncated_answer_Then_it_retries_over_TCP(t *testing.T) {
+	// Mock.
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	tcpListener, err := net.Listen("tcp", udpConn.LocalAddr().String())
+	assert.NoError(t, err)
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		res := &dns.Msg{}
+		res.SetReply(req)
+		if w.RemoteAddr().Network() == "udp" {
+			res.Truncated = true
+		} else {
+			res.Answer = append(res.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+				Txt: []string{"full answer"},
+			})
+		}
+		_ = w.WriteMsg(res)
+	})
+
+	udpServer := &dns.Server{PacketConn: udpConn, Handler: handler}
+	tcpServer := &dns.Server{Listener: tcpListener, Handler: handler}
+	go func() { _ = udpServer.ActivateAndServe() }()
+	go func() { _ = tcpServer.ActivateAndServe() }()
+	defer udpServer.Shutdown()
+	defer tcpServer.Shutdown()
+
+	// Execute.
+	msg, err := queryOne(context.Background(), "example.com", dns.TypeTXT, udpConn.LocalAddr().String(), &dns.Client{ReadTimeout: DefaultTimeout})
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.False(t, msg.Truncated)
+	assert.Len(t, msg.Answer, 1)
+}
+
+func Test_When_DKIM_selector_exists_Then_its_key_is_recorded(t *testing.T) {
+	// Mock.
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		if domain != "sel1._domainkey.example.com" {
+			return nil, errors.New("NXDOMAIN")
+		}
+
+		msg := &dns.Msg{}
+		msg.Answer = append(msg.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: domain + ".", Rrtype: dns.TypeTXT},
+			Txt: []string{"v=DKIM1; k=rsa; p=MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQC"},
+		})
+		return msg, nil
+	}
+
+	// Setup.
+	resolver := NewDNSResolver()
+	resolver.NameServer = "1.1.1.1"
+	resolver.QueryTypes = []uint16{}
+	resolver.DKIMSelectors = []string{"sel0", "sel1", "sel2"}
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*DNSResolution)
+
+	// Assert.
+	assert.Len(t, resolution.DKIMKeys, 1)
+	assert.Equal(t, "sel1", resolution.DKIMKeys[0].Selector)
+	assert.Contains(t, resolution.DKIMKeys[0].Record.String(), "v=DKIM1")
+}
+
+func Test_When_resolv_conf_is_missing_Then_fallback_NameServer_is_used(t *testing.T) {
+	// Setup.
+	ResolvConfPath = filepath.Join(t.TempDir(), "missing.conf")
+	defer func() { ResolvConfPath = "/etc/resolv.conf" }()
+
+	// Execute.
+	nameServer := findLocalNameServer()
+
+	// Assert.
+	assert.Equal(t, FallbackNameServer, nameServer)
+}
+
+func Test_When_resolv_conf_is_custom_Then_its_NameServer_is_used(t *testing.T) {
+	// Setup.
+	ResolvConfPath = filepath.Join(t.TempDir(), "resolv.conf")
+	defer func() { ResolvConfPath = "/etc/resolv.conf" }()
+	assert.NoError(t, os.WriteFile(ResolvConfPath, []byte("nameserver 2001:db8::53\n"), 0644))
+
+	// Execute.
+	nameServer := findLocalNameServer()
+
+	// Assert.
+	assert.Equal(t, "[2001:db8::53]:53", nameServer)
+}
+
 func Test_That_findNameServerFor_dissects_NS_records(t *testing.T) {
 	// Mock.
-	queryOneCallback = func(domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
 		msg := mockDNSResponse(dns.TypeNS, 1)
 		rr := &msg.Answer[0]
 		(*rr).(*dns.NS).Ns = "ns.example.com."
@@ -99,7 +400,7 @@ func Test_That_findNameServerFor_dissects_NS_records(t *testing.T) {
 	resolver := NewDNSResolver()
 
 	// Execute.
-	nameServer := resolver.findNameServerFor("example.com")
+	nameServer := resolver.findNameServerFor(context.Background(), "example.com")
 
 	// Assert.
 	assert.Equal(t, "ns.example.com:53", nameServer)
@@ -109,7 +410,7 @@ func Test_That_findNameServerFor_caches_results(t *testing.T) {
 	// Mock.
 	counterMux := sync.Mutex{}
 	var invocationCount int
-	queryOneCallback = func(domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
 		// We need to count with a mutex, because DNS queries are run concurrently.
 		counterMux.Lock()
 		invocationCount++
@@ -126,8 +427,8 @@ func Test_That_findNameServerFor_caches_results(t *testing.T) {
 	resolver := NewDNSResolver()
 
 	// Execute.
-	_ = resolver.findNameServerFor("example.com")
-	_ = resolver.findNameServerFor("example.com")
+	_ = resolver.findNameServerFor(context.Background(), "example.com")
+	_ = resolver.findNameServerFor(context.Background(), "example.com")
 
 	// Assert.
 	assert.Equal(t, 1, invocationCount)
@@ -164,6 +465,25 @@ func Test_dissectDomain_By_TXT_record(t *testing.T) {
 	assert.Equal(t, "related.example.com", domains[0])
 }
 
+func Test_dissectDomain_By_SPF_record(t *testing.T) {
+	// Setup.
+	record := &dns.TXT{
+		Hdr: dns.RR_Header{Name: "example.com", Rrtype: dns.TypeTXT},
+		Txt: []string{
+			"v=spf1 ip4:192.0.2.0/24 include:_spf.example.net ~include:",
+			" include:%{i}._spf.example.org redirect=spf.example.org -all",
+		},
+	}
+
+	// Execute.
+	domains := dissectDomainsFromRecord(record)
+
+	// Assert.
+	assert.Contains(t, domains, "_spf.example.net")
+	assert.Contains(t, domains, "spf.example.org")
+	assert.NotContains(t, domains, "%{i}._spf.example.org")
+}
+
 func Test_dissectDomain_By_RRSIG_record(t *testing.T) {
 	// Setup.
 	record := &dns.RRSIG{
@@ -248,6 +568,42 @@ func Test_dissectDomain_By_KX_record(t *testing.T) {
 	assert.Equal(t, "related.example.com", domains[0])
 }
 
+func Test_dissectDomain_By_HTTPS_record(t *testing.T) {
+	// Setup.
+	record := &dns.HTTPS{SVCB: dns.SVCB{
+		Hdr:      dns.RR_Header{Name: "example.com", Rrtype: dns.TypeHTTPS},
+		Priority: 1,
+		Target:   "related.example.com.",
+	}}
+
+	// Execute.
+	domains := dissectDomainsFromRecord(record)
+
+	// Assert.
+	assert.Equal(t, []string{"related.example.com"}, domains)
+}
+
+func Test_dissectIPs_By_SVCB_record(t *testing.T) {
+	// Setup.
+	record := &dns.SVCB{
+		Hdr:      dns.RR_Header{Name: "example.com", Rrtype: dns.TypeSVCB},
+		Priority: 1,
+		Target:   ".",
+		Value: []dns.SVCBKeyValue{
+			&dns.SVCBIPv4Hint{Hint: []net.IP{net.ParseIP("192.0.2.1")}},
+			&dns.SVCBIPv6Hint{Hint: []net.IP{net.ParseIP("2001:db8::1")}},
+		},
+	}
+
+	// Execute.
+	ips := dissectIPsFromRecord(record)
+	domains := dissectDomainsFromRecord(record)
+
+	// Assert.
+	assert.Equal(t, []string{"192.0.2.1", "2001:db8::1"}, ips)
+	assert.Empty(t, domains)
+}
+
 func Test_dissectDomain_By_unsupported_record(t *testing.T) {
 	// Setup.
 	record := &dns.MB{
@@ -262,6 +618,54 @@ func Test_dissectDomain_By_unsupported_record(t *testing.T) {
 	assert.Empty(t, domains)
 }
 
+func Test_That_DNSResolution_Hosts_merges_A_and_AAAA_records(t *testing.T) {
+	// Setup.
+	resolution := &DNSResolution{
+		ResolutionBase: &ResolutionBase{query: "example.com"},
+		Records: []DNSRecordPair{
+			{QueryType: dns.TypeA, Record: &DNSRecord{&dns.A{
+				Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA},
+				A:   net.ParseIP("93.184.216.34"),
+			}}},
+			{QueryType: dns.TypeAAAA, Record: &DNSRecord{&dns.AAAA{
+				Hdr:  dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeAAAA},
+				AAAA: net.ParseIP("2606:2800:220:1:248:1893:25c8:1946"),
+			}}},
+			{QueryType: dns.TypeA, Record: &DNSRecord{&dns.A{
+				Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA},
+				A:   net.ParseIP("93.184.216.34"),
+			}}},
+		},
+	}
+
+	// Execute.
+	hosts := resolution.Hosts()
+
+	// Assert.
+	assert.Len(t, hosts, 1)
+	assert.Equal(t, "example.com", hosts[0].Name)
+	assert.Equal(t, []string{"93.184.216.34"}, hosts[0].IPv4s)
+	assert.Equal(t, []string{"2606:2800:220:1:248:1893:25c8:1946"}, hosts[0].IPv6s)
+}
+
+func Test_That_DNSResolution_Raw_returns_DNS_records(t *testing.T) {
+	// Setup.
+	record := &dns.A{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA},
+		A:   net.ParseIP("93.184.216.34"),
+	}
+	var resolution Resolution = &DNSResolution{
+		ResolutionBase: &ResolutionBase{query: "example.com"},
+		Records:        []DNSRecordPair{{QueryType: dns.TypeA, Record: &DNSRecord{record}}},
+	}
+
+	// Execute.
+	raw := resolution.(RawResolution).Raw()
+
+	// Assert.
+	assert.Equal(t, []dns.RR{record}, raw)
+}
+
 func Test_parentDomainOf_By_subdomain(t *testing.T) {
 	// Setup.
 	domain := "sub.example.com"
@@ -307,3 +711,514 @@ func mockDNSResponse(qType uint16, numRecords int) *dns.Msg {
 
 	return msg
 }
+
+func mockNSECChain(chain map[string]string) {
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		msg := &dns.Msg{}
+		if next, ok := chain[domain]; ok && qType == dns.TypeNSEC {
+			msg.Answer = append(msg.Answer, &dns.NSEC{
+				Hdr:        dns.RR_Header{Name: dns.Fqdn(domain), Rrtype: dns.TypeNSEC, Class: dns.ClassINET},
+				NextDomain: dns.Fqdn(next),
+			})
+		}
+		return msg, nil
+	}
+}
+
+func Test_When_zone_walk_is_enabled_Then_NSEC_chain_is_followed(t *testing.T) {
+	// Mock.
+	mockNSECChain(map[string]string{
+		"example.com":      "api.example.com",
+		"api.example.com":  "mail.example.com",
+		"mail.example.com": "example.com",
+	})
+
+	// Setup.
+	resolver := NewDNSResolver()
+	resolver.NameServer = "127.0.0.1:53"
+	resolver.QueryTypes = []uint16{dns.TypeNSEC}
+	resolver.DKIMSelectors = nil
+	resolver.ZoneWalkLimit = 10
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*DNSResolution)
+
+	// Assert.
+	assert.Equal(t, []string{"api.example.com", "mail.example.com"}, resolution.WalkedDomains)
+	assert.Contains(t, resolution.Domains(), "mail.example.com")
+}
+
+func Test_When_zone_walk_hits_the_limit_Then_it_stops(t *testing.T) {
+	// Mock.
+	mockNSECChain(map[string]string{
+		"example.com":   "a.example.com",
+		"a.example.com": "b.example.com",
+		"b.example.com": "c.example.com",
+		"c.example.com": "example.com",
+	})
+
+	// Setup.
+	resolver := NewDNSResolver()
+	resolver.NameServer = "127.0.0.1:53"
+	resolver.QueryTypes = []uint16{dns.TypeNSEC}
+	resolver.DKIMSelectors = nil
+	resolver.ZoneWalkLimit = 2
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*DNSResolution)
+
+	// Assert.
+	assert.Equal(t, []string{"a.example.com", "b.example.com"}, resolution.WalkedDomains)
+}
+
+func Test_When_zone_walk_is_disabled_Then_NSEC_chain_is_not_followed(t *testing.T) {
+	// Mock.
+	mockNSECChain(map[string]string{
+		"example.com":   "a.example.com",
+		"a.example.com": "example.com",
+	})
+
+	// Setup.
+	resolver := NewDNSResolver()
+	resolver.NameServer = "127.0.0.1:53"
+	resolver.QueryTypes = []uint16{dns.TypeNSEC}
+	resolver.DKIMSelectors = nil
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*DNSResolution)
+
+	// Assert.
+	assert.Empty(t, resolution.WalkedDomains)
+}
+
+func Test_When_DnsResolver_MaxRecordsPerQuery_is_set_Then_answers_are_truncated(t *testing.T) {
+	// Mock.
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		return mockDNSResponse(dns.TypeA, 500), nil
+	}
+
+	// Setup.
+	resolver := NewDNSResolver()
+	resolver.NameServer = "127.0.0.1:53"
+	resolver.QueryTypes = []uint16{dns.TypeA}
+	resolver.DKIMSelectors = nil
+	resolver.MaxRecordsPerQuery = 10
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*DNSResolution)
+
+	// Assert.
+	assert.Len(t, resolution.Records, 10)
+}
+
+func Test_When_LookupRecords_is_called_Then_answer_records_are_returned(t *testing.T) {
+	// Mock.
+	var usedNameServer string
+	var usedType uint16
+	expected := []dns.RR{
+		&dns.MX{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeMX, Class: dns.ClassINET}, Preference: 10, Mx: "mx1.example.com."},
+		&dns.MX{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeMX, Class: dns.ClassINET}, Preference: 20, Mx: "mx2.example.com."},
+	}
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		usedNameServer, usedType = nameServer, qType
+		return &dns.Msg{Answer: expected}, nil
+	}
+
+	// Execute.
+	records, err := LookupRecords("example.com", dns.TypeMX, WithNameServer("9.9.9.9:53"))
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.Equal(t, expected, records)
+	assert.Equal(t, "9.9.9.9:53", usedNameServer)
+	assert.Equal(t, dns.TypeMX, usedType)
+}
+
+func Test_When_LookupRecords_fails_Then_error_is_returned(t *testing.T) {
+	// Mock.
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		return nil, errors.New("NXDOMAIN")
+	}
+
+	// Execute.
+	records, err := LookupRecords("nonexistent.example.com", dns.TypeA, WithNameServer("9.9.9.9:53"))
+
+	// Assert.
+	assert.EqualError(t, err, "NXDOMAIN")
+	assert.Nil(t, records)
+}
+
+func Test_When_CAA_records_are_dissected_Then_CAs_and_report_hosts_are_found(t *testing.T) {
+	// Setup.
+	records := []*dns.CAA{
+		{Hdr: dns.RR_Header{Rrtype: dns.TypeCAA}, Tag: "issue", Value: "letsencrypt.org; accounturi=https://acme.example.net/1"},
+		{Hdr: dns.RR_Header{Rrtype: dns.TypeCAA}, Tag: "issuewild", Value: ";"},
+		{Hdr: dns.RR_Header{Rrtype: dns.TypeCAA}, Tag: "iodef", Value: "https://caa.example.com/report"},
+		{Hdr: dns.RR_Header{Rrtype: dns.TypeCAA}, Tag: "iodef", Value: "mailto:security@example.org"},
+	}
+
+	// Execute.
+	var domains []string
+	for _, record := range records {
+		domains = append(domains, dissectDomainsFromRecord(record)...)
+	}
+
+	// Assert.
+	assert.Equal(t, []string{"letsencrypt.org", "caa.example.com", "example.org"}, domains)
+}
+
+func Test_When_WithDNSPreset_is_used_Then_preset_query_types_are_set(t *testing.T) {
+	// Setup.
+	expected := map[string][]uint16{
+		"mail":     {dns.TypeMX, dns.TypeTXT, dns.TypeA, dns.TypeAAAA, dns.TypeSPF, dns.TypeCAA},
+		"web":      {dns.TypeA, dns.TypeAAAA, dns.TypeCNAME, dns.TypeHTTPS, dns.TypeSVCB},
+		"security": {dns.TypeDNSKEY, dns.TypeDS, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeCAA, dns.TypeTLSA},
+		"all":      DefaultDNSQueryTypes[:],
+	}
+
+	for preset, queryTypes := range expected {
+		resolver := NewDNSResolver()
+		udig := newUdigImpl()
+		udig.AddDomainResolver(resolver)
+
+		// Execute.
+		WithDNSPreset(preset)(udig)
+
+		// Assert.
+		assert.Equal(t, queryTypes, resolver.QueryTypes, preset)
+	}
+}
+
+func Test_When_WithDNSPreset_is_unknown_Then_query_types_are_kept(t *testing.T) {
+	// Setup.
+	resolver := NewDNSResolver()
+	udig := newUdigImpl()
+	udig.AddDomainResolver(resolver)
+
+	// Execute.
+	WithDNSPreset("fancy")(udig)
+
+	// Assert.
+	assert.Equal(t, DefaultDNSQueryTypes[:], resolver.QueryTypes)
+}
+
+// serveDNS runs a local UDP name server with a given handler, returns its address and a shutdown func.
+func serveDNS(t *testing.T, handler dns.HandlerFunc) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	server := &dns.Server{PacketConn: conn, Handler: handler}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+
+	return conn.LocalAddr().String(), func() { _ = server.Shutdown() }
+}
+
+// serveDNSOverTCP runs a TCP name server (e.g. for zone transfers) answering by a given handler,
+// returns its address and a shutdown function.
+func serveDNSOverTCP(t *testing.T, handler dns.HandlerFunc) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	server := &dns.Server{Listener: listener, Handler: handler}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+
+	return listener.Addr().String(), func() { _ = server.Shutdown() }
+}
+
+func Test_When_DNS_cookie_is_set_Then_query_carries_it_and_server_cookie_is_verified(t *testing.T) {
+	// Mock.
+	var sent string
+	var sentMutex sync.Mutex
+	nameServer, shutdown := serveDNS(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		res := &dns.Msg{}
+		res.SetReply(req)
+		if opt := req.IsEdns0(); opt != nil {
+			for _, option := range opt.Option {
+				if cookie, ok := option.(*dns.EDNS0_COOKIE); ok {
+					sentMutex.Lock()
+					sent = cookie.Cookie
+					sentMutex.Unlock()
+					res.SetEdns0(dns.DefaultMsgSize, false)
+					res.IsEdns0().Option = append(res.IsEdns0().Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie.Cookie + "0102030405060708"})
+				}
+			}
+		}
+		_ = w.WriteMsg(res)
+	})
+	defer shutdown()
+
+	// Setup.
+	cookie := newClientCookie()
+	ctx := withDNSCookie(context.Background(), cookie)
+
+	// Execute.
+	msg, err := queryOne(ctx, "example.com", dns.TypeA, nameServer, &dns.Client{ReadTimeout: DefaultTimeout})
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.Len(t, cookie, 16)
+	sentMutex.Lock()
+	assert.Equal(t, cookie, sent)
+	sentMutex.Unlock()
+	assert.True(t, hasServerCookie(msg, cookie))
+}
+
+func Test_When_server_rejects_EDNS0_Then_query_is_retried_without_DNS_cookie(t *testing.T) {
+	// Mock.
+	queries := 0
+	var queriesMutex sync.Mutex
+	nameServer, shutdown := serveDNS(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		queriesMutex.Lock()
+		queries++
+		queriesMutex.Unlock()
+		res := &dns.Msg{}
+		if req.IsEdns0() != nil {
+			res.SetRcode(req, dns.RcodeFormatError)
+		} else {
+			res.SetReply(req)
+		}
+		_ = w.WriteMsg(res)
+	})
+	defer shutdown()
+
+	// Setup.
+	cookie := newClientCookie()
+	ctx := withDNSCookie(context.Background(), cookie)
+
+	// Execute.
+	msg, err := queryOne(ctx, "example.com", dns.TypeA, nameServer, &dns.Client{ReadTimeout: DefaultTimeout})
+
+	// Assert.
+	assert.NoError(t, err)
+	queriesMutex.Lock()
+	assert.Equal(t, 2, queries)
+	queriesMutex.Unlock()
+	assert.False(t, hasServerCookie(msg, cookie))
+}
+
+func Test_When_DNS_padding_is_set_Then_query_carries_padding_to_the_block_size(t *testing.T) {
+	// Mock.
+	var padding *dns.EDNS0_PADDING
+	var size int
+	var mutex sync.Mutex
+	nameServer, shutdown := serveDNS(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		mutex.Lock()
+		if opt := req.IsEdns0(); opt != nil {
+			for _, option := range opt.Option {
+				if padded, ok := option.(*dns.EDNS0_PADDING); ok {
+					padding = padded
+				}
+			}
+		}
+		size = req.Len()
+		mutex.Unlock()
+		res := &dns.Msg{}
+		res.SetReply(req)
+		_ = w.WriteMsg(res)
+	})
+	defer shutdown()
+
+	// Setup.
+	ctx := withDNSPadding(withDNSCookie(context.Background(), newClientCookie()), DefaultDNSPaddingBlockSize)
+
+	// Execute.
+	_, err := queryOne(ctx, "example.com", dns.TypeA, nameServer, &dns.Client{ReadTimeout: DefaultTimeout})
+
+	// Assert.
+	assert.NoError(t, err)
+	mutex.Lock()
+	assert.NotNil(t, padding)
+	assert.Equal(t, DefaultDNSPaddingBlockSize, size)
+	mutex.Unlock()
+}
+
+func Test_When_WithDNSCookies_is_used_Then_all_DNS_based_queries_carry_cookies(t *testing.T) {
+	// Mock.
+	var cookied []string
+	var mutex sync.Mutex
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		if cookie, _ := ctx.Value(dnsCookieContextKey{}).(string); len(cookie) == 16 {
+			mutex.Lock()
+			cookied = append(cookied, dns.TypeToString[qType]+" "+domain)
+			mutex.Unlock()
+		}
+		return &dns.Msg{}, nil
+	}
+
+	// Setup.
+	dig := NewUdig(
+		WithDomainResolvers(NewSPFResolver()),
+		WithBruteForce("www"),
+		WithDNSCookies(),
+		WithNameServer("127.0.0.1:53"),
+	).(*udigImpl)
+
+	// Execute.
+	for _, resolver := range dig.domainResolvers {
+		resolver.ResolveDomain(context.Background(), "example.com")
+	}
+	_, err := LookupRecords("example.com", dns.TypeMX, WithNameServer("127.0.0.1:53"), WithDNSCookies())
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.Contains(t, cookied, "TXT example.com")
+	assert.Contains(t, cookied, "A www.example.com")
+	assert.Contains(t, cookied, "MX example.com")
+}
+
+func Test_When_WithObfuscatedQueries_is_used_Then_all_DNS_based_queries_are_padded(t *testing.T) {
+	// Mock.
+	var padded []string
+	var mutex sync.Mutex
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		if blockSize, _ := ctx.Value(dnsPaddingContextKey{}).(int); blockSize == DefaultDNSPaddingBlockSize {
+			mutex.Lock()
+			padded = append(padded, dns.TypeToString[qType]+" "+domain)
+			mutex.Unlock()
+		}
+		return &dns.Msg{}, nil
+	}
+
+	// Setup.
+	dig := NewUdig(
+		WithDomainResolvers(NewSPFResolver()),
+		WithBruteForce("www"),
+		WithObfuscatedQueries(DefaultDNSPaddingBlockSize),
+		WithNameServer("127.0.0.1:53"),
+	).(*udigImpl)
+
+	// Execute.
+	for _, resolver := range dig.domainResolvers {
+		resolver.ResolveDomain(context.Background(), "example.com")
+	}
+	_, err := LookupRecords("example.com", dns.TypeMX, WithNameServer("127.0.0.1:53"), WithObfuscatedQueries(DefaultDNSPaddingBlockSize))
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.Contains(t, padded, "TXT example.com")
+	assert.Contains(t, padded, "A www.example.com")
+	assert.Contains(t, padded, "MX example.com")
+}
+
+func Test_When_zone_transfer_is_allowed_Then_all_zone_records_are_collected(t *testing.T) {
+	// Mock.
+	soa := &dns.SOA{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET}, Ns: "ns1.example.com.", Mbox: "admin.example.com."}
+	nameServer, shutdown := serveDNSOverTCP(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		res := &dns.Msg{}
+		res.SetReply(req)
+		res.Answer = []dns.RR{
+			soa,
+			&dns.A{Hdr: dns.RR_Header{Name: "intranet.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.ParseIP("192.0.2.1")},
+			soa,
+		}
+		_ = w.WriteMsg(res)
+	})
+	defer shutdown()
+
+	// Setup.
+	resolver := NewDNSResolver()
+	resolver.NameServer = nameServer
+	resolver.QueryTypes = []uint16{dns.TypeAXFR}
+	resolver.ZoneTransfer = true
+	resolver.DKIMSelectors = nil
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*DNSResolution)
+
+	// Assert.
+	assert.NoError(t, resolution.Error())
+	assert.Len(t, resolution.Records, 2)
+	assert.Equal(t, dns.TypeAXFR, resolution.Records[0].QueryType)
+	assert.Contains(t, resolution.Domains(), "intranet.example.com")
+	assert.Equal(t, []string{"192.0.2.1"}, resolution.IPs())
+}
+
+func Test_When_zone_transfer_is_refused_Then_no_failure_is_recorded(t *testing.T) {
+	// Mock.
+	nameServer, shutdown := serveDNSOverTCP(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		res := &dns.Msg{}
+		res.SetRcode(req, dns.RcodeRefused)
+		_ = w.WriteMsg(res)
+	})
+	defer shutdown()
+
+	// Setup.
+	resolver := NewDNSResolver()
+	resolver.NameServer = nameServer
+	resolver.QueryTypes = []uint16{dns.TypeAXFR}
+	resolver.ZoneTransfer = true
+	resolver.DKIMSelectors = nil
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*DNSResolution)
+
+	// Assert.
+	assert.NoError(t, resolution.Error())
+	assert.Empty(t, resolution.Records)
+}
+
+func Test_When_domain_has_no_NS_records_Then_no_zone_transfer_is_attempted(t *testing.T) {
+	// Mock.
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		return &dns.Msg{}, nil
+	}
+
+	// Setup.
+	resolver := NewDNSResolver()
+
+	// Execute.
+	targets := resolver.transferTargetsFor(context.Background(), "www.example.com", "127.0.0.1:53")
+
+	// Assert.
+	assert.Empty(t, targets)
+}
+
+func Test_When_zone_transfer_is_not_enabled_Then_AXFR_is_skipped(t *testing.T) {
+	// Mock.
+	transfers := 0
+	nameServer, shutdown := serveDNSOverTCP(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		transfers++
+		res := &dns.Msg{}
+		res.SetRcode(req, dns.RcodeRefused)
+		_ = w.WriteMsg(res)
+	})
+	defer shutdown()
+
+	// Setup.
+	resolver := NewDNSResolver()
+	resolver.NameServer = nameServer
+	resolver.QueryTypes = []uint16{dns.TypeAXFR}
+	resolver.DKIMSelectors = nil
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*DNSResolution)
+
+	// Assert.
+	assert.NoError(t, resolution.Error())
+	assert.Empty(t, resolution.Records)
+	assert.Zero(t, transfers)
+}
+
+func Test_When_zone_transfer_stalls_Then_context_deadline_interrupts_it(t *testing.T) {
+	// Mock.
+	nameServer, shutdown := serveDNSOverTCP(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		time.Sleep(time.Second)
+	})
+	defer shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// Execute.
+	started := time.Now()
+	_, err := transferZone(ctx, "example.com", nameServer)
+
+	// Assert.
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(started)), int64(500*time.Millisecond))
+}
@@ -3,23 +3,25 @@ package udig
 import (
 	"fmt"
 	"github.com/ip2location/ip2location-go"
+	"github.com/oschwald/geoip2-golang"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 var (
-	// GeoDBPath is a path to IP2Location DB file.
+	// GeoDBPath is a path to GeoIP DB file, either IP2Location (BIN) or MaxMind (mmdb).
 	GeoDBPath = findGeoipDatabase("IP2LOCATION-LITE-DB1.IPV6.BIN")
 )
 
 // CheckGeoipDatabase returns true if a given path points to a valid GeoIP DB file.
-func checkGeoipDatabase(geoipPath string) bool {
+func checkGeoipDatabase(backend GeoBackend, geoipPath string) bool {
 	if info, err := os.Stat(geoipPath); err != nil || info.IsDir() {
-		LogErr("%s: Cannot use IP2Location DB at '%s' (file exists: %t).", TypeGEO, geoipPath, os.IsExist(err))
+		LogErr("%s: Cannot use %s DB at '%s' (file exists: %t).", TypeGEO, backend.Name(), geoipPath, os.IsExist(err))
 		return false
 	}
-	_, err := ip2location.OpenDB(geoipPath)
-	return err == nil
+	return backend.Check() == nil
 }
 
 // FindGeoipDatabase attempts to locate a GeoIP database file at a given path.
@@ -53,22 +55,13 @@ func findGeoipDatabase(geoipPath string) string {
 	return filepath.Join(filepath.Dir(executable), geoipPath)
 }
 
-func queryIP(ip string) *ip2location.IP2Locationrecord {
-	db, err := ip2location.OpenDB(GeoDBPath)
-	if err != nil {
-		LogErr("%s: Could not open DB. The cause was: %s", TypeGEO, err.Error())
-		return nil
+// NewGeoBackend returns a GeoBackend for a DB file at a given path,
+// MaxMind for "*.mmdb" files and IP2Location otherwise.
+func NewGeoBackend(geoipPath string) GeoBackend {
+	if strings.EqualFold(filepath.Ext(geoipPath), ".mmdb") {
+		return &MaxMindBackend{DBPath: geoipPath}
 	}
-
-	record, err := db.Get_country_short(ip)
-	if err != nil {
-		LogErr("%s: Could not query DB for IP %s. The cause was: %s", TypeGEO, ip, err.Error())
-		return nil
-	}
-
-	db.Close()
-
-	return &record
+	return &IP2LocationBackend{DBPath: geoipPath}
 }
 
 /////////////////////////////////////////
@@ -77,39 +70,250 @@ func queryIP(ip string) *ip2location.IP2Locationrecord {
 
 // NewGeoResolver creates a new GeoResolver with sensible defaults.
 func NewGeoResolver() *GeoResolver {
-	return &GeoResolver{
-		enabled:       checkGeoipDatabase(GeoDBPath),
-		cachedResults: map[string]*GeoResolution{},
+	resolver := &GeoResolver{cachedResults: map[string]*GeoResolution{}}
+	resolver.SetBackend(NewGeoBackend(GeoDBPath))
+	return resolver
+}
+
+// SetBackend makes the resolver query a given backend, which is checked right away.
+// If the backend cannot be used, the resolver is disabled (i.e. yields no records).
+func (resolver *GeoResolver) SetBackend(backend GeoBackend) {
+	if resolver.Backend != nil && resolver.Backend != backend {
+		resolver.Backend.Close()
+	}
+	resolver.Backend = backend
+	resolver.enabled = checkGeoipDatabase(backend, backend.Path())
+}
+
+// Close releases the DB of the backend. The resolver must not be used afterwards.
+func (resolver *GeoResolver) Close() error {
+	resolver.enabled = false
+	if resolver.Backend == nil {
+		return nil
 	}
+	return resolver.Backend.Close()
 }
 
 // ResolveIP resolves a given IP address to a corresponding GeoIP record.
 func (resolver *GeoResolver) ResolveIP(ip string) Resolution {
+	resolver.cacheMutex.RLock()
 	resolution := resolver.cachedResults[ip]
+	resolver.cacheMutex.RUnlock()
 	if resolution != nil {
 		return resolution
 	}
 	resolution = &GeoResolution{ResolutionBase: &ResolutionBase{query: ip}}
-	resolver.cachedResults[ip] = resolution
+	defer resolver.cacheResult(ip, resolution)
 
 	if !resolver.enabled {
+		resolution.addError(&disabledError{reason: "no DB"})
 		return resolution
 	}
 
-	geoRecord := queryIP(ip)
-	if geoRecord == nil {
+	geoRecord, err := resolver.Backend.Lookup(ip)
+	if err != nil {
+		resolution.addError(err)
 		return resolution
 	}
-	resolution.Record = &GeoRecord{CountryCode: geoRecord.Country_short}
+	resolution.Record = geoRecord
 
 	return resolution
 }
 
+func (resolver *GeoResolver) cacheResult(ip string, resolution *GeoResolution) {
+	resolver.cacheMutex.Lock()
+	resolver.cachedResults[ip] = resolution
+	resolver.cacheMutex.Unlock()
+}
+
 // Type returns "GEO".
 func (resolver *GeoResolver) Type() ResolutionType {
 	return TypeGEO
 }
 
+/////////////////////////////////////////
+// IP2LOCATION BACKEND
+/////////////////////////////////////////
+
+// Name returns "IP2Location".
+func (backend *IP2LocationBackend) Name() string {
+	return "IP2Location"
+}
+
+// Path returns the path to the DB file.
+func (backend *IP2LocationBackend) Path() string {
+	return backend.DBPath
+}
+
+// Check opens the DB to see if it can be used. The DB is kept open for lookups.
+func (backend *IP2LocationBackend) Check() error {
+	_, err := backend.open()
+	return err
+}
+
+// Close closes the DB (if open). It must not be called while lookups are in flight.
+func (backend *IP2LocationBackend) Close() error {
+	backend.dbMutex.Lock()
+	defer backend.dbMutex.Unlock()
+
+	if backend.db != nil {
+		backend.db.Close()
+		backend.db = nil
+	}
+	return nil
+}
+
+// open returns the DB, opening it on the first call.
+// The handle is safe for concurrent lookups (all reads are positional, i.e. ReadAt).
+func (backend *IP2LocationBackend) open() (*ip2location.DB, error) {
+	backend.dbMutex.Lock()
+	defer backend.dbMutex.Unlock()
+
+	if backend.db == nil {
+		db, err := ip2location.OpenDB(backend.DBPath)
+		if err != nil {
+			return nil, err
+		}
+		backend.db = db
+	}
+	return backend.db, nil
+}
+
+// Lookup queries the DB for a location of a given IP. Fields not present in the DB are left empty.
+func (backend *IP2LocationBackend) Lookup(ip string) (*GeoRecord, error) {
+	db, err := backend.open()
+	if err != nil {
+		LogErr("%s: Could not open DB. The cause was: %s", TypeGEO, err.Error())
+		return nil, err
+	}
+
+	record, err := db.Get_all(ip)
+	if err != nil {
+		LogErr("%s: Could not query DB for IP %s. The cause was: %s", TypeGEO, ip, err.Error())
+		return nil, err
+	}
+
+	return &GeoRecord{
+		CountryCode: record.Country_short,
+		Region:      ip2locationValue(record.Region),
+		City:        ip2locationValue(record.City),
+		Latitude:    float64(record.Latitude),
+		Longitude:   float64(record.Longitude),
+	}, nil
+}
+
+// ip2locationValue returns a given field value or "" if it is not available in the DB.
+func ip2locationValue(value string) string {
+	if value == "-" || strings.HasPrefix(value, "This parameter is unavailable") {
+		return ""
+	}
+	return value
+}
+
+/////////////////////////////////////////
+// MAXMIND BACKEND
+/////////////////////////////////////////
+
+// Name returns "MaxMind".
+func (backend *MaxMindBackend) Name() string {
+	return "MaxMind"
+}
+
+// Path returns the path to the DB file.
+func (backend *MaxMindBackend) Path() string {
+	return backend.DBPath
+}
+
+// Check opens the DB to see if it can be used (i.e. it is a Country or City DB).
+// The DB is kept open for lookups.
+func (backend *MaxMindBackend) Check() error {
+	db, err := backend.open()
+	if err != nil {
+		return err
+	}
+
+	if _, err = db.Country(net.IPv4zero); err != nil {
+		LogErr("%s: Cannot use MaxMind DB at '%s'. The cause was: %s", TypeGEO, backend.DBPath, err.Error())
+		backend.Close()
+		return err
+	}
+	return nil
+}
+
+// Close closes the DB (if open). It must not be called while lookups are in flight.
+func (backend *MaxMindBackend) Close() (err error) {
+	backend.dbMutex.Lock()
+	defer backend.dbMutex.Unlock()
+
+	if backend.db != nil {
+		err = backend.db.Close()
+		backend.db = nil
+	}
+	return err
+}
+
+// open returns the DB, opening it on the first call.
+// The reader is safe for concurrent lookups.
+func (backend *MaxMindBackend) open() (*geoip2.Reader, error) {
+	backend.dbMutex.Lock()
+	defer backend.dbMutex.Unlock()
+
+	if backend.db == nil {
+		db, err := geoip2.Open(backend.DBPath)
+		if err != nil {
+			return nil, err
+		}
+		backend.db = db
+	}
+	return backend.db, nil
+}
+
+// Lookup queries the DB for a location of a given IP. If the IP is not located,
+// the registered country is used instead (nil if there is none either).
+// City-level data and ASN are filled in only by City and Enterprise DBs respectively.
+func (backend *MaxMindBackend) Lookup(ip string) (*GeoRecord, error) {
+	ipAddr := net.ParseIP(ip)
+	if ipAddr == nil {
+		LogErr("%s: IP %s is invalid.", TypeGEO, ip)
+		return nil, fmt.Errorf("invalid IP %s", ip)
+	}
+
+	db, err := backend.open()
+	if err != nil {
+		LogErr("%s: Could not open DB. The cause was: %s", TypeGEO, err.Error())
+		return nil, err
+	}
+
+	// City lookups work on Country DBs too, just yielding no city data.
+	record, err := db.City(ipAddr)
+	if err != nil {
+		LogErr("%s: Could not query DB for IP %s. The cause was: %s", TypeGEO, ip, err.Error())
+		return nil, err
+	}
+
+	geoRecord := &GeoRecord{
+		CountryCode: record.Country.IsoCode,
+		City:        record.City.Names["en"],
+		Latitude:    record.Location.Latitude,
+		Longitude:   record.Location.Longitude,
+	}
+	if geoRecord.CountryCode == "" {
+		geoRecord.CountryCode = record.RegisteredCountry.IsoCode
+	}
+	if geoRecord.CountryCode == "" {
+		return nil, nil
+	}
+	if len(record.Subdivisions) > 0 {
+		geoRecord.Region = record.Subdivisions[0].Names["en"]
+	}
+
+	if enterprise, err := db.Enterprise(ipAddr); err == nil {
+		geoRecord.ASN = uint32(enterprise.Traits.AutonomousSystemNumber)
+	}
+
+	return geoRecord, nil
+}
+
 /////////////////////////////////////////
 // GEO RESOLUTION
 /////////////////////////////////////////
@@ -119,10 +323,45 @@ func (res *GeoResolution) Type() ResolutionType {
 	return TypeGEO
 }
 
+// Raw returns the geographical record as *GeoRecord (possibly nil).
+func (res *GeoResolution) Raw() interface{} {
+	return res.Record
+}
+
 /////////////////////////////////////////
 // GEO RECORD
 /////////////////////////////////////////
 
 func (record *GeoRecord) String() string {
-	return fmt.Sprintf("country code: %s", record.CountryCode)
+	str := fmt.Sprintf("country code: %s", record.CountryCode)
+	if record.Region != "" {
+		str += fmt.Sprintf(", region: %s", record.Region)
+	}
+	if record.City != "" {
+		str += fmt.Sprintf(", city: %s", record.City)
+	}
+	if record.HasCoordinates() {
+		str += fmt.Sprintf(", coordinates: %.4f,%.4f", record.Latitude, record.Longitude)
+	}
+	if record.ASN != 0 {
+		str += fmt.Sprintf(", ASN: %d", record.ASN)
+	}
+	return str
+}
+
+// HasCoordinates returns true if the location has been resolved to coordinates.
+func (record *GeoRecord) HasCoordinates() bool {
+	return record.Latitude != 0 || record.Longitude != 0
+}
+
+// Place returns a human-readable city-level location (e.g. "Prague, Hlavni mesto Praha, CZ"),
+// or just the country code if there is no city-level data.
+func (record *GeoRecord) Place() string {
+	var parts []string
+	for _, part := range []string{record.City, record.Region, record.CountryCode} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
 }
@@ -0,0 +1,117 @@
+package udig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_When_GeoIP_DB_is_mmdb_Then_MaxMind_backend_is_used(t *testing.T) {
+	// Mock.
+	dir, err := ioutil.TempDir("", "udig")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := filepath.Join(dir, "GeoLite2-Country.mmdb")
+	writeMMDB(t, dbPath, "GeoLite2-Country", "192.0.2.0/24", mmdbEncoder{}.control(7, 1).
+		str("country").control(7, 1).str("iso_code").str("CZ"))
+
+	// Setup.
+	resolver := NewGeoResolver()
+	resolver.SetBackend(NewGeoBackend(dbPath))
+
+	// Execute.
+	located := resolver.ResolveIP("192.0.2.10").(*GeoResolution)
+	unknown := resolver.ResolveIP("198.51.100.1").(*GeoResolution)
+
+	// Assert.
+	assert.IsType(t, &MaxMindBackend{}, resolver.Backend)
+	assert.NoError(t, located.Error())
+	assert.Equal(t, &GeoRecord{CountryCode: "CZ"}, located.Record)
+	assert.NoError(t, unknown.Error())
+	assert.Nil(t, unknown.Record)
+}
+
+func Test_When_MaxMind_City_DB_is_used_Then_city_level_data_is_populated(t *testing.T) {
+	// Mock.
+	dir, err := ioutil.TempDir("", "udig")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := filepath.Join(dir, "GeoLite2-City.mmdb")
+	writeMMDB(t, dbPath, "GeoLite2-City", "192.0.2.0/24", mmdbEncoder{}.control(7, 4).
+		str("city").control(7, 1).str("names").control(7, 1).str("en").str("Prague").
+		str("country").control(7, 1).str("iso_code").str("CZ").
+		str("location").control(7, 2).str("latitude").double(50.0880).str("longitude").double(14.4208).
+		str("subdivisions").control(11, 1).control(7, 1).str("names").control(7, 1).str("en").str("Hlavni mesto Praha"))
+
+	// Setup.
+	resolver := NewGeoResolver()
+	resolver.SetBackend(NewGeoBackend(dbPath))
+
+	// Execute.
+	resolution := resolver.ResolveIP("192.0.2.10").(*GeoResolution)
+
+	// Assert.
+	assert.NoError(t, resolution.Error())
+	assert.Equal(t, &GeoRecord{CountryCode: "CZ", Region: "Hlavni mesto Praha", City: "Prague", Latitude: 50.0880, Longitude: 14.4208}, resolution.Record)
+	assert.Equal(t, "country code: CZ, region: Hlavni mesto Praha, city: Prague, coordinates: 50.0880,14.4208", resolution.Record.String())
+	assert.Equal(t, "Prague, Hlavni mesto Praha, CZ", resolution.Record.Place())
+}
+
+func Test_When_GeoIP_DB_is_opened_Then_it_is_shared_by_concurrent_lookups_until_closed(t *testing.T) {
+	// Mock.
+	dir, err := ioutil.TempDir("", "udig")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := filepath.Join(dir, "GeoLite2-Country.mmdb")
+	writeMMDB(t, dbPath, "GeoLite2-Country", "192.0.2.0/24", mmdbEncoder{}.control(7, 1).
+		str("country").control(7, 1).str("iso_code").str("CZ"))
+
+	// Setup.
+	backend := NewGeoBackend(dbPath)
+	assert.NoError(t, backend.Check())
+	// Lookups must not need the file anymore.
+	assert.NoError(t, os.Remove(dbPath))
+
+	// Execute.
+	records := make([]*GeoRecord, 16)
+	var wg sync.WaitGroup
+	for i := range records {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			records[i], _ = backend.Lookup("192.0.2.10")
+		}(i)
+	}
+	wg.Wait()
+	assert.NoError(t, backend.Close())
+	_, errAfterClose := backend.Lookup("192.0.2.10")
+
+	// Assert.
+	for _, record := range records {
+		assert.Equal(t, &GeoRecord{CountryCode: "CZ"}, record)
+	}
+	assert.Error(t, errAfterClose)
+}
+
+func Test_When_GeoRecord_has_country_only_Then_String_shows_country_only(t *testing.T) {
+	// Setup.
+	record := &GeoRecord{CountryCode: "US"}
+
+	// Execute.
+	str := record.String()
+
+	// Assert.
+	assert.Equal(t, "country code: US", str)
+}
+
+func Test_When_GeoIP_DB_is_not_mmdb_Then_IP2Location_backend_is_used(t *testing.T) {
+	// Execute.
+	backend := NewGeoBackend("IP2LOCATION-LITE-DB1.IPV6.BIN")
+
+	// Assert.
+	assert.Equal(t, &IP2LocationBackend{DBPath: "IP2LOCATION-LITE-DB1.IPV6.BIN"}, backend)
+}
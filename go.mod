@@ -1,15 +1,18 @@
 module github.com/netrixone/udig
 
-go 1.13
+go 1.16
 
 require (
 	github.com/akamensky/argparse v1.2.1
 	github.com/domainr/whois v0.0.0-20180714175948-975c7833b02e
 	github.com/domainr/whoistest v0.0.0-20180714175718-26cad4b7c941 // indirect
 	github.com/ip2location/ip2location-go v8.3.0+incompatible
-	github.com/miekg/dns v1.1.27
+	github.com/miekg/dns v1.1.50
 	github.com/nbio/st v0.0.0-20140626010706-e9e8d9816f32 // indirect
+	github.com/oschwald/geoip2-golang v1.4.0
+	github.com/oschwald/maxminddb-golang v1.6.0
 	github.com/saintfish/chardet v0.0.0-20120816061221-3af4cd4741ca // indirect
 	github.com/stretchr/testify v1.5.1
 	github.com/zonedb/zonedb v1.0.2611 // indirect
+	golang.org/x/net v0.0.0-20210726213435-c6fcb2dbf985
 )
@@ -0,0 +1,460 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/netrixone/udig"
+)
+
+// NodeType is an enumeration type for graph node types.
+type NodeType string
+
+const (
+	// NodeDomain is a type of all domain nodes.
+	NodeDomain NodeType = "domain"
+
+	// NodeIP is a type of all IP address nodes.
+	NodeIP NodeType = "ip"
+
+	// NodeContact is a type of all WHOIS contact nodes.
+	NodeContact NodeType = "contact"
+
+	// NodeAS is a type of all autonomous system nodes.
+	NodeAS NodeType = "as"
+
+	// NodeGeo is a type of all geographical location nodes.
+	NodeGeo NodeType = "geo"
+
+	// NodeNetwork is a type of all RIR netblock nodes.
+	NodeNetwork NodeType = "network"
+
+	// NodeVirtual is a type of the synthetic root joining multiple seeds (see WithVirtualRoot).
+	NodeVirtual NodeType = "virtual"
+)
+
+const (
+	// DefaultVirtualRoot is a default ID of the synthetic root joining multiple seeds.
+	DefaultVirtualRoot = "udig"
+
+	// SeedEdgeLabel is a label of edges from the virtual root to the seeds.
+	SeedEdgeLabel = "SEED"
+)
+
+// Graph is a directed graph of everything discovered during a crawl.
+// Nodes are keyed by their unique ID, edges point from the resolved query
+// to whatever has been discovered in it.
+type Graph struct {
+	Root  string
+	Nodes map[string]*Node
+	Edges []*Edge
+	// EdgeDetails enables recording of the raw value (e.g. a DNS record) behind each edge.
+	EdgeDetails bool
+	seen        map[Edge]bool
+}
+
+// Node is a single discovered item (e.g. a domain or an IP).
+type Node struct {
+	Type  NodeType
+	Label string
+}
+
+// Edge is a relationship between two nodes labeled by the resolution
+// which discovered it (e.g. "DNS/A"). Detail optionally holds the raw value
+// it was found in (see Graph.EdgeDetails).
+type Edge struct {
+	From   string
+	To     string
+	Label  string
+	Detail string
+}
+
+// New creates an empty graph with a given root domain.
+func New(root string) *Graph {
+	g := &Graph{
+		Root:  root,
+		Nodes: map[string]*Node{},
+		seen:  map[Edge]bool{},
+	}
+	g.AddNode(root, NodeDomain, root)
+	return g
+}
+
+// Collect builds a graph out of resolutions of a given root domain.
+func Collect(root string, resolutions []udig.Resolution, opts ...Option) *Graph {
+	c := newCollector(opts)
+	g := New(root)
+	g.EdgeDetails = c.edgeDetails
+	for _, res := range resolutions {
+		g.AddResolution(res)
+	}
+	if c.filter != nil {
+		return g.Filter(*c.filter)
+	}
+	return g
+}
+
+// Option is a type of functions, which configure how multiple seeds are collected.
+type Option func(*collector)
+
+type collector struct {
+	virtualRoot string
+	edgeDetails bool
+	filter      *Filter
+}
+
+func newCollector(opts []Option) *collector {
+	c := &collector{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithSeedAsRoot makes every seed the root of its own graph (this is the default).
+func WithSeedAsRoot() Option {
+	return func(c *collector) {
+		c.virtualRoot = ""
+	}
+}
+
+// WithVirtualRoot makes all seeds hang under a synthetic root node of a given ID,
+// so that they form a single graph. An empty ID means DefaultVirtualRoot.
+func WithVirtualRoot(id string) Option {
+	return func(c *collector) {
+		if id == "" {
+			id = DefaultVirtualRoot
+		}
+		c.virtualRoot = id
+	}
+}
+
+// WithEdgeDetails makes the graph record the raw value (e.g. a TXT record or a CT log)
+// behind each edge, so that it can be verified.
+func WithEdgeDetails() Option {
+	return func(c *collector) {
+		c.edgeDetails = true
+	}
+}
+
+// WithFilter makes the collected graphs keep only the part selected by a given filter (see Graph.Filter).
+func WithFilter(filter Filter) Option {
+	return func(c *collector) {
+		c.filter = &filter
+	}
+}
+
+// CollectBatch builds graphs out of resolutions of multiple seeds (see udig.ResolveBatch).
+// By default there is one graph per seed (ordered by the seed), with WithVirtualRoot
+// there is a single graph joining all the seeds.
+func CollectBatch(results map[string][]udig.Resolution, opts ...Option) []*Graph {
+	c := newCollector(opts)
+
+	seeds := make([]string, 0, len(results))
+	for seed := range results {
+		seeds = append(seeds, seed)
+	}
+	sort.Strings(seeds)
+
+	if c.virtualRoot == "" {
+		graphs := make([]*Graph, 0, len(seeds))
+		for _, seed := range seeds {
+			graphs = append(graphs, Collect(seed, results[seed], opts...))
+		}
+		return graphs
+	}
+
+	g := &Graph{
+		Root:        c.virtualRoot,
+		Nodes:       map[string]*Node{},
+		EdgeDetails: c.edgeDetails,
+		seen:        map[Edge]bool{},
+	}
+	g.AddNode(c.virtualRoot, NodeVirtual, c.virtualRoot)
+	for _, seed := range seeds {
+		g.AddNode(seed, NodeDomain, seed)
+		g.AddEdge(c.virtualRoot, seed, SeedEdgeLabel)
+		for _, res := range results[seed] {
+			g.AddResolution(res)
+		}
+	}
+	if c.filter != nil {
+		g = g.Filter(*c.filter)
+	}
+	return []*Graph{g}
+}
+
+// AddNode adds a node with a given ID unless it has already been added.
+func (g *Graph) AddNode(id string, nodeType NodeType, label string) {
+	if g.Nodes[id] != nil {
+		return
+	}
+	g.Nodes[id] = &Node{Type: nodeType, Label: label}
+}
+
+// AddEdge adds an edge between two existing nodes unless it has already been added.
+// Self-loops are ignored.
+func (g *Graph) AddEdge(from string, to string, label string) {
+	g.AddDetailedEdge(from, to, label, "")
+}
+
+// AddDetailedEdge adds an edge like AddEdge, keeping a given detail if EdgeDetails is enabled.
+// Edges differing only in detail are considered the same, the first detail wins.
+func (g *Graph) AddDetailedEdge(from string, to string, label string, detail string) {
+	key := Edge{From: from, To: to, Label: label}
+	if from == to || g.seen[key] {
+		return
+	}
+	g.seen[key] = true
+
+	edge := key
+	if g.EdgeDetails {
+		edge.Detail = detail
+	}
+	g.Edges = append(g.Edges, &edge)
+}
+
+// AddResolution adds all nodes and edges discovered in a given resolution.
+func (g *Graph) AddResolution(res udig.Resolution) {
+	query := res.Query()
+
+	switch res.Type() {
+	case udig.TypeDNS:
+		g.AddNode(query, NodeDomain, query)
+		for _, rr := range res.(*udig.DNSResolution).Records {
+			if caa, ok := rr.Record.RR.(*dns.CAA); ok {
+				g.addCAA(query, caa)
+				continue
+			}
+			label := fmt.Sprintf("%s/%s", udig.TypeDNS, dns.TypeToString[rr.Record.Header().Rrtype])
+			g.addDomains(query, label, rr.Record.String())
+			g.addIPs(query, label, rr.Record.String())
+		}
+		for _, key := range res.(*udig.DNSResolution).DKIMKeys {
+			g.addDomains(query, fmt.Sprintf("%s/DKIM", udig.TypeDNS), key.Record.String())
+		}
+		for _, domain := range res.(*udig.DNSResolution).WalkedDomains {
+			g.AddNode(domain, NodeDomain, domain)
+			g.AddEdge(query, domain, fmt.Sprintf("%s/NSEC-WALK", udig.TypeDNS))
+		}
+		break
+
+	case udig.TypeWHOIS:
+		g.AddNode(query, NodeDomain, query)
+		for _, contact := range res.(*udig.WhoisResolution).Contacts {
+			id := contactID(&contact)
+			if id == "" {
+				continue
+			}
+			g.AddNode(id, NodeContact, id)
+			g.AddDetailedEdge(query, id, string(udig.TypeWHOIS), contact.String())
+		}
+		for _, domain := range res.Domains() {
+			g.AddNode(domain, NodeDomain, domain)
+			g.AddEdge(query, domain, string(udig.TypeWHOIS))
+		}
+		break
+
+	case udig.TypeHTTP:
+		g.AddNode(query, NodeDomain, query)
+		for _, header := range res.(*udig.HTTPResolution).Headers {
+			for _, domain := range udig.DissectDomainsFromStrings(header.Value) {
+				g.AddNode(domain, NodeDomain, domain)
+				g.AddDetailedEdge(query, domain, fmt.Sprintf("%s/%s", udig.TypeHTTP, header.Name), header.String())
+			}
+		}
+		for _, domain := range res.(*udig.HTTPResolution).InsecureDomains {
+			g.AddNode(domain, NodeDomain, domain)
+			g.AddEdge(query, domain, fmt.Sprintf("%s/mixed-content", udig.TypeHTTP))
+		}
+		for _, url := range res.(*udig.HTTPResolution).Redirects {
+			for _, domain := range udig.DissectDomainsFromString(url) {
+				g.AddNode(domain, NodeDomain, domain)
+				g.AddDetailedEdge(query, domain, fmt.Sprintf("%s/redirect", udig.TypeHTTP), url)
+			}
+		}
+		for _, domain := range udig.DissectDomainsFromString(res.(*udig.HTTPResolution).Location) {
+			g.AddNode(domain, NodeDomain, domain)
+			g.AddDetailedEdge(query, domain, fmt.Sprintf("%s/location", udig.TypeHTTP), res.(*udig.HTTPResolution).Location)
+		}
+		for _, domain := range res.(*udig.HTTPResolution).BodyDomains {
+			g.AddNode(domain, NodeDomain, domain)
+			g.AddEdge(query, domain, fmt.Sprintf("%s/body", udig.TypeHTTP))
+		}
+		break
+
+	case udig.TypeBGP:
+		g.AddNode(query, NodeIP, query)
+		for _, as := range res.(*udig.BGPResolution).Records {
+			id := fmt.Sprintf("AS%d", as.ASN)
+			label := id
+			if as.Name != "" {
+				label = fmt.Sprintf("%s (%s)", id, as.Name)
+			}
+			g.AddNode(id, NodeAS, label)
+			g.AddDetailedEdge(query, id, string(udig.TypeBGP), as.String())
+		}
+		break
+
+	case udig.TypeGEO:
+		g.AddNode(query, NodeIP, query)
+		if record := res.(*udig.GeoResolution).Record; record != nil && record.CountryCode != "" {
+			g.AddNode(record.CountryCode, NodeGeo, record.CountryCode)
+			if record.City == "" && record.Region == "" {
+				g.AddDetailedEdge(query, record.CountryCode, string(udig.TypeGEO), record.String())
+				break
+			}
+
+			// City-level location in between the IP and its country.
+			place := record.Place()
+			g.AddNode(place, NodeGeo, place)
+			g.AddDetailedEdge(query, place, string(udig.TypeGEO), record.String())
+			g.AddEdge(place, record.CountryCode, string(udig.TypeGEO))
+		}
+		break
+
+	case udig.TypeIPWHOIS:
+		g.AddNode(query, NodeIP, query)
+		if record := res.(*udig.IPWhoisResolution).Record; record != nil {
+			id := record.Range
+			if id == "" {
+				id = record.CIDR
+			}
+			if id == "" {
+				id = record.NetName
+			}
+			label := id
+			if record.NetName != "" {
+				label = fmt.Sprintf("%s (%s)", record.NetName, id)
+			}
+			g.AddNode(id, NodeNetwork, label)
+			g.AddDetailedEdge(query, id, string(udig.TypeIPWHOIS), record.String())
+		}
+		break
+
+	case udig.TypePTR:
+		g.AddNode(query, NodeIP, query)
+		for _, record := range res.(*udig.PTRResolution).Records {
+			// Unconfirmed names are told apart, so that they can be styled differently.
+			label := fmt.Sprintf("%s/%s", udig.TypePTR, udig.PTRConsistent)
+			if !record.Confirmed {
+				label = fmt.Sprintf("%s/%s", udig.TypePTR, udig.PTRMismatch)
+			}
+			g.AddNode(record.Name, NodeDomain, record.Name)
+			g.AddDetailedEdge(query, record.Name, label, record.String())
+		}
+		break
+
+	case udig.TypeTLS:
+		g.AddNode(query, NodeDomain, query)
+		for _, cert := range res.(*udig.TLSResolution).Certificates {
+			single := &udig.TLSResolution{Certificates: []udig.TLSCertificate{cert}}
+			for _, domain := range single.Domains() {
+				g.AddNode(domain, NodeDomain, domain)
+				g.AddDetailedEdge(query, domain, string(udig.TypeTLS), cert.String())
+			}
+		}
+		break
+
+	case udig.TypeCT:
+		g.AddNode(query, NodeDomain, query)
+		for _, log := range res.(*udig.CTResolution).Logs {
+			for _, domain := range log.ExtractDomains() {
+				g.AddNode(domain, NodeDomain, domain)
+				g.AddDetailedEdge(query, domain, string(udig.TypeCT), log.String())
+			}
+		}
+		for _, cert := range res.(*udig.CTResolution).Certificates {
+			for _, domain := range udig.DissectDomainsFromStrings(cert.DNSNames) {
+				g.AddNode(domain, NodeDomain, domain)
+				g.AddDetailedEdge(query, domain, string(udig.TypeCT), cert.String())
+			}
+		}
+		break
+
+	default:
+		// Anything else that only yields domains and IPs.
+		g.AddNode(query, NodeDomain, query)
+		for _, domain := range res.Domains() {
+			g.AddNode(domain, NodeDomain, domain)
+			g.AddEdge(query, domain, string(res.Type()))
+		}
+		for _, ip := range res.IPs() {
+			g.AddNode(ip, NodeIP, ip)
+			g.AddEdge(query, ip, string(res.Type()))
+		}
+		break
+	}
+}
+
+// Depths returns the BFS distance of each node from the root. Nodes unreachable
+// from the root (e.g. when multiple seeds share one graph) are not included.
+func (g *Graph) Depths() map[string]int {
+	adjacency := map[string][]string{}
+	for _, e := range g.Edges {
+		adjacency[e.From] = append(adjacency[e.From], e.To)
+	}
+
+	depths := map[string]int{g.Root: 0}
+	queue := []string{g.Root}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, next := range adjacency[id] {
+			if _, ok := depths[next]; ok {
+				continue
+			}
+			depths[next] = depths[id] + 1
+			queue = append(queue, next)
+		}
+	}
+	return depths
+}
+
+func (g *Graph) addDomains(from string, label string, haystack string) {
+	for _, domain := range udig.DissectDomainsFromString(haystack) {
+		g.AddNode(domain, NodeDomain, domain)
+		g.AddDetailedEdge(from, domain, label, haystack)
+	}
+}
+
+func (g *Graph) addIPs(from string, label string, haystack string) {
+	for _, ip := range udig.DissectIpsFromString(haystack) {
+		g.AddNode(ip, NodeIP, ip)
+		g.AddDetailedEdge(from, ip, label, haystack)
+	}
+}
+
+// addCAA adds an edge to the CA (issue), the wildcard CA (issuewild) or the report endpoint (iodef)
+// of a given CAA record, labeled "DNS/CAA", "DNS/CAA-WILD" and "DNS/CAA-IODEF" respectively.
+func (g *Graph) addCAA(from string, caa *dns.CAA) {
+	domain := udig.CleanDomain(udig.CAADomain(caa))
+	if domain == "" {
+		return
+	}
+
+	label := fmt.Sprintf("%s/CAA", udig.TypeDNS)
+	switch strings.ToLower(caa.Tag) {
+	case "issuewild":
+		label += "-WILD"
+		break
+	case "iodef":
+		label += "-IODEF"
+		break
+	}
+
+	g.AddNode(domain, NodeDomain, domain)
+	g.AddDetailedEdge(from, domain, label, caa.String())
+}
+
+// contactID picks the most descriptive property of a WHOIS contact as its ID.
+func contactID(contact *udig.WhoisContact) string {
+	for _, value := range []string{contact.Registrar, contact.RegistrantOrganization, contact.Registrant, contact.Name, contact.Contact} {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+package graph
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// csvHeader names the columns of the edge list.
+var csvHeader = []string{"from", "to", "label", "from_type", "to_type"}
+
+// EmitCSV writes the edges of the graph to a given writer as a CSV edge list
+// (from, to, label, from_type, to_type), e.g. for pandas or a spreadsheet.
+// Edges are sorted, so the output is stable.
+func (g *Graph) EmitCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+
+	if err := out.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, e := range g.sortedEdges() {
+		if err := out.Write([]string{e.From, e.To, e.Label, g.nodeType(e.From), g.nodeType(e.To)}); err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// nodeType returns the type of a node with a given ID ("" if there is no such node).
+func (g *Graph) nodeType(id string) string {
+	if node := g.Nodes[id]; node != nil {
+		return string(node.Type)
+	}
+	return ""
+}
@@ -0,0 +1,58 @@
+package graph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// EmitCypher writes the graph to a given writer as a Cypher script (e.g. for Neo4j import).
+// Nodes are merged by their ID and labeled by their type, relationships are typed
+// by the edge label. Repeated imports do not create duplicates.
+func (g *Graph) EmitCypher(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintf(out, "// udig graph of %s\n", strings.Replace(g.Root, "\n", " ", -1))
+
+	depths := g.Depths()
+	for _, id := range g.sortedNodeIDs() {
+		node := g.Nodes[id]
+		depth, ok := depths[id]
+		if !ok {
+			depth = -1
+		}
+		fmt.Fprintf(out, "MERGE (n:%s {id: %s}) SET n.type = %s, n.label = %s, n.depth = %d;\n",
+			cypherIdentifier(string(node.Type)), cypherString(id), cypherString(string(node.Type)), cypherString(node.Label), depth)
+	}
+
+	for _, e := range g.sortedEdges() {
+		detail := ""
+		if e.Detail != "" {
+			detail = ", r.detail = " + cypherString(e.Detail)
+		}
+		fmt.Fprintf(out, "MATCH (a%s), (b%s) MERGE (a)-[r:%s]->(b) SET r.label = %s%s;\n",
+			g.cypherNodePattern(e.From), g.cypherNodePattern(e.To), cypherIdentifier(e.Label), cypherString(e.Label), detail)
+	}
+
+	return out.Flush()
+}
+
+// cypherNodePattern returns a pattern matching a node with a given ID (e.g. ":`domain` {id: 'example.com'}").
+func (g *Graph) cypherNodePattern(id string) string {
+	if node := g.Nodes[id]; node != nil {
+		return fmt.Sprintf(":%s {id: %s}", cypherIdentifier(string(node.Type)), cypherString(id))
+	}
+	return fmt.Sprintf(" {id: %s}", cypherString(id))
+}
+
+// cypherIdentifier quotes a given label or relationship type, doubling any backticks.
+func cypherIdentifier(identifier string) string {
+	return "`" + strings.Replace(identifier, "`", "``", -1) + "`"
+}
+
+// cypherString quotes a given string literal, escaping backslashes, quotes and line breaks.
+func cypherString(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`)
+	return "'" + replacer.Replace(value) + "'"
+}
@@ -0,0 +1,38 @@
+package graph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// EmitDOT writes the graph to a given writer as a Graphviz DOT digraph (e.g. for `dot -Tsvg`).
+// Nodes are identified by their quoted IDs and shown with their labels (their type goes to the class
+// attribute), edges are labeled and carry their detail (if any) as a tooltip. Nodes and edges are
+// sorted, so the output is stable.
+func (g *Graph) EmitDOT(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintf(out, "digraph %s {\n", dotString(g.Root))
+	for _, id := range g.sortedNodeIDs() {
+		node := g.Nodes[id]
+		fmt.Fprintf(out, "    %s [label=%s, class=%s];\n", dotString(id), dotString(node.Label), dotString(string(node.Type)))
+	}
+	for _, e := range g.sortedEdges() {
+		if e.Detail != "" {
+			fmt.Fprintf(out, "    %s -> %s [label=%s, tooltip=%s];\n", dotString(e.From), dotString(e.To), dotString(e.Label), dotString(e.Detail))
+			continue
+		}
+		fmt.Fprintf(out, "    %s -> %s [label=%s];\n", dotString(e.From), dotString(e.To), dotString(e.Label))
+	}
+	fmt.Fprintln(out, "}")
+
+	return out.Flush()
+}
+
+// dotString quotes a given value as a DOT string, escaping quotes, backslashes and line breaks.
+func dotString(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", "")
+	return `"` + replacer.Replace(value) + `"`
+}
@@ -0,0 +1,90 @@
+package graph
+
+import "strings"
+
+// Filter selects the part of a graph to keep (see Graph.Filter).
+type Filter struct {
+	// IncludeTypes keeps nodes of the given types only (all types if empty).
+	IncludeTypes []NodeType
+	// ExcludeTypes drops nodes of the given types.
+	ExcludeTypes []NodeType
+	// ExcludeLabels drops edges whose label starts with any of the given prefixes
+	// (e.g. "WHOIS" or "DNS/TXT"), a trailing "*" is optional.
+	ExcludeLabels []string
+}
+
+// Filter returns a copy of the graph without the nodes and edges excluded by a given filter.
+// Edges of dropped nodes are dropped too, as are nodes left without any edge afterwards.
+// The root is always kept.
+func (g *Graph) Filter(filter Filter) *Graph {
+	out := &Graph{
+		Root:        g.Root,
+		Nodes:       map[string]*Node{},
+		EdgeDetails: g.EdgeDetails,
+		seen:        map[Edge]bool{},
+	}
+
+	keptNode := func(id string) bool {
+		node := g.Nodes[id]
+		if id == g.Root {
+			return true
+		}
+		if node == nil {
+			return false
+		}
+		return filter.keepsType(node.Type)
+	}
+
+	connected := map[string]bool{}
+	kept := map[string]bool{}
+	for _, e := range g.Edges {
+		connected[e.From] = true
+		connected[e.To] = true
+		if !keptNode(e.From) || !keptNode(e.To) || !filter.keepsLabel(e.Label) {
+			continue
+		}
+		kept[e.From] = true
+		kept[e.To] = true
+		out.AddDetailedEdge(e.From, e.To, e.Label, e.Detail)
+	}
+
+	for id, node := range g.Nodes {
+		if !keptNode(id) {
+			continue
+		}
+		if id != g.Root && connected[id] && !kept[id] {
+			// Orphaned by the filter.
+			continue
+		}
+		out.Nodes[id] = &Node{Type: node.Type, Label: node.Label}
+	}
+
+	return out
+}
+
+func (filter *Filter) keepsType(nodeType NodeType) bool {
+	for _, excluded := range filter.ExcludeTypes {
+		if nodeType == excluded {
+			return false
+		}
+	}
+	if len(filter.IncludeTypes) == 0 {
+		return true
+	}
+	for _, included := range filter.IncludeTypes {
+		if nodeType == included {
+			return true
+		}
+	}
+	return false
+}
+
+func (filter *Filter) keepsLabel(label string) bool {
+	for _, prefix := range filter.ExcludeLabels {
+		prefix = strings.TrimSuffix(prefix, "*")
+		if prefix != "" && strings.HasPrefix(label, prefix) {
+			return false
+		}
+	}
+	return true
+}
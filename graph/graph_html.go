@@ -0,0 +1,54 @@
+package graph
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+)
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>udig report: {{.Root}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #eee; }
+</style>
+</head>
+<body>
+<h1>udig report: {{.Root}}</h1>
+<h2>Nodes ({{len .Nodes}})</h2>
+<table>
+<tr><th>Label</th><th>Type</th><th>Depth</th></tr>
+{{range .Nodes}}<tr data-depth="{{.Depth}}"><td>{{.Label}}</td><td>{{.Type}}</td><td>{{.Depth}}</td></tr>
+{{end}}</table>
+<h2>Edges ({{len .Edges}})</h2>
+<table>
+<tr><th>From</th><th>Label</th><th>To</th></tr>
+{{range .Edges}}<tr><td>{{.From}}</td><td{{if .Detail}} title="{{.Detail}}"{{end}}>{{.Label}}</td><td>{{.To}}</td></tr>
+{{end}}</table>
+<script type="application/json" id="graph">{{.JSON}}</script>
+</body>
+</html>
+`))
+
+type htmlReport struct {
+	*jsonGraph
+	JSON template.JS
+}
+
+// EmitHTML writes the graph to a given writer as a self-contained HTML report.
+// The report lists all nodes and edges and embeds the JSON graph for further processing.
+func (g *Graph) EmitHTML(w io.Writer) error {
+	data := g.toJSON()
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return htmlTemplate.Execute(w, &htmlReport{jsonGraph: data, JSON: template.JS(raw)})
+}
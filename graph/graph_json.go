@@ -0,0 +1,92 @@
+package graph
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+type jsonGraph struct {
+	Root  string          `json:"root"`
+	Nodes []jsonGraphNode `json:"nodes"`
+	Edges []jsonGraphEdge `json:"edges"`
+}
+
+type jsonGraphNode struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Label string `json:"label"`
+	// Depth is the BFS distance from the root, -1 if the node is unreachable.
+	Depth int `json:"depth"`
+}
+
+type jsonGraphEdge struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Label  string `json:"label"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// EmitJSON writes the graph to a given writer as a JSON document.
+// Nodes and edges are sorted, so the output is stable.
+func (g *Graph) EmitJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(g.toJSON())
+}
+
+func (g *Graph) toJSON() *jsonGraph {
+	out := &jsonGraph{
+		Root:  g.Root,
+		Nodes: []jsonGraphNode{},
+		Edges: []jsonGraphEdge{},
+	}
+
+	depths := g.Depths()
+	for _, id := range g.sortedNodeIDs() {
+		depth, ok := depths[id]
+		if !ok {
+			depth = -1
+		}
+		out.Nodes = append(out.Nodes, jsonGraphNode{
+			ID:    id,
+			Type:  string(g.Nodes[id].Type),
+			Label: g.Nodes[id].Label,
+			Depth: depth,
+		})
+	}
+
+	for _, e := range g.sortedEdges() {
+		out.Edges = append(out.Edges, jsonGraphEdge{From: e.From, To: e.To, Label: e.Label, Detail: e.Detail})
+	}
+
+	return out
+}
+
+// sortedNodeIDs returns IDs of all nodes in lexicographical order.
+func (g *Graph) sortedNodeIDs() []string {
+	ids := make([]string, 0, len(g.Nodes))
+	for id := range g.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// sortedEdges returns all edges sorted by source, target and label.
+func (g *Graph) sortedEdges() []Edge {
+	edges := make([]Edge, 0, len(g.Edges))
+	for _, e := range g.Edges {
+		edges = append(edges, *e)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		if edges[i].To != edges[j].To {
+			return edges[i].To < edges[j].To
+		}
+		return edges[i].Label < edges[j].Label
+	})
+	return edges
+}
@@ -0,0 +1,69 @@
+package graph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// mermaidUnsafe matches characters not allowed in Mermaid node identifiers.
+var mermaidUnsafe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// EmitMermaid writes the graph to a given writer as a Mermaid flowchart (e.g. for Markdown docs).
+// Nodes are identified by their sanitized IDs and shown with their labels, edges are labeled.
+// Nodes and edges are sorted, so the output is stable.
+func (g *Graph) EmitMermaid(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintln(out, "graph LR")
+
+	ids := g.mermaidIDs()
+	for _, id := range g.sortedNodeIDs() {
+		fmt.Fprintf(out, "    %s[%s]\n", ids[id], mermaidString(g.Nodes[id].Label))
+	}
+
+	for _, e := range g.sortedEdges() {
+		from, to := ids[e.From], ids[e.To]
+		if from == "" {
+			from = mermaidID(e.From)
+		}
+		if to == "" {
+			to = mermaidID(e.To)
+		}
+		fmt.Fprintf(out, "    %s -->|%s| %s\n", from, mermaidString(e.Label), to)
+	}
+
+	return out.Flush()
+}
+
+// mermaidIDs maps IDs of all nodes to unique Mermaid identifiers.
+// Nodes whose IDs sanitize the same way are told apart by a numeric suffix (in sorted order).
+func (g *Graph) mermaidIDs() map[string]string {
+	ids := map[string]string{}
+	taken := map[string]bool{}
+	for _, id := range g.sortedNodeIDs() {
+		base := mermaidID(id)
+		unique := base
+		for i := 2; taken[unique]; i++ {
+			unique = base + "_" + strconv.Itoa(i)
+		}
+		taken[unique] = true
+		ids[id] = unique
+	}
+	return ids
+}
+
+// mermaidID returns a given node ID as a Mermaid identifier (e.g. "n_example_com").
+// The prefix keeps clear of leading digits and keywords (e.g. "end").
+func mermaidID(id string) string {
+	return "n_" + mermaidUnsafe.ReplaceAllString(id, "_")
+}
+
+// mermaidString quotes a given label, replacing quotes and line breaks with entity codes.
+func mermaidString(value string) string {
+	replacer := strings.NewReplacer(`"`, "#quot;", "\n", " ", "\r", " ")
+	return `"` + replacer.Replace(value) + `"`
+}
@@ -0,0 +1,506 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/netrixone/udig"
+	"github.com/stretchr/testify/assert"
+)
+
+func mockGraph() *Graph {
+	g := New("example.com")
+	g.AddNode("sub.example.com", NodeDomain, "sub.example.com")
+	g.AddNode("93.184.216.34", NodeIP, "93.184.216.34")
+	g.AddNode("US", NodeGeo, "US")
+	g.AddEdge("example.com", "sub.example.com", "DNS/CNAME")
+	g.AddEdge("sub.example.com", "93.184.216.34", "DNS/A")
+	g.AddEdge("93.184.216.34", "US", "GEO")
+	return g
+}
+
+func Test_When_EmitHTML_completes_Then_report_contains_all_nodes(t *testing.T) {
+	// Setup.
+	g := mockGraph()
+	buffer := &bytes.Buffer{}
+
+	// Execute.
+	err := g.EmitHTML(buffer)
+
+	// Assert.
+	assert.NoError(t, err)
+	html := buffer.String()
+	assert.Contains(t, html, "<title>udig report: example.com</title>")
+	assert.Contains(t, html, "<td>sub.example.com</td>")
+	assert.Contains(t, html, "<td>93.184.216.34</td>")
+	assert.Contains(t, html, "<td>US</td>")
+}
+
+func Test_When_EmitJSON_completes_Then_nodes_carry_their_depth(t *testing.T) {
+	// Setup.
+	g := mockGraph()
+	g.AddNode("orphan.example.org", NodeDomain, "orphan.example.org")
+	buffer := &bytes.Buffer{}
+
+	// Execute.
+	err := g.EmitJSON(buffer)
+
+	// Assert.
+	assert.NoError(t, err)
+	var out jsonGraph
+	assert.NoError(t, json.Unmarshal(buffer.Bytes(), &out))
+	depths := map[string]int{}
+	for _, node := range out.Nodes {
+		depths[node.ID] = node.Depth
+	}
+	assert.Equal(t, map[string]int{
+		"example.com":        0,
+		"sub.example.com":    1,
+		"93.184.216.34":      2,
+		"US":                 3,
+		"orphan.example.org": -1,
+	}, depths)
+}
+
+func Test_When_EmitJSON_completes_Then_node_types_and_labels_round_trip(t *testing.T) {
+	// Setup.
+	g := New("example.com")
+	g.AddNode("AS15169", NodeAS, "AS15169 (GOOGLE)")
+	g.AddNode("John Doe <john@example.com>", NodeContact, "John Doe")
+	g.AddEdge("example.com", "AS15169", "BGP")
+	g.AddEdge("example.com", "John Doe <john@example.com>", "WHOIS")
+	buffer := &bytes.Buffer{}
+
+	// Execute.
+	err := g.EmitJSON(buffer)
+
+	// Assert.
+	assert.NoError(t, err)
+	var out jsonGraph
+	assert.NoError(t, json.Unmarshal(buffer.Bytes(), &out))
+	assert.Equal(t, []jsonGraphNode{
+		{ID: "AS15169", Type: string(NodeAS), Label: "AS15169 (GOOGLE)", Depth: 1},
+		{ID: "John Doe <john@example.com>", Type: string(NodeContact), Label: "John Doe", Depth: 1},
+		{ID: "example.com", Type: string(NodeDomain), Label: "example.com", Depth: 0},
+	}, out.Nodes)
+}
+
+func Test_When_EmitJSON_completes_Then_all_edges_are_listed_in_order(t *testing.T) {
+	// Setup.
+	g := mockGraph()
+	g.AddEdge("example.com", "93.184.216.34", "DNS/A")
+	g.AddEdge("example.com", "sub.example.com", "TLS")
+	buffer := &bytes.Buffer{}
+
+	// Execute.
+	err := g.EmitJSON(buffer)
+
+	// Assert.
+	assert.NoError(t, err)
+	var out jsonGraph
+	assert.NoError(t, json.Unmarshal(buffer.Bytes(), &out))
+	assert.Equal(t, []jsonGraphEdge{
+		{From: "93.184.216.34", To: "US", Label: "GEO"},
+		{From: "example.com", To: "93.184.216.34", Label: "DNS/A"},
+		{From: "example.com", To: "sub.example.com", Label: "DNS/CNAME"},
+		{From: "example.com", To: "sub.example.com", Label: "TLS"},
+		{From: "sub.example.com", To: "93.184.216.34", Label: "DNS/A"},
+	}, out.Edges)
+}
+
+func Test_When_edge_details_are_enabled_Then_JSON_edges_carry_them(t *testing.T) {
+	// Setup.
+	g := New("example.com")
+	g.EdgeDetails = true
+	g.AddNode("_spf.example.net", NodeDomain, "_spf.example.net")
+	g.AddDetailedEdge("example.com", "_spf.example.net", "DNS/TXT", `example.com. 300 IN TXT "v=spf1 include:_spf.example.net -all"`)
+	buffer := &bytes.Buffer{}
+
+	// Execute.
+	err := g.EmitJSON(buffer)
+
+	// Assert.
+	assert.NoError(t, err)
+	var out jsonGraph
+	assert.NoError(t, json.Unmarshal(buffer.Bytes(), &out))
+	assert.Len(t, out.Edges, 1)
+	assert.Equal(t, `example.com. 300 IN TXT "v=spf1 include:_spf.example.net -all"`, out.Edges[0].Detail)
+}
+
+func Test_When_edge_details_are_disabled_Then_JSON_edges_omit_them(t *testing.T) {
+	// Setup.
+	g := New("example.com")
+	g.AddNode("_spf.example.net", NodeDomain, "_spf.example.net")
+	g.AddDetailedEdge("example.com", "_spf.example.net", "DNS/TXT", `example.com. 300 IN TXT "v=spf1 include:_spf.example.net -all"`)
+	buffer := &bytes.Buffer{}
+
+	// Execute.
+	err := g.EmitJSON(buffer)
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.NotContains(t, buffer.String(), "detail")
+}
+
+func Test_When_EmitCypher_completes_Then_script_merges_nodes_and_relationships(t *testing.T) {
+	// Setup.
+	g := mockGraph()
+	buffer := &bytes.Buffer{}
+
+	// Execute.
+	err := g.EmitCypher(buffer)
+
+	// Assert.
+	assert.NoError(t, err)
+	cypher := buffer.String()
+	assert.Contains(t, cypher, "MERGE (n:`domain` {id: 'example.com'}) SET n.type = 'domain', n.label = 'example.com', n.depth = 0;")
+	assert.Contains(t, cypher, "MATCH (a:`ip` {id: '93.184.216.34'}), (b:`geo` {id: 'US'}) MERGE (a)-[r:`GEO`]->(b) SET r.label = 'GEO';")
+}
+
+func Test_When_EmitCypher_gets_quotes_and_backticks_Then_they_are_escaped(t *testing.T) {
+	// Setup.
+	g := New("example.com")
+	g.AddNode("O'Brien", NodeContact, "O'Brien \\ Co")
+	g.AddEdge("example.com", "O'Brien", "WHOIS`x")
+	buffer := &bytes.Buffer{}
+
+	// Execute.
+	err := g.EmitCypher(buffer)
+
+	// Assert.
+	assert.NoError(t, err)
+	cypher := buffer.String()
+	assert.Contains(t, cypher, `{id: 'O\'Brien'}) SET n.type = 'contact', n.label = 'O\'Brien \\ Co', n.depth = 1;`)
+	assert.Contains(t, cypher, "[r:`WHOIS``x`]")
+}
+
+func Test_When_EmitGraphML_completes_Then_document_declares_nodes_and_edges(t *testing.T) {
+	// Setup.
+	g := mockGraph()
+	buffer := &bytes.Buffer{}
+
+	// Execute.
+	err := g.EmitGraphML(buffer)
+
+	// Assert.
+	assert.NoError(t, err)
+	graphML := buffer.String()
+	assert.Contains(t, graphML, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	assert.Contains(t, graphML, `<key id="type" for="node" attr.name="type" attr.type="string"></key>`)
+	assert.Contains(t, graphML, `<node id="93.184.216.34">
+      <data key="type">ip</data>
+      <data key="label">93.184.216.34</data>
+      <data key="depth">2</data>
+    </node>`)
+	assert.Contains(t, graphML, `<edge id="e0" source="93.184.216.34" target="US">
+      <data key="edge_label">GEO</data>
+    </edge>`)
+
+	var parsed struct {
+		Nodes []struct {
+			ID string `xml:"id,attr"`
+		} `xml:"graph>node"`
+		Edges []struct {
+			Source string `xml:"source,attr"`
+		} `xml:"graph>edge"`
+	}
+	assert.NoError(t, xml.Unmarshal(buffer.Bytes(), &parsed))
+	assert.Len(t, parsed.Nodes, 4)
+	assert.Len(t, parsed.Edges, 3)
+}
+
+func Test_When_EmitGraphML_gets_markup_Then_it_is_escaped(t *testing.T) {
+	// Setup.
+	g := New("example.com")
+	g.AddNode("<b>&co", NodeContact, "<b>&co")
+	g.AddEdge("example.com", "<b>&co", "WHOIS")
+	buffer := &bytes.Buffer{}
+
+	// Execute.
+	err := g.EmitGraphML(buffer)
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.Contains(t, buffer.String(), `<node id="&lt;b&gt;&amp;co">`)
+	assert.NotContains(t, buffer.String(), "<b>")
+}
+
+func Test_When_EmitMermaid_completes_Then_flowchart_has_sanitized_nodes_and_labeled_edges(t *testing.T) {
+	// Setup.
+	g := mockGraph()
+	buffer := &bytes.Buffer{}
+
+	// Execute.
+	err := g.EmitMermaid(buffer)
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.Equal(t, `graph LR
+    n_93_184_216_34["93.184.216.34"]
+    n_US["US"]
+    n_example_com["example.com"]
+    n_sub_example_com["sub.example.com"]
+    n_93_184_216_34 -->|"GEO"| n_US
+    n_example_com -->|"DNS/CNAME"| n_sub_example_com
+    n_sub_example_com -->|"DNS/A"| n_93_184_216_34
+`, buffer.String())
+}
+
+func Test_When_EmitMermaid_gets_colliding_IDs_and_quotes_Then_they_are_told_apart_and_escaped(t *testing.T) {
+	// Setup.
+	g := New("example.com")
+	g.AddNode("John (Doe)", NodeContact, `John "JD" Doe`)
+	g.AddNode("John_(Doe)", NodeContact, "John Doe")
+	g.AddEdge("example.com", "John (Doe)", "WHOIS")
+	g.AddEdge("example.com", "John_(Doe)", "WHOIS")
+	buffer := &bytes.Buffer{}
+
+	// Execute.
+	err := g.EmitMermaid(buffer)
+
+	// Assert.
+	assert.NoError(t, err)
+	mermaid := buffer.String()
+	assert.Contains(t, mermaid, `n_John__Doe_["John #quot;JD#quot; Doe"]`)
+	assert.Contains(t, mermaid, `n_John__Doe__2["John Doe"]`)
+	assert.Contains(t, mermaid, `n_example_com -->|"WHOIS"| n_John__Doe__2`)
+}
+
+func Test_When_EmitCSV_completes_Then_edges_are_listed_with_endpoint_types(t *testing.T) {
+	// Setup.
+	g := mockGraph()
+	g.AddNode("Acme, \"Inc\"", NodeContact, "Acme")
+	g.AddEdge("example.com", "Acme, \"Inc\"", "WHOIS")
+	buffer := &bytes.Buffer{}
+
+	// Execute.
+	err := g.EmitCSV(buffer)
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.Equal(t, `from,to,label,from_type,to_type
+93.184.216.34,US,GEO,ip,geo
+example.com,"Acme, ""Inc""",WHOIS,domain,contact
+example.com,sub.example.com,DNS/CNAME,domain,domain
+sub.example.com,93.184.216.34,DNS/A,domain,ip
+`, buffer.String())
+}
+
+func Test_When_EmitDOT_completes_Then_digraph_has_quoted_nodes_and_labeled_edges(t *testing.T) {
+	// Setup.
+	g := mockGraph()
+	g.AddNode(`Acme "Inc"`, NodeContact, `Acme "Inc"`)
+	g.AddEdge("example.com", `Acme "Inc"`, "WHOIS")
+	buffer := &bytes.Buffer{}
+
+	// Execute.
+	err := g.EmitDOT(buffer)
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.Equal(t, `digraph "example.com" {
+    "93.184.216.34" [label="93.184.216.34", class="ip"];
+    "Acme \"Inc\"" [label="Acme \"Inc\"", class="contact"];
+    "US" [label="US", class="geo"];
+    "example.com" [label="example.com", class="domain"];
+    "sub.example.com" [label="sub.example.com", class="domain"];
+    "93.184.216.34" -> "US" [label="GEO"];
+    "example.com" -> "Acme \"Inc\"" [label="WHOIS"];
+    "example.com" -> "sub.example.com" [label="DNS/CNAME"];
+    "sub.example.com" -> "93.184.216.34" [label="DNS/A"];
+}
+`, buffer.String())
+}
+
+type mockResolution struct {
+	udig.Resolution
+	query   string
+	domains []string
+}
+
+func (res *mockResolution) Type() udig.ResolutionType {
+	return "MOCK"
+}
+
+func (res *mockResolution) Query() string {
+	return res.query
+}
+
+func (res *mockResolution) Domains() []string {
+	return res.domains
+}
+
+func (res *mockResolution) IPs() []string {
+	return nil
+}
+
+func mockBatch() map[string][]udig.Resolution {
+	return map[string][]udig.Resolution{
+		"example.com": {&mockResolution{query: "example.com", domains: []string{"www.example.com"}}},
+		"example.org": {&mockResolution{query: "example.org", domains: []string{"www.example.org"}}},
+	}
+}
+
+func Test_When_CollectBatch_uses_seeds_as_roots_Then_each_seed_has_its_own_graph(t *testing.T) {
+	// Execute.
+	graphs := CollectBatch(mockBatch(), WithSeedAsRoot())
+
+	// Assert.
+	assert.Len(t, graphs, 2)
+	assert.Equal(t, "example.com", graphs[0].Root)
+	assert.Equal(t, "example.org", graphs[1].Root)
+	assert.Len(t, graphs[0].Nodes, 2)
+	assert.Nil(t, graphs[0].Nodes["www.example.org"])
+}
+
+func Test_When_CollectBatch_uses_virtual_root_Then_graph_is_single_and_connected(t *testing.T) {
+	// Execute.
+	graphs := CollectBatch(mockBatch(), WithVirtualRoot(""))
+
+	// Assert.
+	assert.Len(t, graphs, 1)
+	g := graphs[0]
+	assert.Equal(t, DefaultVirtualRoot, g.Root)
+	assert.Equal(t, NodeVirtual, g.Nodes[DefaultVirtualRoot].Type)
+	assert.Equal(t, map[string]int{
+		DefaultVirtualRoot: 0,
+		"example.com":      1,
+		"example.org":      1,
+		"www.example.com":  2,
+		"www.example.org":  2,
+	}, g.Depths())
+}
+
+func Test_When_graph_is_filtered_by_node_type_Then_orphans_are_dropped_and_root_is_kept(t *testing.T) {
+	// Setup.
+	g := mockGraph()
+	g.AddNode("AS15133", NodeAS, "AS15133")
+	g.AddEdge("93.184.216.34", "AS15133", "BGP")
+
+	// Execute.
+	filtered := g.Filter(Filter{IncludeTypes: []NodeType{NodeIP}})
+
+	// Assert.
+	assert.Len(t, filtered.Nodes, 1)
+	assert.NotNil(t, filtered.Nodes["example.com"])
+	assert.Empty(t, filtered.Edges)
+	assert.Len(t, g.Nodes, 5, "the original graph is intact")
+}
+
+func Test_When_graph_is_filtered_by_edge_label_Then_matching_edges_are_dropped(t *testing.T) {
+	// Setup.
+	g := mockGraph()
+	g.AddNode("John Doe", NodeContact, "John Doe")
+	g.AddEdge("example.com", "John Doe", "WHOIS")
+
+	// Execute.
+	filtered := g.Filter(Filter{ExcludeLabels: []string{"WHOIS*"}, ExcludeTypes: []NodeType{NodeGeo}})
+
+	// Assert.
+	assert.Nil(t, filtered.Nodes["John Doe"])
+	assert.Nil(t, filtered.Nodes["US"])
+	assert.Equal(t, []Edge{
+		{From: "example.com", To: "sub.example.com", Label: "DNS/CNAME"},
+		{From: "sub.example.com", To: "93.184.216.34", Label: "DNS/A"},
+	}, filtered.sortedEdges())
+}
+
+func Test_When_DNS_resolution_has_CAA_records_Then_they_point_to_CAs_and_report_endpoint(t *testing.T) {
+	// Setup.
+	caa := func(tag string, value string) udig.DNSRecordPair {
+		return udig.DNSRecordPair{QueryType: dns.TypeCAA, Record: &udig.DNSRecord{RR: &dns.CAA{
+			Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeCAA, Class: dns.ClassINET},
+			Tag: tag, Value: value,
+		}}}
+	}
+	res := &udig.DNSResolution{
+		ResolutionBase: &udig.ResolutionBase{},
+		Records: []udig.DNSRecordPair{
+			caa("issue", "letsencrypt.org; validationmethods=dns-01"),
+			caa("issuewild", "sectigo.com"),
+			caa("iodef", "mailto:caa@security.example.net"),
+			caa("issue", ";"),
+		},
+	}
+
+	// Execute.
+	// Note: the query cannot be set outside of udig, so the edges start at an empty root.
+	g := Collect("", []udig.Resolution{res})
+
+	// Assert.
+	assert.Equal(t, []Edge{
+		{From: "", To: "letsencrypt.org", Label: "DNS/CAA"},
+		{From: "", To: "sectigo.com", Label: "DNS/CAA-WILD"},
+		{From: "", To: "security.example.net", Label: "DNS/CAA-IODEF"},
+	}, g.sortedEdges())
+}
+
+func Test_When_HTTP_resolution_has_redirects_Then_they_point_to_redirect_targets(t *testing.T) {
+	// Setup.
+	res := &udig.HTTPResolution{
+		ResolutionBase: &udig.ResolutionBase{},
+		Redirects:      []string{"https://www.example.com/", "https://login.example.net/sso?next=/"},
+	}
+
+	// Execute.
+	// Note: the query cannot be set outside of udig, so the edges start at an empty root.
+	g := Collect("", []udig.Resolution{res}, WithEdgeDetails())
+
+	// Assert.
+	assert.Equal(t, []Edge{
+		{From: "", To: "example.com", Label: "HTTP/redirect", Detail: "https://www.example.com/"},
+		{From: "", To: "login.example.net", Label: "HTTP/redirect", Detail: "https://login.example.net/sso?next=/"},
+	}, g.sortedEdges())
+}
+
+func Test_When_HTTP_resolution_has_location_Then_it_points_to_its_domain(t *testing.T) {
+	// Setup.
+	res := &udig.HTTPResolution{
+		ResolutionBase: &udig.ResolutionBase{},
+		Location:       "https://login.example.net/sso",
+	}
+
+	// Execute.
+	g := Collect("", []udig.Resolution{res}, WithEdgeDetails())
+
+	// Assert.
+	assert.Equal(t, []Edge{
+		{From: "", To: "login.example.net", Label: "HTTP/location", Detail: "https://login.example.net/sso"},
+	}, g.sortedEdges())
+}
+
+func Test_When_HTTP_resolution_has_body_domains_Then_they_are_linked(t *testing.T) {
+	// Setup.
+	res := &udig.HTTPResolution{
+		ResolutionBase: &udig.ResolutionBase{},
+		BodyDomains:    []string{"cdn.example.net", "example.org"},
+	}
+
+	// Execute.
+	g := Collect("", []udig.Resolution{res})
+
+	// Assert.
+	assert.Equal(t, []Edge{
+		{From: "", To: "cdn.example.net", Label: "HTTP/body"},
+		{From: "", To: "example.org", Label: "HTTP/body"},
+	}, g.sortedEdges())
+}
+
+func Test_When_EmitDOT_with_edge_details_completes_Then_edges_have_tooltips(t *testing.T) {
+	// Setup.
+	g := New("example.com")
+	g.EdgeDetails = true
+	g.AddNode("sub.example.com", NodeDomain, "sub.example.com")
+	g.AddDetailedEdge("example.com", "sub.example.com", "DNS/CNAME", "sub.example.com.\t300\tIN\tCNAME\t\"x\"")
+	buffer := &bytes.Buffer{}
+
+	// Execute.
+	err := g.EmitDOT(buffer)
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.Contains(t, buffer.String(), `"example.com" -> "sub.example.com" [label="DNS/CNAME", tooltip="sub.example.com.	300	IN	CNAME	\"x\""];`)
+}
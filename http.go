@@ -1,10 +1,13 @@
 package udig
 
 import (
-	"crypto/tls"
+	"context"
 	"fmt"
-	"net"
+	"io"
 	"net/http"
+	neturl "net/url"
+	"regexp"
+	"strings"
 )
 
 var (
@@ -17,58 +20,162 @@ var (
 	}
 )
 
-// fetchHeaders connects to a given URL and on successful connection returns
-// a map of HTTP headers in the response.
-func fetchHeaders(url string) http.Header {
-	transport := http.DefaultTransport.(*http.Transport)
+const (
+	// DefaultHTTPMaxBodyBytes is a default max number of bytes read from a response body.
+	DefaultHTTPMaxBodyBytes = 1 << 20
 
-	transport.DialContext = (&net.Dialer{
-		Timeout:   DefaultTimeout,
-		KeepAlive: DefaultTimeout,
-	}).DialContext
+	// MaxHTTPRedirects is a max number of redirects followed per request.
+	MaxHTTPRedirects = 10
 
-	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	transport.TLSHandshakeTimeout = DefaultTimeout
+	// DefaultUserAgent is a User-Agent sent with all HTTP requests by default.
+	DefaultUserAgent = "udig/1.5"
+)
+
+var (
+	// For finding plain HTTP URLs (i.e. mixed content) in pages and headers.
+	insecureURLPattern = regexp.MustCompile(`(?i)http://[^\s"'<>()]+`)
+)
 
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   DefaultTimeout,
+var (
+	// DefaultHTTPSchemes is a list of URL schemes probed by default (in this order).
+	DefaultHTTPSchemes = [...]string{"https", "http"}
+)
+
+// fetchPage connects to a given URL and on successful connection returns
+// the (closed) response and (at most maxBodyBytes of) its textual body.
+// Binary bodies (by content type) are not read at all.
+func fetchPage(ctx context.Context, client *http.Client, url string, header http.Header, maxBodyBytes int64) (*http.Response, []byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		LogErr("%s: Could not GET %s - the cause was: %s.", TypeHTTP, url, err.Error())
+		return nil, nil, err
+	}
+	setRequestHeaders(request, header)
+
+	response, err := client.Do(request)
+	if err != nil {
+		LogErr("%s: Could not GET %s - the cause was: %s.", TypeHTTP, url, err.Error())
+		return nil, nil, err
 	}
+	defer response.Body.Close()
 
-	response, err := client.Get(url)
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxBodyBytes))
 	if err != nil {
-		// Don't bother trying to find CSP on non-TLS sites.
-		LogErr("HTTP: Could not GET %s - the cause was: %s.", url, err.Error())
-		return map[string][]string{}
+		LogDebug("%s: Could not read body of %s - the cause was: %s.", TypeHTTP, url, err.Error())
 	}
 
-	return response.Header
+	contentType := response.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	if !isTextContent(contentType) {
+		LogDebug("%s: Skipping body of %s, content type %s is not textual.", TypeHTTP, url, contentType)
+		return response, nil, nil
+	}
+
+	return response, body, nil
 }
 
-/////////////////////////////////////////
-// HTTP RESOLVER
-/////////////////////////////////////////
+// isTextContent returns true if a given content type denotes a textual payload (HTML, JS, JSON, ...).
+func isTextContent(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	for _, suffix := range []string{"javascript", "json", "xml", "ecmascript"} {
+		if strings.HasSuffix(mediaType, suffix) {
+			return true
+		}
+	}
+	return false
+}
 
-// NewHTTPResolver creates a new HTTPResolver with sensible defaults.
-func NewHTTPResolver() *HTTPResolver {
-	transport := http.DefaultTransport.(*http.Transport)
+// isDowngrade tells if the first response of a given plain HTTP fetch (i.e. before
+// any redirects were followed) is served as it is, i.e. without a redirect to HTTPS.
+func isDowngrade(response *http.Response) bool {
+	for response.Request != nil && response.Request.Response != nil {
+		response = response.Request.Response
+	}
+
+	isRedirect := response.StatusCode >= 300 && response.StatusCode < 400
+	return !isRedirect || !strings.HasPrefix(strings.ToLower(response.Header.Get("Location")), "https://")
+}
+
+// setRequestHeaders adds given headers to a given request (replacing existing ones).
+func setRequestHeaders(request *http.Request, header http.Header) {
+	for name, values := range header {
+		request.Header[http.CanonicalHeaderKey(name)] = append([]string{}, values...)
+	}
+}
+
+// withoutRedirects returns a copy of a given client, which does not follow redirects.
+func withoutRedirects(client *http.Client) *http.Client {
+	noRedirectClient := *client
+	noRedirectClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &noRedirectClient
+}
+
+// withRedirectChain returns a copy of a given client, which records URLs of all followed
+// redirects into a given chain. It stops (keeping the last response) after MaxHTTPRedirects
+// or once a URL repeats.
+func withRedirectChain(client *http.Client, chain *[]string) *http.Client {
+	chainingClient := *client
+	chainingClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		url := req.URL.String()
+		for _, previous := range via {
+			if samePage(previous.URL, req.URL) {
+				LogDebug("%s: Redirect loop detected at %s.", TypeHTTP, url)
+				return http.ErrUseLastResponse
+			}
+		}
 
-	transport.DialContext = (&net.Dialer{
-		Timeout:   DefaultTimeout,
-		KeepAlive: DefaultTimeout,
-	}).DialContext
+		*chain = append(*chain, url)
+		if len(via) >= MaxHTTPRedirects {
+			LogDebug("%s: Stopped after %d redirects at %s.", TypeHTTP, MaxHTTPRedirects, url)
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+	return &chainingClient
+}
 
-	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	transport.TLSHandshakeTimeout = DefaultTimeout
+// samePage returns true if given URLs point to the same page ("https://a" equals "https://a/").
+func samePage(a *neturl.URL, b *neturl.URL) bool {
+	normalize := func(u *neturl.URL) string {
+		normalized := *u
+		if normalized.Path == "" {
+			normalized.Path = "/"
+		}
+		return normalized.String()
+	}
+	return normalize(a) == normalize(b)
+}
 
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   DefaultTimeout,
+// dissectInsecureDomains returns domains of all plain HTTP URLs found in given strings.
+func dissectInsecureDomains(haystacks ...string) (domains []string) {
+	for _, haystack := range haystacks {
+		for _, url := range insecureURLPattern.FindAllString(haystack, -1) {
+			domains = append(domains, DissectDomainsFromString(url)...)
+		}
 	}
+	return domains
+}
+
+/////////////////////////////////////////
+// HTTP RESOLVER
+/////////////////////////////////////////
 
+// NewHTTPResolver creates a new HTTPResolver with sensible defaults.
+func NewHTTPResolver() *HTTPResolver {
 	return &HTTPResolver{
-		Headers: DefaultHTTPHeaders[:],
-		Client:  client,
+		Headers:         DefaultHTTPHeaders[:],
+		Schemes:         DefaultHTTPSchemes[:],
+		FollowRedirects: true,
+		MaxBodyBytes:    DefaultHTTPMaxBodyBytes,
+		UserAgent:       DefaultUserAgent,
+		Client:          newHTTPClient(),
 	}
 }
 
@@ -78,20 +185,89 @@ func (resolver *HTTPResolver) Type() ResolutionType {
 }
 
 // ResolveDomain resolves a given domain to a list of corresponding HTTP headers.
-func (resolver *HTTPResolver) ResolveDomain(domain string) Resolution {
+func (resolver *HTTPResolver) ResolveDomain(ctx context.Context, domain string) Resolution {
 	resolution := &HTTPResolution{
 		ResolutionBase: &ResolutionBase{query: domain},
 	}
 
-	headers := fetchHeaders("https://" + domain)
+	resolver.resolveURLs(ctx, resolution, "https://"+domain, "http://"+domain)
+
+	return resolution
+}
+
+func (resolver *HTTPResolver) resolveURLs(ctx context.Context, resolution *HTTPResolution, secureURL string, insecureURL string) {
+	client := resolver.Client
+	if resolver.FollowRedirects {
+		client = withRedirectChain(client, &resolution.Redirects)
+	} else {
+		client = withoutRedirects(client)
+	}
+
+	// Only give up if none of the schemes is served.
+	var errs []error
+	for _, scheme := range resolver.Schemes {
+		url := secureURL
+		if scheme == "http" {
+			url = insecureURL
+		}
+
+		response, body, err := fetchPage(ctx, client, url, resolver.requestHeaders(), resolver.MaxBodyBytes)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resolver.resolvePage(resolution, scheme, response.Header, body)
+		if scheme == "http" {
+			resolution.Downgrade = isDowngrade(response)
+		}
+	}
+	if len(errs) == len(resolver.Schemes) {
+		for _, err := range errs {
+			resolution.addError(err)
+		}
+	}
+
+	resolution.InsecureDomains = uniqueStrings(resolution.InsecureDomains)
+	resolution.BodyDomains = uniqueStrings(resolution.BodyDomains)
+	resolution.Redirects = uniqueStrings(resolution.Redirects)
+}
+
+// resolvePage collects headers (tagged by a given scheme) and domains of a single page.
+func (resolver *HTTPResolver) resolvePage(resolution *HTTPResolution, scheme string, headers http.Header, body []byte) {
+	if !resolver.FollowRedirects && resolution.Location == "" {
+		resolution.Location = headers.Get("Location")
+	}
+
 	for _, name := range resolver.Headers {
 		value := headers[http.CanonicalHeaderKey(name)]
 		if len(DissectDomainsFromStrings(value)) > 0 {
-			resolution.Headers = append(resolution.Headers, HTTPHeader{name, value})
+			resolution.Headers = append(resolution.Headers, HTTPHeader{Name: name, Value: value, Scheme: scheme})
+		}
+		if scheme == "https" {
+			resolution.InsecureDomains = append(resolution.InsecureDomains, dissectInsecureDomains(value...)...)
 		}
 	}
 
-	return resolution
+	if scheme == "https" {
+		// Look for resources loaded over plain HTTP from the HTTPS page.
+		resolution.InsecureDomains = append(resolution.InsecureDomains, dissectInsecureDomains(string(body))...)
+	}
+
+	if resolver.ScanBody {
+		resolution.BodyDomains = append(resolution.BodyDomains, DissectDomainsFromStrings([]string{string(body)})...)
+	}
+}
+
+// requestHeaders returns RequestHeaders along with the User-Agent (if set).
+func (resolver *HTTPResolver) requestHeaders() http.Header {
+	header := resolver.RequestHeaders.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if resolver.UserAgent != "" {
+		header.Set("User-Agent", resolver.UserAgent)
+	}
+	return header
 }
 
 /////////////////////////////////////////
@@ -108,13 +284,32 @@ func (res *HTTPResolution) Domains() (domains []string) {
 	for _, header := range res.Headers {
 		domains = append(domains, DissectDomainsFromStrings(header.Value)...)
 	}
+	domains = append(domains, res.InsecureDomains...)
+	domains = append(domains, DissectDomainsFromString(res.Location)...)
+	domains = append(domains, DissectDomainsFromStrings(res.Redirects)...)
+	domains = append(domains, res.BodyDomains...)
 	return domains
 }
 
+// Raw returns the headers as http.Header.
+func (res *HTTPResolution) Raw() interface{} {
+	headers := http.Header{}
+	for _, header := range res.Headers {
+		// Headers of the first probed scheme take precedence.
+		if _, ok := headers[http.CanonicalHeaderKey(header.Name)]; !ok {
+			headers[http.CanonicalHeaderKey(header.Name)] = header.Value
+		}
+	}
+	return headers
+}
+
 /////////////////////////////////////////
 // HTTP HEADER
 /////////////////////////////////////////
 
 func (header *HTTPHeader) String() string {
-	return fmt.Sprintf("%s: %v", header.Name, header.Value)
+	if header.Scheme == "" {
+		return fmt.Sprintf("%s: %v", header.Name, header.Value)
+	}
+	return fmt.Sprintf("%s: %v (%s)", header.Name, header.Value, header.Scheme)
 }
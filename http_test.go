@@ -0,0 +1,372 @@
+package udig
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_When_page_has_mixed_content_Then_insecure_domains_are_captured_and_downgrade_flagged(t *testing.T) {
+	// Mock.
+	secureServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<html><img src="http://insecure.example.net/logo.png"><a href="https://secure.example.net/">ok</a></html>`))
+	}))
+	defer secureServer.Close()
+
+	insecureServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("no redirect here"))
+	}))
+	defer insecureServer.Close()
+
+	// Setup.
+	resolver := NewHTTPResolver()
+	resolution := &HTTPResolution{ResolutionBase: &ResolutionBase{query: "example.net"}}
+
+	// Execute.
+	resolver.resolveURLs(context.Background(), resolution, secureServer.URL, insecureServer.URL)
+
+	// Assert.
+	assert.Equal(t, []string{"insecure.example.net"}, resolution.InsecureDomains)
+	assert.True(t, resolution.Downgrade)
+	assert.Contains(t, resolution.Domains(), "insecure.example.net")
+}
+
+func Test_When_HTTP_redirects_to_HTTPS_Then_downgrade_is_not_flagged(t *testing.T) {
+	// Mock.
+	secureServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer secureServer.Close()
+
+	insecureRequests := 0
+	insecureServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		insecureRequests++
+		http.Redirect(w, r, secureServer.URL, http.StatusMovedPermanently)
+	}))
+	defer insecureServer.Close()
+
+	// Setup.
+	resolver := NewHTTPResolver()
+	resolver.Client = secureServer.Client()
+	resolution := &HTTPResolution{ResolutionBase: &ResolutionBase{query: "example.net"}}
+
+	// Execute.
+	resolver.resolveURLs(context.Background(), resolution, secureServer.URL, insecureServer.URL)
+
+	// Assert.
+	assert.NoError(t, resolution.Error())
+	assert.False(t, resolution.Downgrade)
+	// The downgrade is told from the response of the HTTP page itself.
+	assert.Equal(t, 1, insecureRequests)
+}
+
+func Test_When_HTTP_is_not_among_schemes_Then_downgrade_is_not_probed(t *testing.T) {
+	// Mock.
+	secureServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer secureServer.Close()
+
+	insecureRequests := 0
+	insecureServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		insecureRequests++
+	}))
+	defer insecureServer.Close()
+
+	// Setup.
+	resolver := NewHTTPResolver()
+	resolver.Client = secureServer.Client()
+	resolver.Schemes = []string{"https"}
+	resolution := &HTTPResolution{ResolutionBase: &ResolutionBase{query: "example.net"}}
+
+	// Execute.
+	resolver.resolveURLs(context.Background(), resolution, secureServer.URL, insecureServer.URL)
+
+	// Assert.
+	assert.NoError(t, resolution.Error())
+	assert.False(t, resolution.Downgrade)
+	assert.Zero(t, insecureRequests)
+}
+
+func Test_When_redirects_are_not_followed_Then_location_is_captured(t *testing.T) {
+	// Mock.
+	hops := 0
+	secureServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hops++
+		http.Redirect(w, r, "https://landing.example.net/welcome", http.StatusFound)
+	}))
+	defer secureServer.Close()
+
+	// Setup.
+	resolver := NewHTTPResolver()
+	resolver.Schemes = []string{"https"}
+	resolver.FollowRedirects = false
+	resolution := &HTTPResolution{ResolutionBase: &ResolutionBase{query: "example.net"}}
+
+	// Execute.
+	resolver.resolveURLs(context.Background(), resolution, secureServer.URL, secureServer.URL)
+
+	// Assert.
+	assert.Equal(t, 1, hops) // HTTPS page only, no follow-ups.
+	assert.Equal(t, "https://landing.example.net/welcome", resolution.Location)
+	assert.Contains(t, resolution.Domains(), "landing.example.net")
+}
+
+func Test_When_resolvers_are_created_Then_they_do_not_share_the_default_transport(t *testing.T) {
+	// Setup.
+	defaultTransport := http.DefaultTransport.(*http.Transport)
+	defaultTLSConfig := defaultTransport.TLSClientConfig
+
+	// Execute.
+	httpResolver := NewHTTPResolver()
+	ctResolver := NewCTResolver()
+
+	// Assert.
+	httpTransport := httpResolver.Client.Transport.(*http.Transport)
+	ctTransport := ctResolver.Client.Transport.(*http.Transport)
+	assert.NotSame(t, defaultTransport, httpTransport)
+	assert.NotSame(t, defaultTransport, ctTransport)
+	assert.NotSame(t, httpTransport, ctTransport)
+	assert.Same(t, defaultTLSConfig, defaultTransport.TLSClientConfig)
+}
+
+func Test_When_redirects_are_followed_Then_chain_is_recorded(t *testing.T) {
+	// Mock.
+	secureServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			http.Redirect(w, r, "/hop", http.StatusFound)
+			break
+		case "/hop":
+			http.Redirect(w, r, "/final", http.StatusMovedPermanently)
+			break
+		}
+	}))
+	defer secureServer.Close()
+
+	// Setup.
+	resolver := NewHTTPResolver()
+	resolution := &HTTPResolution{ResolutionBase: &ResolutionBase{query: "example.net"}}
+
+	// Execute.
+	resolver.resolveURLs(context.Background(), resolution, secureServer.URL, secureServer.URL)
+
+	// Assert.
+	assert.Equal(t, []string{secureServer.URL + "/hop", secureServer.URL + "/final"}, resolution.Redirects)
+}
+
+func Test_When_redirects_loop_Then_chain_stops_at_the_loop(t *testing.T) {
+	// Mock.
+	hops := 0
+	secureServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hops++
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		} else {
+			http.Redirect(w, r, "/", http.StatusFound)
+		}
+	}))
+	defer secureServer.Close()
+
+	// Setup.
+	resolver := NewHTTPResolver()
+	resolution := &HTTPResolution{ResolutionBase: &ResolutionBase{query: "example.net"}}
+
+	// Execute.
+	resolver.resolveURLs(context.Background(), resolution, secureServer.URL, "http://127.0.0.1:0")
+
+	// Assert.
+	assert.Equal(t, []string{secureServer.URL + "/loop"}, resolution.Redirects)
+	assert.Equal(t, 2, hops)
+	assert.NoError(t, resolution.Error())
+}
+
+func Test_When_ScanBody_is_set_Then_body_domains_are_captured(t *testing.T) {
+	// Mock.
+	secureServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><script src="https://cdn.example.net/app.js"></script><a href="https://blog.example.org/">blog</a> mail us at support.example.com</html>`))
+	}))
+	defer secureServer.Close()
+
+	// Setup.
+	resolver := NewHTTPResolver()
+	resolver.ScanBody = true
+	resolution := &HTTPResolution{ResolutionBase: &ResolutionBase{query: "example.net"}}
+
+	// Execute.
+	resolver.resolveURLs(context.Background(), resolution, secureServer.URL, "http://127.0.0.1:0")
+
+	// Assert.
+	assert.ElementsMatch(t, []string{"cdn.example.net", "blog.example.org", "support.example.com"}, resolution.BodyDomains)
+	assert.Subset(t, resolution.Domains(), resolution.BodyDomains)
+}
+
+func Test_When_body_is_binary_Then_it_is_not_scanned(t *testing.T) {
+	// Mock.
+	secureServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("cdn.example.net"))
+	}))
+	defer secureServer.Close()
+
+	// Setup.
+	resolver := NewHTTPResolver()
+	resolver.ScanBody = true
+	resolution := &HTTPResolution{ResolutionBase: &ResolutionBase{query: "example.net"}}
+
+	// Execute.
+	resolver.resolveURLs(context.Background(), resolution, secureServer.URL, "http://127.0.0.1:0")
+
+	// Assert.
+	assert.Empty(t, resolution.BodyDomains)
+}
+
+func Test_When_body_exceeds_MaxBodyBytes_Then_the_rest_is_not_scanned(t *testing.T) {
+	// Mock.
+	secureServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("first.example.net " + strings.Repeat(" ", 100) + " second.example.net"))
+	}))
+	defer secureServer.Close()
+
+	// Setup.
+	resolver := NewHTTPResolver()
+	resolver.ScanBody = true
+	resolver.MaxBodyBytes = 64
+	resolution := &HTTPResolution{ResolutionBase: &ResolutionBase{query: "example.net"}}
+
+	// Execute.
+	resolver.resolveURLs(context.Background(), resolution, secureServer.URL, "http://127.0.0.1:0")
+
+	// Assert.
+	assert.Equal(t, []string{"first.example.net"}, resolution.BodyDomains)
+}
+
+func Test_When_UserAgent_and_RequestHeaders_are_set_Then_every_request_carries_them(t *testing.T) {
+	// Mock.
+	var userAgents, languages []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.UserAgent())
+		languages = append(languages, r.Header.Get("Accept-Language"))
+	})
+	secureServer := httptest.NewTLSServer(handler)
+	defer secureServer.Close()
+	insecureServer := httptest.NewServer(handler)
+	defer insecureServer.Close()
+
+	// Setup.
+	resolver := NewHTTPResolver()
+	resolver.UserAgent = "Mozilla/5.0 (compatible; scanner)"
+	resolver.RequestHeaders = http.Header{"Accept-Language": {"cs"}}
+	resolution := &HTTPResolution{ResolutionBase: &ResolutionBase{query: "example.net"}}
+
+	// Execute.
+	resolver.resolveURLs(context.Background(), resolution, secureServer.URL, insecureServer.URL)
+
+	// Assert.
+	// HTTPS page and HTTP page.
+	assert.Equal(t, []string{"Mozilla/5.0 (compatible; scanner)", "Mozilla/5.0 (compatible; scanner)"}, userAgents)
+	assert.Equal(t, []string{"cs", "cs"}, languages)
+}
+
+func Test_When_UserAgent_is_not_changed_Then_udig_identifies_itself(t *testing.T) {
+	// Mock.
+	var userAgent string
+	secureServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+	}))
+	defer secureServer.Close()
+
+	// Setup.
+	resolver := NewHTTPResolver()
+	resolution := &HTTPResolution{ResolutionBase: &ResolutionBase{query: "example.net"}}
+
+	// Execute.
+	resolver.resolveURLs(context.Background(), resolution, secureServer.URL, "http://127.0.0.1:0")
+
+	// Assert.
+	assert.Equal(t, DefaultUserAgent, userAgent)
+}
+
+func Test_When_both_schemes_are_probed_Then_headers_are_tagged_by_scheme(t *testing.T) {
+	// Mock.
+	secureServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "https://api.example.net")
+	}))
+	defer secureServer.Close()
+	insecureServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "http://legacy.example.net")
+	}))
+	defer insecureServer.Close()
+
+	// Setup.
+	resolver := NewHTTPResolver()
+	resolution := &HTTPResolution{ResolutionBase: &ResolutionBase{query: "example.net"}}
+
+	// Execute.
+	resolver.resolveURLs(context.Background(), resolution, secureServer.URL, insecureServer.URL)
+
+	// Assert.
+	assert.Equal(t, []HTTPHeader{
+		{Name: "access-control-allow-origin", Value: []string{"https://api.example.net"}, Scheme: "https"},
+		{Name: "access-control-allow-origin", Value: []string{"http://legacy.example.net"}, Scheme: "http"},
+	}, resolution.Headers)
+	assert.NoError(t, resolution.Error())
+}
+
+func Test_When_only_HTTP_is_served_Then_its_headers_are_captured(t *testing.T) {
+	// Mock.
+	insecureServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "http://legacy.example.net")
+	}))
+	defer insecureServer.Close()
+
+	// Setup.
+	resolver := NewHTTPResolver()
+	resolution := &HTTPResolution{ResolutionBase: &ResolutionBase{query: "example.net"}}
+
+	// Execute.
+	resolver.resolveURLs(context.Background(), resolution, "https://127.0.0.1:0", insecureServer.URL)
+
+	// Assert.
+	assert.Len(t, resolution.Headers, 1)
+	assert.Equal(t, "http", resolution.Headers[0].Scheme)
+	assert.NoError(t, resolution.Error())
+	assert.True(t, resolution.Downgrade)
+}
+
+func Test_When_WithDialContext_is_used_Then_HTTP_fetches_go_through_the_dialer(t *testing.T) {
+	// Mock.
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "https://api.example.com")
+	}))
+	defer server.Close()
+
+	// A "tunnel" delivering all connections to the server.
+	var dialedMutex sync.Mutex
+	var dialed []string
+	tunnel := func(ctx context.Context, network string, address string) (net.Conn, error) {
+		dialedMutex.Lock()
+		dialed = append(dialed, address)
+		dialedMutex.Unlock()
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+
+	// Setup.
+	resolver := NewHTTPResolver()
+	resolver.Schemes = []string{"https"}
+	udig := newUdigImpl()
+	udig.AddDomainResolver(resolver)
+	WithDialContext(tunnel)(udig)
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "intranet.example.com").(*HTTPResolution)
+
+	// Assert.
+	assert.NoError(t, resolution.Error())
+	assert.Contains(t, dialed, "intranet.example.com:443")
+	assert.Contains(t, resolution.Domains(), "api.example.com")
+}
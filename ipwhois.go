@@ -0,0 +1,202 @@
+package udig
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/domainr/whois"
+)
+
+const (
+	// IPWhoisBootstrapServer is a WHOIS server which refers IPs to the responsible RIR.
+	IPWhoisBootstrapServer = "whois.iana.org"
+
+	// MaxIPWhoisReferralHops caps the number of referrals followed per IP (IANA -> RIR -> RIR).
+	MaxIPWhoisReferralHops = 3
+)
+
+var (
+	// For parsing abuse contacts in RIPE-like comments, e.g. "% Abuse contact for '...' is 'abuse@example.com'"
+	abuseCommentPattern = regexp.MustCompile(`(?i)abuse contact for .* is '([^']+)'`)
+)
+
+// parseIPWhoisResponse parses a given RIR WHOIS response to IPWhoisRecord (first values win)
+// and returns a host name of a WHOIS server it refers to (or "").
+func parseIPWhoisResponse(response []byte) (record IPWhoisRecord, referral string) {
+	scanner := bufio.NewScanner(bytes.NewReader(response))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		} else if line[0] == '%' || line[0] == '#' {
+			// Comments usually carry the abuse contact only.
+			if groups := abuseCommentPattern.FindStringSubmatch(line); groups != nil {
+				setOnceString(&record.Abuse, groups[1])
+			}
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+		if value == "" {
+			continue
+		}
+
+		switch key {
+		case "inetnum", "inet6num", "netrange":
+			setOnceString(&record.Range, value)
+			break
+		case "cidr":
+			setOnceString(&record.CIDR, value)
+			break
+		case "netname":
+			setOnceString(&record.NetName, value)
+			break
+		case "org-name", "orgname", "organisation", "owner", "descr":
+			setOnceString(&record.Organization, value)
+			break
+		case "abuse-mailbox", "orgabuseemail":
+			setOnceString(&record.Abuse, value)
+			break
+		case "country":
+			setOnceString(&record.Country, value)
+			break
+		case "refer", "referralserver", "whois":
+			setOnceString(&referral, whoisHostOf(value))
+			break
+		}
+	}
+
+	return record, referral
+}
+
+// whoisHostOf strips a scheme and port from a given WHOIS server reference,
+// e.g. "whois://whois.ripe.net:43" -> "whois.ripe.net".
+func whoisHostOf(server string) string {
+	server = strings.ToLower(server)
+	if i := strings.Index(server, "://"); i >= 0 {
+		server = server[i+3:]
+	}
+	server = strings.TrimSuffix(server, "/")
+	if host, _, err := net.SplitHostPort(server); err == nil {
+		return host
+	}
+	return server
+}
+
+func setOnceString(target *string, value string) {
+	if *target == "" {
+		*target = value
+	}
+}
+
+/////////////////////////////////////////
+// IP WHOIS RESOLVER
+/////////////////////////////////////////
+
+// NewIPWhoisResolver creates a new IPWhoisResolver with sensible defaults.
+func NewIPWhoisResolver() *IPWhoisResolver {
+	return &IPWhoisResolver{
+		Client:        whois.NewClient(DefaultTimeout),
+		cachedResults: map[string]*IPWhoisResolution{},
+	}
+}
+
+// ResolveIP resolves a given IP address to a netblock record of the responsible RIR.
+func (resolver *IPWhoisResolver) ResolveIP(ip string) Resolution {
+	resolver.cacheMutex.RLock()
+	resolution := resolver.cachedResults[ip]
+	resolver.cacheMutex.RUnlock()
+	if resolution != nil {
+		return resolution
+	}
+	resolution = &IPWhoisResolution{ResolutionBase: &ResolutionBase{query: ip}}
+	defer resolver.cacheResult(ip, resolution)
+
+	if net.ParseIP(ip) == nil {
+		LogErr("%s: IP %s is invalid.", TypeIPWHOIS, ip)
+		resolution.addError(fmt.Errorf("invalid IP %s", ip))
+		return resolution
+	}
+
+	// Start at IANA and follow the referrals until some RIR knows the netblock.
+	server := IPWhoisBootstrapServer
+	visited := map[string]bool{}
+	for hop := 0; hop <= MaxIPWhoisReferralHops && server != "" && !visited[server]; hop++ {
+		visited[server] = true
+
+		request := &whois.Request{Query: ip, Host: server}
+		if err := request.Prepare(); err != nil {
+			LogErr("%s: %s -> %s", TypeIPWHOIS, ip, err.Error())
+			resolution.addError(err)
+			break
+		}
+
+		response, err := resolver.Client.Fetch(request)
+		if err != nil {
+			LogErr("%s: %s -> %s", TypeIPWHOIS, ip, err.Error())
+			resolution.addError(err)
+			break
+		}
+
+		record, referral := parseIPWhoisResponse(response.Body)
+		if !record.IsEmpty() && server != IPWhoisBootstrapServer {
+			record.Registry = server
+			resolution.Record = &record
+		}
+		server = referral
+	}
+
+	return resolution
+}
+
+func (resolver *IPWhoisResolver) cacheResult(ip string, resolution *IPWhoisResolution) {
+	resolver.cacheMutex.Lock()
+	resolver.cachedResults[ip] = resolution
+	resolver.cacheMutex.Unlock()
+}
+
+// Type returns "IPWHOIS".
+func (resolver *IPWhoisResolver) Type() ResolutionType {
+	return TypeIPWHOIS
+}
+
+/////////////////////////////////////////
+// IP WHOIS RESOLUTION
+/////////////////////////////////////////
+
+// Type returns "IPWHOIS".
+func (res *IPWhoisResolution) Type() ResolutionType {
+	return TypeIPWHOIS
+}
+
+// Raw returns the netblock record as *IPWhoisRecord (possibly nil).
+func (res *IPWhoisResolution) Raw() interface{} {
+	return res.Record
+}
+
+/////////////////////////////////////////
+// IP WHOIS RECORD
+/////////////////////////////////////////
+
+// IsEmpty returns true if no netblock information has been parsed.
+func (record *IPWhoisRecord) IsEmpty() bool {
+	return record.Range == "" && record.CIDR == "" && record.NetName == ""
+}
+
+func (record *IPWhoisRecord) String() string {
+	return fmt.Sprintf(
+		"range: %s, netname: %s, org: %s, abuse: %s, country: %s, registry: %s",
+		record.Range, record.NetName, record.Organization, record.Abuse, record.Country, record.Registry,
+	)
+}
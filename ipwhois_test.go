@@ -0,0 +1,41 @@
+package udig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_When_RIR_refers_to_another_RIR_Then_referral_is_followed(t *testing.T) {
+	// Mock.
+	var dialed []string
+	responses := map[string]string{
+		"whois.iana.org": "% IANA WHOIS server\n\nrefer:        whois.arin.net\n\ninetnum:      193.0.0.0 - 193.255.255.255\norganisation: RIPE NCC\n",
+		"whois.arin.net": "NetRange:       193.0.0.0 - 193.255.255.255\nNetName:        RIPE-C3\nNetType:        Transferred to RIPE NCC\nReferralServer: whois://whois.ripe.net\n",
+		"whois.ripe.net": "% Abuse contact for '193.0.0.0 - 193.0.7.255' is 'abuse@ripe.net'\n\ninetnum:        193.0.0.0 - 193.0.7.255\nnetname:        RIPE-NCC\ndescr:          RIPE Network Coordination Centre\ncountry:        NL\n",
+	}
+
+	// Setup.
+	resolver := NewIPWhoisResolver()
+	resolver.Client.DialContext = mockWhoisDialer(responses, &dialed)
+
+	// Execute.
+	resolution := resolver.ResolveIP("193.0.6.139").(*IPWhoisResolution)
+
+	// Assert.
+	assert.Equal(t, []string{"whois.iana.org", "whois.arin.net", "whois.ripe.net"}, dialed)
+	assert.Equal(t, &IPWhoisRecord{
+		Range:        "193.0.0.0 - 193.0.7.255",
+		NetName:      "RIPE-NCC",
+		Organization: "RIPE Network Coordination Centre",
+		Abuse:        "abuse@ripe.net",
+		Country:      "NL",
+		Registry:     "whois.ripe.net",
+	}, resolution.Record)
+}
+
+func Test_whoisHostOf_By_referral_forms(t *testing.T) {
+	assert.Equal(t, "whois.ripe.net", whoisHostOf("whois://whois.ripe.net"))
+	assert.Equal(t, "rwhois.example.net", whoisHostOf("rwhois://rwhois.example.net:4321/"))
+	assert.Equal(t, "whois.apnic.net", whoisHostOf("whois.apnic.net"))
+}
@@ -0,0 +1,158 @@
+package udig
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/domainr/whois"
+	"github.com/miekg/dns"
+)
+
+// limiter caps the number of outbound network operations in flight,
+// it is shared among all resolvers of a Udig instance (see WithMaxConcurrency).
+// A nil limiter imposes no limit.
+type limiter chan struct{}
+
+func newLimiter(n int) limiter {
+	if n <= 0 {
+		return nil
+	}
+	return make(limiter, n)
+}
+
+// acquire blocks until there is a free slot or the context is done,
+// returns false in the latter case.
+func (l limiter) acquire(ctx context.Context) bool {
+	if l == nil {
+		return ctx.Err() == nil
+	}
+	return acquire(ctx, l)
+}
+
+func (l limiter) release() {
+	if l != nil {
+		<-l
+	}
+}
+
+// query runs a DNS query through the limiter.
+func (l limiter) query(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+	if !l.acquire(ctx) {
+		return nil, ctx.Err()
+	}
+	defer l.release()
+	return queryOneCallback(ctx, domain, qType, nameServer, client)
+}
+
+// httpClient returns a copy of a given client, whose requests go through the limiter.
+func (l limiter) httpClient(client *http.Client) *http.Client {
+	if l == nil || client == nil {
+		return client
+	}
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	limited := *client
+	limited.Transport = &limitedTransport{limiter: l, next: next}
+	return &limited
+}
+
+// whoisClient returns a copy of a given client, whose connections go through the limiter.
+func (l limiter) whoisClient(client *whois.Client) *whois.Client {
+	if l == nil || client == nil {
+		return client
+	}
+	dial := client.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	limited := *client
+	limited.DialContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		if !l.acquire(ctx) {
+			return nil, ctx.Err()
+		}
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			l.release()
+			return nil, err
+		}
+		return &limitedConn{Conn: conn, limiter: l}, nil
+	}
+	return &limited
+}
+
+// limitedTransport holds a limiter slot for the duration of every round trip.
+type limitedTransport struct {
+	limiter limiter
+	next    http.RoundTripper
+}
+
+func (transport *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !transport.limiter.acquire(req.Context()) {
+		return nil, req.Context().Err()
+	}
+	defer transport.limiter.release()
+	return transport.next.RoundTrip(req)
+}
+
+// limitedConn holds a limiter slot until the connection is closed.
+type limitedConn struct {
+	net.Conn
+	limiter limiter
+	once    sync.Once
+}
+
+func (conn *limitedConn) Close() error {
+	conn.once.Do(conn.limiter.release)
+	return conn.Conn.Close()
+}
+
+// rateLimiter spaces out resolver dispatches evenly to a given number per second,
+// it is shared among all crawls of a Udig instance (see WithRateLimit).
+// A nil rateLimiter imposes no limit.
+type rateLimiter struct {
+	interval time.Duration
+	next     time.Time
+	mutex    sync.Mutex
+}
+
+func newRateLimiter(perSecond int) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Second / time.Duration(perSecond)}
+}
+
+// wait blocks until the next dispatch is due or the context is done,
+// returns false in the latter case.
+func (l *rateLimiter) wait(ctx context.Context) bool {
+	if l == nil {
+		return ctx.Err() == nil
+	}
+
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mutex.Unlock()
+
+	if delay <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -20,32 +20,79 @@ const (
 	noColor    = "\033[0m"
 )
 
+// Color modes of the log output.
+const (
+	LogColorAuto    = iota // Colorize terminals only, unless NO_COLOR is set (see https://no-color.org).
+	LogColorForce          // Always colorize.
+	LogColorDisable        // Never colorize.
+)
+
 // LogLevel contains the actual log level setting.
 var LogLevel = LogLevelDebug
 
-// LogPanic formats and prints a given log on STDERR and panics.
+// LogColor contains the actual color mode setting.
+var LogColor = LogColorAuto
+
+// LogFile is where info and debug logs are printed (STDOUT if nil), errors always go to STDERR.
+var LogFile *os.File
+
+// LogPanic formats and prints a given log on STDERR and panics with the log message.
 func LogPanic(format string, a ...interface{}) {
-	LogErr(format, a)
-	panic(nil)
+	LogErr(format, a...)
+	panic(fmt.Sprintf(format, a...))
 }
 
 // LogErr formats and prints a given log on STDERR.
 func LogErr(format string, a ...interface{}) {
 	if LogLevel <= LogLevelErr {
-		fmt.Fprintf(os.Stderr, errColor+"[!] "+format+"\n"+noColor, a...)
+		fmt.Fprintf(os.Stderr, colorize(os.Stderr, errColor, "[!] "+format+"\n"), a...)
 	}
 }
 
-// LogInfo formats and prints a given log on STDOUT.
+// LogInfo formats and prints a given log on LogFile.
 func LogInfo(format string, a ...interface{}) {
 	if LogLevel <= LogLevelInfo {
-		fmt.Printf(infoColor+"[+] "+format+"\n"+noColor, a...)
+		file := logFile()
+		fmt.Fprintf(file, colorize(file, infoColor, "[+] "+format+"\n"), a...)
 	}
 }
 
-// LogDebug formats and prints a given log on STDOUT.
+// LogDebug formats and prints a given log on LogFile.
 func LogDebug(format string, a ...interface{}) {
 	if LogLevel <= LogLevelDebug {
-		fmt.Printf(debugColor+"[~] "+format+"\n"+noColor, a...)
+		file := logFile()
+		fmt.Fprintf(file, colorize(file, debugColor, "[~] "+format+"\n"), a...)
+	}
+}
+
+// logFile returns LogFile, or STDOUT if unset.
+func logFile() *os.File {
+	if LogFile == nil {
+		return os.Stdout
+	}
+	return LogFile
+}
+
+// colorize wraps a given log line in a given color, if the output to a given file is to be colorized.
+func colorize(file *os.File, color string, line string) string {
+	if !isColorEnabled(file) {
+		return line
+	}
+	return color + line + noColor
+}
+
+// isColorEnabled tells if the output to a given file is to be colorized (see LogColor).
+func isColorEnabled(file *os.File) bool {
+	switch LogColor {
+	case LogColorForce:
+		return true
+	case LogColorDisable:
+		return false
+	}
+
+	if os.Getenv("NO_COLOR") != "" {
+		return false
 	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
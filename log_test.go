@@ -0,0 +1,59 @@
+package udig
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureStderr returns everything written to STDERR by a given function.
+func captureStderr(t *testing.T, write func()) string {
+	reader, writer, err := os.Pipe()
+	assert.NoError(t, err)
+	stderr := os.Stderr
+	os.Stderr = writer
+	defer func() { os.Stderr = stderr }()
+
+	write()
+	writer.Close()
+	output, _ := ioutil.ReadAll(reader)
+	return string(output)
+}
+
+func Test_When_LogPanic_is_called_Then_message_is_formatted_and_panicked_with(t *testing.T) {
+	// Execute.
+	output := captureStderr(t, func() {
+		assert.PanicsWithValue(t, "Cannot read /etc/resolv.conf: 2 errors", func() {
+			LogPanic("Cannot read %s: %d errors", "/etc/resolv.conf", 2)
+		})
+	})
+
+	// Assert.
+	assert.Equal(t, "[!] Cannot read /etc/resolv.conf: 2 errors\n", output)
+}
+
+func Test_When_LogColorForce_is_set_Then_piped_log_is_colorized(t *testing.T) {
+	// Setup.
+	LogColor = LogColorForce
+	defer func() { LogColor = LogColorAuto }()
+
+	// Execute.
+	output := captureStderr(t, func() { LogErr("Something %s.", "failed") })
+
+	// Assert.
+	assert.Equal(t, errColor+"[!] Something failed.\n"+noColor, output)
+}
+
+func Test_When_NO_COLOR_is_set_Then_log_is_not_colorized(t *testing.T) {
+	// Setup.
+	os.Setenv("NO_COLOR", "1")
+	defer os.Unsetenv("NO_COLOR")
+
+	// Execute.
+	enabled := isColorEnabled(os.Stdout)
+
+	// Assert.
+	assert.False(t, enabled)
+}
@@ -0,0 +1,525 @@
+package udig
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// WithDomainResolvers replaces the default domain resolvers with given ones (none disables
+// domain resolution altogether). Options configuring resolvers only affect those present
+// when they are applied, so pass this one first.
+func WithDomainResolvers(resolvers ...DomainResolver) Option {
+	return func(udig *udigImpl) {
+		udig.domainResolvers = append([]DomainResolver{}, resolvers...)
+	}
+}
+
+// WithIPResolvers replaces the default IP resolvers with given ones (none disables
+// IP resolution altogether). Like WithDomainResolvers, pass it before other options.
+func WithIPResolvers(resolvers ...IPResolver) Option {
+	return func(udig *udigImpl) {
+		udig.ipResolvers = append([]IPResolver{}, resolvers...)
+	}
+}
+
+// WithWildcardDetection makes all DNS resolvers probe for wildcard records, so that
+// subdomains resolved by a wildcard are not crawled any further. Note that this costs
+// extra DNS queries.
+func WithWildcardDetection() Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			if dnsResolver, ok := resolver.(*DNSResolver); ok {
+				dnsResolver.DetectWildcards = true
+			}
+		}
+	}
+}
+
+// WithDNSCookies makes all DNS (and SPF, brute-force) resolvers send DNS cookies (RFC 7873) along
+// with their queries, so that their answers are harder to spoof. Name servers not supporting cookies
+// are queried as usual, see DNSResolution.ServerCookie for those which do.
+func WithDNSCookies() Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			switch r := resolver.(type) {
+			case *DNSResolver:
+				r.Cookies = true
+				break
+			case *SPFResolver:
+				r.Cookies = true
+				break
+			case *BruteForceResolver:
+				r.Cookies = true
+				break
+			}
+		}
+	}
+}
+
+// WithObfuscatedQueries makes all DNS (and SPF, brute-force) resolvers pad their queries (RFC 7830)
+// to a multiple of a given block size (e.g. DefaultDNSPaddingBlockSize), so that on-path observers
+// see uniform message sizes. This is only worth it over an encrypted transport (e.g. DoT via WithDialContext).
+func WithObfuscatedQueries(blockSize int) Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			switch r := resolver.(type) {
+			case *DNSResolver:
+				r.PaddingBlockSize = blockSize
+				break
+			case *SPFResolver:
+				r.PaddingBlockSize = blockSize
+				break
+			case *BruteForceResolver:
+				r.PaddingBlockSize = blockSize
+				break
+			}
+		}
+	}
+}
+
+// WithSPFOnlyTXTIPs makes all DNS resolvers take IPs of TXT records from SPF policies only,
+// so that addresses in other TXT records (e.g. examples in DKIM keys) are not resolved.
+func WithSPFOnlyTXTIPs() Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			if dnsResolver, ok := resolver.(*DNSResolver); ok {
+				dnsResolver.SPFOnlyTXTIPs = true
+			}
+		}
+	}
+}
+
+// WithZoneTransfer makes all DNS resolvers attempt zone transfers (AXFR) from the authoritative
+// name servers of each domain. Most of them refuse, but each attempt costs a TCP connection.
+func WithZoneTransfer() Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			if dnsResolver, ok := resolver.(*DNSResolver); ok {
+				dnsResolver.ZoneTransfer = true
+			}
+		}
+	}
+}
+
+// WithDNSPreset makes all DNS resolvers query a named list of RR types (see DNSQueryPresets),
+// e.g. "mail" or "security". An unknown preset is reported and ignored.
+func WithDNSPreset(preset string) Option {
+	return func(udig *udigImpl) {
+		queryTypes, ok := DNSQueryPresets[preset]
+		if !ok {
+			LogErr("%s: Unknown query preset %s -> ignoring.", TypeDNS, preset)
+			return
+		}
+		for _, resolver := range udig.domainResolvers {
+			if dnsResolver, ok := resolver.(*DNSResolver); ok {
+				dnsResolver.QueryTypes = append([]uint16{}, queryTypes...)
+			}
+		}
+	}
+}
+
+// WithNameServer makes all DNS (and SPF, PTR, brute-force) resolvers use a given name server
+// (host:port) instead of discovering one for each domain.
+func WithNameServer(nameServer string) Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			switch r := resolver.(type) {
+			case *DNSResolver:
+				r.NameServer = nameServer
+				break
+			case *SPFResolver:
+				r.NameServer = nameServer
+				break
+			case *BruteForceResolver:
+				r.NameServer = nameServer
+				break
+			}
+		}
+		for _, resolver := range udig.ipResolvers {
+			if r, ok := resolver.(*PTRResolver); ok {
+				r.NameServer = nameServer
+			}
+		}
+	}
+}
+
+// WithExpectedIssuers makes all TLS and CT resolvers flag certificates which have not
+// been issued by any of given issuers (matched as case-insensitive substrings of the issuer DN).
+func WithExpectedIssuers(issuers ...string) Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			switch r := resolver.(type) {
+			case *TLSResolver:
+				r.ExpectedIssuers = issuers
+				break
+			case *CTResolver:
+				r.ExpectedIssuers = issuers
+				break
+			}
+		}
+	}
+}
+
+// WithoutRedirects makes all HTTP resolvers inspect only the first-hop response
+// and capture its redirect target instead of following it.
+func WithoutRedirects() Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			if r, ok := resolver.(*HTTPResolver); ok {
+				r.FollowRedirects = false
+			}
+		}
+	}
+}
+
+// WithBodyScan makes all HTTP resolvers dissect domains from (textual) response bodies
+// too, reading at most a given number of bytes per response (0 keeps the default).
+func WithBodyScan(maxBytes int64) Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			if r, ok := resolver.(*HTTPResolver); ok {
+				r.ScanBody = true
+				if maxBytes > 0 {
+					r.MaxBodyBytes = maxBytes
+				}
+			}
+		}
+	}
+}
+
+// WithOnlyRelatedOutput removes items referring only to domains unrelated to the seed
+// from the output (e.g. a CSP header pointing to a 3rd party). Crawling is not affected.
+func WithOnlyRelatedOutput() Option {
+	return func(udig *udigImpl) {
+		udig.onlyRelatedOutput = true
+	}
+}
+
+// WithDomainRewriter makes the crawler pass each discovered domain through a given function
+// (e.g. to map internal names to canonical ones) before deciding whether to crawl it.
+// The rewritten domain is what gets checked for relation and resolved, an empty one is dropped.
+// Resolutions themselves are left intact.
+func WithDomainRewriter(rewrite func(domain string) string) Option {
+	return func(udig *udigImpl) {
+		udig.rewriteDomain = rewrite
+	}
+}
+
+// WithTXTReferences makes the crawler follow domains delegated to by TXT records (i.e. SPF includes
+// and redirects) even if they are not related to the domain. The scope does not grow any further
+// from there: only CNAMEs and TXT references of such domains are followed.
+func WithTXTReferences() Option {
+	return func(udig *udigImpl) {
+		udig.followTXTRefs = true
+	}
+}
+
+// WithSharedBatchState makes all crawls of a batch (see ResolveBatch and ResolveIPBatch) skip
+// domains and IPs already resolved by any other crawl of the batch, so that infrastructure shared
+// by the seeds is resolved only once. Its resolutions are then part of the first seed's results only.
+// Relation to each seed is still decided separately.
+func WithSharedBatchState() Option {
+	return func(udig *udigImpl) {
+		udig.sharedProcessed = true
+	}
+}
+
+// WithResolutionHandler makes the crawler pass each resolution to a given handler as soon as
+// it is available, i.e. while the crawl is still running. Within a crawl the handler is called
+// one resolution at a time, but batch resolutions (see ResolveBatch) may call it concurrently.
+func WithResolutionHandler(handler func(res Resolution)) Option {
+	return func(udig *udigImpl) {
+		udig.onResolution = handler
+	}
+}
+
+// WithZoneWalk makes all DNS resolvers enumerate up to a given number of names
+// by walking NSEC chains of the resolved zones.
+func WithZoneWalk(limit int) Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			if dnsResolver, ok := resolver.(*DNSResolver); ok {
+				dnsResolver.ZoneWalkLimit = limit
+			}
+		}
+	}
+}
+
+// WithMaxRecordsPerQuery caps the number of records kept per DNS answer,
+// as well as the number of CT logs and WHOIS contacts per query.
+func WithMaxRecordsPerQuery(n int) Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			switch r := resolver.(type) {
+			case *DNSResolver:
+				r.MaxRecordsPerQuery = n
+				break
+			case *CTResolver:
+				r.MaxLogs = n
+				break
+			case *WhoisResolver:
+				r.MaxContacts = n
+				break
+			}
+		}
+	}
+}
+
+// WithMaxQueries caps the number of unique queries (domains and IPs) resolved per crawl to n.
+// Once the budget is exhausted, the crawl stops and returns the resolutions gathered so far.
+func WithMaxQueries(n int) Option {
+	return func(udig *udigImpl) {
+		udig.maxQueries = n
+	}
+}
+
+// WithIncludePrivateIPs makes the crawler resolve private, loopback and link-local IPs too
+// (e.g. for internal recon). By default such IPs are skipped as noise of external recon,
+// both when discovered and when given to ResolveIPBatch.
+func WithIncludePrivateIPs(include bool) Option {
+	return func(udig *udigImpl) {
+		udig.includePrivateIPs = include
+	}
+}
+
+// WithRateLimit caps the rate of resolver dispatches (i.e. one resolver on one domain or IP)
+// across the whole crawl to a given number per second. Unlike WithMaxConcurrency, this spaces
+// out bursts of new work rather than bounding the work in flight. Batch crawls share the limit.
+func WithRateLimit(perSecond int) Option {
+	return func(udig *udigImpl) {
+		udig.rateLimiter = newRateLimiter(perSecond)
+		for _, resolver := range udig.domainResolvers {
+			if r, ok := resolver.(*BruteForceResolver); ok {
+				// Each guess is a dispatch of its own.
+				r.rateLimiter = udig.rateLimiter
+			}
+		}
+	}
+}
+
+// WithMaxConcurrency caps the total number of outbound network operations in flight
+// across all resolvers (DNS queries, HTTP requests and WHOIS connections) to n.
+func WithMaxConcurrency(n int) Option {
+	return func(udig *udigImpl) {
+		limiter := newLimiter(n)
+		for _, resolver := range udig.domainResolvers {
+			switch r := resolver.(type) {
+			case *DNSResolver:
+				r.limiter = limiter
+				break
+			case *WhoisResolver:
+				r.Client = limiter.whoisClient(r.Client)
+				r.HTTPClient = limiter.httpClient(r.HTTPClient)
+				break
+			case *TLSResolver:
+				r.limiter = limiter
+				break
+			case *HTTPResolver:
+				r.Client = limiter.httpClient(r.Client)
+				break
+			case *CTResolver:
+				r.Client = limiter.httpClient(r.Client)
+				break
+			case *SPFResolver:
+				r.limiter = limiter
+				break
+			case *BruteForceResolver:
+				r.limiter = limiter
+				break
+			}
+		}
+		for _, resolver := range udig.ipResolvers {
+			switch r := resolver.(type) {
+			case *BGPResolver:
+				r.limiter = limiter
+				r.WhoisClient = limiter.whoisClient(r.WhoisClient)
+				break
+			case *IPWhoisResolver:
+				r.Client = limiter.whoisClient(r.Client)
+				break
+			case *PTRResolver:
+				r.limiter = limiter
+				break
+			}
+		}
+	}
+}
+
+// WithClientCertificate makes all TLS, HTTP and CT resolvers present a given certificate
+// to servers requiring client authentication (mutual TLS).
+func WithClientCertificate(cert tls.Certificate) Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			switch r := resolver.(type) {
+			case *TLSResolver:
+				r.ClientCertificates = append(r.ClientCertificates, cert)
+				break
+			case *HTTPResolver:
+				r.Client = withClientCertificate(r.Client, cert)
+				break
+			case *CTResolver:
+				r.Client = withClientCertificate(r.Client, cert)
+				break
+			}
+		}
+	}
+}
+
+// WithCTBackends makes all CT resolvers query given backends (in this order,
+// until one of them answers) instead of crt.sh only.
+func WithCTBackends(backends ...CTBackend) Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			if r, ok := resolver.(*CTResolver); ok {
+				r.Backends = backends
+			}
+		}
+	}
+}
+
+// WithBruteForce adds a brute-force resolver guessing subdomains of each domain from a given
+// wordlist of prefixes (see BruteForceResolver). Like WithDomainResolvers, pass it before
+// other options (e.g. WithMaxConcurrency or WithRateLimit), so that they apply to it too.
+func WithBruteForce(wordlist ...string) Option {
+	return func(udig *udigImpl) {
+		udig.AddDomainResolver(NewBruteForceResolver(wordlist...))
+	}
+}
+
+// WithPassiveDNS adds a passive DNS resolver querying a given source, so that subdomains
+// observed by the source in the past are crawled too (within the usual domain relation).
+func WithPassiveDNS(source PassiveDNSSource) Option {
+	return func(udig *udigImpl) {
+		udig.AddDomainResolver(NewPassiveDNSResolver(source))
+	}
+}
+
+// WithCTCertificates makes all CT resolvers download and parse the certificate of each log
+// (at most maxCerts per query, 0 means no limit), so that its SANs can be crawled too.
+func WithCTCertificates(maxCerts int) Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			if r, ok := resolver.(*CTResolver); ok {
+				r.FetchCerts = true
+				r.MaxCerts = maxCerts
+			}
+		}
+	}
+}
+
+// WithCTValidity makes all CT resolvers keep only logs of currently valid certificates
+// (if onlyCurrentlyValid is set) and of certificates expiring before notAfterBefore (unless zero).
+func WithCTValidity(onlyCurrentlyValid bool, notAfterBefore time.Time) Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			if r, ok := resolver.(*CTResolver); ok {
+				r.OnlyCurrentlyValid = onlyCurrentlyValid
+				r.NotAfterBefore = notAfterBefore
+			}
+		}
+	}
+}
+
+// WithASNDatabase makes all BGP resolvers fall back to a MaxMind ASN DB at a given path
+// if the DNS lookup fails.
+func WithASNDatabase(path string) Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.ipResolvers {
+			if r, ok := resolver.(*BGPResolver); ok {
+				r.ASNDBPath = path
+			}
+		}
+	}
+}
+
+// WithGeoDatabase makes all GeoIP resolvers use a DB file at a given path,
+// either MaxMind (*.mmdb) or IP2Location.
+func WithGeoDatabase(path string) Option {
+	return WithGeoBackend(NewGeoBackend(path))
+}
+
+// WithGeoBackend makes all GeoIP resolvers query a given backend.
+func WithGeoBackend(backend GeoBackend) Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.ipResolvers {
+			if r, ok := resolver.(*GeoResolver); ok {
+				r.SetBackend(backend)
+			}
+		}
+	}
+}
+
+// WithDKIMSelectors overrides DKIM selectors probed by all DNS resolvers.
+func WithDKIMSelectors(selectors ...string) Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			if dnsResolver, ok := resolver.(*DNSResolver); ok {
+				dnsResolver.DKIMSelectors = selectors
+			}
+		}
+	}
+}
+
+// WithClock makes all DNS, TLS and CT resolvers tell the time by a given clock
+// (e.g. for reproducible cache expiry, certificate expiry and CT log windows).
+func WithClock(clock Clock) Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			switch r := resolver.(type) {
+			case *DNSResolver:
+				r.Clock = clock
+				break
+			case *TLSResolver:
+				r.Clock = clock
+				break
+			case *CTResolver:
+				r.Clock = clock
+				break
+			}
+		}
+	}
+}
+
+// WithDialContext makes all TCP based resolvers (DNS, SPF and brute force over TCP, TLS,
+// HTTP, CT, WHOIS and RDAP) open connections via a given dial function, e.g. through an SSH tunnel
+// to a jump host. Use it before WithMaxConcurrency to keep the WHOIS connections limited.
+func WithDialContext(dial DialContextFunc) Option {
+	return func(udig *udigImpl) {
+		for _, resolver := range udig.domainResolvers {
+			switch r := resolver.(type) {
+			case *DNSResolver:
+				r.DialContext = dial
+				break
+			case *SPFResolver:
+				r.DialContext = dial
+				break
+			case *BruteForceResolver:
+				r.DialContext = dial
+				break
+			case *TLSResolver:
+				r.DialContext = dial
+				break
+			case *HTTPResolver:
+				r.Client = withDialContext(r.Client, dial)
+				break
+			case *CTResolver:
+				r.Client = withDialContext(r.Client, dial)
+				break
+			case *WhoisResolver:
+				r.Client = withWhoisDialContext(r.Client, dial)
+				r.HTTPClient = withDialContext(r.HTTPClient, dial)
+				break
+			}
+		}
+		for _, resolver := range udig.ipResolvers {
+			switch r := resolver.(type) {
+			case *BGPResolver:
+				r.WhoisClient = withWhoisDialContext(r.WhoisClient, dial)
+				break
+			case *IPWhoisResolver:
+				r.Client = withWhoisDialContext(r.Client, dial)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package udig
+
+import (
+	"context"
+	"strings"
+)
+
+/////////////////////////////////////////
+// PASSIVE DNS RESOLVER
+/////////////////////////////////////////
+
+// NewPassiveDNSResolver creates a new PassiveDNSResolver querying a given source
+// (NoopPassiveDNSSource if nil).
+func NewPassiveDNSResolver(source PassiveDNSSource) *PassiveDNSResolver {
+	if source == nil {
+		source = &NoopPassiveDNSSource{}
+	}
+	return &PassiveDNSResolver{
+		Source:        source,
+		cachedResults: map[string]*PassiveDNSResolution{},
+	}
+}
+
+// Type returns "PDNS".
+func (resolver *PassiveDNSResolver) Type() ResolutionType {
+	return TypePassiveDNS
+}
+
+// ResolveDomain resolves a given domain to a list of its subdomains observed by the passive DNS source.
+func (resolver *PassiveDNSResolver) ResolveDomain(ctx context.Context, domain string) Resolution {
+	resolution := &PassiveDNSResolution{
+		ResolutionBase: &ResolutionBase{query: domain},
+		Source:         resolver.Source.Name(),
+	}
+
+	if resolver.isCovered(domain) {
+		// Subdomains of this domain have already been fetched along with its parent.
+		return resolution
+	}
+
+	subdomains, err := resolver.Source.FetchSubdomains(ctx, domain)
+	if err != nil {
+		LogErr("%s: %s -> %s failed. The cause was: %s", TypePassiveDNS, domain, resolution.Source, err.Error())
+		resolution.addError(err)
+	}
+	resolution.Subdomains = subdomainsOf(domain, subdomains)
+
+	resolver.cacheMutex.Lock()
+	resolver.cachedResults[domain] = resolution
+	resolver.cacheMutex.Unlock()
+
+	return resolution
+}
+
+// isCovered tells if a given domain or any of its parents has already been resolved.
+func (resolver *PassiveDNSResolver) isCovered(domain string) bool {
+	resolver.cacheMutex.RLock()
+	defer resolver.cacheMutex.RUnlock()
+
+	for ; domain != ""; domain = ParentDomainOf(domain) {
+		if resolver.cachedResults[domain] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// subdomainsOf returns given names which are subdomains of a given domain, cleaned, unique and sorted.
+func subdomainsOf(domain string, names []string) (subdomains []string) {
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = CleanDomain(name)
+		if !strings.HasSuffix(name, "."+domain) || seen[name] {
+			continue
+		}
+		seen[name] = true
+		subdomains = append(subdomains, name)
+	}
+	SortDomains(subdomains)
+	return subdomains
+}
+
+/////////////////////////////////////////
+// NOOP PASSIVE DNS SOURCE
+/////////////////////////////////////////
+
+// Name returns "noop".
+func (source *NoopPassiveDNSSource) Name() string {
+	return "noop"
+}
+
+// FetchSubdomains returns no subdomains.
+func (source *NoopPassiveDNSSource) FetchSubdomains(ctx context.Context, domain string) ([]string, error) {
+	return nil, nil
+}
+
+/////////////////////////////////////////
+// PASSIVE DNS RESOLUTION
+/////////////////////////////////////////
+
+// Type returns "PDNS".
+func (res *PassiveDNSResolution) Type() ResolutionType {
+	return TypePassiveDNS
+}
+
+// Domains returns the subdomains.
+func (res *PassiveDNSResolution) Domains() []string {
+	return res.Subdomains
+}
+
+// Raw returns the subdomains as []string.
+func (res *PassiveDNSResolution) Raw() interface{} {
+	return res.Subdomains
+}
@@ -0,0 +1,84 @@
+package udig
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stubPassiveDNSSource struct {
+	PassiveDNSSource
+	subdomains map[string][]string
+	err        error
+}
+
+func (source *stubPassiveDNSSource) Name() string {
+	return "stub"
+}
+
+func (source *stubPassiveDNSSource) FetchSubdomains(ctx context.Context, domain string) ([]string, error) {
+	return source.subdomains[domain], source.err
+}
+
+func Test_When_passive_DNS_source_knows_subdomains_Then_they_are_crawled(t *testing.T) {
+	// Mock.
+	source := &stubPassiveDNSSource{subdomains: map[string][]string{
+		"example.com": {"DEV.example.com.", "old.api.example.com", "dev.example.com", "example.org"},
+	}}
+	var queried []string
+	var mutex sync.Mutex
+	resolver := &mockDomainResolver{resolve: func(domain string) Resolution {
+		mutex.Lock()
+		queried = append(queried, domain)
+		mutex.Unlock()
+		return &HTTPResolution{ResolutionBase: &ResolutionBase{query: domain}}
+	}}
+
+	// Setup.
+	dig := NewUdig(WithDomainResolvers(resolver), WithIPResolvers(), WithPassiveDNS(source))
+
+	// Execute.
+	resolutions := dig.Resolve(context.Background(), "example.com")
+
+	// Assert.
+	assert.ElementsMatch(t, []string{"example.com", "dev.example.com", "old.api.example.com"}, queried)
+	var subdomains []string
+	for _, res := range resolutions {
+		if res.Type() == TypePassiveDNS {
+			subdomains = append(subdomains, res.Domains()...)
+		}
+	}
+	assert.Equal(t, []string{"dev.example.com", "old.api.example.com"}, subdomains)
+}
+
+func Test_When_passive_DNS_source_fails_Then_error_is_recorded(t *testing.T) {
+	// Mock.
+	source := &stubPassiveDNSSource{err: errors.New("quota exceeded")}
+
+	// Setup.
+	resolver := NewPassiveDNSResolver(source)
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*PassiveDNSResolution)
+
+	// Assert.
+	assert.EqualError(t, resolution.Error(), "quota exceeded")
+	assert.Equal(t, "stub", resolution.Source)
+	assert.Empty(t, resolution.Subdomains)
+}
+
+func Test_When_passive_DNS_resolver_has_no_source_Then_it_finds_nothing(t *testing.T) {
+	// Setup.
+	resolver := NewPassiveDNSResolver(nil)
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*PassiveDNSResolution)
+
+	// Assert.
+	assert.NoError(t, resolution.Error())
+	assert.Equal(t, "noop", resolution.Source)
+	assert.Empty(t, resolution.Domains())
+}
@@ -0,0 +1,180 @@
+package udig
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+/////////////////////////////////////////
+// PTR RESOLVER
+/////////////////////////////////////////
+
+// NewPTRResolver creates a new PTRResolver with sensible defaults.
+func NewPTRResolver() *PTRResolver {
+	return &PTRResolver{
+		Client:        &dns.Client{ReadTimeout: DefaultTimeout},
+		cachedResults: map[string]*PTRResolution{},
+	}
+}
+
+// Type returns "PTR".
+func (resolver *PTRResolver) Type() ResolutionType {
+	return TypePTR
+}
+
+// ResolveIP resolves a given IP address to its PTR records and confirms each of them
+// by a forward lookup (A for IPv4, AAAA for IPv6).
+func (resolver *PTRResolver) ResolveIP(ip string) Resolution {
+	resolver.cacheMutex.RLock()
+	resolution := resolver.cachedResults[ip]
+	resolver.cacheMutex.RUnlock()
+	if resolution != nil {
+		return resolution
+	}
+	resolution = &PTRResolution{ResolutionBase: &ResolutionBase{query: ip}}
+	defer resolver.cacheResult(ip, resolution)
+
+	ipAddr := net.ParseIP(ip)
+	if ipAddr == nil {
+		LogErr("%s: IP %s is invalid.", TypePTR, ip)
+		resolution.addError(fmt.Errorf("invalid IP %s", ip))
+		return resolution
+	}
+
+	nameServer := resolver.NameServer
+	if nameServer == "" {
+		nameServer = getLocalNameServer()
+	}
+
+	names, err := resolver.lookupNames(ipAddr, nameServer)
+	if err != nil {
+		resolution.addError(err)
+		return resolution
+	}
+
+	qType := dns.TypeA
+	if ipAddr.To4() == nil {
+		qType = dns.TypeAAAA
+	}
+
+	for _, name := range names {
+		record := PTRRecord{Name: name}
+		record.ForwardIPs, err = resolver.lookupAddresses(name, qType, nameServer)
+		if err != nil {
+			resolution.addError(err)
+		}
+		for _, forwardIP := range record.ForwardIPs {
+			if net.ParseIP(forwardIP).Equal(ipAddr) {
+				record.Confirmed = true
+				break
+			}
+		}
+		resolution.Records = append(resolution.Records, record)
+	}
+	resolution.Consistency = ptrConsistencyOf(resolution.Records)
+
+	return resolution
+}
+
+// lookupNames returns PTR names of a given IP (without the trailing dot).
+func (resolver *PTRResolver) lookupNames(ipAddr net.IP, nameServer string) (names []string, err error) {
+	reverse, err := dns.ReverseAddr(ipAddr.String())
+	if err != nil {
+		return names, err
+	}
+
+	msg, err := resolver.limiter.query(context.Background(), reverse, dns.TypePTR, nameServer, resolver.Client)
+	if err != nil {
+		if err.Error() == dns.RcodeToString[dns.RcodeNameError] {
+			LogDebug("%s: No PTR record found for IP %s.", TypePTR, ipAddr)
+			return names, nil
+		}
+		LogErr("%s: PTR %s -> %s", TypePTR, reverse, err.Error())
+		return names, fmt.Errorf("PTR %s: %s", reverse, err.Error())
+	}
+
+	for _, rr := range msg.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			names = append(names, strings.TrimSuffix(ptr.Ptr, "."))
+		}
+	}
+	return names, nil
+}
+
+// lookupAddresses returns addresses of a given type a given name resolves to.
+func (resolver *PTRResolver) lookupAddresses(name string, qType uint16, nameServer string) (ips []string, err error) {
+	msg, err := resolver.limiter.query(context.Background(), name, qType, nameServer, resolver.Client)
+	if err != nil {
+		if err.Error() == dns.RcodeToString[dns.RcodeNameError] {
+			LogDebug("%s: PTR name %s does not exist.", TypePTR, name)
+			return ips, nil
+		}
+		LogErr("%s: %s %s -> %s", TypePTR, dns.TypeToString[qType], name, err.Error())
+		return ips, fmt.Errorf("%s %s: %s", dns.TypeToString[qType], name, err.Error())
+	}
+
+	for _, rr := range msg.Answer {
+		switch record := rr.(type) {
+		case *dns.A:
+			ips = append(ips, record.A.String())
+			break
+		case *dns.AAAA:
+			ips = append(ips, record.AAAA.String())
+			break
+		}
+	}
+	return ips, nil
+}
+
+func (resolver *PTRResolver) cacheResult(ip string, resolution *PTRResolution) {
+	resolver.cacheMutex.Lock()
+	resolver.cachedResults[ip] = resolution
+	resolver.cacheMutex.Unlock()
+}
+
+// ptrConsistencyOf returns a verdict for given PTR records.
+func ptrConsistencyOf(records []PTRRecord) PTRConsistency {
+	if len(records) == 0 {
+		return PTRNone
+	}
+	for _, record := range records {
+		if record.Confirmed {
+			return PTRConsistent
+		}
+	}
+	return PTRMismatch
+}
+
+/////////////////////////////////////////
+// PTR RESOLUTION
+/////////////////////////////////////////
+
+// Type returns "PTR".
+func (res *PTRResolution) Type() ResolutionType {
+	return TypePTR
+}
+
+// Domains returns the PTR names.
+func (res *PTRResolution) Domains() (domains []string) {
+	for _, record := range res.Records {
+		domains = append(domains, record.Name)
+	}
+	return domains
+}
+
+// Raw returns the PTR records as []PTRRecord.
+func (res *PTRResolution) Raw() interface{} {
+	return res.Records
+}
+
+/////////////////////////////////////////
+// PTR RECORD
+/////////////////////////////////////////
+
+func (record *PTRRecord) String() string {
+	return fmt.Sprintf("name: %s, forward: %v, confirmed: %t", record.Name, record.ForwardIPs, record.Confirmed)
+}
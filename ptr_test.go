@@ -0,0 +1,100 @@
+package udig
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockPTRData answers PTR queries from given reverse names and A queries from given forward names.
+func mockPTRData(reverse map[string]string, forward map[string]string) {
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		msg := &dns.Msg{}
+		switch qType {
+		case dns.TypePTR:
+			name, ok := reverse[domain]
+			if !ok {
+				return nil, errors.New(dns.RcodeToString[dns.RcodeNameError])
+			}
+			msg.Answer = append(msg.Answer, &dns.PTR{
+				Hdr: dns.RR_Header{Name: dns.Fqdn(domain), Rrtype: dns.TypePTR, Class: dns.ClassINET},
+				Ptr: dns.Fqdn(name),
+			})
+			break
+		case dns.TypeA:
+			ip, ok := forward[domain]
+			if !ok {
+				return nil, errors.New(dns.RcodeToString[dns.RcodeNameError])
+			}
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: dns.Fqdn(domain), Rrtype: dns.TypeA, Class: dns.ClassINET},
+				A:   net.ParseIP(ip),
+			})
+			break
+		}
+		return msg, nil
+	}
+}
+
+func Test_When_PTR_name_resolves_back_Then_IP_is_consistent(t *testing.T) {
+	// Mock.
+	mockPTRData(
+		map[string]string{"1.2.0.192.in-addr.arpa.": "host.example.com"},
+		map[string]string{"host.example.com": "192.0.2.1"},
+	)
+
+	// Setup.
+	resolver := NewPTRResolver()
+	resolver.NameServer = "127.0.0.1:53"
+
+	// Execute.
+	resolution := resolver.ResolveIP("192.0.2.1").(*PTRResolution)
+
+	// Assert.
+	assert.NoError(t, resolution.Error())
+	assert.Equal(t, []PTRRecord{{Name: "host.example.com", ForwardIPs: []string{"192.0.2.1"}, Confirmed: true}}, resolution.Records)
+	assert.Equal(t, PTRConsistent, resolution.Consistency)
+	assert.Equal(t, []string{"host.example.com"}, resolution.Domains())
+}
+
+func Test_When_PTR_name_resolves_elsewhere_Then_IP_is_mismatched(t *testing.T) {
+	// Mock.
+	mockPTRData(
+		map[string]string{"2.2.0.192.in-addr.arpa.": "other.example.com"},
+		map[string]string{"other.example.com": "198.51.100.9"},
+	)
+
+	// Setup.
+	resolver := NewPTRResolver()
+	resolver.NameServer = "127.0.0.1:53"
+
+	// Execute.
+	resolution := resolver.ResolveIP("192.0.2.2").(*PTRResolution)
+
+	// Assert.
+	assert.NoError(t, resolution.Error())
+	assert.False(t, resolution.Records[0].Confirmed)
+	assert.Equal(t, PTRMismatch, resolution.Consistency)
+}
+
+func Test_When_IP_has_no_PTR_Then_verdict_is_NoPTR(t *testing.T) {
+	// Mock.
+	mockPTRData(map[string]string{}, map[string]string{})
+
+	// Setup.
+	resolver := NewPTRResolver()
+	resolver.NameServer = "127.0.0.1:53"
+
+	// Execute.
+	resolution := resolver.ResolveIP("192.0.2.3").(*PTRResolution)
+
+	// Assert.
+	assert.NoError(t, resolution.Error())
+	assert.Empty(t, resolution.Records)
+	assert.Equal(t, PTRNone, resolution.Consistency)
+	assert.Equal(t, map[string]PTRConsistency{"192.0.2.3": PTRNone}, PTRConsistencies([]Resolution{resolution}))
+}
@@ -0,0 +1,214 @@
+package udig
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// SPFMaxLookups is a max number of DNS lookups allowed during SPF evaluation (RFC 7208).
+	SPFMaxLookups = 10
+
+	// DefaultSPFMaxDepth is a default max depth of followed includes and redirects.
+	DefaultSPFMaxDepth = 10
+)
+
+// spfLookupMechanisms are SPF terms, which cost a DNS lookup during evaluation.
+var spfLookupMechanisms = [...]string{"include", "a", "mx", "ptr", "exists", "redirect"}
+
+// parseSPF returns the number of DNS lookups required by a given SPF policy
+// and domains referenced by its include and redirect terms.
+func parseSPF(policy string) (lookups int, targets []string) {
+	terms := strings.Fields(strings.ToLower(policy))
+	if len(terms) == 0 || terms[0] != "v=spf1" {
+		// Not an SPF record.
+		return lookups, targets
+	}
+
+	for _, term := range terms[1:] {
+		// Strip the qualifier (if any).
+		term = strings.TrimLeft(term, "+-~?")
+
+		for _, mechanism := range spfLookupMechanisms {
+			if term == mechanism || strings.HasPrefix(term, mechanism+":") ||
+				strings.HasPrefix(term, mechanism+"=") || strings.HasPrefix(term, mechanism+"/") {
+				lookups++
+				break
+			}
+		}
+	}
+
+	return lookups, dissectDomainsFromSPF(policy)
+}
+
+/////////////////////////////////////////
+// SPF RESOLVER
+/////////////////////////////////////////
+
+// NewSPFResolver creates a new SPFResolver with sensible defaults.
+func NewSPFResolver() *SPFResolver {
+	return &SPFResolver{
+		MaxDepth:     DefaultSPFMaxDepth,
+		Client:       &dns.Client{ReadTimeout: DefaultTimeout},
+		clientCookie: newClientCookie(),
+	}
+}
+
+// Type returns "SPF".
+func (resolver *SPFResolver) Type() ResolutionType {
+	return TypeSPF
+}
+
+// ResolveDomain resolves the SPF policy of a given domain to an include tree
+// and counts the DNS lookups it requires.
+func (resolver *SPFResolver) ResolveDomain(ctx context.Context, domain string) Resolution {
+	ctx = withDNSDialContext(ctx, resolver.DialContext)
+	if resolver.Cookies {
+		ctx = withDNSCookie(ctx, resolver.clientCookie)
+	}
+	ctx = withDNSPadding(ctx, resolver.PaddingBlockSize)
+
+	resolution := &SPFResolution{
+		ResolutionBase: &ResolutionBase{query: domain},
+	}
+
+	nameServer := resolver.NameServer
+	if nameServer == "" {
+		nameServer = getLocalNameServer()
+	}
+
+	resolution.Record = resolver.resolveRecord(ctx, resolution, domain, nameServer, map[string]bool{})
+	if resolution.Record == nil {
+		return resolution
+	}
+
+	resolution.Lookups = resolution.Record.TotalLookups()
+	resolution.SPFTooManyLookups = resolution.Lookups > SPFMaxLookups
+	if resolution.SPFTooManyLookups {
+		LogDebug("%s: %s -> %d DNS lookups required, only %d allowed.", TypeSPF, domain, resolution.Lookups, SPFMaxLookups)
+	}
+
+	return resolution
+}
+
+// resolveRecord fetches the SPF policy of a given domain and recursively the policies
+// it refers to. Domains on the current path are not followed again (i.e. loops are cut).
+func (resolver *SPFResolver) resolveRecord(ctx context.Context, resolution *SPFResolution, domain string, nameServer string, path map[string]bool) *SPFRecord {
+	policy, err := resolver.fetchPolicy(ctx, domain, nameServer)
+	if err != nil {
+		resolution.addError(err)
+		return nil
+	}
+	if policy == "" {
+		return nil
+	}
+
+	record := &SPFRecord{Domain: domain, Policy: policy}
+	var targets []string
+	record.Lookups, targets = parseSPF(policy)
+
+	if len(path) >= resolver.MaxDepth {
+		LogDebug("%s: %s -> max depth of %d reached, not following %v.", TypeSPF, domain, resolver.MaxDepth, targets)
+		return record
+	}
+
+	path[domain] = true
+	defer delete(path, domain)
+
+	for _, target := range targets {
+		if path[target] {
+			LogDebug("%s: %s -> include loop via %s.", TypeSPF, domain, target)
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if include := resolver.resolveRecord(ctx, resolution, target, nameServer, path); include != nil {
+			record.Includes = append(record.Includes, include)
+		}
+	}
+
+	return record
+}
+
+// fetchPolicy returns the SPF policy published by a given domain or "" if there is none.
+func (resolver *SPFResolver) fetchPolicy(ctx context.Context, domain string, nameServer string) (string, error) {
+	msg, err := resolver.limiter.query(ctx, domain, dns.TypeTXT, nameServer, resolver.Client)
+	if err != nil {
+		if err.Error() == dns.RcodeToString[dns.RcodeNameError] {
+			LogDebug("%s: %s -> domain does not exist.", TypeSPF, domain)
+			return "", nil
+		}
+		LogErr("%s: TXT %s -> %s", TypeSPF, domain, err.Error())
+		return "", fmt.Errorf("TXT %s: %s", domain, err.Error())
+	}
+
+	var policies []string
+	for _, rr := range msg.Answer {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		// Long policies are split into multiple strings.
+		policy := strings.Join(txt.Txt, "")
+		if strings.HasPrefix(strings.ToLower(policy), "v=spf1") {
+			policies = append(policies, policy)
+		}
+	}
+
+	if len(policies) == 0 {
+		return "", nil
+	}
+	if len(policies) > 1 {
+		LogErr("%s: %s -> %d SPF policies published, using the first one.", TypeSPF, domain, len(policies))
+	}
+	return policies[0], nil
+}
+
+/////////////////////////////////////////
+// SPF RESOLUTION
+/////////////////////////////////////////
+
+// Type returns "SPF".
+func (res *SPFResolution) Type() ResolutionType {
+	return TypeSPF
+}
+
+// Domains returns a list of domains found in the include tree.
+func (res *SPFResolution) Domains() (domains []string) {
+	if res.Record == nil {
+		return domains
+	}
+	for _, include := range res.Record.Includes {
+		domains = append(domains, include.domains()...)
+	}
+	return domains
+}
+
+/////////////////////////////////////////
+// SPF RECORD
+/////////////////////////////////////////
+
+// TotalLookups returns the number of DNS lookups required by this policy and all the included ones.
+func (record *SPFRecord) TotalLookups() int {
+	lookups := record.Lookups
+	for _, include := range record.Includes {
+		lookups += include.TotalLookups()
+	}
+	return lookups
+}
+
+func (record *SPFRecord) domains() []string {
+	domains := []string{record.Domain}
+	for _, include := range record.Includes {
+		domains = append(domains, include.domains()...)
+	}
+	return domains
+}
+
+func (record *SPFRecord) String() string {
+	return fmt.Sprintf("domain: %s, lookups: %d (total %d), policy: %s", record.Domain, record.Lookups, record.TotalLookups(), record.Policy)
+}
@@ -0,0 +1,118 @@
+package udig
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func mockSPFPolicies(policies map[string]string) {
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		policy, ok := policies[domain]
+		if !ok {
+			return nil, errors.New(dns.RcodeToString[dns.RcodeNameError])
+		}
+		msg := &dns.Msg{}
+		msg.Answer = append(msg.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: dns.Fqdn(domain), Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+			Txt: []string{"google-site-verification=abc"},
+		}, &dns.TXT{
+			Hdr: dns.RR_Header{Name: dns.Fqdn(domain), Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+			Txt: []string{policy},
+		})
+		return msg, nil
+	}
+}
+
+func Test_When_SPF_includes_are_nested_Then_all_lookups_are_counted(t *testing.T) {
+	// Mock.
+	mockSPFPolicies(map[string]string{
+		"example.com":   "v=spf1 include:a.example.com include:b.example.com mx -all",
+		"a.example.com": "v=spf1 include:c.example.com a ~all",
+		"b.example.com": "v=spf1 redirect=c.example.com",
+		"c.example.com": "v=spf1 a mx:mail.example.com ptr exists:%{i}.spf.example.com ip4:192.0.2.0/24 -all",
+	})
+
+	// Setup.
+	resolver := NewSPFResolver()
+	resolver.NameServer = "127.0.0.1:53"
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*SPFResolution)
+
+	// Assert.
+	// example.com (3) + a (2) + c via a (4) + b (1) + c via b (4).
+	assert.Equal(t, 14, resolution.Lookups)
+	assert.True(t, resolution.SPFTooManyLookups)
+	assert.NoError(t, resolution.Error())
+
+	assert.Equal(t, "example.com", resolution.Record.Domain)
+	assert.Len(t, resolution.Record.Includes, 2)
+	assert.Equal(t, "a.example.com", resolution.Record.Includes[0].Domain)
+	assert.Equal(t, "c.example.com", resolution.Record.Includes[0].Includes[0].Domain)
+	assert.Equal(t, "b.example.com", resolution.Record.Includes[1].Domain)
+	assert.Equal(t, "c.example.com", resolution.Record.Includes[1].Includes[0].Domain)
+	assert.ElementsMatch(t, []string{"a.example.com", "b.example.com", "c.example.com", "c.example.com"}, resolution.Domains())
+}
+
+func Test_When_SPF_includes_loop_Then_recursion_stops(t *testing.T) {
+	// Mock.
+	mockSPFPolicies(map[string]string{
+		"example.com":   "v=spf1 include:a.example.com -all",
+		"a.example.com": "v=spf1 include:example.com -all",
+	})
+
+	// Setup.
+	resolver := NewSPFResolver()
+	resolver.NameServer = "127.0.0.1:53"
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*SPFResolution)
+
+	// Assert.
+	assert.Equal(t, 2, resolution.Lookups)
+	assert.False(t, resolution.SPFTooManyLookups)
+	assert.Empty(t, resolution.Record.Includes[0].Includes)
+}
+
+func Test_When_SPF_is_deeper_than_MaxDepth_Then_recursion_is_bounded(t *testing.T) {
+	// Mock.
+	mockSPFPolicies(map[string]string{
+		"example.com":   "v=spf1 include:a.example.com -all",
+		"a.example.com": "v=spf1 include:b.example.com -all",
+		"b.example.com": "v=spf1 include:c.example.com -all",
+		"c.example.com": "v=spf1 a -all",
+	})
+
+	// Setup.
+	resolver := NewSPFResolver()
+	resolver.NameServer = "127.0.0.1:53"
+	resolver.MaxDepth = 2
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*SPFResolution)
+
+	// Assert.
+	assert.Equal(t, []string{"a.example.com", "b.example.com"}, resolution.Domains())
+	assert.Equal(t, 3, resolution.Lookups)
+}
+
+func Test_When_domain_has_no_SPF_Then_resolution_is_empty(t *testing.T) {
+	// Mock.
+	mockSPFPolicies(map[string]string{})
+
+	// Setup.
+	resolver := NewSPFResolver()
+	resolver.NameServer = "127.0.0.1:53"
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "example.com").(*SPFResolution)
+
+	// Assert.
+	assert.Nil(t, resolution.Record)
+	assert.Zero(t, resolution.Lookups)
+	assert.NoError(t, resolution.Error())
+}
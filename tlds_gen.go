@@ -0,0 +1,63 @@
+//go:build ignore
+// +build ignore
+
+// This program downloads the list of TLDs by IANA (tlds-alpha-by-domain.txt) into tlds.txt
+// (see utils.go). Run it by "go generate".
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	tldsURL  = "https://data.iana.org/TLD/tlds-alpha-by-domain.txt"
+	tldsFile = "tlds.txt"
+)
+
+func main() {
+	client := &http.Client{Timeout: 30 * time.Second}
+	res, err := client.Get(tldsURL)
+	if err != nil {
+		fail("Could not download %s. The cause was: %s", tldsURL, err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		fail("Could not download %s. The status was: %s", tldsURL, res.Status)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		fail("Could not read %s. The cause was: %s", tldsURL, err.Error())
+	}
+
+	// Sanity check, so that a broken download does not wipe the list.
+	count := 0
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			count++
+		}
+	}
+	if count < 1000 {
+		fail("Refusing to write %s with only %d TLDs.", tldsFile, count)
+	}
+
+	if err := ioutil.WriteFile(tldsFile, body, 0644); err != nil {
+		fail("Could not write %s. The cause was: %s", tldsFile, err.Error())
+	}
+	fmt.Printf("Wrote %d TLDs to %s.\n", count, tldsFile)
+}
+
+func fail(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	os.Exit(1)
+}
@@ -1,37 +1,57 @@
 package udig
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"net"
-	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 /////////////////////////////////////////
 // TLS RESOLVER
 /////////////////////////////////////////
 
-// NewTLSResolver creates a new TLSResolver with sensible defaults.
-func NewTLSResolver() *TLSResolver {
-	transport := http.DefaultTransport.(*http.Transport)
-
-	transport.DialContext = (&net.Dialer{
-		Timeout:   DefaultTimeout,
-		KeepAlive: DefaultTimeout,
-		DualStack: true,
-	}).DialContext
-
-	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	transport.TLSHandshakeTimeout = DefaultTimeout
+var (
+	// DefaultTLSPorts is a list of ports probed for TLS services by default.
+	DefaultTLSPorts = [...]int{443}
 
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   DefaultTimeout,
+	// CDNSharedDomains maps domains of names shared by CDN customers to the CDN providers.
+	CDNSharedDomains = map[string]string{
+		"cloudfront.net":    "Amazon CloudFront",
+		"fastly.net":        "Fastly",
+		"fastlylb.net":      "Fastly",
+		"akamaiedge.net":    "Akamai",
+		"akamaized.net":     "Akamai",
+		"edgekey.net":       "Akamai",
+		"azureedge.net":     "Azure CDN",
+		"azurefd.net":       "Azure Front Door",
+		"cloudflaressl.com": "Cloudflare",
+		"b-cdn.net":         "Bunny CDN",
+		"cdn77.org":         "CDN77",
+		"edgecastcdn.net":   "Edgio",
+		"netlify.app":       "Netlify",
+		"vercel.app":        "Vercel",
 	}
+)
 
+// NewTLSResolver creates a new TLSResolver with sensible defaults.
+func NewTLSResolver() *TLSResolver {
 	return &TLSResolver{
-		Client: client,
+		Ports: DefaultTLSPorts[:],
+		Dialer: &net.Dialer{
+			Timeout:   DefaultTimeout,
+			KeepAlive: DefaultTimeout,
+		},
 	}
 }
 
@@ -41,32 +61,224 @@ func (resolver *TLSResolver) Type() ResolutionType {
 }
 
 // ResolveDomain resolves a given domain to a list of TLS certificates.
-func (resolver *TLSResolver) ResolveDomain(domain string) Resolution {
+func (resolver *TLSResolver) ResolveDomain(ctx context.Context, domain string) Resolution {
 	resolution := &TLSResolution{
 		ResolutionBase: &ResolutionBase{query: domain},
 	}
 
-	certificates := resolver.fetchTLSCertChain(domain)
-	for _, cert := range certificates {
-		resolution.Certificates = append(resolution.Certificates, TLSCertificate{*cert})
+	serverName := resolver.ServerName
+	if serverName == "" {
+		serverName = domain
+	}
+
+	now := clockNow(resolver.Clock)
+	chains := 0
+	validChains := 0
+	for _, port := range resolver.Ports {
+		certificates, err := resolver.fetchTLSCertChain(ctx, domain, port, serverName)
+		if err != nil {
+			resolution.addError(err)
+		}
+		if len(certificates) == 0 {
+			continue
+		}
+
+		for _, cert := range certificates {
+			resolution.Certificates = append(resolution.Certificates, TLSCertificate{
+				Certificate:     *cert,
+				Port:            port,
+				Expired:         now.After(cert.NotAfter),
+				SelfSigned:      isSelfSigned(cert),
+				DaysUntilExpiry: int(cert.NotAfter.Sub(now).Hours() / 24),
+			})
+		}
+
+		// Only the leaf matters, intermediates are issued by roots.
+		if !isExpectedIssuer(certificates[0].Issuer.String(), resolver.ExpectedIssuers) {
+			resolution.UnexpectedIssuer = true
+		}
+		if certificates[0].VerifyHostname(domain) != nil {
+			resolution.HostnameMismatch = true
+			if provider := cdnProviderOf(certificates[0].DNSNames); provider != "" {
+				LogDebug("%s: %s:%d -> fronted by %s.", TypeTLS, domain, port, provider)
+				resolution.CDNFronted = true
+				resolution.CDNProvider = provider
+			}
+		}
+		chains++
+		if resolver.verifyChain(serverName, certificates, now) {
+			validChains++
+		} else if resolver.isIncompleteChain(certificates, now) {
+			LogDebug("%s: %s:%d -> intermediate certificates are missing.", TypeTLS, domain, port)
+			resolution.IncompleteChain = true
+		}
 	}
+	resolution.ChainValid = chains > 0 && validChains == chains
 
 	return resolution
 }
 
-func (resolver *TLSResolver) fetchTLSCertChain(domain string) (chain []*x509.Certificate) {
-	res, err := resolver.Client.Get("https://" + domain)
+// verifyChain returns true if the leaf of a given chain is valid for a given domain at a given time,
+// using the rest of the chain as intermediates.
+func (resolver *TLSResolver) verifyChain(domain string, chain []*x509.Certificate, now time.Time) bool {
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		DNSName:       domain,
+		Roots:         resolver.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+	if err != nil {
+		LogDebug("%s: %s -> invalid certificate chain: %s", TypeTLS, domain, err.Error())
+		return false
+	}
+	return true
+}
+
+// isIncompleteChain returns true if the leaf of a given chain cannot be chained to a trusted
+// root only because the server did not present all the intermediates, i.e. the presented
+// chain does not end with a (possibly untrusted) self-signed root. Validity is checked at a given time.
+func (resolver *TLSResolver) isIncompleteChain(chain []*x509.Certificate, now time.Time) bool {
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         resolver.Roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		CurrentTime:   now,
+	})
+	if _, ok := err.(x509.UnknownAuthorityError); !ok {
+		// Either trusted, or broken for a different reason (e.g. expired).
+		return false
+	}
+
+	return !isSelfSigned(topOfChain(chain))
+}
+
+// topOfChain follows issuers of the leaf among a given chain and returns the last one found.
+func topOfChain(chain []*x509.Certificate) *x509.Certificate {
+	top := chain[0]
+	for hop := 0; hop < len(chain) && !isSelfSigned(top); hop++ {
+		var issuer *x509.Certificate
+		for _, cert := range chain {
+			if cert != top && top.CheckSignatureFrom(cert) == nil {
+				issuer = cert
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+		top = issuer
+	}
+	return top
+}
+
+// isSelfSigned returns true if a given certificate is signed by its own key.
+func isSelfSigned(cert *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+		return false
+	}
+	return cert.CheckSignatureFrom(cert) == nil
+}
+
+// cdnProviderOf returns the CDN provider sharing any of given names (see CDNSharedDomains)
+// or "" if there is none.
+func cdnProviderOf(names []string) string {
+	for _, name := range names {
+		name = strings.TrimPrefix(strings.ToLower(name), "*.")
+		for labels := strings.Split(name, "."); len(labels) >= 2; labels = labels[1:] {
+			if provider, ok := CDNSharedDomains[strings.Join(labels, ".")]; ok {
+				return provider
+			}
+		}
+	}
+	return ""
+}
+
+// isExpectedIssuer returns true if a given issuer contains any of the expected
+// issuers (case-insensitive) or if there are no expectations at all.
+func isExpectedIssuer(issuer string, expectedIssuers []string) bool {
+	if len(expectedIssuers) == 0 {
+		return true
+	}
+
+	issuer = strings.ToLower(issuer)
+	for _, expected := range expectedIssuers {
+		if strings.Contains(issuer, strings.ToLower(expected)) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchTLSCertChain does a TLS handshake with a given domain on a given port
+// (presenting a given SNI) and returns the peer certificates.
+// Any TLS service will do, not just HTTPS.
+func (resolver *TLSResolver) fetchTLSCertChain(ctx context.Context, domain string, port int, serverName string) (chain []*x509.Certificate, err error) {
+	address := net.JoinHostPort(domain, strconv.Itoa(port))
+
+	if !resolver.limiter.acquire(ctx) {
+		return chain, ctx.Err()
+	}
+	defer resolver.limiter.release()
+
+	config := &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         serverName,
+		Certificates:       resolver.ClientCertificates,
+	}
+
+	var conn *tls.Conn
+	if resolver.DialContext != nil {
+		conn, err = resolver.handshakeVia(ctx, address, config)
+	} else {
+		dialer := &tls.Dialer{NetDialer: resolver.Dialer, Config: config}
+		var rawConn net.Conn
+		if rawConn, err = dialer.DialContext(ctx, "tcp", address); err == nil {
+			conn = rawConn.(*tls.Conn)
+		}
+	}
 	if err != nil {
-		LogErr("%s: %s -> %s", TypeTLS, domain, err.Error())
-		return chain
+		LogErr("%s: %s -> %s", TypeTLS, address, err.Error())
+		return chain, err
 	}
+	defer conn.Close()
 
-	if res.TLS == nil {
-		// No cert available.
-		return chain
+	return conn.ConnectionState().PeerCertificates, nil
+}
+
+// handshakeVia does a TLS handshake over a connection opened by the custom dialer.
+func (resolver *TLSResolver) handshakeVia(ctx context.Context, address string, config *tls.Config) (*tls.Conn, error) {
+	if resolver.Dialer != nil && resolver.Dialer.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, resolver.Dialer.Timeout)
+		defer cancel()
 	}
 
-	return res.TLS.PeerCertificates
+	rawConn, err := resolver.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bound the handshake by the context (tls.Conn.HandshakeContext needs Go 1.17).
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = rawConn.SetDeadline(deadline)
+	}
+	conn := tls.Client(rawConn, config)
+	if err = conn.Handshake(); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	_ = rawConn.SetDeadline(time.Time{})
+	return conn, nil
 }
 
 /////////////////////////////////////////
@@ -86,6 +298,15 @@ func (res *TLSResolution) Domains() (domains []string) {
 	return domains
 }
 
+// Raw returns the certificate chain as []x509.Certificate.
+func (res *TLSResolution) Raw() interface{} {
+	certificates := make([]x509.Certificate, 0, len(res.Certificates))
+	for _, cert := range res.Certificates {
+		certificates = append(certificates, cert.Certificate)
+	}
+	return certificates
+}
+
 /////////////////////////////////////////
 // TLS CERTIFICATE
 /////////////////////////////////////////
@@ -99,7 +320,44 @@ func (cert *TLSCertificate) String() string {
 	if issuer == "" {
 		issuer = cert.Issuer.String()
 	}
-	return fmt.Sprintf("subject: %s, issuer: %s, domains: %v", subject, issuer, cert.DNSNames)
+	return fmt.Sprintf("port: %d, subject: %s, issuer: %s, domains: %v", cert.Port, subject, issuer, cert.DNSNames)
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the certificate (lowercase hex).
+func (cert *TLSCertificate) Fingerprint() string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// PEM returns the certificate PEM-encoded.
+func (cert *TLSCertificate) PEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// WriteCertificates saves all unique certificates of given TLS resolutions into a given directory
+// as PEM files named by their fingerprints (e.g. "3a7b...e1.pem"). Returns paths of the files.
+func WriteCertificates(dir string, resolutions []Resolution) (paths []string, err error) {
+	written := map[string]bool{}
+	for _, res := range resolutions {
+		tlsRes, ok := res.(*TLSResolution)
+		if !ok {
+			continue
+		}
+		for _, cert := range tlsRes.Certificates {
+			fingerprint := cert.Fingerprint()
+			if written[fingerprint] {
+				continue
+			}
+			written[fingerprint] = true
+
+			path := filepath.Join(dir, fingerprint+".pem")
+			if err = ioutil.WriteFile(path, cert.PEM(), 0644); err != nil {
+				return paths, err
+			}
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
 }
 
 func dissectDomainsFromCert(cert *TLSCertificate) (domains []string) {
@@ -0,0 +1,409 @@
+package udig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func portOf(server *httptest.Server) int {
+	return server.Listener.Addr().(*net.TCPAddr).Port
+}
+
+// issueCertificate signs a given template by a given issuer (self-signs if the issuer is nil).
+func issueCertificate(t *testing.T, template *x509.Certificate, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if issuer == nil {
+		issuer, issuerKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, issuerKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	return cert, key
+}
+
+func newClientCertificate(t *testing.T) tls.Certificate {
+	cert, key := issueCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "udig client"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, nil, nil)
+
+	return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}
+}
+
+// newServerChain issues a root CA, an intermediate CA and a leaf for 127.0.0.1.
+func newServerChain(t *testing.T) (root *x509.Certificate, intermediate *x509.Certificate, leaf tls.Certificate) {
+	ca := func(serial int64, name string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: name},
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+	}
+	root, rootKey := issueCertificate(t, ca(1, "udig root"), nil, nil)
+	intermediate, intermediateKey := issueCertificate(t, ca(2, "udig intermediate"), root, rootKey)
+	leafCert, leafKey := issueCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "udig leaf"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, intermediate, intermediateKey)
+
+	return root, intermediate, tls.Certificate{Certificate: [][]byte{leafCert.Raw}, PrivateKey: leafKey}
+}
+
+func Test_When_certificate_is_from_unlisted_issuer_Then_unexpected_issuer_is_flagged(t *testing.T) {
+	// Mock.
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// Setup.
+	resolver := NewTLSResolver()
+	resolver.Ports = []int{portOf(server)}
+	resolver.ExpectedIssuers = []string{"Let's Encrypt", "DigiCert"}
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "127.0.0.1").(*TLSResolution)
+
+	// Assert.
+	assert.NotEmpty(t, resolution.Certificates)
+	assert.True(t, resolution.UnexpectedIssuer)
+}
+
+func Test_When_certificate_is_from_listed_issuer_Then_unexpected_issuer_is_not_flagged(t *testing.T) {
+	// Mock.
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// Setup.
+	resolver := NewTLSResolver()
+	resolver.Ports = []int{portOf(server)}
+	resolver.ExpectedIssuers = []string{"acme co"}
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "127.0.0.1").(*TLSResolution)
+
+	// Assert.
+	assert.NotEmpty(t, resolution.Certificates)
+	assert.False(t, resolution.UnexpectedIssuer)
+}
+
+func Test_When_certificate_is_self_signed_Then_chain_is_not_valid(t *testing.T) {
+	// Mock.
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// Setup.
+	resolver := NewTLSResolver()
+	resolver.Ports = []int{portOf(server)}
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "127.0.0.1").(*TLSResolution)
+
+	// Assert.
+	assert.Len(t, resolution.Certificates, 1)
+	assert.False(t, resolution.ChainValid)
+	assert.True(t, resolution.Certificates[0].SelfSigned)
+	assert.False(t, resolution.Certificates[0].Expired)
+	assert.Greater(t, resolution.Certificates[0].DaysUntilExpiry, 0)
+}
+
+func Test_When_certificate_is_issued_by_trusted_root_Then_chain_is_valid(t *testing.T) {
+	// Mock.
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// Setup.
+	resolver := NewTLSResolver()
+	resolver.Ports = []int{portOf(server)}
+	resolver.Roots = x509.NewCertPool()
+	resolver.Roots.AddCert(server.Certificate())
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "127.0.0.1").(*TLSResolution)
+
+	// Assert.
+	assert.True(t, resolution.ChainValid)
+}
+
+func Test_When_clock_is_past_certificate_expiry_Then_chain_is_not_valid(t *testing.T) {
+	// Mock.
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// Setup.
+	resolver := NewTLSResolver()
+	resolver.Ports = []int{portOf(server)}
+	resolver.Roots = x509.NewCertPool()
+	resolver.Roots.AddCert(server.Certificate())
+	resolver.Clock = &fixedClock{now: server.Certificate().NotAfter.Add(24 * time.Hour)}
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "127.0.0.1").(*TLSResolution)
+
+	// Assert.
+	assert.True(t, resolution.Certificates[0].Expired)
+	assert.False(t, resolution.ChainValid)
+}
+
+func Test_When_multiple_ports_are_probed_Then_certificates_are_tagged_by_port(t *testing.T) {
+	// Mock.
+	var serverNames []string
+	newServer := func() *httptest.Server {
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.TLS = &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverNames = append(serverNames, hello.ServerName)
+			return nil, nil
+		}}
+		server.StartTLS()
+		return server
+	}
+	first, second := newServer(), newServer()
+	defer first.Close()
+	defer second.Close()
+
+	// Setup.
+	resolver := NewTLSResolver()
+	resolver.Ports = []int{portOf(first), portOf(second)}
+	resolver.ServerName = "vhost.example.com"
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "127.0.0.1").(*TLSResolution)
+
+	// Assert.
+	assert.Len(t, resolution.Certificates, 2)
+	assert.Equal(t, portOf(first), resolution.Certificates[0].Port)
+	assert.Equal(t, portOf(second), resolution.Certificates[1].Port)
+	assert.Equal(t, []string{"vhost.example.com", "vhost.example.com"}, serverNames)
+}
+
+func Test_When_WithClientCertificate_is_used_Then_certificate_is_presented_to_mTLS_servers(t *testing.T) {
+	// Mock.
+	presented := make(chan string, 2)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAnyClientCert,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err == nil {
+				presented <- cert.Subject.CommonName
+			}
+			return err
+		},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	tlsResolver := NewTLSResolver()
+	tlsResolver.Ports = []int{portOf(server)}
+	httpResolver := NewHTTPResolver()
+
+	// Setup.
+	udig := newUdigImpl()
+	udig.AddDomainResolver(tlsResolver)
+	udig.AddDomainResolver(httpResolver)
+	WithClientCertificate(newClientCertificate(t))(udig)
+
+	// Execute.
+	tlsResolution := tlsResolver.ResolveDomain(context.Background(), "127.0.0.1").(*TLSResolution)
+	httpResolution := &HTTPResolution{ResolutionBase: &ResolutionBase{query: "127.0.0.1"}}
+	httpResolver.resolveURLs(context.Background(), httpResolution, server.URL, "http://127.0.0.1:0")
+
+	// Assert.
+	assert.NotEmpty(t, tlsResolution.Certificates)
+	assert.NoError(t, httpResolution.Error())
+	for i := 0; i < 2; i++ {
+		select {
+		case subject := <-presented:
+			assert.Equal(t, "udig client", subject)
+		case <-time.After(time.Second):
+			assert.Fail(t, "client certificate was not presented")
+		}
+	}
+}
+
+func Test_When_server_presents_only_the_leaf_Then_chain_is_incomplete(t *testing.T) {
+	// Mock.
+	root, _, leaf := newServerChain(t)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{leaf}}
+	server.StartTLS()
+	defer server.Close()
+
+	// Setup.
+	resolver := NewTLSResolver()
+	resolver.Ports = []int{portOf(server)}
+	resolver.Roots = x509.NewCertPool()
+	resolver.Roots.AddCert(root)
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "127.0.0.1").(*TLSResolution)
+
+	// Assert.
+	assert.Len(t, resolution.Certificates, 1)
+	assert.False(t, resolution.ChainValid)
+	assert.True(t, resolution.IncompleteChain)
+}
+
+func Test_When_server_presents_the_intermediates_Then_chain_is_complete(t *testing.T) {
+	// Mock.
+	root, intermediate, leaf := newServerChain(t)
+	leaf.Certificate = append(leaf.Certificate, intermediate.Raw)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{leaf}}
+	server.StartTLS()
+	defer server.Close()
+
+	// Setup.
+	resolver := NewTLSResolver()
+	resolver.Ports = []int{portOf(server)}
+	resolver.Roots = x509.NewCertPool()
+	resolver.Roots.AddCert(root)
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "127.0.0.1").(*TLSResolution)
+
+	// Assert.
+	assert.Len(t, resolution.Certificates, 2)
+	assert.True(t, resolution.ChainValid)
+	assert.False(t, resolution.IncompleteChain)
+}
+
+func Test_When_custom_dialer_is_set_Then_TLS_handshake_goes_through_it(t *testing.T) {
+	// Mock.
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	var dialed []string
+	tunnel := func(ctx context.Context, network string, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+
+	// Setup.
+	resolver := NewTLSResolver()
+	resolver.DialContext = tunnel
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "intranet.example.com").(*TLSResolution)
+
+	// Assert.
+	assert.Equal(t, []string{"intranet.example.com:443"}, dialed)
+	assert.Len(t, resolution.Certificates, 1)
+}
+
+func Test_When_certificate_covers_only_a_CDN_wildcard_Then_domain_is_CDN_fronted(t *testing.T) {
+	// Mock.
+	cert, key := issueCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "*.cloudfront.net"},
+		DNSNames:     []string{"*.cloudfront.net", "cloudfront.net"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil, nil)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}}
+	server.StartTLS()
+	defer server.Close()
+
+	// Setup.
+	resolver := NewTLSResolver()
+	resolver.DialContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "shop.example.com").(*TLSResolution)
+
+	// Assert.
+	assert.Len(t, resolution.Certificates, 1)
+	assert.True(t, resolution.HostnameMismatch)
+	assert.True(t, resolution.CDNFronted)
+	assert.Equal(t, "Amazon CloudFront", resolution.CDNProvider)
+}
+
+func Test_When_certificate_covers_the_domain_Then_it_is_not_CDN_fronted(t *testing.T) {
+	// Mock.
+	cert, key := issueCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "shop.example.com"},
+		DNSNames:     []string{"shop.example.com", "*.cloudfront.net"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil, nil)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}}
+	server.StartTLS()
+	defer server.Close()
+
+	// Setup.
+	resolver := NewTLSResolver()
+	resolver.DialContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+
+	// Execute.
+	resolution := resolver.ResolveDomain(context.Background(), "shop.example.com").(*TLSResolution)
+
+	// Assert.
+	assert.False(t, resolution.HostnameMismatch)
+	assert.False(t, resolution.CDNFronted)
+	assert.Empty(t, resolution.CDNProvider)
+}
+
+func Test_When_certificates_are_written_Then_each_is_a_valid_PEM_named_by_fingerprint(t *testing.T) {
+	// Mock.
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "udig")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Setup.
+	resolver := NewTLSResolver()
+	resolver.Ports = []int{portOf(server), portOf(server)}
+	resolution := resolver.ResolveDomain(context.Background(), "127.0.0.1")
+
+	// Execute.
+	paths, err := WriteCertificates(dir, []Resolution{resolution})
+
+	// Assert.
+	assert.NoError(t, err)
+	fingerprint := sha256.Sum256(server.Certificate().Raw)
+	assert.Equal(t, []string{filepath.Join(dir, hex.EncodeToString(fingerprint[:])+".pem")}, paths)
+
+	raw, err := ioutil.ReadFile(paths[0])
+	assert.NoError(t, err)
+	block, _ := pem.Decode(raw)
+	assert.Equal(t, "CERTIFICATE", block.Type)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	assert.NoError(t, err)
+	assert.True(t, cert.Equal(server.Certificate()))
+}
@@ -1,6 +1,12 @@
 package udig
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/miekg/dns"
@@ -8,42 +14,349 @@ import (
 
 type udigImpl struct {
 	Udig
-	domainResolvers []DomainResolver
-	ipResolvers     []IPResolver
-	domainQueue     chan string
-	ipQueue         chan string
-	processed       map[string]bool
-	seen            map[string]bool
+	domainResolvers   []DomainResolver
+	ipResolvers       []IPResolver
+	domainQueue       chan string
+	ipQueue           chan string
+	processed         map[string]bool
+	processedMutex    *sync.Mutex
+	sharedProcessed   bool
+	seen              map[string]bool
+	seed              string
+	onlyRelatedOutput bool
+	onResolution      func(res Resolution)
+	followTXTRefs     bool
+	referenced        map[string]bool
+	rewriteDomain     func(domain string) string
+	rateLimiter       *rateLimiter
+	maxQueries        int
+	queries           int
+	includePrivateIPs bool
 }
 
-// NewUdig creates a new Udig instances provisioned with
-// all supported resolvers. You can also supply your own
-// resolvers to the returned instance.
-func NewUdig() Udig {
-	udig := &udigImpl{
+const (
+	// DefaultBatchConcurrency is a max number of seeds resolved concurrently in a batch.
+	DefaultBatchConcurrency = 4
+)
+
+func newUdigImpl() *udigImpl {
+	return &udigImpl{
 		domainResolvers: []DomainResolver{},
 		ipResolvers:     []IPResolver{},
 		domainQueue:     make(chan string, 1024),
 		ipQueue:         make(chan string, 1024),
 		processed:       map[string]bool{},
+		processedMutex:  &sync.Mutex{},
 		seen:            map[string]bool{},
+		referenced:      map[string]bool{},
 	}
+}
+
+// NewUdig creates a new Udig instances provisioned with
+// all supported resolvers. You can also supply your own
+// resolvers to the returned instance and tweak
+// the configuration via options.
+func NewUdig(opts ...Option) Udig {
+	udig := newUdigImpl()
 
 	udig.AddDomainResolver(NewDNSResolver())
 	udig.AddDomainResolver(NewWhoisResolver())
 	udig.AddDomainResolver(NewTLSResolver())
 	udig.AddDomainResolver(NewHTTPResolver())
 	udig.AddDomainResolver(NewCTResolver())
+	udig.AddDomainResolver(NewSPFResolver())
 
 	udig.AddIPResolver(NewBGPResolver())
 	udig.AddIPResolver(NewGeoResolver())
+	udig.AddIPResolver(NewIPWhoisResolver())
+	udig.AddIPResolver(NewPTRResolver())
+
+	for _, opt := range opts {
+		opt(udig)
+	}
 
 	return udig
 }
 
-func (udig *udigImpl) Resolve(domain string) []Resolution {
+// ResolveBatch resolves given domains with a bounded concurrency (see DefaultBatchConcurrency).
+// All the seeds share the same resolvers (and thus their caches), each seed is crawled
+// separately though, unless WithSharedBatchState is used. The results are keyed by the seed
+// domain. Once the context is cancelled, no more seeds are started and the running crawls stop early.
+func ResolveBatch(ctx context.Context, domains []string, opts ...Option) map[string][]Resolution {
+	prototype := NewUdig(opts...).(*udigImpl)
+
+	return resolveBatch(ctx, domains, func(domain string) []Resolution {
+		return prototype.clone().Resolve(ctx, domain)
+	})
+}
+
+// ResolveIPBatch resolves given IPs using the IP resolvers only (e.g. BGP, GEO, PTR),
+// with a bounded concurrency (see DefaultBatchConcurrency). If crawl is set,
+// hostnames found in PTR records are crawled as domains too. The results are keyed by the IP.
+func ResolveIPBatch(ctx context.Context, ips []string, crawl bool, opts ...Option) map[string][]Resolution {
+	prototype := NewUdig(opts...).(*udigImpl)
+
+	return resolveBatch(ctx, ips, func(ip string) []Resolution {
+		resolutions := prototype.clone().resolveOneIP(ctx, ip)
+		if !crawl {
+			return resolutions
+		}
+
+		for _, res := range resolutions {
+			if res.Type() != TypePTR {
+				continue
+			}
+			for _, hostname := range res.Domains() {
+				if ctx.Err() != nil {
+					break
+				}
+				resolutions = append(resolutions, prototype.clone().Resolve(ctx, hostname)...)
+			}
+		}
+		return resolutions
+	})
+}
+
+// resolveBatch runs a given resolution of each seed with a bounded concurrency
+// (see DefaultBatchConcurrency). Once the context is cancelled, no more seeds are started.
+func resolveBatch(ctx context.Context, seeds []string, resolve func(seed string) []Resolution) map[string][]Resolution {
+	results := map[string][]Resolution{}
+	resultsMutex := sync.Mutex{}
+	semaphore := make(chan struct{}, DefaultBatchConcurrency)
+	var wg sync.WaitGroup
+
+dispatch:
+	for _, seed := range seeds {
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case semaphore <- struct{}{}:
+		}
+
+		if ctx.Err() != nil {
+			// Both might have been ready, don't start a new crawl anyway.
+			<-semaphore
+			break
+		}
+
+		wg.Add(1)
+		go func(seed string) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			resolutions := resolve(seed)
+
+			resultsMutex.Lock()
+			results[seed] = resolutions
+			resultsMutex.Unlock()
+		}(seed)
+	}
+
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		LogDebug("Batch resolution cancelled: %s", ctx.Err().Error())
+	}
+
+	return results
+}
+
+// DiscoveredDomains returns all distinct domains found in given resolutions
+// (including the queried ones) in a canonical form and order (see SortDomains).
+func DiscoveredDomains(resolutions []Resolution) (domains []string) {
+	for _, res := range resolutions {
+		if net.ParseIP(res.Query()) == nil {
+			domains = append(domains, CleanDomain(res.Query()))
+		}
+		for _, domain := range res.Domains() {
+			if domain = CleanDomain(domain); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+	}
+	domains = uniqueStrings(domains)
+	SortDomains(domains)
+	return domains
+}
+
+// DiscoveredIPs returns all distinct IP addresses found in given resolutions
+// (including the queried ones) in a canonical form and order (see SortIPs).
+func DiscoveredIPs(resolutions []Resolution) (ips []string) {
+	for _, res := range resolutions {
+		candidates := append([]string{res.Query()}, res.IPs()...)
+		for _, candidate := range candidates {
+			if ip := net.ParseIP(candidate); ip != nil {
+				ips = append(ips, ip.String())
+			}
+		}
+	}
+	ips = uniqueStrings(ips)
+	SortIPs(ips)
+	return ips
+}
+
+// NewlyDiscovered returns domains and IPs found in given resolutions (see DiscoveredDomains
+// and DiscoveredIPs) which are not in a given baseline of a previous run (see ReadBaseline).
+func NewlyDiscovered(resolutions []Resolution, baseline map[string]bool) (domains []string, ips []string) {
+	for _, domain := range DiscoveredDomains(resolutions) {
+		if !baseline[domain] {
+			domains = append(domains, domain)
+		}
+	}
+	for _, ip := range DiscoveredIPs(resolutions) {
+		if !baseline[ip] {
+			ips = append(ips, ip)
+		}
+	}
+	return domains, ips
+}
+
+// PTRConsistencies returns reverse/forward DNS consistency verdicts (see PTRResolver)
+// of all IPs resolved in given resolutions.
+func PTRConsistencies(resolutions []Resolution) map[string]PTRConsistency {
+	verdicts := map[string]PTRConsistency{}
+	for _, res := range resolutions {
+		if ptr, ok := res.(*PTRResolution); ok && ptr.Consistency != "" {
+			verdicts[ptr.Query()] = ptr.Consistency
+		}
+	}
+	return verdicts
+}
+
+// AggregateWhoisContacts folds WHOIS contacts of given resolutions into unique ones
+// (keyed by WhoisContactKey, i.e. registrar and registrant by default), each listing
+// the domains it covers. Summaries are sorted by their keys.
+func AggregateWhoisContacts(resolutions []Resolution) (summaries []WhoisContactSummary) {
+	index := map[string]int{}
+	var keys []string
+	for _, res := range resolutions {
+		whois, ok := res.(*WhoisResolution)
+		if !ok {
+			continue
+		}
+		for _, contact := range whois.Contacts {
+			key := WhoisContactKey(&contact)
+			if key == "" {
+				continue
+			}
+			i, seen := index[key]
+			if !seen {
+				i = len(summaries)
+				index[key] = i
+				keys = append(keys, key)
+				summaries = append(summaries, WhoisContactSummary{WhoisContact: contact.identity()})
+			}
+			summaries[i].Domains = append(summaries[i].Domains, CleanDomain(whois.Query()))
+		}
+	}
+
+	for i := range summaries {
+		summaries[i].Domains = uniqueStrings(summaries[i].Domains)
+		SortDomains(summaries[i].Domains)
+	}
+	sort.Sort(whoisSummariesByKey{summaries: summaries, keys: keys})
+	return summaries
+}
+
+// Diagnose sums up failures of given resolutions per resolver type, so that empty results
+// can be told apart from disabled (see ErrResolverDisabled) or failing resolvers.
+// Only resolvers with any failures are listed, sorted by their types.
+func Diagnose(resolutions []Resolution) (diagnostics []ResolverDiagnostic) {
+	index := map[ResolutionType]int{}
+	for _, res := range resolutions {
+		failed, ok := res.(ErrorResolution)
+		if !ok || failed.Error() == nil {
+			continue
+		}
+
+		i, seen := index[res.Type()]
+		if !seen {
+			i = len(diagnostics)
+			index[res.Type()] = i
+			diagnostics = append(diagnostics, ResolverDiagnostic{Type: res.Type()})
+		}
+
+		err := failed.Error()
+		var disabled *disabledError
+		switch {
+		case errors.As(err, &disabled):
+			diagnostics[i].Disabled = disabled.reason
+			break
+		case isTimeout(err):
+			diagnostics[i].Timeouts++
+			break
+		default:
+			diagnostics[i].Failures++
+			break
+		}
+	}
+
+	sort.Slice(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Type < diagnostics[j].Type
+	})
+	return diagnostics
+}
+
+// isTimeout tells if a given failure (or any of the combined ones) is a timeout.
+func isTimeout(err error) bool {
+	if errs, ok := err.(resolutionErrors); ok {
+		for _, err := range errs.Errors() {
+			if isTimeout(err) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) || strings.Contains(strings.ToLower(err.Error()), "timeout")
+}
+
+func (diagnostic *ResolverDiagnostic) String() string {
+	if diagnostic.Disabled != "" {
+		return fmt.Sprintf("%s: disabled (%s)", diagnostic.Type, diagnostic.Disabled)
+	}
+
+	var entries []string
+	if diagnostic.Timeouts > 0 {
+		entries = append(entries, fmt.Sprintf("%d timeouts", diagnostic.Timeouts))
+	}
+	if diagnostic.Failures > 0 {
+		entries = append(entries, fmt.Sprintf("%d failures", diagnostic.Failures))
+	}
+	return fmt.Sprintf("%s: %s", diagnostic.Type, strings.Join(entries, ", "))
+}
+
+// whoisSummariesByKey sorts summaries along with their keys.
+type whoisSummariesByKey struct {
+	summaries []WhoisContactSummary
+	keys      []string
+}
+
+func (s whoisSummariesByKey) Len() int           { return len(s.keys) }
+func (s whoisSummariesByKey) Less(i, j int) bool { return s.keys[i] < s.keys[j] }
+func (s whoisSummariesByKey) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.summaries[i], s.summaries[j] = s.summaries[j], s.summaries[i]
+}
+
+func (udig *udigImpl) Resolve(ctx context.Context, domain string) []Resolution {
+	// All the resolvers speak ASCII only.
+	if ascii, err := ToASCIIDomain(domain); err != nil {
+		LogErr("Could not convert domain %s to ASCII. The cause was: %s", domain, err.Error())
+	} else {
+		domain = ascii
+	}
+
+	udig.seed = domain
 	udig.domainQueue <- domain
-	return udig.resolveDomains()
+
+	return udig.resolveDomains(ctx)
 }
 
 func (udig *udigImpl) AddDomainResolver(resolver DomainResolver) {
@@ -54,13 +367,44 @@ func (udig *udigImpl) AddIPResolver(resolver IPResolver) {
 	udig.ipResolvers = append(udig.ipResolvers, resolver)
 }
 
-func (udig *udigImpl) resolveDomains() (resolutions []Resolution) {
+// clone creates a new instance sharing resolvers and configuration with this one,
+// but otherwise starting with a clean state.
+func (udig *udigImpl) clone() *udigImpl {
+	clone := newUdigImpl()
+	clone.domainResolvers = udig.domainResolvers
+	clone.ipResolvers = udig.ipResolvers
+	clone.onlyRelatedOutput = udig.onlyRelatedOutput
+	clone.onResolution = udig.onResolution
+	clone.followTXTRefs = udig.followTXTRefs
+	clone.rewriteDomain = udig.rewriteDomain
+	clone.rateLimiter = udig.rateLimiter
+	clone.maxQueries = udig.maxQueries
+	clone.includePrivateIPs = udig.includePrivateIPs
+	if udig.sharedProcessed {
+		clone.processed = udig.processed
+		clone.processedMutex = udig.processedMutex
+		clone.sharedProcessed = true
+	}
+	return clone
+}
+
+func (udig *udigImpl) resolveDomains(ctx context.Context) (resolutions []Resolution) {
 	for len(udig.domainQueue) > 0 {
+		if ctx.Err() != nil {
+			LogDebug("Resolution cancelled: %s", ctx.Err().Error())
+			break
+		}
+
+		if udig.isOverBudget() {
+			LogErr("Query budget of %d exhausted, %d domains left unresolved.", udig.maxQueries, len(udig.domainQueue))
+			break
+		}
+
 		// Poll a domain.
 		domain := <-udig.domainQueue
 
 		// Resolve it.
-		newResolutions := udig.resolveOneDomain(domain)
+		newResolutions := udig.resolveOneDomain(ctx, domain)
 
 		// Store the results.
 		resolutions = append(resolutions, newResolutions...)
@@ -68,20 +412,28 @@ func (udig *udigImpl) resolveDomains() (resolutions []Resolution) {
 		// Enqueue all related domains from the result.
 		udig.enqueueDomains(udig.getRelatedDomains(newResolutions)...)
 
+		// Pass the results on (only once they are not needed for crawling).
+		udig.emit(newResolutions)
+
 		// Resolve all the discovered IPs.
-		resolutions = append(resolutions, udig.resolveIPs()...)
+		resolutions = append(resolutions, udig.resolveIPs(ctx)...)
 	}
 
 	return resolutions
 }
 
-func (udig *udigImpl) resolveIPs() (resolutions []Resolution) {
+func (udig *udigImpl) resolveIPs(ctx context.Context) (resolutions []Resolution) {
 	for len(udig.ipQueue) > 0 {
+		if ctx.Err() != nil || udig.isOverBudget() {
+			break
+		}
+
 		// Poll an IP.
 		ip := <-udig.ipQueue
 
 		// Resolve it.
-		newResolutions := udig.resolveOneIP(ip)
+		newResolutions := udig.resolveOneIP(ctx, ip)
+		udig.emit(newResolutions)
 
 		resolutions = append(resolutions, newResolutions...)
 	}
@@ -89,12 +441,12 @@ func (udig *udigImpl) resolveIPs() (resolutions []Resolution) {
 	return resolutions
 }
 
-func (udig *udigImpl) resolveOneDomain(domain string) (resolutions []Resolution) {
+func (udig *udigImpl) resolveOneDomain(ctx context.Context, domain string) (resolutions []Resolution) {
 	// Make sure we don't repeat ourselves.
-	if udig.isProcessed(domain) {
+	if !udig.claim(domain) {
 		return resolutions
 	}
-	defer udig.addProcessed(domain)
+	udig.queries++
 
 	resolutionChannel := make(chan Resolution, 1024)
 
@@ -102,8 +454,10 @@ func (udig *udigImpl) resolveOneDomain(domain string) (resolutions []Resolution)
 	wg.Add(len(udig.domainResolvers))
 
 	for _, resolver := range udig.domainResolvers {
+		// Once cancelled, this no longer waits (and the resolvers give up on their own).
+		udig.rateLimiter.wait(ctx)
 		go func(resolver DomainResolver) {
-			resolution := resolver.ResolveDomain(domain)
+			resolution := resolver.ResolveDomain(ctx, domain)
 			resolutionChannel <- resolution
 
 			// Enqueue all discovered IPs.
@@ -122,19 +476,27 @@ func (udig *udigImpl) resolveOneDomain(domain string) (resolutions []Resolution)
 	return resolutions
 }
 
-func (udig *udigImpl) resolveOneIP(ip string) (resolutions []Resolution) {
+func (udig *udigImpl) resolveOneIP(ctx context.Context, ip string) (resolutions []Resolution) {
+	if udig.isSkippedIP(ip) {
+		return resolutions
+	}
+
 	// Make sure we don't repeat ourselves.
-	if udig.isProcessed(ip) {
+	if !udig.claim(ip) {
 		return resolutions
 	}
-	defer udig.addProcessed(ip)
+	udig.queries++
 
 	resolutionChannel := make(chan Resolution, 1024)
 
 	var wg sync.WaitGroup
-	wg.Add(len(udig.ipResolvers))
 
 	for _, resolver := range udig.ipResolvers {
+		// IP resolvers do not take the context, so once cancelled, no more of them are started.
+		if !udig.rateLimiter.wait(ctx) {
+			break
+		}
+		wg.Add(1)
 		go func(resolver IPResolver) {
 			resolutionChannel <- resolver.ResolveIP(ip)
 			wg.Done()
@@ -162,13 +524,59 @@ func (udig *udigImpl) isCnameOrRelated(nextDomain string, resolution Resolution)
 		break
 	}
 
-	// Otherwise try heuristics.
-	return IsDomainRelated(nextDomain, resolution.Query())
+	// Otherwise try heuristics (unless we only got here by a TXT reference).
+	if !udig.referenced[resolution.Query()] && IsDomainRelated(nextDomain, resolution.Query()) {
+		return true
+	}
+
+	if udig.followTXTRefs && resolution.Type() == TypeDNS && isTXTReference(nextDomain, resolution.(*DNSResolution)) {
+		// Follow SPF delegations, but nothing else from there.
+		udig.referenced[nextDomain] = true
+		return true
+	}
+
+	return false
+}
+
+// isTXTReference tells if a given domain is delegated to by a TXT record of a given resolution
+// (i.e. an SPF include or redirect).
+func isTXTReference(domain string, resolution *DNSResolution) bool {
+	for _, rr := range resolution.Records {
+		txt, ok := rr.Record.RR.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		for _, reference := range dissectDomainsFromSPF(strings.Join(txt.Txt, "")) {
+			if CleanDomain(reference) == domain {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func (udig *udigImpl) getRelatedDomains(resolutions []Resolution) (domains []string) {
+	wildcards := map[string]bool{}
 	for _, resolution := range resolutions {
+		if resolution.Type() == TypeDNS && resolution.(*DNSResolution).Wildcard {
+			wildcards[resolution.Query()] = true
+		}
+	}
+
+	for _, resolution := range resolutions {
+		if wildcards[resolution.Query()] {
+			// Anything found here is a product of a wildcard record -> don't crawl it.
+			LogDebug("%s: Domain %s is a wildcard -> skipping discovered domains.", resolution.Type(), resolution.Query())
+			continue
+		}
+
 		for _, nextDomain := range resolution.Domains() {
+			if udig.rewriteDomain != nil {
+				if nextDomain = udig.rewriteDomain(nextDomain); nextDomain == "" {
+					continue
+				}
+			}
+
 			// Crawl new and related domains only.
 			if udig.isProcessed(nextDomain) || udig.isSeen(nextDomain) {
 				continue
@@ -197,16 +605,42 @@ func (udig *udigImpl) enqueueDomains(domains ...string) {
 
 func (udig *udigImpl) enqueueIps(ips ...string) {
 	for _, ip := range ips {
-		udig.ipQueue <- ip
+		if !udig.isSkippedIP(ip) {
+			udig.ipQueue <- ip
+		}
 	}
 }
 
+// isSkippedIP tells if a given IP is not to be resolved, i.e. it is private (see WithIncludePrivateIPs).
+func (udig *udigImpl) isSkippedIP(ip string) bool {
+	if udig.includePrivateIPs || !IsPrivateIP(ip) {
+		return false
+	}
+	LogDebug("IP %s is private -> skipping.", ip)
+	return true
+}
+
 func (udig *udigImpl) isProcessed(query string) bool {
+	udig.processedMutex.Lock()
+	defer udig.processedMutex.Unlock()
 	return udig.processed[query]
 }
 
-func (udig *udigImpl) addProcessed(query string) {
+// claim marks a given query as processed, returns false if it already was
+// (possibly by another crawl of the batch, see WithSharedBatchState).
+func (udig *udigImpl) claim(query string) bool {
+	udig.processedMutex.Lock()
+	defer udig.processedMutex.Unlock()
+	if udig.processed[query] {
+		return false
+	}
 	udig.processed[query] = true
+	return true
+}
+
+// isOverBudget tells if the crawl has resolved as many queries as allowed (see WithMaxQueries).
+func (udig *udigImpl) isOverBudget() bool {
+	return udig.maxQueries > 0 && udig.queries >= udig.maxQueries
 }
 
 func (udig *udigImpl) isSeen(query string) bool {
@@ -216,3 +650,80 @@ func (udig *udigImpl) isSeen(query string) bool {
 func (udig *udigImpl) addSeen(query string) {
 	udig.seen[query] = true
 }
+
+// emit filters given resolutions (see WithOnlyRelatedOutput) and passes them
+// to the resolution handler (see WithResolutionHandler).
+func (udig *udigImpl) emit(resolutions []Resolution) {
+	for _, res := range resolutions {
+		if udig.onlyRelatedOutput && udig.seed != "" {
+			filterUnrelated(udig.seed, res)
+		}
+		if udig.onResolution != nil {
+			udig.onResolution(res)
+		}
+	}
+}
+
+// filterUnrelated removes all items from a given resolution, which only refer to
+// domains unrelated to a given seed. Items without any domains are kept.
+func filterUnrelated(seed string, res Resolution) {
+	switch res.Type() {
+	case TypeDNS:
+		dnsRes := res.(*DNSResolution)
+		var records []DNSRecordPair
+		for _, rr := range dnsRes.Records {
+			if hasRelatedDomain(seed, dissectDomainsFromRecord(rr.Record.RR)) {
+				records = append(records, rr)
+			}
+		}
+		dnsRes.Records = records
+		break
+
+	case TypeHTTP:
+		httpRes := res.(*HTTPResolution)
+		var headers []HTTPHeader
+		for _, header := range httpRes.Headers {
+			if hasRelatedDomain(seed, DissectDomainsFromStrings(header.Value)) {
+				headers = append(headers, header)
+			}
+		}
+		httpRes.Headers = headers
+		break
+
+	case TypeTLS:
+		tlsRes := res.(*TLSResolution)
+		var certificates []TLSCertificate
+		for _, cert := range tlsRes.Certificates {
+			if hasRelatedDomain(seed, dissectDomainsFromCert(&cert)) {
+				certificates = append(certificates, cert)
+			}
+		}
+		tlsRes.Certificates = certificates
+		break
+
+	case TypeCT:
+		ctRes := res.(*CTResolution)
+		var logs []CTAggregatedLog
+		for _, log := range ctRes.Logs {
+			if hasRelatedDomain(seed, log.ExtractDomains()) {
+				logs = append(logs, log)
+			}
+		}
+		ctRes.Logs = logs
+		break
+	}
+}
+
+// hasRelatedDomain returns true if at least one of given domains is related to a given seed
+// or if there are no domains at all.
+func hasRelatedDomain(seed string, domains []string) bool {
+	if len(domains) == 0 {
+		return true
+	}
+	for _, domain := range domains {
+		if IsDomainRelated(domain, seed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,738 @@
+package udig
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockDomainResolver struct {
+	DomainResolver
+	resolve func(domain string) Resolution
+}
+
+func (resolver *mockDomainResolver) ResolveDomain(ctx context.Context, domain string) Resolution {
+	return resolver.resolve(domain)
+}
+
+func Test_When_WithOnlyRelatedOutput_is_used_Then_unrelated_domains_are_not_emitted(t *testing.T) {
+	// Mock.
+	resolver := &mockDomainResolver{resolve: func(domain string) Resolution {
+		return &HTTPResolution{
+			ResolutionBase: &ResolutionBase{query: domain},
+			Headers: []HTTPHeader{
+				{Name: "content-security-policy", Value: []string{"connect-src https://www.googleapis.com"}},
+				{Name: "access-control-allow-origin", Value: []string{"https://api.example.com"}},
+			},
+		}
+	}}
+
+	// Setup.
+	udig := newUdigImpl()
+	udig.AddDomainResolver(resolver)
+	WithOnlyRelatedOutput()(udig)
+
+	// Execute.
+	resolutions := udig.Resolve(context.Background(), "example.com")
+
+	// Assert.
+	assert.Equal(t, "example.com", resolutions[0].Query())
+	headers := resolutions[0].(*HTTPResolution).Headers
+	assert.Len(t, headers, 1)
+	assert.Equal(t, "access-control-allow-origin", headers[0].Name)
+}
+
+func Test_When_WithDomainResolvers_and_WithIPResolvers_are_used_Then_only_given_resolvers_run(t *testing.T) {
+	// Mock.
+	resolver := &mockDomainResolver{resolve: func(domain string) Resolution {
+		return &HTTPResolution{ResolutionBase: &ResolutionBase{query: domain}}
+	}}
+
+	// Setup.
+	dig := NewUdig(WithDomainResolvers(resolver), WithIPResolvers(), WithWildcardDetection()).(*udigImpl)
+
+	// Execute.
+	resolutions := dig.Resolve(context.Background(), "example.com")
+
+	// Assert.
+	assert.Equal(t, []DomainResolver{resolver}, dig.domainResolvers)
+	assert.Empty(t, dig.ipResolvers)
+	assert.Len(t, resolutions, 1)
+	assert.Equal(t, TypeHTTP, resolutions[0].Type())
+}
+
+func Test_When_WithDomainRewriter_is_used_Then_rewritten_domains_are_resolved(t *testing.T) {
+	// Mock.
+	resolver := &mockDomainResolver{resolve: func(domain string) Resolution {
+		return &HTTPResolution{
+			ResolutionBase: &ResolutionBase{query: domain},
+			Headers: []HTTPHeader{{Name: "access-control-allow-origin", Value: []string{
+				"https://api.corp.example.com", "https://api.example.com", "https://drop.example.com",
+			}}},
+		}
+	}}
+
+	// Setup.
+	udig := newUdigImpl()
+	udig.AddDomainResolver(resolver)
+	WithDomainRewriter(func(domain string) string {
+		if domain == "drop.example.com" {
+			return ""
+		}
+		return strings.Replace(domain, ".corp.", ".", 1)
+	})(udig)
+
+	// Execute.
+	resolutions := udig.Resolve(context.Background(), "example.com")
+
+	// Assert.
+	var queried []string
+	for _, res := range resolutions {
+		queried = append(queried, res.Query())
+	}
+	assert.Equal(t, []string{"example.com", "api.example.com"}, queried)
+}
+
+func Test_When_WithResolutionHandler_is_used_Then_each_resolution_is_handled_during_the_crawl(t *testing.T) {
+	// Mock.
+	var handled []Resolution
+	resolver := &mockDomainResolver{resolve: func(domain string) Resolution {
+		// The previous resolution must have been handled before we get here.
+		if domain == "api.example.com" {
+			assert.Len(t, handled, 1)
+		}
+		res := &HTTPResolution{ResolutionBase: &ResolutionBase{query: domain}}
+		if domain == "example.com" {
+			res.Headers = []HTTPHeader{{Name: "access-control-allow-origin", Value: []string{"https://api.example.com"}}}
+		}
+		return res
+	}}
+
+	// Setup.
+	udig := newUdigImpl()
+	udig.AddDomainResolver(resolver)
+	WithResolutionHandler(func(res Resolution) { handled = append(handled, res) })(udig)
+
+	// Execute.
+	resolutions := udig.Resolve(context.Background(), "example.com")
+
+	// Assert.
+	assert.Len(t, resolutions, 2)
+	assert.Equal(t, resolutions, handled)
+}
+
+func Test_When_ResolveBatch_completes_Then_all_seeds_are_resolved(t *testing.T) {
+	// Mock.
+	resolver := &mockDomainResolver{resolve: func(domain string) Resolution {
+		return &HTTPResolution{ResolutionBase: &ResolutionBase{query: domain}}
+	}}
+	withMock := func(udig *udigImpl) {
+		udig.domainResolvers = []DomainResolver{resolver}
+		udig.ipResolvers = []IPResolver{}
+	}
+
+	// Execute.
+	results := ResolveBatch(context.Background(), []string{"example.com", "example.org"}, withMock)
+
+	// Assert.
+	assert.Len(t, results, 2)
+	assert.Equal(t, "example.com", results["example.com"][0].Query())
+	assert.Equal(t, "example.org", results["example.org"][0].Query())
+}
+
+func Test_When_ResolveBatch_context_is_cancelled_Then_no_seed_is_resolved(t *testing.T) {
+	// Mock.
+	resolver := &mockDomainResolver{resolve: func(domain string) Resolution {
+		return &HTTPResolution{ResolutionBase: &ResolutionBase{query: domain}}
+	}}
+	withMock := func(udig *udigImpl) {
+		udig.domainResolvers = []DomainResolver{resolver}
+		udig.ipResolvers = []IPResolver{}
+	}
+
+	// Setup.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Execute.
+	results := ResolveBatch(ctx, []string{"example.com", "example.org"}, withMock)
+
+	// Assert.
+	assert.Empty(t, results)
+}
+
+func Test_When_WithSharedBatchState_is_used_Then_shared_IP_is_resolved_only_once(t *testing.T) {
+	// Mock.
+	domainResolver := &mockDomainResolver{resolve: func(domain string) Resolution {
+		return &DNSResolution{
+			ResolutionBase: &ResolutionBase{query: domain},
+			Records: []DNSRecordPair{
+				{QueryType: dns.TypeA, Record: &DNSRecord{&dns.A{Hdr: dns.RR_Header{Rrtype: dns.TypeA}, A: net.ParseIP("192.0.2.1")}}},
+			},
+		}
+	}}
+	var resolved []string
+	resolvedMutex := sync.Mutex{}
+	ipResolver := &mockIPResolver{resolve: func(ip string) Resolution {
+		resolvedMutex.Lock()
+		resolved = append(resolved, ip)
+		resolvedMutex.Unlock()
+		return &BGPResolution{ResolutionBase: &ResolutionBase{query: ip}}
+	}}
+	withMock := func(udig *udigImpl) {
+		udig.domainResolvers = []DomainResolver{domainResolver}
+		udig.ipResolvers = []IPResolver{ipResolver}
+	}
+
+	// Execute.
+	results := ResolveBatch(context.Background(), []string{"example.com", "example.org"}, withMock, WithSharedBatchState())
+
+	// Assert.
+	assert.Len(t, results, 2)
+	assert.Equal(t, []string{"192.0.2.1"}, resolved)
+	assert.Len(t, append(results["example.com"], results["example.org"]...), 3)
+}
+
+type mockIPResolver struct {
+	IPResolver
+	resolve func(ip string) Resolution
+}
+
+func (resolver *mockIPResolver) ResolveIP(ip string) Resolution {
+	return resolver.resolve(ip)
+}
+
+func Test_When_private_IP_is_discovered_Then_it_is_resolved_only_with_WithIncludePrivateIPs(t *testing.T) {
+	// Mock.
+	domainResolver := &mockDomainResolver{resolve: func(domain string) Resolution {
+		return &DNSResolution{
+			ResolutionBase: &ResolutionBase{query: domain},
+			Records: []DNSRecordPair{
+				{QueryType: dns.TypeA, Record: &DNSRecord{&dns.A{Hdr: dns.RR_Header{Rrtype: dns.TypeA}, A: net.ParseIP("10.0.0.5")}}},
+			},
+		}
+	}}
+	var resolved []string
+	ipResolver := &mockIPResolver{resolve: func(ip string) Resolution {
+		resolved = append(resolved, ip)
+		return &BGPResolution{ResolutionBase: &ResolutionBase{query: ip}}
+	}}
+	withMock := WithDomainResolvers(domainResolver)
+	withIPMock := WithIPResolvers(ipResolver)
+
+	// Execute.
+	NewUdig(withMock, withIPMock).Resolve(context.Background(), "example.com")
+	skipped := resolved
+	resolved = nil
+	NewUdig(withMock, withIPMock, WithIncludePrivateIPs(true)).Resolve(context.Background(), "example.com")
+
+	// Assert.
+	assert.Empty(t, skipped)
+	assert.Equal(t, []string{"10.0.0.5"}, resolved)
+}
+
+func Test_When_ResolveIPBatch_completes_Then_each_IP_is_resolved_by_IP_resolvers_only(t *testing.T) {
+	// Mock.
+	domainResolver := &mockDomainResolver{resolve: func(domain string) Resolution {
+		return &HTTPResolution{ResolutionBase: &ResolutionBase{query: domain}}
+	}}
+	ipResolver := &mockIPResolver{resolve: func(ip string) Resolution {
+		return &PTRResolution{
+			ResolutionBase: &ResolutionBase{query: ip},
+			Records:        []PTRRecord{{Name: "host.example.com"}},
+		}
+	}}
+	withMock := func(udig *udigImpl) {
+		udig.domainResolvers = []DomainResolver{domainResolver}
+		udig.ipResolvers = []IPResolver{ipResolver}
+	}
+
+	// Setup.
+	ips, err := ReadIPList(strings.NewReader("192.0.2.1\n# comment\n\n198.51.100.0/31\nfoo\n"))
+	assert.NoError(t, err)
+
+	// Execute.
+	results := ResolveIPBatch(context.Background(), ips, false, withMock)
+
+	// Assert.
+	assert.Equal(t, []string{"192.0.2.1", "198.51.100.0", "198.51.100.1"}, ips)
+	assert.Len(t, results, 3)
+	for _, ip := range ips {
+		assert.Len(t, results[ip], 1)
+		assert.Equal(t, TypePTR, results[ip][0].Type())
+		assert.Equal(t, ip, results[ip][0].Query())
+	}
+}
+
+func Test_When_ResolveIPBatch_crawls_Then_PTR_hostnames_are_resolved_as_domains(t *testing.T) {
+	// Mock.
+	domainResolver := &mockDomainResolver{resolve: func(domain string) Resolution {
+		return &HTTPResolution{ResolutionBase: &ResolutionBase{query: domain}}
+	}}
+	ipResolver := &mockIPResolver{resolve: func(ip string) Resolution {
+		return &PTRResolution{
+			ResolutionBase: &ResolutionBase{query: ip},
+			Records:        []PTRRecord{{Name: "host.example.com"}},
+		}
+	}}
+	withMock := func(udig *udigImpl) {
+		udig.domainResolvers = []DomainResolver{domainResolver}
+		udig.ipResolvers = []IPResolver{ipResolver}
+	}
+
+	// Execute.
+	results := ResolveIPBatch(context.Background(), []string{"192.0.2.1"}, true, withMock)
+
+	// Assert.
+	assert.Len(t, results["192.0.2.1"], 2)
+	assert.Equal(t, TypePTR, results["192.0.2.1"][0].Type())
+	assert.Equal(t, TypeHTTP, results["192.0.2.1"][1].Type())
+	assert.Equal(t, "host.example.com", results["192.0.2.1"][1].Query())
+}
+
+func Test_When_ReadIPList_gets_a_big_CIDR_Then_it_is_truncated(t *testing.T) {
+	// Execute.
+	ips, err := ReadIPList(strings.NewReader("10.0.0.0/8"))
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.Len(t, ips, MaxCIDRAddresses)
+	assert.Equal(t, "10.0.0.0", ips[0])
+	assert.Equal(t, "10.0.3.255", ips[MaxCIDRAddresses-1])
+}
+
+func Test_When_ReadDomainList_completes_Then_unique_domains_are_returned_in_order(t *testing.T) {
+	// Execute.
+	domains, err := ReadDomainList(strings.NewReader("example.com\n# comment\n\n  Example.ORG.\nexample.com\n"))
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com", "example.org"}, domains)
+}
+
+func Test_When_resolution_is_wildcard_Then_its_domains_are_not_crawled(t *testing.T) {
+	// Setup.
+	udig := newUdigImpl()
+	resolutions := []Resolution{
+		&DNSResolution{ResolutionBase: &ResolutionBase{query: "foo.example.com"}, Wildcard: true},
+		&HTTPResolution{
+			ResolutionBase: &ResolutionBase{query: "foo.example.com"},
+			Headers:        []HTTPHeader{{Name: "access-control-allow-origin", Value: []string{"https://bar.example.com"}}},
+		},
+	}
+
+	// Execute.
+	domains := udig.getRelatedDomains(resolutions)
+
+	// Assert.
+	assert.Empty(t, domains)
+}
+
+func Test_When_Unicode_domain_is_resolved_Then_its_punycode_form_is_queried(t *testing.T) {
+	// Mock.
+	var queried []string
+	resolver := &mockDomainResolver{resolve: func(domain string) Resolution {
+		queried = append(queried, domain)
+		return &HTTPResolution{ResolutionBase: &ResolutionBase{query: domain}}
+	}}
+
+	// Setup.
+	udig := newUdigImpl()
+	udig.AddDomainResolver(resolver)
+
+	// Execute.
+	resolutions := udig.Resolve(context.Background(), "пример.рф")
+
+	// Assert.
+	assert.Equal(t, []string{"xn--e1afmkfd.xn--p1ai"}, queried)
+	assert.Equal(t, "xn--e1afmkfd.xn--p1ai", resolutions[0].Query())
+}
+
+// inFlightCounter tracks the peak number of concurrent calls.
+type inFlightCounter struct {
+	mutex    sync.Mutex
+	inFlight int
+	peak     int
+}
+
+func (counter *inFlightCounter) track() {
+	counter.mutex.Lock()
+	counter.inFlight++
+	if counter.inFlight > counter.peak {
+		counter.peak = counter.inFlight
+	}
+	counter.mutex.Unlock()
+
+	time.Sleep(2 * time.Millisecond)
+
+	counter.mutex.Lock()
+	counter.inFlight--
+	counter.mutex.Unlock()
+}
+
+type countingTransport struct {
+	counter *inFlightCounter
+}
+
+func (transport *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport.counter.track()
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+func Test_When_WithMaxConcurrency_is_used_Then_calls_in_flight_never_exceed_the_cap(t *testing.T) {
+	// Mock.
+	counter := &inFlightCounter{}
+	chain := map[string]string{"example.com": "a.example.com", "a.example.com": "b.example.com"}
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		counter.track()
+		msg := &dns.Msg{}
+		if next, ok := chain[domain]; ok && qType == dns.TypeA {
+			msg.Answer = append(msg.Answer, &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: dns.Fqdn(domain), Rrtype: dns.TypeCNAME, Class: dns.ClassINET},
+				Target: dns.Fqdn(next),
+			})
+		}
+		return msg, nil
+	}
+
+	dnsResolver := NewDNSResolver()
+	dnsResolver.NameServer = "127.0.0.1:53"
+	httpResolver := NewHTTPResolver()
+	httpResolver.Client = &http.Client{Transport: &countingTransport{counter: counter}}
+
+	// Setup.
+	udig := newUdigImpl()
+	udig.AddDomainResolver(dnsResolver)
+	udig.AddDomainResolver(httpResolver)
+	WithMaxConcurrency(3)(udig)
+
+	// Execute.
+	resolutions := udig.Resolve(context.Background(), "example.com")
+
+	// Assert.
+	queried := map[string]bool{}
+	for _, res := range resolutions {
+		queried[res.Query()] = true
+	}
+	assert.Equal(t, map[string]bool{"example.com": true, "a.example.com": true, "b.example.com": true}, queried)
+	assert.LessOrEqual(t, counter.peak, 3)
+}
+
+func mockSPFZone() {
+	txts := map[string]string{
+		"example.com":           "v=spf1 include:_spf.mailer.net -all",
+		"_spf.mailer.net":       "v=spf1 include:_netblocks.mailer.net ~all",
+		"_netblocks.mailer.net": "v=spf1 include:_spf.mailer.net ip4:192.0.2.0/24 ~all",
+	}
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		msg := &dns.Msg{}
+		if txt, ok := txts[domain]; ok && qType == dns.TypeTXT {
+			msg.Answer = append(msg.Answer, &dns.TXT{Hdr: dns.RR_Header{Name: dns.Fqdn(domain), Rrtype: dns.TypeTXT, Class: dns.ClassINET}, Txt: []string{txt}})
+		}
+		if domain == "_spf.mailer.net" && qType == dns.TypeMX {
+			msg.Answer = append(msg.Answer, &dns.MX{Hdr: dns.RR_Header{Name: dns.Fqdn(domain), Rrtype: dns.TypeMX, Class: dns.ClassINET}, Mx: "mx.mailer.net."})
+		}
+		return msg, nil
+	}
+}
+
+func Test_When_WithTXTReferences_is_used_Then_SPF_includes_are_crawled_within_scope(t *testing.T) {
+	// Mock.
+	mockSPFZone()
+	resolver := NewDNSResolver()
+	resolver.NameServer = "127.0.0.1:53"
+
+	// Setup.
+	udig := newUdigImpl()
+	udig.AddDomainResolver(resolver)
+	WithTXTReferences()(udig)
+
+	// Execute.
+	resolutions := udig.Resolve(context.Background(), "example.com")
+
+	// Assert.
+	var queried []string
+	for _, res := range resolutions {
+		queried = append(queried, res.Query())
+	}
+	assert.Equal(t, []string{"example.com", "_spf.mailer.net", "_netblocks.mailer.net"}, queried)
+}
+
+func Test_When_WithTXTReferences_is_not_used_Then_SPF_includes_are_not_crawled(t *testing.T) {
+	// Mock.
+	mockSPFZone()
+	resolver := NewDNSResolver()
+	resolver.NameServer = "127.0.0.1:53"
+
+	// Setup.
+	udig := newUdigImpl()
+	udig.AddDomainResolver(resolver)
+
+	// Execute.
+	resolutions := udig.Resolve(context.Background(), "example.com")
+
+	// Assert.
+	assert.Len(t, resolutions, 1)
+}
+
+func Test_When_WithSPFOnlyTXTIPs_is_used_Then_only_SPF_IPs_are_resolved(t *testing.T) {
+	// Mock.
+	txts := []string{"v=spf1 ip4:192.0.2.10 -all", "v=DKIM1; k=rsa; n=test key from 198.51.100.7"}
+	queryOneCallback = func(ctx context.Context, domain string, qType uint16, nameServer string, client *dns.Client) (*dns.Msg, error) {
+		msg := &dns.Msg{}
+		if domain == "example.com" && qType == dns.TypeTXT {
+			for _, txt := range txts {
+				msg.Answer = append(msg.Answer, &dns.TXT{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeTXT, Class: dns.ClassINET}, Txt: []string{txt}})
+			}
+		}
+		return msg, nil
+	}
+	var resolved []string
+	var mutex sync.Mutex
+	ipResolver := &mockIPResolver{resolve: func(ip string) Resolution {
+		mutex.Lock()
+		resolved = append(resolved, ip)
+		mutex.Unlock()
+		return &BGPResolution{ResolutionBase: &ResolutionBase{query: ip}}
+	}}
+	dnsResolver := NewDNSResolver()
+	dnsResolver.NameServer = "127.0.0.1:53"
+	options := []Option{WithDomainResolvers(dnsResolver), WithIPResolvers(ipResolver)}
+
+	// Execute.
+	NewUdig(options...).Resolve(context.Background(), "example.com")
+	unrestricted := resolved
+	resolved = nil
+	NewUdig(append(options, WithSPFOnlyTXTIPs())...).Resolve(context.Background(), "example.com")
+
+	// Assert.
+	assert.ElementsMatch(t, []string{"192.0.2.10", "198.51.100.7"}, unrestricted)
+	assert.Equal(t, []string{"192.0.2.10"}, resolved)
+}
+
+func Test_When_WithRateLimit_is_used_Then_dispatches_are_spaced_out(t *testing.T) {
+	// Mock.
+	var mutex sync.Mutex
+	var dispatched []time.Time
+	resolver := &mockDomainResolver{resolve: func(domain string) Resolution {
+		mutex.Lock()
+		dispatched = append(dispatched, time.Now())
+		mutex.Unlock()
+
+		res := &HTTPResolution{ResolutionBase: &ResolutionBase{query: domain}}
+		if domain == "example.com" {
+			res.Headers = []HTTPHeader{{Name: "access-control-allow-origin", Value: []string{"https://api.example.com"}}}
+		}
+		return res
+	}}
+
+	// Setup.
+	udig := newUdigImpl()
+	udig.AddDomainResolver(resolver)
+	udig.AddDomainResolver(resolver)
+	WithRateLimit(20)(udig)
+
+	// Execute.
+	started := time.Now()
+	resolutions := udig.Resolve(context.Background(), "example.com")
+
+	// Assert.
+	assert.Len(t, resolutions, 4)
+	assert.Len(t, dispatched, 4)
+	// The first dispatch is immediate, the other 3 are 50 ms apart each.
+	assert.GreaterOrEqual(t, int64(time.Since(started)), int64(150*time.Millisecond))
+}
+
+func Test_When_context_is_cancelled_during_rate_limiting_Then_no_more_IP_resolvers_are_dispatched(t *testing.T) {
+	// Mock.
+	var mutex sync.Mutex
+	var resolved []string
+	ipResolver := &mockIPResolver{resolve: func(ip string) Resolution {
+		mutex.Lock()
+		resolved = append(resolved, ip)
+		mutex.Unlock()
+		return &BGPResolution{ResolutionBase: &ResolutionBase{query: ip}}
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Setup.
+	udig := newUdigImpl()
+	udig.AddIPResolver(ipResolver)
+	udig.AddIPResolver(ipResolver)
+	WithRateLimit(20)(udig)
+
+	// Execute.
+	resolutions := udig.resolveOneIP(ctx, "192.0.2.10")
+
+	// Assert.
+	assert.Empty(t, resolutions)
+	assert.Empty(t, resolved)
+}
+
+func Test_When_WithMaxQueries_is_used_Then_runaway_crawl_stops_with_partial_results(t *testing.T) {
+	// Mock.
+	var handled int
+	resolver := &mockDomainResolver{resolve: func(domain string) Resolution {
+		// Every domain leads to yet another one.
+		return &HTTPResolution{
+			ResolutionBase: &ResolutionBase{query: domain},
+			Headers:        []HTTPHeader{{Name: "access-control-allow-origin", Value: []string{"https://a." + domain}}},
+		}
+	}}
+
+	// Setup.
+	udig := newUdigImpl()
+	udig.AddDomainResolver(resolver)
+	WithMaxQueries(3)(udig)
+	WithResolutionHandler(func(res Resolution) { handled++ })(udig)
+
+	// Execute.
+	resolutions := udig.Resolve(context.Background(), "example.com")
+
+	// Assert.
+	assert.Len(t, resolutions, 3)
+	assert.Equal(t, "a.a.example.com", resolutions[2].Query())
+	assert.Equal(t, 3, handled)
+}
+
+func Test_When_rate_limit_is_not_set_Then_waiting_is_a_no_op(t *testing.T) {
+	// Setup.
+	limiter := newRateLimiter(0)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Execute & Assert.
+	assert.Nil(t, limiter)
+	assert.True(t, limiter.wait(context.Background()))
+	assert.False(t, limiter.wait(cancelled))
+}
+
+func Test_When_DiscoveredDomains_and_IPs_are_collected_Then_they_are_unique_and_sorted(t *testing.T) {
+	// Setup.
+	resolutions := []Resolution{
+		&HTTPResolution{
+			ResolutionBase: &ResolutionBase{query: "www.example.com"},
+			Headers:        []HTTPHeader{{Name: "access-control-allow-origin", Value: []string{"https://api.example.com", "https://EXAMPLE.com"}}},
+		},
+		&DNSResolution{
+			ResolutionBase: &ResolutionBase{query: "example.com"},
+			Records: []DNSRecordPair{
+				{QueryType: dns.TypeAAAA, Record: &DNSRecord{&dns.AAAA{Hdr: dns.RR_Header{Rrtype: dns.TypeAAAA}, AAAA: net.ParseIP("2001:db8::1")}}},
+				{QueryType: dns.TypeA, Record: &DNSRecord{&dns.A{Hdr: dns.RR_Header{Rrtype: dns.TypeA}, A: net.ParseIP("192.0.2.1")}}},
+			},
+		},
+		&BGPResolution{ResolutionBase: &ResolutionBase{query: "10.0.0.1"}},
+	}
+
+	// Execute.
+	domains := DiscoveredDomains(resolutions)
+	ips := DiscoveredIPs(resolutions)
+
+	// Assert.
+	assert.Equal(t, []string{"example.com", "api.example.com"}, domains)
+	assert.Equal(t, []string{"10.0.0.1", "192.0.2.1", "2001:db8::1"}, ips)
+}
+
+func Test_When_WithClock_is_used_Then_CT_window_and_certificate_expiry_follow_it(t *testing.T) {
+	// Mock.
+	ctServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[
+			{"id": 1, "issuer_name": "CN=R3", "name_value": "old.example.com", "entry_timestamp": "2099-03-01T00:00:00"},
+			{"id": 2, "issuer_name": "CN=R3", "name_value": "new.example.com", "entry_timestamp": "2099-09-01T00:00:00"}
+		]`))
+	}))
+	defer ctServer.Close()
+
+	origURL := CTApiUrl
+	CTApiUrl = ctServer.URL
+	defer func() { CTApiUrl = origURL }()
+
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsServer.Close()
+
+	// The test certificate of httptest expires in 2084.
+	clock := &fixedClock{now: time.Date(2100, 6, 1, 0, 0, 0, 0, time.UTC)}
+
+	// Setup.
+	ctResolver := NewCTResolver()
+	tlsResolver := NewTLSResolver()
+	tlsResolver.Ports = []int{portOf(tlsServer)}
+	udig := newUdigImpl()
+	udig.AddDomainResolver(ctResolver)
+	udig.AddDomainResolver(tlsResolver)
+	WithClock(clock)(udig)
+
+	// Execute.
+	ctResolution := ctResolver.ResolveDomain(context.Background(), "example.com").(*CTResolution)
+	tlsResolution := tlsResolver.ResolveDomain(context.Background(), "127.0.0.1").(*TLSResolution)
+
+	// Assert.
+	assert.Equal(t, "2099-06-01", CTLogFromDate(clock.Now()))
+	assert.Len(t, ctResolution.Logs, 1)
+	assert.Equal(t, "new.example.com", ctResolution.Logs[0].NameValue)
+	assert.Len(t, tlsResolution.Certificates, 1)
+	assert.True(t, tlsResolution.Certificates[0].Expired)
+	assert.Less(t, tlsResolution.Certificates[0].DaysUntilExpiry, 0)
+}
+
+func Test_When_WHOIS_contacts_share_a_registrar_Then_they_are_aggregated(t *testing.T) {
+	// Setup.
+	contact := func(registrar string, registrant string, created string) WhoisContact {
+		return WhoisContact{Registrar: registrar, Registrant: registrant, CreationDate: created}
+	}
+	resolutions := []Resolution{
+		&WhoisResolution{ResolutionBase: &ResolutionBase{query: "example.com"}, Contacts: []WhoisContact{contact("Acme Registrar", "Example Inc.", "2001-01-01")}},
+		&WhoisResolution{ResolutionBase: &ResolutionBase{query: "example.net"}, Contacts: []WhoisContact{contact("ACME Registrar", "Example Inc.", "2005-05-05")}},
+		&WhoisResolution{ResolutionBase: &ResolutionBase{query: "api.example.com"}, Contacts: []WhoisContact{contact("Acme Registrar", "Example Inc.", "2001-01-01"), {NSSet: "ns.example.com"}}},
+		&WhoisResolution{ResolutionBase: &ResolutionBase{query: "example.org"}, Contacts: []WhoisContact{contact("Other Registrar", "Example Inc.", "2010-10-10")}},
+		&HTTPResolution{ResolutionBase: &ResolutionBase{query: "example.com"}},
+	}
+
+	// Execute.
+	summaries := AggregateWhoisContacts(resolutions)
+
+	// Assert.
+	assert.Equal(t, []WhoisContactSummary{
+		{WhoisContact: WhoisContact{Registrar: "Acme Registrar", Registrant: "Example Inc."}, Domains: []string{"example.com", "api.example.com", "example.net"}},
+		{WhoisContact: WhoisContact{Registrar: "Other Registrar", Registrant: "Example Inc."}, Domains: []string{"example.org"}},
+	}, summaries)
+}
+
+func Test_When_GEO_has_no_DB_and_HTTP_times_out_Then_diagnostics_mention_both(t *testing.T) {
+	// Setup.
+	geoResolver := NewGeoResolver()
+	geoResolver.SetBackend(NewGeoBackend(filepath.Join(os.TempDir(), "udig-missing.BIN")))
+	httpResolution := &HTTPResolution{ResolutionBase: &ResolutionBase{query: "example.com"}}
+	httpResolution.addError(errors.New("https://example.com: context deadline exceeded (Client.Timeout exceeded while awaiting headers)"))
+	tlsResolution := &TLSResolution{ResolutionBase: &ResolutionBase{query: "example.com"}}
+	tlsResolution.addError(errors.New("connection refused"))
+	resolutions := []Resolution{
+		geoResolver.ResolveIP("192.0.2.1"),
+		geoResolver.ResolveIP("192.0.2.2"),
+		httpResolution,
+		tlsResolution,
+		&DNSResolution{ResolutionBase: &ResolutionBase{query: "example.com"}},
+	}
+
+	// Execute.
+	diagnostics := Diagnose(resolutions)
+
+	// Assert.
+	assert.Len(t, diagnostics, 3)
+	assert.Equal(t, "GEO: disabled (no DB)", diagnostics[0].String())
+	assert.Equal(t, "HTTP: 1 timeouts", diagnostics[1].String())
+	assert.Equal(t, "TLS: 1 failures", diagnostics[2].String())
+}
@@ -1,22 +1,37 @@
 package udig
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	_ "embed"
+	"encoding/hex"
+	"io"
 	"net"
+	"net/http"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/domainr/whois"
 	"github.com/miekg/dns"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
 )
 
 const (
-	// Note: there is no support for unicode domains.
+	// Note: unicode domains are matched in their punycode form (see DissectDomainsFromString).
 	_char         = `[a-z0-9]`
 	_charOrSymbol = `[a-z0-9-_]`
 	_domainWord   = _char + `(?:` + _charOrSymbol + `*` + _char + `)?`
 
-	// TLDs by IANA, sorted by longest to shortest. Source: https://data.iana.org/TLD/tlds-alpha-by-domain.txt
-	_tlds   = "northwesternmutual|travelersinsurance|americanexpress|kerryproperties|sandvikcoromant|afamilycompany|americanfamily|bananarepublic|cancerresearch|cookingchannel|kerrylogistics|weatherchannel|international|lifeinsurance|spreadbetting|travelchannel|wolterskluwer|construction|lplfinancial|scholarships|versicherung|accountants|barclaycard|blackfriday|blockbuster|bridgestone|calvinklein|contractors|creditunion|engineering|enterprises|foodnetwork|investments|kerryhotels|lamborghini|motorcycles|olayangroup|photography|playstation|productions|progressive|redumbrella|rightathome|williamhill|accountant|apartments|associates|basketball|bnpparibas|boehringer|capitalone|consulting|creditcard|cuisinella|eurovision|extraspace|foundation|healthcare|immobilien|industries|management|mitsubishi|nationwide|newholland|nextdirect|onyourside|properties|protection|prudential|realestate|republican|restaurant|schaeffler|swiftcover|tatamotors|technology|telefonica|university|vistaprint|vlaanderen|volkswagen|accenture|alfaromeo|allfinanz|amsterdam|analytics|aquarelle|barcelona|bloomberg|christmas|community|directory|education|equipment|fairwinds|financial|firestone|fresenius|frontdoor|fujixerox|furniture|goldpoint|hisamitsu|homedepot|homegoods|homesense|honeywell|institute|insurance|kuokgroup|ladbrokes|lancaster|landrover|lifestyle|marketing|marshalls|melbourne|microsoft|panasonic|passagens|pramerica|richardli|scjohnson|shangrila|solutions|statebank|statefarm|stockholm|travelers|vacations|yodobashi|abudhabi|airforce|allstate|attorney|barclays|barefoot|bargains|baseball|boutique|bradesco|broadway|brussels|budapest|builders|business|capetown|catering|catholic|chrysler|cipriani|cityeats|cleaning|clinique|clothing|commbank|computer|delivery|deloitte|democrat|diamonds|discount|discover|download|engineer|ericsson|esurance|etisalat|everbank|exchange|feedback|fidelity|firmdale|football|frontier|goodyear|grainger|graphics|guardian|hdfcbank|helsinki|holdings|hospital|infiniti|ipiranga|istanbul|jpmorgan|lighting|lundbeck|marriott|maserati|mckinsey|memorial|merckmsd|mortgage|movistar|observer|partners|pharmacy|pictures|plumbing|property|redstone|reliance|saarland|samsclub|security|services|shopping|showtime|softbank|software|stcgroup|supplies|symantec|training|uconnect|vanguard|ventures|verisign|woodside|yokohama|abogado|academy|agakhan|alibaba|android|athleta|auction|audible|auspost|avianca|banamex|bauhaus|bentley|bestbuy|booking|brother|bugatti|capital|caravan|careers|cartier|channel|charity|chintai|citadel|clubmed|college|cologne|comcast|company|compare|contact|cooking|corsica|country|coupons|courses|cricket|cruises|dentist|digital|domains|exposed|express|farmers|fashion|ferrari|ferrero|finance|fishing|fitness|flights|florist|flowers|forsale|frogans|fujitsu|gallery|genting|godaddy|grocery|guitars|hamburg|hangout|hitachi|holiday|hosting|hoteles|hotmail|hyundai|iselect|ismaili|jewelry|juniper|kitchen|komatsu|lacaixa|lancome|lanxess|lasalle|latrobe|leclerc|liaison|limited|lincoln|markets|metlife|monster|netbank|netflix|network|neustar|okinawa|oldnavy|organic|origins|philips|pioneer|politie|realtor|recipes|rentals|reviews|rexroth|samsung|sandvik|schmidt|schwarz|science|shiksha|shriram|singles|staples|starhub|storage|support|surgery|systems|temasek|theater|theatre|tickets|tiffany|toshiba|trading|walmart|wanggou|watches|weather|website|wedding|whoswho|windows|winners|xfinity|yamaxun|youtube|zuerich|abarth|abbott|abbvie|africa|agency|airbus|airtel|alipay|alsace|alstom|anquan|aramco|author|bayern|beauty|berlin|bharti|bostik|boston|broker|camera|career|caseih|casino|center|chanel|chrome|church|circle|claims|clinic|coffee|comsec|condos|coupon|credit|cruise|dating|datsun|dealer|degree|dental|design|direct|doctor|dunlop|dupont|durban|emerck|energy|estate|events|expert|family|flickr|futbol|gallup|garden|george|giving|global|google|gratis|health|hermes|hiphop|hockey|hotels|hughes|imamat|insure|intuit|jaguar|joburg|juegos|kaufen|kinder|kindle|kosher|lancia|latino|lawyer|lefrak|living|locker|london|luxury|madrid|maison|makeup|market|mattel|mobile|mobily|monash|mormon|moscow|museum|mutual|nagoya|natura|nissan|nissay|norton|nowruz|office|olayan|online|oracle|orange|otsuka|pfizer|photos|physio|piaget|pictet|quebec|racing|realty|reisen|repair|report|review|rocher|rogers|ryukyu|safety|sakura|sanofi|school|schule|search|secure|select|shouji|soccer|social|stream|studio|supply|suzuki|swatch|sydney|taipei|taobao|target|tattoo|tennis|tienda|tjmaxx|tkmaxx|toyota|travel|unicom|viajes|viking|villas|virgin|vision|voting|voyage|vuelos|walter|warman|webcam|xihuan|yachts|yandex|zappos|actor|adult|aetna|amfam|amica|apple|archi|audio|autos|azure|baidu|beats|bible|bingo|black|boats|bosch|build|canon|cards|chase|cheap|cisco|citic|click|cloud|coach|codes|crown|cymru|dabur|dance|deals|delta|dodge|drive|dubai|earth|edeka|email|epson|faith|fedex|final|forex|forum|gallo|games|gifts|gives|glade|glass|globo|gmail|green|gripe|group|gucci|guide|homes|honda|horse|house|hyatt|ikano|intel|irish|iveco|jetzt|koeln|kyoto|lamer|lease|legal|lexus|lilly|linde|lipsy|lixil|loans|locus|lotte|lotto|lupin|macys|mango|media|miami|money|mopar|movie|nadex|nexus|nikon|ninja|nokia|nowtv|omega|onion|osaka|paris|parts|party|phone|photo|pizza|place|poker|praxi|press|prime|promo|quest|radio|rehab|reise|ricoh|rocks|rodeo|rugby|salon|sener|seven|sharp|shell|shoes|skype|sling|smart|smile|solar|space|sport|stada|store|study|style|sucks|swiss|tatar|tires|tirol|tmall|today|tokyo|tools|toray|total|tours|trade|trust|tunes|tushu|ubank|vegas|video|vodka|volvo|wales|watch|weber|weibo|works|world|xerox|yahoo|aarp|able|adac|aero|aigo|akdn|ally|amex|arab|army|arpa|arte|asda|asia|audi|auto|baby|band|bank|bbva|beer|best|bike|bing|blog|blue|bofa|bond|book|buzz|cafe|call|camp|care|cars|casa|case|cash|cbre|cern|chat|citi|city|club|cool|coop|cyou|data|date|dclk|deal|dell|desi|diet|dish|docs|doha|duck|duns|dvag|erni|fage|fail|fans|farm|fast|fiat|fido|film|fire|fish|flir|food|ford|free|fund|game|gbiz|gent|ggee|gift|gmbh|gold|golf|goog|guge|guru|hair|haus|hdfc|help|here|hgtv|host|hsbc|icbc|ieee|imdb|immo|info|itau|java|jeep|jobs|jprs|kddi|kiwi|kpmg|kred|land|lego|lgbt|lidl|life|like|limo|link|live|loan|loft|love|ltda|luxe|maif|meet|meme|menu|mini|mint|mobi|moda|moto|name|navy|news|next|nico|nike|ollo|open|page|pars|pccw|pics|ping|pink|play|plus|pohl|porn|post|prod|prof|qpon|raid|read|reit|rent|rest|rich|rmit|room|rsvp|ruhr|safe|sale|sarl|save|saxo|scor|scot|seat|seek|sexy|shaw|shia|shop|show|silk|sina|site|skin|sncf|sohu|song|sony|spot|star|surf|talk|taxi|team|tech|teva|tiaa|tips|town|toys|tube|vana|visa|viva|vivo|vote|voto|wang|weir|wien|wiki|wine|work|xbox|yoga|zara|zero|zone|aaa|abb|abc|aco|ads|aeg|afl|aig|anz|aol|app|art|aws|axa|bar|bbc|bbt|bcg|bcn|bet|bid|bio|biz|bms|bmw|bnl|bom|boo|bot|box|buy|bzh|cab|cal|cam|car|cat|cba|cbn|cbs|ceb|ceo|cfa|cfd|com|crs|csc|dad|day|dds|dev|dhl|diy|dnp|dog|dot|dtv|dvr|eat|eco|edu|esq|eus|fan|fit|fly|foo|fox|frl|ftr|fun|fyi|gal|gap|gdn|gea|gle|gmo|gmx|goo|gop|got|gov|hbo|hiv|hkt|hot|how|ibm|ice|icu|ifm|inc|ing|ink|int|ist|itv|jcb|jcp|jio|jll|jmp|jnj|jot|joy|kfh|kia|kim|kpn|krd|lat|law|lds|llc|lol|lpl|ltd|man|map|mba|med|men|mil|mit|mlb|mls|mma|moe|moi|mom|mov|msd|mtn|mtr|nab|nba|nec|net|new|nfl|ngo|nhk|now|nra|nrw|ntt|nyc|obi|off|one|ong|onl|ooo|org|ott|ovh|pay|pet|phd|pid|pin|pnc|pro|pru|pub|pwc|qvc|red|ren|ril|rio|rip|run|rwe|sap|sas|sbi|sbs|sca|scb|ses|sew|sex|sfr|ski|sky|soy|srl|srt|stc|tab|tax|tci|tdk|tel|thd|tjx|top|trv|tui|tvs|ubs|uno|uol|ups|vet|vig|vin|vip|wed|win|wme|wow|wtc|wtf|xin|xxx|xyz|you|yun|zip|ac|ad|ae|af|ag|ai|al|am|ao|aq|ar|as|at|au|aw|ax|az|ba|bb|bd|be|bf|bg|bh|bi|bj|bm|bn|bo|br|bs|bt|bv|bw|by|bz|ca|cc|cd|cf|cg|ch|ci|ck|cl|cm|cn|co|cr|cu|cv|cw|cx|cy|cz|de|dj|dk|dm|do|dz|ec|ee|eg|er|es|et|eu|fi|fj|fk|fm|fo|fr|ga|gb|gd|ge|gf|gg|gh|gi|gl|gm|gn|gp|gq|gr|gs|gt|gu|gw|gy|hk|hm|hn|hr|ht|hu|id|ie|il|im|in|io|iq|ir|is|it|je|jm|jo|jp|ke|kg|kh|ki|km|kn|kp|kr|kw|ky|kz|la|lb|lc|li|lk|lr|ls|lt|lu|lv|ly|ma|mc|md|me|mg|mh|mk|ml|mm|mn|mo|mp|mq|mr|ms|mt|mu|mv|mw|mx|my|mz|na|nc|ne|nf|ng|ni|nl|no|np|nr|nu|nz|om|pa|pe|pf|pg|ph|pk|pl|pm|pn|pr|ps|pt|pw|py|qa|re|ro|rs|ru|rw|sa|sb|sc|sd|se|sg|sh|si|sj|sk|sl|sm|sn|so|sr|ss|st|su|sv|sx|sy|sz|tc|td|tf|tg|th|tj|tk|tl|tm|tn|to|tr|tt|tv|tw|tz|ua|ug|uk|us|uy|uz|va|vc|ve|vg|vi|vn|vu|wf|ws|ye|yt|za|zm|zw"
-	_domain = `\b(?i)(?:` + _domainWord + `\.)+(?:` + _tlds + `)\b`
+	// Punycoded TLDs (e.g. "xn--p1ai" for "рф") are not listed, any of them is accepted.
+	_idnTld = `xn--` + _char + `(?:` + _charOrSymbol + `*` + _char + `)?`
+	// Special-use TLDs not delegated by IANA (RFC 7686).
+	_specialTlds = "onion"
 
 	// IPv4 and IPv6.
 	_octet = `(25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9][0-9]|[0-9])`
@@ -35,14 +50,50 @@ var (
 		return isDomainRelated(domainA, domainB, true)
 	}
 	IsDomainRelated = DefaultDomainRelation
-	domainPattern   = regexp.MustCompile(_domain)
-	ipPattern       = regexp.MustCompile(_ip)
+	// Like idna.Lookup, but tolerates underscores (e.g. "_dmarc").
+	idnaProfile   = idna.New(idna.MapForLookup(), idna.StrictDomainName(false))
+	domainPattern = compileDomainPattern(tldList)
+	// Candidates of internationalized domains, which need to be punycoded before matching.
+	unicodeDomainPattern = regexp.MustCompile(`[\p{L}\p{N}_-]+(?:\.[\p{L}\p{N}_-]+)+`)
+	ipPattern            = regexp.MustCompile(_ip)
+	// Private (RFC 1918, RFC 4193), loopback and link-local networks.
+	privateNetworks = parseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "127.0.0.0/8",
+		"169.254.0.0/16", "fc00::/7", "::1/128", "fe80::/10")
 )
 
 type DomainRelationFn func(domainA string, domainB string) bool
 
-func init() {
-	domainPattern.Longest()
+//go:generate go run tlds_gen.go
+
+// Delegated TLDs, one per line. The header line of tlds.txt tells where they come from,
+// tlds_gen.go refreshes them from IANA.
+//
+//go:embed tlds.txt
+var tldList string
+
+// compileDomainPattern returns a pattern matching domains under given TLDs (one per line, "#" starts a comment).
+// TLDs are deduplicated and sorted by longest to shortest, so the longest one wins (e.g. "community" over "com").
+func compileDomainPattern(tldList string) *regexp.Regexp {
+	tlds := strings.Split(_specialTlds, "|")
+	seen := map[string]bool{}
+	for _, line := range strings.Split(tldList, "\n") {
+		tld := strings.ToLower(strings.TrimSpace(line))
+		if tld == "" || strings.HasPrefix(tld, "#") || strings.HasPrefix(tld, "xn--") || seen[tld] {
+			continue
+		}
+		seen[tld] = true
+		tlds = append(tlds, tld)
+	}
+	sort.Slice(tlds, func(i, j int) bool {
+		if len(tlds[i]) != len(tlds[j]) {
+			return len(tlds[i]) > len(tlds[j])
+		}
+		return tlds[i] < tlds[j]
+	})
+
+	pattern := regexp.MustCompile(`\b(?i)(?:` + _domainWord + `\.)+(?:` + strings.Join(tlds, "|") + `|` + _idnTld + `)\b`)
+	pattern.Longest()
+	return pattern
 }
 
 func DissectDomainsFromStrings(haystacks []string) (domains []string) {
@@ -52,7 +103,19 @@ func DissectDomainsFromStrings(haystacks []string) (domains []string) {
 	return domains
 }
 
+// DissectDomainsFromString returns all domains found in a given string in their clean ASCII form
+// (see CleanDomain). Internationalized domains (e.g. "münchen.de") are punycoded.
 func DissectDomainsFromString(haystack string) []string {
+	if !isASCII(haystack) {
+		haystack = unicodeDomainPattern.ReplaceAllStringFunc(haystack, func(candidate string) string {
+			if isASCII(candidate) {
+				return candidate
+			}
+			ascii, _ := ToASCIIDomain(candidate)
+			return ascii
+		})
+	}
+
 	domains := domainPattern.FindAllString(haystack, -1)
 	for i := range domains {
 		domains[i] = CleanDomain(domains[i])
@@ -71,6 +134,20 @@ func DissectIpsFromString(haystack string) []string {
 	return ipPattern.FindAllString(haystack, -1)
 }
 
+// acquire takes a slot in a given semaphore unless the context is done first.
+// Returns false if the slot could not be acquired.
+func acquire(ctx context.Context, semaphore chan struct{}) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case semaphore <- struct{}{}:
+		return true
+	}
+}
+
 func IsSubdomain(domain string) bool {
 	return dns.CountLabel(domain) >= 3
 }
@@ -84,7 +161,93 @@ func ParentDomainOf(domain string) string {
 	return strings.Join(labels[1:], ".")
 }
 
+// ToASCIIDomain converts a given (possibly internationalized) domain to its
+// ASCII form, i.e. Unicode labels are punycoded (e.g. "пример.рф" -> "xn--e1afmkfd.xn--p1ai").
+func ToASCIIDomain(domain string) (string, error) {
+	ascii, err := idnaProfile.ToASCII(domain)
+	if err != nil {
+		return domain, err
+	}
+	return ascii, nil
+}
+
+// ToUnicodeDomain converts a given punycoded domain back to its Unicode form for display
+// (e.g. "xn--e1afmkfd.xn--p1ai" -> "пример.рф"). Domains which cannot be converted are returned as is.
+func ToUnicodeDomain(domain string) string {
+	unicode, err := idnaProfile.ToUnicode(domain)
+	if err != nil {
+		return domain
+	}
+	return unicode
+}
+
+func isASCII(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// SortDomains sorts given domains in place for stable reporting: grouped by the
+// registrable domain (see registrableDomainOf), then by subdomain depth, then alphabetically.
+func SortDomains(domains []string) {
+	sort.SliceStable(domains, func(i, j int) bool {
+		regI, regJ := registrableDomainOf(domains[i]), registrableDomainOf(domains[j])
+		if regI != regJ {
+			return regI < regJ
+		}
+		depthI, depthJ := dns.CountLabel(domains[i]), dns.CountLabel(domains[j])
+		if depthI != depthJ {
+			return depthI < depthJ
+		}
+		return domains[i] < domains[j]
+	})
+}
+
+// SortIPs sorts given IP addresses in place numerically, IPv4 before IPv6.
+// Invalid addresses go last (alphabetically).
+func SortIPs(ips []string) {
+	sort.SliceStable(ips, func(i, j int) bool {
+		ipI, ipJ := net.ParseIP(ips[i]), net.ParseIP(ips[j])
+		if ipI == nil || ipJ == nil {
+			if ipI == nil && ipJ == nil {
+				return ips[i] < ips[j]
+			}
+			return ipJ == nil
+		}
+		isV4I, isV4J := ipI.To4() != nil, ipJ.To4() != nil
+		if isV4I != isV4J {
+			return isV4I
+		}
+		return bytes.Compare(ipI.To16(), ipJ.To16()) < 0
+	})
+}
+
+// registrableDomainOf returns the public suffix of a given domain plus one label (e.g. "example.com"
+// or "example.co.uk"). Public suffixes themselves are returned as they are.
+func registrableDomainOf(domain string) string {
+	domain = strings.TrimSuffix(domain, ".")
+	if registrable, err := publicsuffix.EffectiveTLDPlusOne(domain); err == nil {
+		return registrable
+	}
+	return domain
+}
+
+// isRegistrableDomain tells if a given domain is directly under a public suffix (e.g. "example.co.uk").
+func isRegistrableDomain(domain string) bool {
+	domain = strings.TrimSuffix(domain, ".")
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	return err == nil && registrable == domain
+}
+
+// CleanDomain normalizes a given domain: the root dot and the "*." and "www." prefixes are stripped,
+// the rest is lowercased. Internationalized domains are punycoded (see ToASCIIDomain).
 func CleanDomain(domain string) string {
+	if !isASCII(domain) {
+		domain, _ = ToASCIIDomain(domain)
+	}
 	domain = strings.TrimSuffix(domain, ".")
 	domain = strings.TrimPrefix(domain, "*.")
 	domain = strings.TrimPrefix(domain, "www.")
@@ -124,9 +287,134 @@ func isDomainRelated(domainA string, domainB string, strict bool) bool {
 	return related
 }
 
-// reverseIPv4 returns a given IPv4 address in ARPA-like rDNS form.
+// randomLabel returns a random DNS label, which is very unlikely to exist.
+func randomLabel() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// newHTTPClient creates an HTTP client with its own transport, which skips TLS verification.
+// The process-wide http.DefaultTransport is only used as a template, never modified.
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	transport.DialContext = (&net.Dialer{
+		Timeout:   DefaultTimeout,
+		KeepAlive: DefaultTimeout,
+	}).DialContext
+
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	transport.TLSHandshakeTimeout = DefaultTimeout
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   DefaultTimeout,
+	}
+}
+
+// withClientCertificate returns a copy of a given client, which presents a given certificate
+// to servers requesting one. The client's transport (possibly a limited one) is never modified.
+func withClientCertificate(client *http.Client, cert tls.Certificate) *http.Client {
+	return withTransport(client, "present a client certificate", func(transport *http.Transport) {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.Certificates = append(transport.TLSClientConfig.Certificates, cert)
+	})
+}
+
+// withDialContext returns a copy of a given client, which opens connections via a given dial function.
+// The client's transport (possibly a limited one) is never modified.
+func withDialContext(client *http.Client, dial DialContextFunc) *http.Client {
+	return withTransport(client, "use a custom dialer", func(transport *http.Transport) {
+		transport.DialContext = dial
+		// Otherwise TLS connections would bypass the dialer.
+		transport.DialTLSContext = nil
+		transport.Proxy = nil
+	})
+}
+
+// withWhoisDialContext returns a copy of a given WHOIS client, which opens connections via a given dial function.
+func withWhoisDialContext(client *whois.Client, dial DialContextFunc) *whois.Client {
+	if client == nil {
+		return client
+	}
+	modified := *client
+	modified.DialContext = dial
+	return &modified
+}
+
+// withTransport returns a copy of a given client with a copy of its transport modified by a given function.
+// If the transport is limited, the limit is kept. Custom transports cannot be modified (i.e. the action fails).
+func withTransport(client *http.Client, action string, modify func(transport *http.Transport)) *http.Client {
+	if client == nil {
+		return client
+	}
+
+	var transport *http.Transport
+	limited, isLimited := client.Transport.(*limitedTransport)
+	if isLimited {
+		transport, _ = limited.next.(*http.Transport)
+	} else if client.Transport == nil {
+		transport = http.DefaultTransport.(*http.Transport)
+	} else {
+		transport, _ = client.Transport.(*http.Transport)
+	}
+	if transport == nil {
+		LogErr("Cannot %s via a custom %T.", action, client.Transport)
+		return client
+	}
+
+	transport = transport.Clone()
+	modify(transport)
+
+	modified := *client
+	if isLimited {
+		modified.Transport = &limitedTransport{limiter: limited.limiter, next: transport}
+	} else {
+		modified.Transport = transport
+	}
+	return &modified
+}
+
+// realClock is a Clock telling the system time.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// clockNow returns the current time of a given clock (the real one if nil).
+func clockNow(clock Clock) time.Time {
+	if clock == nil {
+		clock = realClock{}
+	}
+	return clock.Now()
+}
+
+// uniqueStrings returns given strings without duplicates, keeping the original order.
+func uniqueStrings(values []string) (unique []string) {
+	seen := map[string]bool{}
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// reverseIPv4 returns a given IPv4 address in ARPA-like rDNS form, or empty string if it is not an IPv4 address.
+// Both 4-byte and 16-byte (IPv4-mapped) representations are accepted.
 func reverseIPv4(ip net.IP) string {
-	return uitoa(uint(ip[15])) + "." + uitoa(uint(ip[14])) + "." + uitoa(uint(ip[13])) + "." + uitoa(uint(ip[12]))
+	ip = ip.To4()
+	if ip == nil {
+		return ""
+	}
+	return uitoa(uint(ip[3])) + "." + uitoa(uint(ip[2])) + "." + uitoa(uint(ip[1])) + "." + uitoa(uint(ip[0]))
 }
 
 // reverseIPv6 returns a given IPv6 address in ARPA-like rDNS form.
@@ -161,3 +449,123 @@ func uitoa(val uint) string {
 	buf[i] = byte('0' + val)
 	return string(buf[i:])
 }
+
+// parseCIDRs parses given CIDRs, panics on an invalid one.
+func parseCIDRs(cidrs ...string) (networks []*net.IPNet) {
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			LogPanic("Invalid CIDR %s.", cidr)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// IsPrivateIP tells if a given IP is a private, loopback or link-local address.
+func IsPrivateIP(ip string) bool {
+	address := net.ParseIP(ip)
+	if address == nil {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxCIDRAddresses is a max number of addresses taken from a single CIDR (see ReadIPList).
+const MaxCIDRAddresses = 1024
+
+// ReadIPList reads IPs and CIDRs, one per line, and returns all the addresses they denote.
+// Blank lines and comments (starting with "#") are skipped, so are invalid entries.
+// CIDRs are expanded up to MaxCIDRAddresses addresses.
+func ReadIPList(reader io.Reader) (ips []string, err error) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		if ip := net.ParseIP(entry); ip != nil {
+			ips = append(ips, ip.String())
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			LogErr("'%s' does not appear like a valid IP or CIDR to me -> skipping.", entry)
+			continue
+		}
+		ips = append(ips, expandCIDR(network)...)
+	}
+	return uniqueStrings(ips), scanner.Err()
+}
+
+// ReadDomainList reads domains, one per line, and returns them in the order of appearance.
+// Blank lines and comments (starting with "#") are skipped, so are duplicates.
+func ReadDomainList(reader io.Reader) (domains []string, err error) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		domains = append(domains, strings.TrimSuffix(strings.ToLower(entry), "."))
+	}
+	return uniqueStrings(domains), scanner.Err()
+}
+
+// ReadBaseline reads items (e.g. domains and IPs) discovered in a previous run, one per line (see WriteBaseline).
+// Blank lines and comments (starting with "#") are skipped.
+func ReadBaseline(reader io.Reader) (map[string]bool, error) {
+	baseline := map[string]bool{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		item := strings.TrimSpace(scanner.Text())
+		if item == "" || strings.HasPrefix(item, "#") {
+			continue
+		}
+		baseline[item] = true
+	}
+	return baseline, scanner.Err()
+}
+
+// WriteBaseline writes given items one per line, so that they can be read by ReadBaseline.
+func WriteBaseline(writer io.Writer, items []string) error {
+	buffered := bufio.NewWriter(writer)
+	for _, item := range items {
+		if _, err := buffered.WriteString(item + "\n"); err != nil {
+			return err
+		}
+	}
+	return buffered.Flush()
+}
+
+// expandCIDR returns addresses of a given network, at most MaxCIDRAddresses of them.
+func expandCIDR(network *net.IPNet) (ips []string) {
+	ip := make(net.IP, len(network.IP))
+	copy(ip, network.IP)
+
+	for ; network.Contains(ip); incrementIP(ip) {
+		if len(ips) >= MaxCIDRAddresses {
+			LogErr("Network %s is too big, taking first %d addresses only.", network, MaxCIDRAddresses)
+			break
+		}
+		ips = append(ips, ip.String())
+	}
+	return ips
+}
+
+// incrementIP increments a given IP in place (wrapping around at the end of the address space).
+func incrementIP(ip net.IP) {
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			break
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package udig
 
 import (
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -42,6 +43,42 @@ func Test_DissectDomainsFrom_By_exotic_tld(t *testing.T) {
 	assert.Equal(t, "example.domain-hyphen.museum", domains[0])
 }
 
+func Test_DissectDomainsFrom_By_new_gtld(t *testing.T) {
+	// Execute.
+	domains := DissectDomainsFromString("Listen at https://example.music/ or https://example.spa/")
+
+	// Assert.
+	assert.Equal(t, []string{"example.music", "example.spa"}, domains)
+}
+
+func Test_DissectDomainsFrom_By_retired_gtld(t *testing.T) {
+	// Execute.
+	domains := DissectDomainsFromString("Visit https://example.iwc/ or https://example.starhub/")
+
+	// Assert.
+	assert.Empty(t, domains)
+}
+
+func Test_DissectDomainsFrom_By_tld_prefixed_by_another_tld(t *testing.T) {
+	// Execute.
+	domains := DissectDomainsFromString("example.community")
+
+	// Assert.
+	assert.Len(t, domains, 1)
+	assert.Equal(t, "example.community", domains[0])
+}
+
+func Test_compileDomainPattern_By_unsorted_list_with_duplicates(t *testing.T) {
+	// Setup.
+	pattern := compileDomainPattern("# Version 1\nCOM\nCOMMUNITY\ncom\n\nXN--P1AI\n")
+
+	// Execute.
+	domains := pattern.FindAllString("example.community example.com example.org example.onion", -1)
+
+	// Assert.
+	assert.Equal(t, []string{"example.community", "example.com", "example.onion"}, domains)
+}
+
 func Test_DissectDomainsFrom_By_complex_domain(t *testing.T) {
 	// Execute.
 	domains := DissectDomainsFromString("external.asd1230-123.asd_internal.asd.gm-_ail.aero")
@@ -160,3 +197,99 @@ func Test_isDomainRelated_By_invalid_domain(t *testing.T) {
 	assert.Equal(t, false, res1)
 	assert.Equal(t, false, res2)
 }
+
+func Test_ToASCIIDomain_By_unicode_domain(t *testing.T) {
+	// Execute.
+	domain, err := ToASCIIDomain("Пример.РФ")
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.Equal(t, "xn--e1afmkfd.xn--p1ai", domain)
+}
+
+func Test_ToASCIIDomain_By_ascii_domain(t *testing.T) {
+	// Execute.
+	domain, err := ToASCIIDomain("_dmarc.example.com")
+
+	// Assert.
+	assert.NoError(t, err)
+	assert.Equal(t, "_dmarc.example.com", domain)
+}
+
+func Test_ToUnicodeDomain_By_punycode_domain(t *testing.T) {
+	// Execute.
+	domain := ToUnicodeDomain("xn--e1afmkfd.xn--p1ai")
+
+	// Assert.
+	assert.Equal(t, "пример.рф", domain)
+}
+
+func Test_DissectDomainsFromString_By_unicode_domains(t *testing.T) {
+	// Execute.
+	domains := DissectDomainsFromString("links: https://München.de/, 例え.jp and xn--e1afmkfd.xn--p1ai")
+
+	// Assert.
+	assert.Equal(t, []string{"xn--mnchen-3ya.de", "xn--r8jz45g.jp", "xn--e1afmkfd.xn--p1ai"}, domains)
+}
+
+func Test_CleanDomain_By_unicode_domain(t *testing.T) {
+	// Execute.
+	domain := CleanDomain("www.Пример.РФ.")
+
+	// Assert.
+	assert.Equal(t, "xn--e1afmkfd.xn--p1ai", domain)
+}
+
+func Test_SortDomains_By_mixed_domains(t *testing.T) {
+	// Setup.
+	domains := []string{"b.a.example.org", "mail.example.com", "example.org", "api.example.org", "example.com"}
+
+	// Execute.
+	SortDomains(domains)
+
+	// Assert.
+	assert.Equal(t, []string{"example.com", "mail.example.com", "example.org", "api.example.org", "b.a.example.org"}, domains)
+}
+
+func Test_SortIPs_By_mixed_IPv4_and_IPv6(t *testing.T) {
+	// Setup.
+	ips := []string{"2001:db8::10", "10.0.0.2", "2001:db8::2", "9.255.0.1", "10.0.0.10", "::1"}
+
+	// Execute.
+	SortIPs(ips)
+
+	// Assert.
+	assert.Equal(t, []string{"9.255.0.1", "10.0.0.2", "10.0.0.10", "::1", "2001:db8::2", "2001:db8::10"}, ips)
+}
+
+func Test_reverseIPv4_By_4_and_16_byte_IPs(t *testing.T) {
+	// Setup.
+	ips := map[string]net.IP{
+		"4-byte":  net.IPv4(192, 0, 2, 10).To4(),
+		"16-byte": net.IPv4(192, 0, 2, 10).To16(),
+		"parsed":  net.ParseIP("192.0.2.10"),
+	}
+
+	for name, ip := range ips {
+		// Execute.
+		reverse := reverseIPv4(ip)
+
+		// Assert.
+		assert.Equal(t, "10.2.0.192", reverse, name)
+	}
+}
+
+func Test_reverseIPv4_By_IPv6(t *testing.T) {
+	// Execute.
+	reverse := reverseIPv4(net.ParseIP("2001:db8::1"))
+
+	// Assert.
+	assert.Empty(t, reverse)
+}
+
+func Test_registrableDomainOf_By_multi_label_public_suffix(t *testing.T) {
+	// Assert.
+	assert.Equal(t, "example.com", registrableDomainOf("www.example.com"))
+	assert.Equal(t, "example.co.uk", registrableDomainOf("api.example.co.uk."))
+	assert.Equal(t, "co.uk", registrableDomainOf("co.uk"))
+}
@@ -3,24 +3,135 @@ package udig
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
 	"io"
+	"net/http"
 	"strings"
 
 	"github.com/domainr/whois"
 )
 
+// MaxWhoisReferralHops caps the number of registrar referrals followed per domain.
+const MaxWhoisReferralHops = 2
+
+// WhoisKeyAliases maps WHOIS keys used by various registries (mostly ccTLDs)
+// to the canonical keys understood by parseWhoisResponse. All keys are lower-case.
+// It can be extended with custom aliases.
+var WhoisKeyAliases = map[string]string{
+	// Registry domain ID.
+	"domain id":   "registry domain id",
+	"roid":        "registry domain id",
+	"registry id": "registry domain id",
+
+	// Registrant.
+	"holder":             "registrant",
+	"holder-c":           "registrant",
+	"domain holder":      "registrant",
+	"owner":              "registrant",
+	"owner-c":            "registrant",
+	"registrant name":    "registrant",
+	"registrant contact": "registrant",
+
+	"registrant organisation": "registrant organization",
+	"registrant org":          "registrant organization",
+	"organization":            "registrant organization",
+	"organisation":            "registrant organization",
+	"org":                     "registrant organization",
+
+	"registrant state":    "registrant state/province",
+	"registrant province": "registrant state/province",
+
+	"country":                 "registrant country",
+	"registrant country code": "registrant country",
+
+	"registrant address":   "address",
+	"registrant's address": "address",
+
+	// Registrar.
+	"registrar name":                "registrar",
+	"sponsoring registrar":          "registrar",
+	"registrar organization":        "registrar",
+	"registrar organisation":        "registrar",
+	"registration service provider": "registrar",
+
+	"iana id":                      "registrar iana id",
+	"sponsoring registrar iana id": "registrar iana id",
+
+	"whois server":    "registrar whois server",
+	"registrar whois": "registrar whois server",
+
+	"referral url":      "registrar url",
+	"registrar website": "registrar url",
+	"url":               "registrar url",
+
+	// Dates.
+	"created":                  "creation date",
+	"created on":               "creation date",
+	"created date":             "creation date",
+	"registered on":            "creation date",
+	"registration date":        "creation date",
+	"registration time":        "creation date",
+	"domain registration date": "creation date",
+	"record created":           "creation date",
+	"activated":                "creation date",
+
+	"updated":       "updated date",
+	"updated on":    "updated date",
+	"update date":   "updated date",
+	"last updated":  "updated date",
+	"last modified": "updated date",
+	"last-update":   "updated date",
+	"modified":      "updated date",
+
+	"expires":                                "expire",
+	"expires on":                             "expire",
+	"expiry date":                            "expire",
+	"expire date":                            "expire",
+	"expiration date":                        "expire",
+	"expiration time":                        "expire",
+	"registry expiry date":                   "expire",
+	"registrar registration expiration date": "expire",
+	"paid-till":                              "expire",
+	"renewal date":                           "expire",
+
+	// Name servers.
+	"nserver":      "nsset",
+	"name server":  "nsset",
+	"name servers": "nsset",
+	"nameserver":   "nsset",
+	"nameservers":  "nsset",
+	"dns":          "nsset",
+
+	// Other contacts.
+	"admin-c":      "contact",
+	"tech-c":       "contact",
+	"person":       "name",
+	"contact name": "name",
+}
+
 // Expect to receive a reader to text with 3 parts:
 // 1. Key-value pairs separated by colon (":")
 // 2. A line `>>> Last update of WHOIS database: [date]<<<`
 // 3. Follow by an empty line, then free text of the legal disclaimers.
+//
+// Keys are translated via WhoisKeyAliases. A key with no value followed by
+// lines indented deeper than the key (e.g. an address block) takes those lines
+// as its values.
 func parseWhoisResponse(reader io.Reader) (contacts []WhoisContact) {
 	scanner := bufio.NewScanner(reader)
 	contact := WhoisContact{}
 
+	// The last key-only line, whose values may continue on the next lines.
+	var continuedField *string
+	var continuedIndent int
+
 	var lineNumber int
 	for lineNumber = 1; scanner.Scan(); lineNumber++ {
 		// Grab the line and clean it.
-		line := strings.Trim(scanner.Text(), " \n\r\t")
+		rawLine := strings.TrimRight(scanner.Text(), " \n\r\t")
+		line := strings.TrimLeft(rawLine, " \t")
+		indent := len(rawLine) - len(line)
 		line = strings.ToLower(line)
 
 		if line == "" {
@@ -29,6 +140,7 @@ func parseWhoisResponse(reader io.Reader) (contacts []WhoisContact) {
 				contacts = append(contacts, contact)
 				contact = WhoisContact{}
 			}
+			continuedField = nil
 			continue
 		} else if line[0] == '%' {
 			// Comment/disclaimer -> skip.
@@ -42,80 +154,85 @@ func parseWhoisResponse(reader io.Reader) (contacts []WhoisContact) {
 		}
 
 		// Parse the individual parts.
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) != 2 {
-			// Invalid line -> skip.
+		var key, value string
+		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 {
+			key = strings.Trim(parts[0], " \n\r\t")
+			value = strings.Trim(parts[1], " \n\r\t")
+			if alias, ok := WhoisKeyAliases[key]; ok {
+				key = alias
+			}
+		}
+		field := whoisContactField(&contact, key)
+
+		// Indented line under a key-only line -> a continued value (unless it is a known key).
+		if field == nil && continuedField != nil && indent > continuedIndent {
+			setOrAppendString(continuedField, line)
 			continue
 		}
+		continuedField = nil
 
-		key := strings.Trim(parts[0], " \n\r\t")
-		value := strings.Trim(parts[1], " \n\r\t")
-		if key == "" || value == "" {
-			// No key/value -> skip.
+		if field == nil {
+			// Invalid line or unknown key -> skip.
 			continue
 		}
 
-		switch key {
-		case "registry domain id":
-			setOrAppendString(&contact.RegistryDomainId, value)
-			break
-		case "registrant":
-			setOrAppendString(&(contact.Registrant), value)
-			break
-		case "registrant organization":
-			setOrAppendString(&contact.RegistrantOrganization, value)
-			break
-		case "registrant state/province":
-			setOrAppendString(&contact.RegistrantStateProvince, value)
-			break
-		case "registrant country":
-			setOrAppendString(&contact.RegistrantCountry, value)
-			break
-		case "registrar":
-			setOrAppendString(&contact.Registrar, value)
-			break
-		case "registrar iana id":
-			setOrAppendString(&contact.RegistrarIanaId, value)
-			break
-		case "registrar whois server":
-			setOrAppendString(&contact.RegistrarWhoisServer, value)
-			break
-		case "registrar url":
-			setOrAppendString(&contact.RegistrarUrl, value)
-			break
-		case "creation date":
-			setOrAppendString(&contact.CreationDate, value)
-			break
-		case "updated date":
-			setOrAppendString(&contact.UpdatedDate, value)
-			break
-		case "registered":
-			setOrAppendString(&contact.Registered, value)
-			break
-		case "changed":
-			setOrAppendString(&contact.Changed, value)
-			break
-		case "expire":
-			setOrAppendString(&contact.Expire, value)
-			break
-		case "nsset":
-			setOrAppendString(&contact.NSSet, value)
-			break
-		case "contact":
-			setOrAppendString(&contact.Contact, value)
-			break
-		case "name":
-			setOrAppendString(&contact.Name, value)
-			break
-		case "address":
-			setOrAppendString(&contact.Address, value)
-			break
+		if value == "" {
+			// No value -> it may follow on the next (indented) lines.
+			continuedField = field
+			continuedIndent = indent
+			continue
 		}
+
+		setOrAppendString(field, value)
 	}
 
 	return contacts
 }
 
+// whoisContactField returns a field of a given contact, which corresponds to a given
+// canonical WHOIS key (or nil if the key is not supported).
+func whoisContactField(contact *WhoisContact, key string) *string {
+	switch key {
+	case "registry domain id":
+		return &contact.RegistryDomainId
+	case "registrant":
+		return &contact.Registrant
+	case "registrant organization":
+		return &contact.RegistrantOrganization
+	case "registrant state/province":
+		return &contact.RegistrantStateProvince
+	case "registrant country":
+		return &contact.RegistrantCountry
+	case "registrar":
+		return &contact.Registrar
+	case "registrar iana id":
+		return &contact.RegistrarIanaId
+	case "registrar whois server":
+		return &contact.RegistrarWhoisServer
+	case "registrar url":
+		return &contact.RegistrarUrl
+	case "creation date":
+		return &contact.CreationDate
+	case "updated date":
+		return &contact.UpdatedDate
+	case "registered":
+		return &contact.Registered
+	case "changed":
+		return &contact.Changed
+	case "expire":
+		return &contact.Expire
+	case "nsset":
+		return &contact.NSSet
+	case "contact":
+		return &contact.Contact
+	case "name":
+		return &contact.Name
+	case "address":
+		return &contact.Address
+	}
+	return nil
+}
+
 func setOrAppendString(target *string, value string) {
 	if *target != "" {
 		value = *target + ", " + value
@@ -123,6 +240,174 @@ func setOrAppendString(target *string, value string) {
 	*target = value
 }
 
+/////////////////////////////////////////
+// RDAP
+/////////////////////////////////////////
+
+// RDAPBootstrapUrl points to IANA's RDAP bootstrap registry for domains (RFC 7484).
+var RDAPBootstrapUrl = "https://data.iana.org/rdap/dns.json"
+
+type rdapBootstrap struct {
+	Services [][][]string `json:"services"`
+}
+
+type rdapDomain struct {
+	Handle      string           `json:"handle"`
+	Events      []rdapEvent      `json:"events"`
+	Entities    []rdapEntity     `json:"entities"`
+	Nameservers []rdapNameserver `json:"nameservers"`
+}
+
+type rdapEvent struct {
+	Action string `json:"eventAction"`
+	Date   string `json:"eventDate"`
+}
+
+type rdapEntity struct {
+	Roles     []string       `json:"roles"`
+	PublicIds []rdapPublicId `json:"publicIds"`
+	VCard     []interface{}  `json:"vcardArray"`
+	Entities  []rdapEntity   `json:"entities"`
+	Links     []rdapLink     `json:"links"`
+}
+
+type rdapPublicId struct {
+	Type       string `json:"type"`
+	Identifier string `json:"identifier"`
+}
+
+type rdapLink struct {
+	Href string `json:"href"`
+}
+
+type rdapNameserver struct {
+	Name string `json:"ldhName"`
+}
+
+// parseRDAPBootstrap maps every TLD from a bootstrap registry to its RDAP base URLs.
+func parseRDAPBootstrap(reader io.Reader) (servers map[string][]string, err error) {
+	bootstrap := rdapBootstrap{}
+	if err = json.NewDecoder(reader).Decode(&bootstrap); err != nil {
+		return nil, err
+	}
+
+	servers = map[string][]string{}
+	for _, service := range bootstrap.Services {
+		if len(service) != 2 {
+			continue
+		}
+		for _, tld := range service[0] {
+			servers[strings.ToLower(tld)] = service[1]
+		}
+	}
+	return servers, nil
+}
+
+// parseRDAPResponse maps an RDAP domain object to a WHOIS contact.
+func parseRDAPResponse(reader io.Reader) (contacts []WhoisContact, err error) {
+	domain := rdapDomain{}
+	if err = json.NewDecoder(reader).Decode(&domain); err != nil {
+		return nil, err
+	}
+
+	contact := WhoisContact{}
+	setOrAppendRDAPString(&contact.RegistryDomainId, domain.Handle)
+
+	for _, event := range domain.Events {
+		switch strings.ToLower(event.Action) {
+		case "registration":
+			setOrAppendRDAPString(&contact.CreationDate, event.Date)
+			break
+		case "last changed":
+			setOrAppendRDAPString(&contact.UpdatedDate, event.Date)
+			break
+		case "expiration":
+			setOrAppendRDAPString(&contact.Expire, event.Date)
+			break
+		}
+	}
+
+	for _, nameserver := range domain.Nameservers {
+		setOrAppendRDAPString(&contact.NSSet, nameserver.Name)
+	}
+
+	for _, entity := range flattenRDAPEntities(domain.Entities) {
+		for _, role := range entity.Roles {
+			switch strings.ToLower(role) {
+			case "registrar":
+				setOrAppendRDAPString(&contact.Registrar, vcardValue(entity.VCard, "fn"))
+				for _, id := range entity.PublicIds {
+					if strings.EqualFold(id.Type, "IANA Registrar ID") {
+						setOrAppendRDAPString(&contact.RegistrarIanaId, id.Identifier)
+					}
+				}
+				setOrAppendRDAPString(&contact.RegistrarUrl, vcardValue(entity.VCard, "url"))
+				break
+			case "registrant":
+				setOrAppendRDAPString(&contact.Registrant, vcardValue(entity.VCard, "fn"))
+				setOrAppendRDAPString(&contact.RegistrantOrganization, vcardValue(entity.VCard, "org"))
+				setOrAppendRDAPString(&contact.RegistrantCountry, vcardValue(entity.VCard, "adr"))
+				break
+			}
+		}
+	}
+
+	if !contact.IsEmpty() {
+		contacts = append(contacts, contact)
+	}
+	return contacts, nil
+}
+
+// flattenRDAPEntities returns given entities along with all their nested entities.
+func flattenRDAPEntities(entities []rdapEntity) (flat []rdapEntity) {
+	for _, entity := range entities {
+		flat = append(flat, entity)
+		flat = append(flat, flattenRDAPEntities(entity.Entities)...)
+	}
+	return flat
+}
+
+// vcardValue returns a text value of a given jCard property (RFC 7095) or "".
+// For "adr" the country name is returned.
+func vcardValue(vcard []interface{}, property string) string {
+	if len(vcard) != 2 {
+		return ""
+	}
+	properties, ok := vcard[1].([]interface{})
+	if !ok {
+		return ""
+	}
+
+	for _, rawProperty := range properties {
+		parts, ok := rawProperty.([]interface{})
+		if !ok || len(parts) < 4 || parts[0] != property {
+			continue
+		}
+
+		switch value := parts[3].(type) {
+		case string:
+			return value
+		case []interface{}:
+			if property == "adr" && len(value) == 7 {
+				country, _ := value[6].(string)
+				return country
+			}
+			break
+		}
+	}
+	return ""
+}
+
+// setOrAppendRDAPString works like setOrAppendString, but normalizes the value
+// to match classic WHOIS output and keeps redacted values empty.
+func setOrAppendRDAPString(target *string, value string) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" || strings.Contains(value, "redacted") {
+		return
+	}
+	setOrAppendString(target, value)
+}
+
 /////////////////////////////////////////
 // WHOIS RESOLVER
 /////////////////////////////////////////
@@ -131,7 +416,10 @@ func setOrAppendString(target *string, value string) {
 // with sensible defaults.
 func NewWhoisResolver() *WhoisResolver {
 	return &WhoisResolver{
-		Client: whois.NewClient(DefaultTimeout),
+		PreferRDAP:     true,
+		FollowReferral: true,
+		Client:         whois.NewClient(DefaultTimeout),
+		HTTPClient:     &http.Client{Timeout: DefaultTimeout},
 	}
 }
 
@@ -142,21 +430,31 @@ func (resolver *WhoisResolver) Type() ResolutionType {
 
 // ResolveDomain attempts to resolve a given domain using WHOIS query
 // yielding a list of WHOIS contacts.
-func (resolver *WhoisResolver) ResolveDomain(domain string) Resolution {
+func (resolver *WhoisResolver) ResolveDomain(ctx context.Context, domain string) Resolution {
 	resolution := &WhoisResolution{
 		ResolutionBase: &ResolutionBase{query: domain},
 	}
 
+	if resolver.PreferRDAP {
+		if contacts, ok := resolver.fetchRDAP(ctx, domain); ok {
+			resolution.Contacts = resolver.capContacts(domain, contacts)
+			return resolution
+		}
+		LogDebug("%s: %s -> RDAP not available, falling back to WHOIS.", TypeWHOIS, domain)
+	}
+
 	// Prepare a request.
 	request, err := whois.NewRequest(domain)
 	if err != nil {
 		LogErr("%s: %s -> %s", TypeWHOIS, domain, err.Error())
+		resolution.addError(err)
 		return resolution
 	}
 
-	response, err := resolver.Client.Fetch(request)
+	response, err := resolver.Client.FetchContext(ctx, request)
 	if err != nil {
 		LogErr("%s: %s -> %s", TypeWHOIS, domain, err.Error())
+		resolution.addError(err)
 		return resolution
 	}
 
@@ -165,9 +463,158 @@ func (resolver *WhoisResolver) ResolveDomain(domain string) Resolution {
 		resolution.Contacts = append(resolution.Contacts, contact)
 	}
 
+	if resolver.FollowReferral {
+		resolution.Contacts = append(resolution.Contacts, resolver.followReferrals(ctx, domain, request.Host, contacts)...)
+	}
+
+	resolution.Contacts = resolver.capContacts(domain, resolution.Contacts)
+
 	return resolution
 }
 
+// capContacts truncates given contacts to MaxContacts (if set).
+func (resolver *WhoisResolver) capContacts(domain string, contacts []WhoisContact) []WhoisContact {
+	if resolver.MaxContacts > 0 && len(contacts) > resolver.MaxContacts {
+		LogErr("%s: %s -> %d contacts returned, keeping first %d.", TypeWHOIS, domain, len(contacts), resolver.MaxContacts)
+		return contacts[:resolver.MaxContacts]
+	}
+	return contacts
+}
+
+// followReferrals queries registrar WHOIS servers referenced by given contacts
+// (at most MaxWhoisReferralHops deep) and returns the contacts found there.
+func (resolver *WhoisResolver) followReferrals(ctx context.Context, domain string, host string, contacts []WhoisContact) (referred []WhoisContact) {
+	visited := map[string]bool{strings.ToLower(host): true}
+
+	for hop := 0; hop < MaxWhoisReferralHops; hop++ {
+		server := referralServerOf(contacts)
+		if server == "" || visited[server] {
+			break
+		}
+		visited[server] = true
+
+		request := &whois.Request{Query: domain, Host: server}
+		if err := request.Prepare(); err != nil {
+			LogErr("%s: %s -> %s", TypeWHOIS, domain, err.Error())
+			break
+		}
+
+		response, err := resolver.Client.FetchContext(ctx, request)
+		if err != nil {
+			LogErr("%s: %s -> referral to %s failed. The cause was: %s", TypeWHOIS, domain, server, err.Error())
+			break
+		}
+
+		LogDebug("%s: %s -> followed referral to %s.", TypeWHOIS, domain, server)
+		contacts = parseWhoisResponse(bytes.NewReader(response.Body))
+		referred = append(referred, contacts...)
+	}
+
+	return referred
+}
+
+// referralServerOf returns a host name of the first registrar WHOIS server among given contacts or "".
+func referralServerOf(contacts []WhoisContact) string {
+	for _, contact := range contacts {
+		if contact.RegistrarWhoisServer == "" {
+			continue
+		}
+		server := strings.SplitN(contact.RegistrarWhoisServer, ",", 2)[0]
+		server = strings.TrimPrefix(strings.TrimSpace(server), "whois://")
+		return strings.TrimSuffix(server, "/")
+	}
+	return ""
+}
+
+// fetchRDAP queries an RDAP server responsible for a given domain,
+// returns false if there is none or the query fails.
+func (resolver *WhoisResolver) fetchRDAP(ctx context.Context, domain string) (contacts []WhoisContact, ok bool) {
+	baseUrls := resolver.rdapServersFor(domain)
+	if len(baseUrls) == 0 {
+		return nil, false
+	}
+
+	url := strings.TrimSuffix(baseUrls[0], "/") + "/domain/" + domain
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		LogErr("%s: %s -> %s", TypeWHOIS, domain, err.Error())
+		return nil, false
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+
+	res, err := resolver.HTTPClient.Do(req)
+	if err != nil {
+		LogDebug("%s: %s -> RDAP query failed. The cause was: %s", TypeWHOIS, domain, err.Error())
+		return nil, false
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		LogDebug("%s: %s -> RDAP server %s responded %d.", TypeWHOIS, domain, baseUrls[0], res.StatusCode)
+		return nil, false
+	}
+
+	if contacts, err = parseRDAPResponse(res.Body); err != nil {
+		LogErr("%s: %s -> Invalid RDAP response. The cause was: %s", TypeWHOIS, domain, err.Error())
+		return nil, false
+	}
+	return contacts, true
+}
+
+// rdapServersFor returns RDAP base URLs for the longest matching suffix of a given domain.
+func (resolver *WhoisResolver) rdapServersFor(domain string) []string {
+	rdapServers := resolver.bootstrapRDAP()
+
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(domain, ".")), ".")
+	for i := 1; i < len(labels); i++ {
+		if servers := rdapServers[strings.Join(labels[i:], ".")]; len(servers) > 0 {
+			return servers
+		}
+	}
+	return nil
+}
+
+// bootstrapRDAP returns RDAP base URLs by TLD from the IANA bootstrap registry.
+// The registry is fetched only once per resolver, a failed fetch is retried by the next query.
+// It does not depend on the context of any single query, so that canceling one does not fail the rest.
+func (resolver *WhoisResolver) bootstrapRDAP() map[string][]string {
+	resolver.rdapMutex.Lock()
+	defer resolver.rdapMutex.Unlock()
+
+	if resolver.rdapServers != nil {
+		return resolver.rdapServers
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, RDAPBootstrapUrl, nil)
+	if err != nil {
+		LogErr("%s: Could not fetch RDAP bootstrap. The cause was: %s", TypeWHOIS, err.Error())
+		return nil
+	}
+
+	res, err := resolver.HTTPClient.Do(req)
+	if err != nil {
+		LogErr("%s: Could not fetch RDAP bootstrap. The cause was: %s", TypeWHOIS, err.Error())
+		return nil
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		LogErr("%s: Could not fetch RDAP bootstrap. The server responded %d.", TypeWHOIS, res.StatusCode)
+		return nil
+	}
+
+	servers, err := parseRDAPBootstrap(res.Body)
+	if err != nil {
+		LogErr("%s: Invalid RDAP bootstrap. The cause was: %s", TypeWHOIS, err.Error())
+		return nil
+	}
+	resolver.rdapServers = servers
+	return servers
+}
+
 /////////////////////////////////////////
 // WHOIS RESOLUTION
 /////////////////////////////////////////
@@ -202,10 +649,48 @@ func (res *WhoisResolution) Domains() (domains []string) {
 	return domains
 }
 
+// Raw returns the contacts as []WhoisContact.
+func (res *WhoisResolution) Raw() interface{} {
+	return res.Contacts
+}
+
 /////////////////////////////////////////
 // WHOIS CONTACT
 /////////////////////////////////////////
 
+// WhoisContactKeyFn returns a key of a given contact, contacts with the same key are aggregated
+// (see AggregateWhoisContacts). An empty key means the contact is not aggregated.
+type WhoisContactKeyFn func(contact *WhoisContact) string
+
+var (
+	// DefaultWhoisContactKey keys contacts on the registrar and registrant (or its organization).
+	DefaultWhoisContactKey WhoisContactKeyFn = func(contact *WhoisContact) string {
+		registrant := contact.Registrant
+		if registrant == "" {
+			registrant = contact.RegistrantOrganization
+		}
+		if contact.Registrar == "" && registrant == "" {
+			return ""
+		}
+		return strings.ToLower(contact.Registrar) + "|" + strings.ToLower(registrant)
+	}
+	WhoisContactKey = DefaultWhoisContactKey
+)
+
+// identity returns a copy of the contact with identifying fields only (i.e. no dates).
+func (contact *WhoisContact) identity() WhoisContact {
+	return WhoisContact{
+		Registrant:              contact.Registrant,
+		RegistrantOrganization:  contact.RegistrantOrganization,
+		RegistrantStateProvince: contact.RegistrantStateProvince,
+		RegistrantCountry:       contact.RegistrantCountry,
+		Registrar:               contact.Registrar,
+		RegistrarIanaId:         contact.RegistrarIanaId,
+		RegistrarWhoisServer:    contact.RegistrarWhoisServer,
+		RegistrarUrl:            contact.RegistrarUrl,
+	}
+}
+
 func (contact *WhoisContact) IsEmpty() bool {
 	return contact.RegistryDomainId == "" &&
 		contact.Registrant == "" &&
@@ -287,3 +772,7 @@ func (contact *WhoisContact) String() string {
 
 	return strings.Join(entries, ", ")
 }
+
+func (summary *WhoisContactSummary) String() string {
+	return summary.WhoisContact.String() + ", domains: " + strings.Join(summary.Domains, " ")
+}
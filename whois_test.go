@@ -0,0 +1,292 @@
+package udig
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mockRDAPServer() *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dns.json":
+			_, _ = fmt.Fprintf(w, `{"services": [[["com", "org"], ["%s/rdap/"]]]}`, server.URL)
+			break
+		case "/rdap/domain/example.com":
+			_, _ = w.Write([]byte(`{
+				"handle": "2336799_DOMAIN_COM-VRSN",
+				"events": [
+					{"eventAction": "registration", "eventDate": "1995-08-14T04:00:00Z"},
+					{"eventAction": "last changed", "eventDate": "2023-08-14T07:01:38Z"},
+					{"eventAction": "expiration", "eventDate": "2024-08-13T04:00:00Z"}
+				],
+				"entities": [
+					{
+						"roles": ["registrar"],
+						"publicIds": [{"type": "IANA Registrar ID", "identifier": "376"}],
+						"vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "Example Registrar"]]],
+						"entities": [
+							{"roles": ["registrant"], "vcardArray": ["vcard", [
+								["fn", {}, "text", "REDACTED FOR PRIVACY"],
+								["org", {}, "text", "Example Org"],
+								["adr", {}, "text", ["", "", "", "", "", "", "CZ"]]
+							]]}
+						]
+					}
+				],
+				"nameservers": [{"ldhName": "A.IANA-SERVERS.NET"}, {"ldhName": "B.IANA-SERVERS.NET"}]
+			}`))
+			break
+		default:
+			http.NotFound(w, r)
+			break
+		}
+	}))
+	return server
+}
+
+func Test_When_RDAP_is_available_Then_contacts_are_parsed_from_it(t *testing.T) {
+	// Mock.
+	server := mockRDAPServer()
+	defer server.Close()
+
+	origURL := RDAPBootstrapUrl
+	RDAPBootstrapUrl = server.URL + "/dns.json"
+	defer func() { RDAPBootstrapUrl = origURL }()
+
+	// Setup.
+	resolver := NewWhoisResolver()
+
+	// Execute.
+	contacts, ok := resolver.fetchRDAP(context.Background(), "example.com")
+
+	// Assert.
+	assert.True(t, ok)
+	assert.Equal(t, []WhoisContact{{
+		RegistryDomainId:       "2336799_domain_com-vrsn",
+		RegistrantOrganization: "example org",
+		RegistrantCountry:      "cz",
+		Registrar:              "example registrar",
+		RegistrarIanaId:        "376",
+		CreationDate:           "1995-08-14t04:00:00z",
+		UpdatedDate:            "2023-08-14t07:01:38z",
+		Expire:                 "2024-08-13t04:00:00z",
+		NSSet:                  "a.iana-servers.net, b.iana-servers.net",
+	}}, contacts)
+}
+
+func Test_When_RDAP_responds_404_Then_it_is_not_used(t *testing.T) {
+	// Mock.
+	server := mockRDAPServer()
+	defer server.Close()
+
+	origURL := RDAPBootstrapUrl
+	RDAPBootstrapUrl = server.URL + "/dns.json"
+	defer func() { RDAPBootstrapUrl = origURL }()
+
+	// Setup.
+	resolver := NewWhoisResolver()
+
+	// Execute.
+	_, okOrg := resolver.fetchRDAP(context.Background(), "example.org")
+	_, okNet := resolver.fetchRDAP(context.Background(), "example.net")
+
+	// Assert.
+	assert.False(t, okOrg)
+	assert.False(t, okNet)
+}
+
+func Test_When_RDAP_bootstrap_fails_Then_it_is_retried_by_the_next_query(t *testing.T) {
+	// Mock.
+	server := mockRDAPServer()
+	defer server.Close()
+
+	var mutex sync.Mutex
+	bootstraps := 0
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		bootstraps++
+		first := bootstraps == 1
+		mutex.Unlock()
+
+		if first {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, server.URL+"/dns.json", http.StatusFound)
+	}))
+	defer flaky.Close()
+
+	origURL := RDAPBootstrapUrl
+	RDAPBootstrapUrl = flaky.URL + "/dns.json"
+	defer func() { RDAPBootstrapUrl = origURL }()
+
+	// Setup.
+	resolver := NewWhoisResolver()
+
+	// Execute.
+	_, okFirst := resolver.fetchRDAP(context.Background(), "example.com")
+	_, okSecond := resolver.fetchRDAP(context.Background(), "example.com")
+	_, okThird := resolver.fetchRDAP(context.Background(), "example.com")
+
+	// Assert.
+	assert.False(t, okFirst)
+	assert.True(t, okSecond)
+	assert.True(t, okThird)
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, 2, bootstraps)
+}
+
+// mockWhoisDialer serves a canned WHOIS response per host over an in-memory connection.
+func mockWhoisDialer(responses map[string]string, dialed *[]string) func(ctx context.Context, network string, address string) (net.Conn, error) {
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		host, _, _ := net.SplitHostPort(address)
+		*dialed = append(*dialed, host)
+
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			_, _ = bufio.NewReader(server).ReadString('\n')
+			_, _ = server.Write([]byte(responses[host]))
+		}()
+		return client, nil
+	}
+}
+
+func Test_When_WHOIS_refers_to_registrar_Then_referrals_are_followed_up_to_the_cap(t *testing.T) {
+	// Mock.
+	var dialed []string
+	responses := map[string]string{
+		"whois.registrar-a.test": "Registrar: Registrar A\nRegistrar WHOIS Server: whois.registrar-b.test\n\n",
+		"whois.registrar-b.test": "Registrant Organization: Example Org\nRegistrar WHOIS Server: whois.registrar-c.test\n\n",
+		"whois.registrar-c.test": "Registrant Organization: Too Deep\n\n",
+	}
+
+	// Setup.
+	resolver := NewWhoisResolver()
+	resolver.Client.DialContext = mockWhoisDialer(responses, &dialed)
+	thin := []WhoisContact{{RegistrarWhoisServer: "whois.registrar-a.test"}}
+
+	// Execute.
+	contacts := resolver.followReferrals(context.Background(), "example.com", "whois.verisign-grs.com", thin)
+
+	// Assert.
+	assert.Equal(t, []string{"whois.registrar-a.test", "whois.registrar-b.test"}, dialed)
+	assert.Equal(t, []WhoisContact{
+		{Registrar: "registrar a", RegistrarWhoisServer: "whois.registrar-b.test"},
+		{RegistrantOrganization: "example org", RegistrarWhoisServer: "whois.registrar-c.test"},
+	}, contacts)
+}
+
+func Test_When_WHOIS_referral_loops_Then_it_is_followed_once(t *testing.T) {
+	// Mock.
+	var dialed []string
+	responses := map[string]string{
+		"whois.registrar-a.test": "Registrar: Registrar A\nRegistrar WHOIS Server: whois.registrar-a.test\n\n",
+	}
+
+	// Setup.
+	resolver := NewWhoisResolver()
+	resolver.Client.DialContext = mockWhoisDialer(responses, &dialed)
+	thin := []WhoisContact{{RegistrarWhoisServer: "whois.registrar-a.test"}}
+
+	// Execute.
+	contacts := resolver.followReferrals(context.Background(), "example.com", "whois.verisign-grs.com", thin)
+
+	// Assert.
+	assert.Equal(t, []string{"whois.registrar-a.test"}, dialed)
+	assert.Len(t, contacts, 1)
+}
+
+func Test_parseWhoisResponse_By_ccTLD_key_variants(t *testing.T) {
+	// Setup.
+	de := "Domain: example.de\nNserver: ns1.example.net\nNserver: ns2.example.net\nChanged: 2020-01-01T00:00:00+01:00\n\n"
+	fr := "domain:      example.fr\nholder-c:    ABC123-FRNIC\nregistrar:   OVH\nExpiry Date: 2030-01-01T00:00:00Z\ncreated:     2000-01-01T00:00:00Z\n\n"
+
+	// Execute.
+	deContacts := parseWhoisResponse(strings.NewReader(de))
+	frContacts := parseWhoisResponse(strings.NewReader(fr))
+
+	// Assert.
+	assert.Equal(t, []WhoisContact{{NSSet: "ns1.example.net, ns2.example.net", Changed: "2020-01-01t00:00:00+01:00"}}, deContacts)
+	assert.Equal(t, []WhoisContact{{
+		Registrant:   "abc123-frnic",
+		Registrar:    "ovh",
+		Expire:       "2030-01-01t00:00:00z",
+		CreationDate: "2000-01-01t00:00:00z",
+	}}, frContacts)
+}
+
+func Test_parseWhoisResponse_By_indented_continuation_lines(t *testing.T) {
+	// Setup.
+	uk := `
+    Registrant:
+        Example Ltd
+
+    Registrant's address:
+        1 Example Street
+        London
+        SW1A 1AA
+
+    Registrar:
+        Example Registrar Ltd [Tag = EXAMPLE]
+        URL: https://registrar.example.co.uk
+
+    Name servers:
+        ns1.example.co.uk
+        ns2.example.co.uk
+
+`
+
+	// Execute.
+	contacts := parseWhoisResponse(strings.NewReader(uk))
+
+	// Assert.
+	assert.Equal(t, []WhoisContact{
+		{Registrant: "example ltd"},
+		{Address: "1 example street, london, sw1a 1aa"},
+		{Registrar: "example registrar ltd [tag = example]", RegistrarUrl: "https://registrar.example.co.uk"},
+		{NSSet: "ns1.example.co.uk, ns2.example.co.uk"},
+	}, contacts)
+}
+
+func Test_When_WithDialContext_is_used_Then_RDAP_requests_go_through_the_dialer(t *testing.T) {
+	// Mock.
+	server := mockRDAPServer()
+	defer server.Close()
+
+	origURL := RDAPBootstrapUrl
+	RDAPBootstrapUrl = "http://rdap.internal/dns.json"
+	defer func() { RDAPBootstrapUrl = origURL }()
+
+	// A "tunnel" delivering all connections to the server.
+	var dialed []string
+	tunnel := func(ctx context.Context, network string, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+
+	// Setup.
+	resolver := NewWhoisResolver()
+	udig := newUdigImpl()
+	udig.AddDomainResolver(resolver)
+	WithDialContext(tunnel)(udig)
+
+	// Execute.
+	contacts, ok := resolver.fetchRDAP(context.Background(), "example.com")
+
+	// Assert.
+	assert.True(t, ok)
+	assert.Len(t, contacts, 1)
+	assert.Contains(t, dialed, "rdap.internal:80")
+}